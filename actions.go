@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// actionHandler processes a single message and returns a success message and
+// optional data to send back to the browser extension
+type actionHandler func(ctx context.Context, session *Session, msg *Message) (string, interface{}, error)
+
+// actionError is returned by handlers to reply with a status other than
+// "error" and its error code, optionally with data explaining the outcome
+type actionError struct {
+	status  string
+	code    string
+	message string
+	data    interface{}
+}
+
+func (e *actionError) Error() string {
+	return e.message
+}
+
+// registerActions returns the table of actions supported over native messaging
+func (t *TabdNativeHost) registerActions() map[string]actionHandler {
+	return map[string]actionHandler{
+		"hello":  t.handleHello,
+		"save":   t.handleSave,
+		"get":    t.handleGet,
+		"delete": t.handleDelete,
+		"clear":  t.handleClear,
+		"batch":  t.handleBatch,
+		"pin":    t.handlePin,
+		"unpin":  t.handlePin,
+		"tag":    t.handleTag,
+		"list":   t.handleList,
+		"ping":   t.handlePing,
+		"status": t.handleStatus,
+
+		"readclipboard":  t.handleReadClipboard,
+		"save_session":   t.handleSaveSession,
+		"list_sessions":  t.handleListSessions,
+		"get_session":    t.handleGetSession,
+		"get_register":   t.handleGetRegister,
+		"diff":           t.handleDiff,
+		"merge":          t.handleMerge,
+		"qr":             t.handleQR,
+		"render_snippet": t.handleRenderSnippet,
+		"incognito":      t.handleIncognito,
+		"save_full":      t.handleSaveFull,
+	}
+}
+
+// dispatch routes a message from a session to its action handler and builds
+// the response. The handler's context is done once the action's timeout
+// passes or ctx is cancelled, whichever comes first.
+func (t *TabdNativeHost) dispatch(ctx context.Context, session *Session, msg *Message) *Response {
+	return t.dispatchMessage(ctx, session, msg, true)
+}
+
+// dispatchMessage is dispatch, taking a rate limit token only if limited is
+// set. The items of a batch are covered by the token of the batch message.
+func (t *TabdNativeHost) dispatchMessage(ctx context.Context, session *Session, msg *Message, limited bool) *Response {
+	action := msg.Action
+	if action == "" {
+		action = "save"
+	}
+
+	response := &Response{
+		Action:    action,
+		Timestamp: time.Now().Unix(),
+		Version:   version,
+	}
+
+	handler, ok := t.actions[action]
+
+	// Unknown actions share one label so clients cannot create new series
+	start := time.Now()
+	defer func() {
+		label := action
+		if !ok {
+			label = "unknown"
+		}
+		t.metrics.observe(label, response.Status, time.Since(start))
+	}()
+
+	// Refuse floods before doing any work, so a misbehaving client cannot
+	// fill the disk
+	if limited {
+		if allowed, retryAfter, started := session.limiter.Allow(); !allowed {
+			if started {
+				logWarnf("Rate limiting messages: more than %g per second", t.config.RateLimit)
+			}
+			response.Status = "rate_limited"
+			response.Code = codeRateLimited
+			response.Message = "Too many messages, retry later"
+			response.Data = &rateLimitResult{RetryAfter: retryAfter.Milliseconds() + 1}
+			return response
+		}
+	}
+
+	if ok && !session.permitted(action) {
+		logWarnf("Refused action %s: not permitted for this connection", action)
+		response.Status = "permission_denied"
+		response.Code = codeNotPermitted
+		response.Message = fmt.Sprintf("Action not permitted: %s", action)
+		return response
+	}
+	if !ok {
+		response.Status = "error"
+		response.Code = codeUnknownAction
+		response.Message = fmt.Sprintf("Unknown action: %s", action)
+		return response
+	}
+
+	if !lockedActions[action] && !t.ensureUnlocked() {
+		err := t.lockError()
+		response.Status = "locked"
+		response.Code = codeLocked
+		if errors.Is(err, ErrKeychainDenied) {
+			response.Status = "keychain_denied"
+			response.Code = codeKeychainDenied
+		}
+		response.Message = err.Error()
+		return response
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.config.RequestTimeoutFor(action))
+	defer cancel()
+	message, data, err := t.callHandler(ctx, handler, action, session, msg)
+	var statusErr *actionError
+	if errors.As(err, &statusErr) {
+		logAttrs(slog.LevelInfo, "Action not completed",
+			slog.String("action", action),
+			slog.String("status", statusErr.status),
+			slog.String("reason", statusErr.message))
+		response.Status = statusErr.status
+		response.Code = statusErr.code
+		response.Message = statusErr.message
+		response.Data = statusErr.data
+		return response
+	}
+	if err != nil {
+		code := errorCode(err)
+		logAttrs(slog.LevelError, "Error handling action",
+			slog.String("action", action),
+			slog.String("code", code),
+			slog.String("error", err.Error()))
+		response.Status = "error"
+		response.Code = code
+		response.Message = err.Error()
+		return response
+	}
+
+	response.Status = "success"
+	response.Message = message
+	response.Data = data
+	return response
+}
+
+// callHandler runs an action handler, turning a panic into an internal_error
+// response so one bad message does not end the session
+func (t *TabdNativeHost) callHandler(ctx context.Context, handler actionHandler, action string, session *Session, msg *Message) (message string, data interface{}, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			t.recordPanic("action "+action, value)
+			err = &actionError{
+				status:  "internal_error",
+				code:    codeInternal,
+				message: fmt.Sprintf("Internal error handling %s", action),
+			}
+		}
+	}()
+	return handler(ctx, session, msg)
+}
+
+// handleSave stores the clipboard data carried by the message
+func (t *TabdNativeHost) handleSave(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	return t.saveMessage(ctx, session, msg, t.config.MaxTextBytes())
+}
+
+// handleSaveFull stores the clipboard data carried by the message without
+// truncating its text, for copies the user wants kept whole
+func (t *TabdNativeHost) handleSaveFull(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	return t.saveMessage(ctx, session, msg, 0)
+}
+
+// saveMessage stores the clipboard data carried by a save message, truncating
+// text longer than maxText bytes unless maxText is zero
+func (t *TabdNativeHost) saveMessage(ctx context.Context, session *Session, msg *Message, maxText int) (string, interface{}, error) {
+	if msg.Data != "" {
+		if _, err := msg.Bytes(); err != nil {
+			return "", nil, invalidRequestf("Failed to save clipboard data: %v", err)
+		}
+	}
+	if msg.Register != "" {
+		if err := validateRegister(msg.Register); err != nil {
+			return "", nil, err
+		}
+		// Registers are kept on disk unless incognito mode is on
+		if msg.Incognito && !t.isIncognito() {
+			return "", nil, invalidRequestf("Failed to save clipboard data: registers cannot hold incognito entries")
+		}
+	}
+
+	// Never persist data copied from blocked origins
+	if err := t.origins.Check(msg.URL); err != nil {
+		t.notifier.notifyBlocked(fmt.Sprintf("Blocked by the origin rules: %v", err))
+		return "", nil, &actionError{
+			status:  "blocked",
+			code:    codeBlocked,
+			message: fmt.Sprintf("Clipboard data not saved: %v", err),
+		}
+	}
+
+	// Tag, redact or refuse content that looks like a secret
+	report := t.sensitive.Apply(&msg.ClipboardData)
+	if report != nil && report.Decision == "refused" {
+		t.notifier.notifyBlocked(fmt.Sprintf("Refused as sensitive: %s", strings.Join(report.Sensitive, ", ")))
+		return "", nil, &actionError{
+			status:  "refused",
+			code:    codeRefused,
+			message: fmt.Sprintf("Clipboard data not saved: detected %s", strings.Join(report.Sensitive, ", ")),
+			data:    report,
+		}
+	}
+
+	// Entries from LAN peers are not shared back to them
+	if session.peer != "" && t.lan != nil {
+		t.lan.noteReceived(&msg.ClipboardData)
+	}
+
+	// The OS clipboard keeps the whole copy
+	copied := msg.ClipboardData
+
+	id, quota, err := t.storeClipboardData(ctx, &msg.ClipboardData, maxText)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to save clipboard data: %w", err)
+	}
+	result := &saveResult{ID: id, Truncated: msg.Truncated, FullLength: msg.FullLength, SensitiveReport: report, QuotaReport: quota}
+	if !msg.ClipboardData.Incognito {
+		t.notifier.notifySaved(&msg.ClipboardData)
+	}
+
+	if msg.Register != "" {
+		if err := t.storeRegister(msg.Register, id, &msg.ClipboardData); err != nil {
+			return "", nil, fmt.Errorf("Failed to save register: %w", err)
+		}
+		result.Register = msg.Register
+	}
+
+	if msg.Pin {
+		if err := t.historyStore().Pin(id, true); err != nil {
+			logWarnf("Error pinning history entry %s: %v", id, err)
+		}
+	}
+
+	// Browser copies also land on the OS clipboard, so the watcher must not
+	// record them a second time
+	t.noteClipboardText(copied.Text)
+
+	// Optionally mirror the entry onto the OS clipboard
+	if t.systemClipboard || (msg.SystemClipboard && session.hasFeature("system_clipboard")) {
+		if err := writeClipboardData(&copied); err != nil {
+			logWarnf("Error writing system clipboard: %v", err)
+			return "Clipboard data saved, but writing the system clipboard failed", result, nil
+		}
+	}
+
+	if result.Truncated {
+		return fmt.Sprintf("Clipboard data saved, truncated to %d bytes; use save_full to keep it whole", maxText), result, nil
+	}
+	return "Clipboard data saved successfully", result, nil
+}
+
+// rateLimitResult tells a rate limited client how many milliseconds to wait
+type rateLimitResult struct {
+	RetryAfter int64 `json:"retryAfter"`
+}
+
+// saveResult is the data returned by a save, including the sensitive content
+// decision when a detector matched and any quota eviction
+type saveResult struct {
+	ID       string `json:"id"`
+	Register string `json:"register,omitempty"`
+
+	// Truncated is set when the text was cut to maxTextLength, with
+	// FullLength the length of the text sent
+	Truncated  bool `json:"truncated,omitempty"`
+	FullLength int  `json:"fullLength,omitempty"`
+	*SensitiveReport
+	*QuotaReport
+}
+
+// BatchResult reports the outcome of one item in a batch
+type BatchResult struct {
+	Status  string      `json:"status"`
+	Code    string      `json:"code,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// handleBatch saves each item in msg.Items in order, so queued copies can be
+// flushed in a single message, and returns one result per item. The batch
+// takes one rate limit token however many items it has.
+func (t *TabdNativeHost) handleBatch(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	if len(msg.Items) == 0 {
+		return "", nil, invalidRequestf("Missing batch items")
+	}
+
+	results := make([]*BatchResult, len(msg.Items))
+	saved := 0
+	for i, item := range msg.Items {
+		response := t.dispatchMessage(ctx, session, &Message{Action: "save", ClipboardData: item}, false)
+		results[i] = &BatchResult{Status: response.Status, Code: response.Code, Message: response.Message, Data: response.Data}
+		if response.Status == "success" {
+			saved++
+		}
+	}
+
+	return fmt.Sprintf("Saved %d of %d items", saved, len(msg.Items)), results, nil
+}
+
+// handleGet returns a history entry by ID, or the latest clipboard data if no ID is given
+func (t *TabdNativeHost) handleGet(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	if msg.ID != "" {
+		data, err := getEntry(ctx, t.historyStore(), msg.ID)
+		if err != nil {
+			if !errors.Is(err, ErrEntryNotFound) && contextErr(ctx) == nil {
+				t.metrics.storageError("retrieve")
+			}
+			return "", nil, fmt.Errorf("Failed to retrieve history entry: %w", err)
+		}
+		t.runRetrieveHooks(msg.ID, data)
+		return "", &HistoryRecord{ID: msg.ID, ClipboardData: *data}, nil
+	}
+
+	data, err := t.getClipboardData(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to retrieve clipboard data: %w", err)
+	}
+	t.runRetrieveHooks("", data)
+	return "", data, nil
+}
+
+// handleDelete removes a history entry by ID
+func (t *TabdNativeHost) handleDelete(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	if msg.ID == "" {
+		return "", nil, invalidRequestf("Missing entry id")
+	}
+	if err := t.historyStore().Delete(msg.ID); err != nil {
+		return "", nil, fmt.Errorf("Failed to delete history entry: %w", err)
+	}
+	t.broadcastEntriesDeleted(msg.ID, 1, "deleted")
+	return "History entry deleted successfully", nil, nil
+}
+
+// handlePin pins a history entry by ID, or unpins it for the unpin action
+func (t *TabdNativeHost) handlePin(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	if msg.ID == "" {
+		return "", nil, invalidRequestf("Missing entry id")
+	}
+
+	pin := msg.Action == "pin"
+	if err := t.historyStore().Pin(msg.ID, pin); err != nil {
+		return "", nil, fmt.Errorf("Failed to update pinned entry: %w", err)
+	}
+
+	if pin {
+		return "History entry pinned successfully", nil, nil
+	}
+	return "History entry unpinned successfully", nil, nil
+}
+
+const (
+	// defaultListLimit is the page size of a list message without a limit,
+	// and maxListLimit the largest page that may be asked for
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// EntrySummary describes a history entry without its full contents
+type EntrySummary struct {
+	ID          string   `json:"id"`
+	Preview     string   `json:"preview"`
+	Kind        string   `json:"kind"`
+	Language    string   `json:"language,omitempty"`
+	Timestamp   int64    `json:"timestamp"`
+	URL         string   `json:"url,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Type        string   `json:"type,omitempty"`
+	ContentType string   `json:"contentType,omitempty"`
+	LinkTitle   string   `json:"linkTitle,omitempty"`
+	Favicon     string   `json:"favicon,omitempty"`
+	Pinned      bool     `json:"pinned,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Incognito   bool     `json:"incognito,omitempty"`
+}
+
+// listResult is the data returned by a list message: one page of entry
+// summaries, newest first, and the number of entries matching the filters
+type listResult struct {
+	Entries []*EntrySummary `json:"entries"`
+	Total   int             `json:"total"`
+	HasMore bool            `json:"hasMore"`
+}
+
+// handleList returns a page of history entry summaries, optionally filtered
+// by the time they were saved, the page they were copied from and their kind
+func (t *TabdNativeHost) handleList(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	if msg.Limit < 0 || msg.Offset < 0 || msg.Since < 0 {
+		return "", nil, invalidRequestf("Failed to list clipboard history: limit, offset and since must not be negative")
+	}
+	limit := msg.Limit
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		return "", nil, invalidRequestf("Failed to list clipboard history: limit must be at most %d", maxListLimit)
+	}
+	if msg.Kind != "" && !validKind(msg.Kind) {
+		return "", nil, invalidRequestf("Failed to list clipboard history: kind must be one of %s", strings.Join(contentKinds, ", "))
+	}
+
+	matches, err := t.historyStore().Query(HistoryQuery{Since: msg.Since, Origin: msg.Origin, Kind: msg.Kind})
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to list clipboard history: %w", err)
+	}
+	page := paginate(matches, msg.Offset, limit)
+
+	result := &listResult{
+		Entries: make([]*EntrySummary, 0, len(page)),
+		Total:   len(matches),
+		HasMore: msg.Offset+len(page) < len(matches),
+	}
+	for _, entry := range page {
+		// Only entries saved before previews and kinds were kept need
+		// decrypting
+		preview, kind, language := entry.Preview, entry.Kind, entry.Language
+		if preview == nil || kind == "" {
+			data, err := t.historyStore().Get(entry.ID)
+			if err != nil {
+				logWarnf("Skipping unreadable history entry %s: %v", entry.ID, err)
+				continue
+			}
+			preview = newEntryPreview(data)
+			kind, language = classifyContent(data)
+		}
+		result.Entries = append(result.Entries, &EntrySummary{
+			ID:          entry.ID,
+			Preview:     preview.Text,
+			Kind:        kind,
+			Language:    language,
+			Timestamp:   entry.Timestamp,
+			URL:         entry.URL,
+			Title:       preview.Title,
+			Type:        entry.Type,
+			ContentType: preview.ContentType,
+			LinkTitle:   preview.LinkTitle,
+			Favicon:     preview.Favicon,
+			Pinned:      entry.Pinned,
+			Tags:        entry.Tags,
+			Incognito:   entry.Incognito,
+		})
+	}
+
+	return "", result, nil
+}
+
+// handlePing lets the extension check that the host is reachable
+func (t *TabdNativeHost) handlePing(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	return "pong", nil, nil
+}
+
+// handleReadClipboard returns the current OS clipboard contents
+func (t *TabdNativeHost) handleReadClipboard(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	data, err := systemClipboardData()
+	if err != nil {
+		return "", nil, &codedError{code: codeClipboard, err: fmt.Errorf("Failed to read system clipboard: %v", err)}
+	}
+	return "", data, nil
+}
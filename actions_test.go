@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestBatchTakesOneRateLimitToken(t *testing.T) {
+	host := newTestHost(t)
+	host.config.RateLimit = 0.001
+	host.config.RateBurst = 2
+	session := host.NewSession(nil, nil)
+	ctx := context.Background()
+
+	items := make([]ClipboardData, 5)
+	for i := range items {
+		items[i] = ClipboardData{Text: fmt.Sprintf("item %d", i), Type: "text"}
+	}
+	response := host.dispatch(ctx, session, &Message{Action: "batch", Items: items})
+	if response.Status != "success" {
+		t.Fatalf("batch: %s", response.Message)
+	}
+	for i, result := range response.Data.([]*BatchResult) {
+		if result.Status != "success" {
+			t.Fatalf("item %d of a batch larger than the burst: %s %s", i, result.Status, result.Message)
+		}
+	}
+
+	// The batch took one of the two tokens
+	if response := host.dispatch(ctx, session, &Message{Action: "save", ClipboardData: ClipboardData{Text: "after", Type: "text"}}); response.Status != "success" {
+		t.Fatalf("save after the batch: %s", response.Message)
+	}
+	if response := host.dispatch(ctx, session, &Message{Action: "save", ClipboardData: ClipboardData{Text: "limited", Type: "text"}}); response.Status != "rate_limited" {
+		t.Fatalf("save beyond the burst = %s, want rate_limited", response.Status)
+	}
+}
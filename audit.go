@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// auditLogName is the audit log in the storage directory
+	auditLogName = "audit.log"
+
+	// auditLockName serializes appends to the audit log between processes
+	auditLockName = ".audit.lock"
+
+	// auditTailSize is how much of the end of the log is read to find the
+	// record the next one chains to
+	auditTailSize = 64 * 1024
+)
+
+// AuditRecord is one line of the audit log. Hash covers every other field,
+// including the hash of the previous record, so editing, inserting or
+// removing a record breaks the chain from that point on.
+type AuditRecord struct {
+	Seq   int64  `json:"seq"`
+	Time  int64  `json:"time"`
+	Actor string `json:"actor"`
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Prev  string `json:"prev"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+// hash computes the record's chained hash
+func (r *AuditRecord) hash() string {
+	unsigned := *r
+	unsigned.Hash = ""
+	data, _ := json.Marshal(&unsigned)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLog appends a hash-chained record of each storage access to a file in
+// the storage directory
+type AuditLog struct {
+	path     string
+	lockPath string
+
+	// actor names who is using storage: the calling extension, the CLI or
+	// the daemon
+	mu    sync.Mutex
+	actor string
+}
+
+// NewAuditLog creates the audit log for a storage directory, or returns nil
+// if the config does not enable it
+func NewAuditLog(config *Config) *AuditLog {
+	if !config.AuditLog {
+		return nil
+	}
+	return &AuditLog{
+		path:     filepath.Join(config.StorageDir, auditLogName),
+		lockPath: filepath.Join(config.StorageDir, auditLockName),
+		actor:    "native",
+	}
+}
+
+// SetActor sets who later records are attributed to
+func (a *AuditLog) SetActor(actor string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.actor = actor
+}
+
+// Record appends a record of op on key. Failures are logged rather than
+// returned, so a broken audit log does not stop the clipboard from working.
+func (a *AuditLog) Record(op, key string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	actor := a.actor
+	a.mu.Unlock()
+
+	if err := a.append(&AuditRecord{Time: time.Now().UnixMilli(), Actor: actor, Op: op, Key: key}); err != nil {
+		logWarnf("Error writing audit log: %v", err)
+	}
+}
+
+// append chains a record to the last one in the log and writes it
+func (a *AuditLog) append(record *AuditRecord) error {
+	lock, err := acquireFileLock(a.lockPath)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	last, err := lastAuditRecord(a.path)
+	if err != nil {
+		return err
+	}
+	record.Seq = 1
+	if last != nil {
+		record.Seq = last.Seq + 1
+		record.Prev = last.Hash
+	}
+	record.Hash = record.hash()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// lastAuditRecord returns the newest record in the log, or nil if it is empty
+func lastAuditRecord(path string) (*AuditRecord, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(info.Size()-auditTailSize, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	lines := bytes.Split(bytes.TrimRight(tail, "\n"), []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		return nil, nil
+	}
+	var record AuditRecord
+	if err := json.Unmarshal(lines[len(lines)-1], &record); err != nil {
+		return nil, fmt.Errorf("invalid last record in %s: %v", path, err)
+	}
+	return &record, nil
+}
+
+// readAuditLog returns every record in the log, oldest first
+func readAuditLog(path string) ([]*AuditRecord, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*AuditRecord{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	records := []*AuditRecord{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("audit log line %d is not a record: %v", line, err)
+		}
+		records = append(records, &record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return records, nil
+}
+
+// verifyAuditChain checks that each record follows the previous one and that
+// its hash matches its content
+func verifyAuditChain(records []*AuditRecord) error {
+	prev := ""
+	for i, record := range records {
+		if record.Seq != int64(i+1) {
+			return fmt.Errorf("record %d has sequence number %d: records were removed or inserted", i+1, record.Seq)
+		}
+		if record.Prev != prev {
+			return fmt.Errorf("record %d does not follow record %d: the chain was broken", record.Seq, record.Seq-1)
+		}
+		if record.hash() != record.Hash {
+			return fmt.Errorf("record %d does not match its hash: it was modified", record.Seq)
+		}
+		prev = record.Hash
+	}
+	return nil
+}
+
+// auditedStorage records the keys read, written and deleted in secure storage
+type auditedStorage struct {
+	SecureStorage
+	audit *AuditLog
+}
+
+func (s *auditedStorage) Store(key string, data []byte) error {
+	return s.StoreContext(context.Background(), key, data)
+}
+
+func (s *auditedStorage) StoreContext(ctx context.Context, key string, data []byte) error {
+	err := storeKey(ctx, s.SecureStorage, key, data)
+	if err == nil {
+		s.audit.Record("write", key)
+	}
+	return err
+}
+
+func (s *auditedStorage) Retrieve(key string) ([]byte, error) {
+	return s.RetrieveContext(context.Background(), key)
+}
+
+func (s *auditedStorage) RetrieveContext(ctx context.Context, key string) ([]byte, error) {
+	data, err := retrieveKey(ctx, s.SecureStorage, key)
+	if err == nil {
+		s.audit.Record("read", key)
+	}
+	return data, err
+}
+
+func (s *auditedStorage) Delete(key string) error {
+	err := s.SecureStorage.Delete(key)
+	if err == nil {
+		s.audit.Record("delete", key)
+	}
+	return err
+}
+
+func (s *auditedStorage) Wipe(key string) error {
+	err := wipeKey(s.SecureStorage, key)
+	if err == nil {
+		s.audit.Record("delete", key)
+	}
+	return err
+}
+
+// auditedHistory records the history entries read and changed. Listing and
+// querying the index are not recorded, as saves and diagnostics do so all
+// the time.
+type auditedHistory struct {
+	HistoryStore
+	audit *AuditLog
+}
+
+func (h *auditedHistory) Append(data *ClipboardData) (string, error) {
+	return h.AppendContext(context.Background(), data)
+}
+
+func (h *auditedHistory) AppendContext(ctx context.Context, data *ClipboardData) (string, error) {
+	id, err := appendEntry(ctx, h.HistoryStore, data)
+	if err == nil {
+		h.audit.Record("write", "history/"+id)
+	}
+	return id, err
+}
+
+func (h *auditedHistory) Get(id string) (*ClipboardData, error) {
+	return h.GetContext(context.Background(), id)
+}
+
+func (h *auditedHistory) GetContext(ctx context.Context, id string) (*ClipboardData, error) {
+	data, err := getEntry(ctx, h.HistoryStore, id)
+	if err == nil {
+		h.audit.Record("read", "history/"+id)
+	}
+	return data, err
+}
+
+func (h *auditedHistory) Update(id string, data *ClipboardData) error {
+	err := h.HistoryStore.Update(id, data)
+	if err == nil {
+		h.audit.Record("write", "history/"+id)
+	}
+	return err
+}
+
+func (h *auditedHistory) Delete(id string) error {
+	err := h.HistoryStore.Delete(id)
+	if err == nil {
+		h.audit.Record("delete", "history/"+id)
+	}
+	return err
+}
+
+func (h *auditedHistory) Clear(before int64, wipe bool) (int, error) {
+	removed, err := h.HistoryStore.Clear(before, wipe)
+	if err == nil && removed > 0 {
+		h.audit.Record("clear", "history")
+	}
+	return removed, err
+}
+
+func (h *auditedHistory) Expire(before int64) (int, error) {
+	removed, err := h.HistoryStore.Expire(before)
+	if err == nil && removed > 0 {
+		h.audit.Record("expire", "history")
+	}
+	return removed, err
+}
+
+func (h *auditedHistory) Pin(id string, pinned bool) error {
+	err := h.HistoryStore.Pin(id, pinned)
+	if err == nil {
+		op := "pin"
+		if !pinned {
+			op = "unpin"
+		}
+		h.audit.Record(op, "history/"+id)
+	}
+	return err
+}
+
+// auditStorage wraps opened storage so accesses are recorded in the audit
+// log, if it is enabled
+func auditStorage(audit *AuditLog, storage SecureStorage, history HistoryStore) (SecureStorage, HistoryStore) {
+	if audit == nil || storage == nil {
+		return storage, history
+	}
+	return &auditedStorage{SecureStorage: storage, audit: audit}, &auditedHistory{HistoryStore: history, audit: audit}
+}
+
+// unwrapStorage returns the storage behind the audit log, for checks on the
+// backend's type
+func unwrapStorage(storage SecureStorage) SecureStorage {
+	if audited, ok := storage.(*auditedStorage); ok {
+		return audited.SecureStorage
+	}
+	return storage
+}
+
+// newAuditCommand creates the audit command, which prints or verifies the
+// audit log
+func newAuditCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit [verify]",
+		Short: "Show or verify the audit log of storage accesses",
+	}
+	flags := cmd.Flags()
+	limit := flags.Int("limit", 50, "number of newest records to print (0 for all)")
+	cmd.RunE = func(_ *cobra.Command, positional []string) error {
+		if len(positional) > 1 || (len(positional) == 1 && positional[0] != "verify") {
+			return fmt.Errorf("usage: audit [verify] [--limit <n>] [--json]")
+		}
+
+		config, err := LoadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		records, err := readAuditLog(filepath.Join(config.StorageDir, auditLogName))
+		if err != nil {
+			return err
+		}
+
+		if len(positional) == 1 {
+			if err := verifyAuditChain(records); err != nil {
+				return fmt.Errorf("audit log verification failed: %v", err)
+			}
+			head := "none"
+			if len(records) > 0 {
+				head = records[len(records)-1].Hash
+			}
+			fmt.Printf("Verified %d audit records; chain head %s\n", len(records), head)
+			return nil
+		}
+
+		if !config.AuditLog && len(records) == 0 {
+			notef("The audit log is disabled; set \"auditLog\": true in the config file to enable it\n")
+			return nil
+		}
+		if *limit > 0 && len(records) > *limit {
+			records = records[len(records)-*limit:]
+		}
+		for _, record := range records {
+			if jsonOutput {
+				line, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(line))
+				continue
+			}
+			fmt.Printf("%6d  %s  %-7s %-40s %s\n", record.Seq,
+				time.UnixMilli(record.Time).Format("2006-01-02 15:04:05"), record.Op, record.Key, record.Actor)
+		}
+		return nil
+	}
+	return cmd
+}
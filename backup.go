@@ -0,0 +1,359 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// backupMagic starts every backup file, followed by the archive
+	// encrypted as a BlobCipher blob
+	backupMagic = "TABDBAK1"
+
+	// backupFormatVersion is bumped when the archive layout changes
+	backupFormatVersion = 1
+
+	// maxBackupSize bounds the backup file read by restore
+	maxBackupSize = 1 << 30
+)
+
+// Files stored in a backup archive
+const (
+	backupManifestFile = "manifest.json"
+	backupHistoryFile  = "history.ndjson"
+	backupSessionsFile = "sessions.json"
+	backupSnippetsFile = "snippets.json"
+	backupConfigFile   = "config.json"
+)
+
+// backupManifest describes the contents of a backup archive
+type backupManifest struct {
+	FormatVersion int    `json:"formatVersion"`
+	HostVersion   string `json:"hostVersion"`
+	Created       int64  `json:"created"`
+	Profile       string `json:"profile,omitempty"`
+	Entries       int    `json:"entries"`
+	Sessions      int    `json:"sessions"`
+	Snippets      int    `json:"snippets"`
+	Config        bool   `json:"config"`
+}
+
+// readBackupPassphrase prompts for the passphrase protecting a backup, twice
+// when creating one
+func readBackupPassphrase(confirm bool) (string, error) {
+	passphrase, err := readPassphrase("Backup passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("passphrase must not be empty")
+	}
+	if confirm {
+		again, err := readPassphrase("Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// newBackupCommand creates the backup command, which writes history, pins, tab
+// sessions, snippets and the config file to a single archive encrypted with a
+// backup passphrase
+func newBackupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Write an encrypted backup of history, sessions, snippets and config",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	out := flags.String("out", "tabd-backup.tar.enc", "backup file to write")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		manifest := &backupManifest{
+			FormatVersion: backupFormatVersion,
+			HostVersion:   version,
+			Created:       time.Now().UnixMilli(),
+			Profile:       currentProfile(),
+		}
+		files := map[string][]byte{}
+
+		records, err := exportRecords(host.historyStore(), 0)
+		if err != nil {
+			return err
+		}
+		var history bytes.Buffer
+		if err := writeRecords(&history, records, "ndjson"); err != nil {
+			return fmt.Errorf("failed to encode history: %v", err)
+		}
+		files[backupHistoryFile] = history.Bytes()
+		manifest.Entries = len(records)
+
+		summaries, err := host.tabSessions()
+		if err != nil {
+			return err
+		}
+		sessions := make([]*TabSession, 0, len(summaries))
+		for _, summary := range summaries {
+			session, err := host.getTabSession(summary.Name)
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, session)
+		}
+		if files[backupSessionsFile], err = json.Marshal(sessions); err != nil {
+			return fmt.Errorf("failed to encode sessions: %v", err)
+		}
+		manifest.Sessions = len(sessions)
+
+		snippets, err := host.snippets()
+		if err != nil {
+			return err
+		}
+		if files[backupSnippetsFile], err = json.Marshal(snippets); err != nil {
+			return fmt.Errorf("failed to encode snippets: %v", err)
+		}
+		manifest.Snippets = len(snippets)
+
+		if path, _, err := configPath(); err == nil {
+			if configData, err := os.ReadFile(path); err == nil {
+				files[backupConfigFile] = configData
+				manifest.Config = true
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to read config file: %w", err)
+			}
+		}
+
+		if files[backupManifestFile], err = json.MarshalIndent(manifest, "", "  "); err != nil {
+			return fmt.Errorf("failed to encode manifest: %v", err)
+		}
+
+		archive, err := writeBackupArchive(files)
+		if err != nil {
+			return err
+		}
+
+		passphrase, err := readBackupPassphrase(true)
+		if err != nil {
+			return err
+		}
+		encrypted, err := NewBlobCipher(passphrase).Encrypt(archive)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt backup: %v", err)
+		}
+		if err := os.WriteFile(*out, append([]byte(backupMagic), encrypted...), 0600); err != nil {
+			return fmt.Errorf("failed to write backup: %v", err)
+		}
+
+		notef("Backed up %d entries, %d sessions and %d snippets to %s\n",
+			manifest.Entries, manifest.Sessions, manifest.Snippets, *out)
+		return nil
+	})
+	return cmd
+}
+
+// writeBackupArchive packs files into a tar archive, manifest first
+func writeBackupArchive(files map[string][]byte) ([]byte, error) {
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	order := []string{backupManifestFile, backupHistoryFile, backupSessionsFile, backupSnippetsFile, backupConfigFile}
+	for _, name := range order {
+		content, ok := files[name]
+		if !ok {
+			continue
+		}
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: time.Now()}
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to write backup archive: %v", err)
+		}
+		if _, err := writer.Write(content); err != nil {
+			return nil, fmt.Errorf("failed to write backup archive: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write backup archive: %v", err)
+	}
+	return archive.Bytes(), nil
+}
+
+// readBackupArchive decrypts a backup file and unpacks its files
+func readBackupArchive(path, passphrase string) (map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxBackupSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %v", err)
+	}
+	if len(data) > maxBackupSize {
+		return nil, fmt.Errorf("backup is larger than %d bytes", maxBackupSize)
+	}
+
+	encrypted, ok := bytes.CutPrefix(data, []byte(backupMagic))
+	if !ok {
+		return nil, fmt.Errorf("%s is not a tabd backup", path)
+	}
+	archive, err := NewBlobCipher(passphrase).Decrypt(encrypted)
+	if err != nil {
+		return nil, errors.New("failed to decrypt backup: wrong passphrase or corrupted file")
+	}
+
+	files := map[string][]byte{}
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup archive: %v", err)
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup archive: %v", err)
+		}
+		files[header.Name] = content
+	}
+	return files, nil
+}
+
+// newRestoreCommand creates the restore command, which imports a backup made
+// by the backup command. Entries are re-encrypted with this machine's storage
+// key, so the key itself never leaves the machine that made the backup.
+func newRestoreCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore an encrypted backup, re-encrypting it for this machine",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	in := flags.String("in", "tabd-backup.tar.enc", "backup file to read")
+	overwriteConfig := flags.Bool("overwrite-config", false, "replace an existing config file with the backed up one")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		passphrase, err := readBackupPassphrase(false)
+		if err != nil {
+			return err
+		}
+		files, err := readBackupArchive(*in, passphrase)
+		if err != nil {
+			return err
+		}
+
+		var manifest backupManifest
+		if err := json.Unmarshal(files[backupManifestFile], &manifest); err != nil {
+			return fmt.Errorf("invalid backup manifest: %v", err)
+		}
+		if manifest.FormatVersion > backupFormatVersion {
+			return fmt.Errorf("backup format %d is newer than this host supports (%d); update tabd-native-host", manifest.FormatVersion, backupFormatVersion)
+		}
+
+		records, err := readRecords(bytes.NewReader(files[backupHistoryFile]), "ndjson")
+		if err != nil {
+			return err
+		}
+		records, err = newRecords(host.historyStore(), records)
+		if err != nil {
+			return err
+		}
+		if err := importRecords(host.historyStore(), records); err != nil {
+			return err
+		}
+
+		var sessions []*TabSession
+		if err := json.Unmarshal(files[backupSessionsFile], &sessions); err != nil {
+			return fmt.Errorf("invalid backup sessions: %v", err)
+		}
+		for _, session := range sessions {
+			if err := host.saveTabSession(session); err != nil {
+				return fmt.Errorf("failed to restore session %s: %v", session.Name, err)
+			}
+		}
+
+		var snippets []Snippet
+		if err := json.Unmarshal(files[backupSnippetsFile], &snippets); err != nil {
+			return fmt.Errorf("invalid backup snippets: %v", err)
+		}
+		for i := range snippets {
+			if err := validateSnippet(&snippets[i]); err != nil {
+				return fmt.Errorf("failed to restore snippet %s: %v", snippets[i].Name, err)
+			}
+			if _, err := host.updateSnippets(snippets[i].Name, &snippets[i]); err != nil {
+				return fmt.Errorf("failed to restore snippet %s: %v", snippets[i].Name, err)
+			}
+		}
+
+		if configData, ok := files[backupConfigFile]; ok {
+			if err := restoreConfig(configData, *overwriteConfig); err != nil {
+				return err
+			}
+		}
+
+		notef("Restored %d entries, %d sessions and %d snippets from a backup made %s\n",
+			len(records), len(sessions), len(snippets), time.UnixMilli(manifest.Created).Format("2006-01-02 15:04"))
+		return nil
+	})
+	return cmd
+}
+
+// newRecords drops records already in history, so restoring a backup twice
+// does not duplicate entries. Only entries saved at the same time as a record
+// are decrypted to compare their content.
+func newRecords(history HistoryStore, records []*HistoryRecord) ([]*HistoryRecord, error) {
+	entries, err := history.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clipboard history: %v", err)
+	}
+	byTimestamp := map[int64][]string{}
+	for _, entry := range entries {
+		byTimestamp[entry.Timestamp] = append(byTimestamp[entry.Timestamp], entry.ID)
+	}
+
+	kept := []*HistoryRecord{}
+	for _, record := range records {
+		duplicate := false
+		for _, id := range byTimestamp[record.Timestamp] {
+			if existing, err := history.Get(id); err == nil && contentHash(existing) == contentHash(&record.ClipboardData) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			kept = append(kept, record)
+		}
+	}
+	return kept, nil
+}
+
+// restoreConfig writes a backed up config file, keeping an existing one
+// unless overwrite is set
+func restoreConfig(configData []byte, overwrite bool) error {
+	path, _, err := configPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil && !overwrite {
+		notef("Kept the existing config file %s; use --overwrite-config to replace it\n", path)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, configData, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	notef("Restored the config file to %s\n", path)
+	return nil
+}
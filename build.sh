@@ -10,9 +10,35 @@ echo "Building Tab'd Native Host..."
 SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 cd "$SCRIPT_DIR"
 
+# Embed version and build information
+VERSION="${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}"
+COMMIT="$(git rev-parse HEAD 2>/dev/null || true)"
+BUILD_DATE="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+LDFLAGS="-X main.version=${VERSION} -X main.commit=${COMMIT} -X main.buildDate=${BUILD_DATE}"
+
+# UPDATE_SIGNING_KEY holds a PEM Ed25519 private key, created with
+# `openssl genpkey -algorithm ed25519`. Release builds embed its public key
+# and sign SHA256SUMS with it, so update can verify who published them.
+SIGNING_KEY_FILE=""
+if [ -n "$UPDATE_SIGNING_KEY" ]; then
+    SIGNING_KEY_FILE="$(mktemp)"
+    trap 'rm -f "$SIGNING_KEY_FILE"' EXIT
+    chmod 600 "$SIGNING_KEY_FILE"
+    printf '%s\n' "$UPDATE_SIGNING_KEY" > "$SIGNING_KEY_FILE"
+    SIGNING_PUBLIC_KEY="$(openssl pkey -in "$SIGNING_KEY_FILE" -pubout -outform DER | tail -c 32 | openssl base64 -A)"
+    if [ -n "$UPDATE_PUBLIC_KEY" ] && [ "$UPDATE_PUBLIC_KEY" != "$SIGNING_PUBLIC_KEY" ]; then
+        echo "UPDATE_PUBLIC_KEY does not match UPDATE_SIGNING_KEY" >&2
+        exit 1
+    fi
+    UPDATE_PUBLIC_KEY="$SIGNING_PUBLIC_KEY"
+fi
+if [ -n "$UPDATE_PUBLIC_KEY" ]; then
+    LDFLAGS="$LDFLAGS -X main.updatePublicKey=${UPDATE_PUBLIC_KEY}"
+fi
+
 # Build for current platform
 echo "Building for $(go env GOOS)/$(go env GOARCH)..."
-go build -o tabd-native-host
+go build -ldflags "$LDFLAGS" -o tabd-native-host
 
 # Make executable
 chmod +x tabd-native-host
@@ -24,32 +50,53 @@ if [ "$1" = "all" ]; then
     echo "Building for all platforms..."
     
     # macOS
-    GOOS=darwin GOARCH=amd64 go build -o tabd-native-host-darwin-amd64
-    GOOS=darwin GOARCH=arm64 go build -o tabd-native-host-darwin-arm64
+    GOOS=darwin GOARCH=amd64 go build -ldflags "$LDFLAGS" -o tabd-native-host-darwin-amd64
+    GOOS=darwin GOARCH=arm64 go build -ldflags "$LDFLAGS" -o tabd-native-host-darwin-arm64
     
     # Linux
-    GOOS=linux GOARCH=amd64 go build -o tabd-native-host-linux-amd64
-    GOOS=linux GOARCH=arm64 go build -o tabd-native-host-linux-arm64
-    GOOS=linux GOARCH=386 go build -o tabd-native-host-linux-386
-    GOOS=linux GOARCH=arm go build -o tabd-native-host-linux-arm
+    GOOS=linux GOARCH=amd64 go build -ldflags "$LDFLAGS" -o tabd-native-host-linux-amd64
+    GOOS=linux GOARCH=arm64 go build -ldflags "$LDFLAGS" -o tabd-native-host-linux-arm64
+    GOOS=linux GOARCH=386 go build -ldflags "$LDFLAGS" -o tabd-native-host-linux-386
+    GOOS=linux GOARCH=arm go build -ldflags "$LDFLAGS" -o tabd-native-host-linux-arm
     
     # Windows
-    GOOS=windows GOARCH=amd64 go build -o tabd-native-host-windows-amd64.exe
-    GOOS=windows GOARCH=386 go build -o tabd-native-host-windows-386.exe
-    GOOS=windows GOARCH=arm64 go build -o tabd-native-host-windows-arm64.exe
+    GOOS=windows GOARCH=amd64 go build -ldflags "$LDFLAGS" -o tabd-native-host-windows-amd64.exe
+    GOOS=windows GOARCH=386 go build -ldflags "$LDFLAGS" -o tabd-native-host-windows-386.exe
+    GOOS=windows GOARCH=arm64 go build -ldflags "$LDFLAGS" -o tabd-native-host-windows-arm64.exe
     
     # FreeBSD
-    GOOS=freebsd GOARCH=amd64 go build -o tabd-native-host-freebsd-amd64
-    GOOS=freebsd GOARCH=386 go build -o tabd-native-host-freebsd-386
+    GOOS=freebsd GOARCH=amd64 go build -ldflags "$LDFLAGS" -o tabd-native-host-freebsd-amd64
+    GOOS=freebsd GOARCH=386 go build -ldflags "$LDFLAGS" -o tabd-native-host-freebsd-386
     
     # OpenBSD
-    GOOS=openbsd GOARCH=amd64 go build -o tabd-native-host-openbsd-amd64
-    GOOS=openbsd GOARCH=386 go build -o tabd-native-host-openbsd-386
+    GOOS=openbsd GOARCH=amd64 go build -ldflags "$LDFLAGS" -o tabd-native-host-openbsd-amd64
+    GOOS=openbsd GOARCH=386 go build -ldflags "$LDFLAGS" -o tabd-native-host-openbsd-386
     
     # NetBSD
-    GOOS=netbsd GOARCH=amd64 go build -o tabd-native-host-netbsd-amd64
-    GOOS=netbsd GOARCH=386 go build -o tabd-native-host-netbsd-386
+    GOOS=netbsd GOARCH=amd64 go build -ldflags "$LDFLAGS" -o tabd-native-host-netbsd-amd64
+    GOOS=netbsd GOARCH=386 go build -ldflags "$LDFLAGS" -o tabd-native-host-netbsd-386
     
+    # Checksums verified by the update command
+    sha256sum tabd-native-host-*-* > SHA256SUMS
+    ASSETS="tabd-native-host-*-* SHA256SUMS"
+
+    # Binaries with a public key built in refuse releases without a signature
+    if [ -n "$SIGNING_KEY_FILE" ]; then
+        openssl pkeyutl -sign -rawin -inkey "$SIGNING_KEY_FILE" -in SHA256SUMS | openssl base64 -A > SHA256SUMS.sig
+        ASSETS="$ASSETS SHA256SUMS.sig"
+        echo "Signed SHA256SUMS"
+    elif [ -n "$UPDATE_PUBLIC_KEY" ]; then
+        echo "UPDATE_PUBLIC_KEY is set without UPDATE_SIGNING_KEY, so SHA256SUMS cannot be signed" >&2
+        exit 1
+    fi
+
+    # RELEASE_TAG uploads the binaries, checksums and signature to that
+    # GitHub release
+    if [ -n "$RELEASE_TAG" ]; then
+        gh release upload "$RELEASE_TAG" $ASSETS --repo iann0036/tabd-extension --clobber
+        echo "Uploaded release assets to $RELEASE_TAG"
+    fi
+
     echo "Cross-platform builds complete"
 fi
 
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+)
+
+const (
+	// maxMessageSize is the largest native messaging frame sent, and the
+	// default limit for incoming frames. Chrome caps host-to-browser messages
+	// at 1MB.
+	maxMessageSize = protocol.MaxFrameSize
+
+	// minMessageSize is the smallest frame limit a peer may ask for in its
+	// hello message
+	minMessageSize = 64 * 1024
+
+	// maxTransferSize bounds the reassembled size of a chunked message
+	maxTransferSize = 64 * 1024 * 1024
+
+	// maxOpenTransfers and maxBufferedSize bound the incomplete transfers a
+	// session may hold at once, and the bytes buffered across them
+	maxOpenTransfers = 4
+	maxBufferedSize  = 2 * maxTransferSize
+
+	// transferTimeout discards incomplete transfers that stop receiving chunks
+	transferTimeout = 5 * time.Minute
+)
+
+// Chunk carries one base64 encoded piece of a message too large for a single
+// native messaging frame
+type Chunk = protocol.Chunk
+
+// chunkTransfer is a partially received chunked message
+type chunkTransfer struct {
+	data     []byte
+	nextSeq  int
+	lastSeen time.Time
+}
+
+// chunkAssembler reassembles incoming chunked messages
+type chunkAssembler struct {
+	mu        sync.Mutex
+	transfers map[string]*chunkTransfer
+
+	// buffered is the size of the data held across all transfers
+	buffered int
+}
+
+// newChunkAssembler creates an empty reassembly buffer
+func newChunkAssembler() *chunkAssembler {
+	return &chunkAssembler{transfers: make(map[string]*chunkTransfer)}
+}
+
+// add appends a chunk to its transfer and returns the complete message once
+// the final chunk arrives, or nil while more chunks are expected
+func (a *chunkAssembler) add(chunk *Chunk) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire()
+
+	if chunk.TransferID == "" {
+		return nil, invalidRequestf("chunk is missing a transfer id")
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(chunk.Payload)
+	if err != nil {
+		a.drop(chunk.TransferID)
+		return nil, invalidRequestf("invalid chunk payload: %v", err)
+	}
+
+	transfer, ok := a.transfers[chunk.TransferID]
+	if !ok {
+		if len(a.transfers) >= maxOpenTransfers {
+			return nil, &codedError{
+				code: codeTooLarge,
+				err:  fmt.Errorf("too many transfers in progress (at most %d)", maxOpenTransfers),
+			}
+		}
+		transfer = &chunkTransfer{}
+		a.transfers[chunk.TransferID] = transfer
+	}
+
+	if chunk.Seq != transfer.nextSeq {
+		a.drop(chunk.TransferID)
+		return nil, invalidRequestf("unexpected chunk %d for transfer %s (expected %d)", chunk.Seq, chunk.TransferID, transfer.nextSeq)
+	}
+
+	if len(transfer.data)+len(payload) > maxTransferSize {
+		a.drop(chunk.TransferID)
+		return nil, &codedError{
+			code: codeTooLarge,
+			err:  fmt.Errorf("transfer %s exceeds maximum size of %d bytes", chunk.TransferID, maxTransferSize),
+		}
+	}
+	if a.buffered+len(payload) > maxBufferedSize {
+		a.drop(chunk.TransferID)
+		return nil, &codedError{
+			code: codeTooLarge,
+			err:  fmt.Errorf("transfers in progress exceed %d bytes in total", maxBufferedSize),
+		}
+	}
+
+	transfer.data = append(transfer.data, payload...)
+	transfer.nextSeq++
+	transfer.lastSeen = time.Now()
+	a.buffered += len(payload)
+
+	if !chunk.Final {
+		return nil, nil
+	}
+
+	a.drop(chunk.TransferID)
+	return transfer.data, nil
+}
+
+// drop discards a transfer and releases its buffered data. Callers must
+// hold a.mu.
+func (a *chunkAssembler) drop(id string) {
+	if transfer, ok := a.transfers[id]; ok {
+		a.buffered -= len(transfer.data)
+		delete(a.transfers, id)
+	}
+}
+
+// expire drops transfers that have not received a chunk recently. Callers
+// must hold a.mu.
+func (a *chunkAssembler) expire() {
+	for id, transfer := range a.transfers {
+		if time.Since(transfer.lastSeen) > transferTimeout {
+			a.drop(id)
+		}
+	}
+}
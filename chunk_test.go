@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+// testChunk encodes a chunk of a transfer
+func testChunk(id string, seq int, final bool, payload string) *Chunk {
+	return &Chunk{TransferID: id, Seq: seq, Final: final, Payload: base64.StdEncoding.EncodeToString([]byte(payload))}
+}
+
+func TestChunkAssemblerOpenTransfers(t *testing.T) {
+	a := newChunkAssembler()
+	for i := 0; i < maxOpenTransfers; i++ {
+		if _, err := a.add(testChunk(fmt.Sprint(i), 0, false, "part")); err != nil {
+			t.Fatalf("transfer %d: %v", i, err)
+		}
+	}
+
+	_, err := a.add(testChunk("one too many", 0, false, "part"))
+	if code := errorCode(err); code != codeTooLarge {
+		t.Fatalf("transfer beyond the limit = %v, want %s", err, codeTooLarge)
+	}
+
+	// Completing a transfer frees its slot and its buffered data
+	complete, err := a.add(testChunk("0", 1, true, "s"))
+	if err != nil || string(complete) != "parts" {
+		t.Fatalf("final chunk = %q, %v", complete, err)
+	}
+	if _, err := a.add(testChunk("next", 0, false, "part")); err != nil {
+		t.Fatalf("transfer after one completed: %v", err)
+	}
+	if want := maxOpenTransfers * len("part"); a.buffered != want {
+		t.Fatalf("buffered = %d, want %d", a.buffered, want)
+	}
+}
+
+func TestChunkAssemblerDropReleasesBuffer(t *testing.T) {
+	a := newChunkAssembler()
+	if _, err := a.add(testChunk("transfer", 0, false, "part")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.add(testChunk("transfer", 2, false, "part")); err == nil {
+		t.Fatal("out of order chunk accepted")
+	}
+	if a.buffered != 0 || len(a.transfers) != 0 {
+		t.Fatalf("failed transfer left %d bytes in %d transfers", a.buffered, len(a.transfers))
+	}
+}
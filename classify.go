@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Content kinds an entry is classified as
+const (
+	kindText     = "text"
+	kindURL      = "url"
+	kindEmail    = "email"
+	kindJSON     = "json"
+	kindMarkdown = "markdown"
+	kindCode     = "code"
+	kindImage    = "image"
+	kindBinary   = "binary"
+)
+
+// contentKinds lists the valid kinds, for validating filters
+var contentKinds = []string{kindText, kindURL, kindEmail, kindJSON, kindMarkdown, kindCode, kindImage, kindBinary}
+
+// codePatterns are line shapes common in source code and rare in prose
+var codePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[;{}]\s*$`),
+	regexp.MustCompile(`^\s*((func|def|class|import|from|package|return|const|let|var|public|private)\b|#include|(if|for|while)\s*\()`),
+	regexp.MustCompile(`(=>|:=|==|!=|&&|\|\|)`),
+	regexp.MustCompile(`^(\t|    )\S`),
+}
+
+// markdownPatterns are line shapes typical of Markdown
+var markdownPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^#{1,6} \S`),
+	regexp.MustCompile(`^\s*([-*+]|\d+\.) \S`),
+	regexp.MustCompile(`\[[^\]]+\]\([^)]+\)`),
+	regexp.MustCompile(`(\*\*|__)\S.*\S(\*\*|__)`),
+	regexp.MustCompile("`[^`]+`"),
+	regexp.MustCompile(`^> \S`),
+	regexp.MustCompile(`^\|.*\|\s*$`),
+}
+
+// languagePatterns guess the language of code. Each language scores a point
+// per pattern found, and the best score wins.
+var languagePatterns = map[string][]*regexp.Regexp{
+	"go": {
+		regexp.MustCompile(`(?m)^package \w+$`),
+		regexp.MustCompile(`\bfunc (\(\w+ \*?\w+\) )?\w+\(`),
+		regexp.MustCompile(`:=`),
+		regexp.MustCompile(`\bfmt\.\w+\(`),
+		regexp.MustCompile(`\bif err != nil\b`),
+	},
+	"python": {
+		regexp.MustCompile(`(?m)^\s*def \w+\(.*\):\s*$`),
+		regexp.MustCompile(`(?m)^\s*(import \w+|from [\w.]+ import)`),
+		regexp.MustCompile(`(?m)^\s*(if|elif|for|while|with|class) .*:\s*$`),
+		regexp.MustCompile(`\bself\.\w+`),
+		regexp.MustCompile(`\bprint\(`),
+	},
+	"javascript": {
+		regexp.MustCompile(`\b(const|let) \w+ = `),
+		regexp.MustCompile(`=>`),
+		regexp.MustCompile(`\bfunction\s*\w*\(`),
+		regexp.MustCompile(`\bconsole\.\w+\(`),
+		regexp.MustCompile(`\b(require\(|module\.exports|document\.|window\.)`),
+	},
+	"typescript": {
+		regexp.MustCompile(`\b(interface|type) \w+ (=|\{)`),
+		regexp.MustCompile(`\w+: (string|number|boolean|any)\b`),
+		regexp.MustCompile(`\bexport (const|function|class|interface|type)\b`),
+	},
+	"java": {
+		regexp.MustCompile(`\bpublic (static )?(class|void|int|String)\b`),
+		regexp.MustCompile(`\bSystem\.out\.print`),
+		regexp.MustCompile(`\bprivate (final )?\w+ \w+;`),
+		regexp.MustCompile(`@Override\b`),
+	},
+	"c": {
+		regexp.MustCompile(`(?m)^#include [<"]`),
+		regexp.MustCompile(`\bint main\(`),
+		regexp.MustCompile(`\bprintf\(`),
+		regexp.MustCompile(`\b(malloc|sizeof)\(`),
+	},
+	"rust": {
+		regexp.MustCompile(`\bfn \w+\(`),
+		regexp.MustCompile(`\blet mut\b`),
+		regexp.MustCompile(`\b(println!|vec!)\(`),
+		regexp.MustCompile(`\b(impl|pub fn|use \w+::)\b`),
+	},
+	"shell": {
+		regexp.MustCompile(`^#!/bin/(ba|z)?sh`),
+		regexp.MustCompile(`(?m)^\s*(echo|export|cd|sudo|apt|brew|curl) `),
+		regexp.MustCompile(`\$\{?\w+\}?`),
+		regexp.MustCompile(`\bfi$|\bdone$|\besac$`),
+	},
+	"sql": {
+		regexp.MustCompile(`(?i)\bselect\b.+\bfrom\b`),
+		regexp.MustCompile(`(?i)\b(insert into|update \w+ set|delete from|create table)\b`),
+		regexp.MustCompile(`(?i)\b(where|group by|order by|join)\b`),
+	},
+	"html": {
+		regexp.MustCompile(`(?i)<(!doctype|html|head|body|div|span|p|a) ?[^>]*>`),
+		regexp.MustCompile(`</\w+>`),
+	},
+	"css": {
+		regexp.MustCompile(`(?m)^\s*[.#]?[\w-]+( [\w.#-]+)* \{\s*$`),
+		regexp.MustCompile(`(?m)^\s*[\w-]+: [^;]+;\s*$`),
+	},
+}
+
+// classifyContent returns the kind of an entry and, for code, a guess at its
+// language. A kind the extension supplies is kept if it is one of the known
+// kinds, so it can label content the host cannot tell apart.
+func classifyContent(data *ClipboardData) (kind, language string) {
+	if data.Kind != "" && validKind(strings.ToLower(data.Kind)) {
+		kind = strings.ToLower(data.Kind)
+	} else {
+		kind = detectKind(data)
+	}
+	if kind == kindCode {
+		language = strings.ToLower(data.Language)
+		if language == "" {
+			language = guessLanguage(data.Text)
+		}
+	}
+	return kind, language
+}
+
+// validKind reports whether kind is one of the known content kinds
+func validKind(kind string) bool {
+	for _, known := range contentKinds {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
+
+// detectKind guesses what an entry holds: a single URL or email address, a
+// JSON document, Markdown, source code or plain text
+func detectKind(data *ClipboardData) string {
+	if data.Text == "" && data.Data != "" {
+		if data.IsImage() {
+			return kindImage
+		}
+		return kindBinary
+	}
+
+	text := strings.TrimSpace(data.Text)
+	if text != "" && !strings.ContainsAny(text, " \t\n") {
+		if parsed, err := url.Parse(text); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "" {
+			return kindURL
+		}
+		if address, err := mail.ParseAddress(strings.TrimPrefix(text, "mailto:")); err == nil && address.Name == "" {
+			return kindEmail
+		}
+	}
+
+	if (strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[")) && json.Valid([]byte(text)) {
+		return kindJSON
+	}
+
+	// Fenced blocks are Markdown even when they hold code
+	if strings.Contains(text, "```") {
+		return kindMarkdown
+	}
+
+	// Both need several lines with the right shapes; a single line with a
+	// semicolon or a dash is usually prose
+	lines := strings.Split(text, "\n")
+	if len(lines) < 2 {
+		return kindText
+	}
+	code := countMatchingLines(lines, codePatterns)
+	markdown := countMatchingLines(lines, markdownPatterns)
+	switch {
+	case code*2 >= len(lines) && code > markdown:
+		return kindCode
+	case markdown*3 >= len(lines):
+		return kindMarkdown
+	default:
+		return kindText
+	}
+}
+
+// countMatchingLines counts the lines matching any of the patterns
+func countMatchingLines(lines []string, patterns []*regexp.Regexp) int {
+	count := 0
+	for _, line := range lines {
+		for _, pattern := range patterns {
+			if pattern.MatchString(line) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// guessLanguage returns the language whose patterns best match the code, or
+// "" if none match
+func guessLanguage(text string) string {
+	best, bestScore := "", 0
+	for language, patterns := range languagePatterns {
+		score := 0
+		for _, pattern := range patterns {
+			if pattern.MatchString(text) {
+				score++
+			}
+		}
+		// Ties go to the alphabetically first language, so the guess is
+		// stable across runs
+		if score > bestScore || (score == bestScore && score > 0 && language < best) {
+			best, bestScore = language, score
+		}
+	}
+	return best
+}
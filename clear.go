@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// parseAge parses a duration such as "30d", "2w" or "12h"
+func parseAge(value string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.ParseFloat(number, 64)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid duration %q (use e.g. 30d, 2w or 12h)", value)
+	}
+	return duration, nil
+}
+
+// wipeFile overwrites a file with random data before removing it. This is
+// best effort: journaling filesystems and SSD wear levelling may still keep
+// older copies of the blocks.
+func wipeFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err == nil {
+		_, err = io.CopyN(file, rand.Reader, info.Size())
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to overwrite %s: %v", path, err)
+	}
+
+	return os.Remove(path)
+}
+
+// clearHistory removes history entries older than the given age, or every
+// entry when all is set, along with the latest clipboard copy if it falls in
+// the cleared range
+func (t *TabdNativeHost) clearHistory(olderThan time.Duration, all, wipe bool) (int, error) {
+	var before int64
+	if !all {
+		if olderThan <= 0 {
+			return 0, errors.New("specify an age to clear entries before, or all")
+		}
+		before = time.Now().Add(-olderThan).UnixMilli()
+	}
+
+	removed, err := t.historyStore().Clear(before, wipe)
+	if err != nil {
+		return removed, fmt.Errorf("failed to clear history: %v", err)
+	}
+	t.broadcastEntriesDeleted("", removed, "cleared")
+
+	for _, storage := range t.latestStorages() {
+		latest, err := latestIn(context.Background(), storage)
+		if err != nil || (!all && latest.Timestamp >= before) {
+			continue
+		}
+		remove := storage.Delete
+		if wipe || latest.Incognito {
+			remove = func(key string) error { return wipeKey(storage, key) }
+		}
+		if err := remove("latest_clipboard"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("failed to remove latest clipboard data: %v", err)
+		}
+	}
+
+	return removed, nil
+}
+
+// handleClear removes history entries older than msg.Before, or all entries
+func (t *TabdNativeHost) handleClear(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	var olderThan time.Duration
+	if msg.Before != "" {
+		var err error
+		if olderThan, err = parseAge(msg.Before); err != nil {
+			return "", nil, invalidRequestf("%v", err)
+		}
+	}
+
+	if olderThan <= 0 && !msg.All {
+		return "", nil, invalidRequestf("specify an age to clear entries before, or all")
+	}
+
+	removed, err := t.clearHistory(olderThan, msg.All, msg.Wipe)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("Cleared %d history entries", removed), map[string]int{"removed": removed}, nil
+}
+
+// newClearCommand creates the clear command, which deletes clipboard history
+// entries from the command line
+func newClearCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clear",
+		Short: "Delete clipboard history entries",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	beforeFlag := flags.String("before", "", "delete entries older than this age (e.g. 30d, 2w, 12h)")
+	all := flags.Bool("all", false, "delete every entry")
+	wipe := flags.Bool("wipe", false, "overwrite stored contents before deleting them")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		var olderThan time.Duration
+		if *beforeFlag != "" {
+			var err error
+			if olderThan, err = parseAge(*beforeFlag); err != nil {
+				return err
+			}
+		}
+		if *all == (*beforeFlag != "") {
+			return errors.New("specify exactly one of --before or --all")
+		}
+
+		removed, err := host.clearHistory(olderThan, *all, *wipe)
+		if err != nil {
+			return err
+		}
+
+		notef("Cleared %d history entries\n", removed)
+		return nil
+	})
+	return cmd
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+// cliCommands returns the CLI subcommands in the order shown by help
+func cliCommands() []*cobra.Command {
+	return []*cobra.Command{
+		newVersionCommand(),
+		newStatusCommand(),
+		newGetClipboardCommand(),
+		newShareCommand(),
+		newQRCommand(),
+		newPasteCommand(),
+		newPickCommand(),
+		newSearchCommand(),
+		newPinCommand(),
+		newMergeCommand(),
+		newDiffCommand(),
+		newTagCommand(),
+		newClearCommand(),
+		newUnlockCommand(),
+		newLockCommand(),
+		newPassphraseCommand(),
+		newBenchKDFCommand(),
+		newRotateKeyCommand(),
+		newExportCommand(),
+		newImportCommand(),
+		newBackupCommand(),
+		newRestoreCommand(),
+		newAuditCommand(),
+		newGetSystemCommand(),
+		newSessionsCommand(),
+		newHistoryCommand(),
+		newSnippetCommand(),
+		newTrayCommand(),
+		newSyncCommand(),
+		newPairCommand(),
+		newDaemonCommand(),
+		newWatchCommand(),
+		newServeCommand(),
+		newServiceCommand(),
+		newUpdateCommand(),
+		newInstallCommand(),
+		newUninstallCommand(),
+		newDoctorCommand(),
+		newTestClientCommand(),
+	}
+}
+
+// newRootCommand creates the CLI, with the global flags shared by every
+// subcommand
+func newRootCommand() *cobra.Command {
+	cobra.EnableCommandSorting = false
+
+	root := &cobra.Command{
+		Use:           "tabd-native-host",
+		Short:         "Tab'd native messaging host and clipboard history CLI",
+		Long:          "Without a command, runs as the native messaging host for the browser extension.",
+		Version:       versionString(),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	root.SetVersionTemplate("{{.Version}}\n")
+	root.SetUsageTemplate(root.UsageTemplate() + "{{if not .HasParent}}\n" + exitCodeUsage() + "{{end}}")
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return fmt.Errorf("%w; run %s --help for usage", err, cmd.CommandPath())
+	})
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&configPathOverride, "config", configPathOverride, "config file to use (default ~/.tabd/config.json, or TABD_CONFIG)")
+	flags.StringVar(&profileOverride, "profile", profileOverride, "profile whose storage directory to use, or TABD_PROFILE")
+	flags.BoolVar(&jsonOutput, "json", jsonOutput, "print JSON from commands that otherwise print text")
+	flags.BoolVar(&quiet, "quiet", quiet, "print no notices or error messages; failures are reported by the exit code")
+
+	root.AddCommand(cliCommands()...)
+	root.InitDefaultHelpCmd()
+	root.InitDefaultCompletionCmd()
+	return root
+}
+
+// exitCodeUsage lists the exit codes for the root command's help
+func exitCodeUsage() string {
+	var usage strings.Builder
+	fmt.Fprintln(&usage, "Exit Codes:")
+	fmt.Fprintf(&usage, "  %d  success\n", 0)
+	fmt.Fprintf(&usage, "  %d  any other failure\n", exitError)
+	fmt.Fprintf(&usage, "  %d  entry, register or file not found\n", exitNotFound)
+	fmt.Fprintf(&usage, "  %d  storage is locked\n", exitLocked)
+	fmt.Fprintf(&usage, "  %d  stored data is corrupt or cannot be decrypted\n", exitCorrupt)
+	fmt.Fprintf(&usage, "  %d  permission denied\n", exitPermission)
+	fmt.Fprintf(&usage, "  %d  update --check found a newer release\n", exitUpdateAvailable)
+	return usage.String()
+}
+
+// nativeMessagingArgs reports whether the arguments are those a browser
+// starts the native host with, rather than a CLI command, and returns them
+// without the global flags. Browsers pass the caller origin (and on Windows a
+// parent window handle), so anything that is not a command falls through to
+// native messaging mode.
+func nativeMessagingArgs(root *cobra.Command, args []string) ([]string, bool) {
+	if cmd, _, err := root.Find(args); err == nil && cmd != root {
+		return nil, false
+	}
+
+	flags := pflag.NewFlagSet(root.Name(), pflag.ContinueOnError)
+	flags.ParseErrorsAllowlist.UnknownFlags = true
+	flags.SetOutput(io.Discard)
+	flags.AddFlagSet(root.PersistentFlags())
+	help := flags.BoolP("help", "h", false, "")
+	version := flags.BoolP("version", "v", false, "")
+	if err := flags.Parse(args); err != nil || *help || *version {
+		return nil, false
+	}
+
+	positional := flags.Args()
+	if len(positional) == 0 {
+		return positional, true
+	}
+	if strings.HasPrefix(positional[0], cobra.ShellCompRequestCmd) {
+		return nil, false
+	}
+	// Browsers never start the host from a terminal, so a word typed there
+	// is a mistyped command rather than a caller origin
+	if term.IsTerminal(int(os.Stdin.Fd())) && !strings.ContainsAny(positional[0], ":/\\@{") {
+		return nil, false
+	}
+	return positional, true
+}
+
+// withHost wraps a subcommand that needs an initialised native host
+func withHost(run func(host *TabdNativeHost, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(_ *cobra.Command, args []string) error {
+		config, err := LoadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		promptKeyringUnlock(config)
+
+		host, err := NewTabdNativeHost(config)
+		if err != nil {
+			return fmt.Errorf("failed to create native host: %w", err)
+		}
+		defer host.Close()
+
+		if err := host.lockError(); err != nil {
+			return err
+		}
+
+		host.audit.SetActor("cli")
+		return run(host, args)
+	}
+}
+
+// promptKeyringUnlock offers to unlock a locked Secret Service collection
+// when the CLI runs in a terminal, rather than quietly keeping keys in files
+func promptKeyringUnlock(config *Config) {
+	if os.Getenv("TABD_DISABLE_KEYRING") != "" || config.StorageBackend == "dpapi" || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return
+	}
+	if state, _ := diagnoseKeyring(); state != keyringLocked {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "The system keyring is locked; enter its password in the prompt to use it")
+	if err := unlockKeyring(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v; keys are kept in files until it is unlocked\n", err)
+	}
+}
+
+// jsonOutput is set by the global --json flag, asking commands that print
+// text for JSON instead
+var jsonOutput bool
+
+// quiet is set by the global --quiet flag, silencing notices and error
+// messages so scripts can rely on the exit code alone
+var quiet bool
+
+// notef prints an informational notice to stderr unless --quiet was given
+func notef(format string, args ...interface{}) {
+	if !quiet {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// newGetClipboardCommand creates the getclipboard command, which prints the
+// latest clipboard entry, or the entry in a register, as indented JSON, its
+// text, or its raw image bytes
+func newGetClipboardCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "getclipboard",
+		Short: "Print the latest clipboard entry as JSON",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	format := flags.String("format", "json", "output format: json, text, html, rtf, png or jpeg")
+	register := flags.String("register", "", "print the entry in this register (a-z) instead")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		if *register != "" {
+			entry, err := host.getRegister(*register)
+			if err != nil {
+				return err
+			}
+			return writeClipboardOutput(os.Stdout, &entry.ClipboardData, *format)
+		}
+
+		// Retrieve clipboard data
+		data, err := host.getClipboardData(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to retrieve clipboard data: %w", err)
+		}
+
+		return writeClipboardOutput(os.Stdout, data, *format)
+	})
+	return cmd
+}
+
+// writeClipboardOutput writes an entry in the requested CLI output format
+func writeClipboardOutput(w io.Writer, data *ClipboardData, format string) error {
+	switch format {
+	case "json":
+	case "text":
+		_, err := io.WriteString(w, data.Text)
+		return err
+	case "html", "rtf":
+		flavor := data.Flavor("text/" + format)
+		if flavor == "" {
+			return fmt.Errorf("clipboard entry has no %s flavor", format)
+		}
+		_, err := io.WriteString(w, flavor)
+		return err
+	case "png", "jpeg":
+		if data.ContentType != "image/"+format || data.Data == "" {
+			return fmt.Errorf("clipboard entry is not a %s image (content type %q)", format, data.ContentType)
+		}
+		image, err := data.Bytes()
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(image)
+		return err
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+
+	// Output as JSON
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return fmt.Errorf("failed to encode clipboard data: %v", err)
+	}
+	return nil
+}
+
+// newGetSystemCommand creates the getsystem command, which prints the current
+// OS clipboard contents as indented JSON
+func newGetSystemCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "getsystem",
+		Short: "Print the current OS clipboard as JSON",
+		Args:  cobra.NoArgs,
+	}
+	cmd.RunE = func(*cobra.Command, []string) error {
+		data, err := systemClipboardData()
+		if err != nil {
+			return fmt.Errorf("failed to read system clipboard: %v", err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			return fmt.Errorf("failed to encode clipboard data: %v", err)
+		}
+		return nil
+	}
+	return cmd
+}
+
+// newHistoryCommand creates the history command, which prints a page of
+// clipboard history as JSON lines, newest first
+func newHistoryCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List clipboard history as JSON lines, newest first",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	limit := flags.Int("limit", 20, "maximum number of entries to print (0 for all)")
+	offset := flags.Int("offset", 0, "number of newest entries to skip")
+	pinned := flags.Bool("pinned", false, "only print pinned entries")
+	tag := flags.String("tag", "", "only print entries with this tag")
+	kind := flags.String("type", "", "only print entries of this kind: "+strings.Join(contentKinds, ", "))
+	format := flags.String("format", "json", "output format: json lines, or alfred or raycast list items")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		if *limit < 0 || *offset < 0 {
+			return fmt.Errorf("--limit and --offset must not be negative")
+		}
+		if *kind != "" && !validKind(*kind) {
+			return fmt.Errorf("--type must be one of %s", strings.Join(contentKinds, ", "))
+		}
+		launcher := slices.Contains(launcherFormats, *format)
+		if *format != "json" && !launcher {
+			return fmt.Errorf("--format must be json, %s", strings.Join(launcherFormats, " or "))
+		}
+
+		entries, err := host.historyStore().Query(HistoryQuery{Pinned: *pinned, Tag: *tag, Kind: *kind, Limit: *limit, Offset: *offset})
+		if err != nil {
+			return fmt.Errorf("failed to list clipboard history: %w", err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		records := []*HistoryRecord{}
+		for _, entry := range entries {
+			data, err := host.historyStore().Get(entry.ID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping unreadable history entry %s: %v\n", entry.ID, err)
+				continue
+			}
+			record := &HistoryRecord{ID: entry.ID, Pinned: entry.Pinned, ClipboardData: *data}
+			if launcher {
+				// Entries saved without a timestamp show when they were stored
+				if record.Timestamp == 0 {
+					record.Timestamp = entry.Timestamp
+				}
+				records = append(records, record)
+				continue
+			}
+			if err := encoder.Encode(record); err != nil {
+				return fmt.Errorf("failed to encode history entry: %v", err)
+			}
+		}
+
+		// Launchers read a single document listing every item
+		if launcher {
+			return writeLauncherItems(os.Stdout, *format, records)
+		}
+		return nil
+	})
+	return cmd
+}
+
+// newPinCommand creates the pin command, which pins or unpins a history entry
+// by ID
+func newPinCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pin <id>",
+		Short: "Pin a history entry so it is never pruned or expired",
+	}
+	flags := cmd.Flags()
+	unpin := flags.Bool("unpin", false, "remove the pin instead")
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		if len(positional) != 1 {
+			return fmt.Errorf("usage: pin [--unpin] <id>")
+		}
+
+		if err := host.historyStore().Pin(positional[0], !*unpin); err != nil {
+			return fmt.Errorf("failed to update pinned entry: %w", err)
+		}
+		return nil
+	})
+	return cmd
+}
+
+// paginate returns the slice of entries selected by offset and limit, where a
+// limit of zero means no limit
+func paginate(entries []HistoryEntry, offset, limit int) []HistoryEntry {
+	if offset >= len(entries) {
+		return nil
+	}
+	entries = entries[offset:]
+	if limit > 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return entries
+}
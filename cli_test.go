@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNativeMessagingArgs(t *testing.T) {
+	t.Cleanup(func() { configPathOverride, profileOverride, jsonOutput, quiet = "", "", false, false })
+	origin := "chrome-extension://" + defaultExtensionID + "/"
+
+	tests := []struct {
+		args   []string
+		native bool
+		want   []string
+	}{
+		{args: nil, native: true, want: []string{}},
+		{args: []string{origin}, native: true, want: []string{origin}},
+		{args: []string{origin, "--parent-window=42"}, native: true, want: []string{origin}},
+		{args: []string{"/home/user/.mozilla/native-messaging-hosts/tabd.json", "tabd@example.com"}, native: true, want: []string{"/home/user/.mozilla/native-messaging-hosts/tabd.json", "tabd@example.com"}},
+		{args: []string{"--profile", "work", origin}, native: true, want: []string{origin}},
+		{args: []string{"history", "--limit", "5"}},
+		{args: []string{"--profile", "work", "pin", "abc"}},
+		{args: []string{"pin", "--unpin", "abc", "--json"}},
+		{args: []string{"help", "pin"}},
+		{args: []string{"completion", "bash"}},
+		{args: []string{"__complete", "hist"}},
+		{args: []string{"--help"}},
+		{args: []string{"--version"}},
+		{args: []string{"--profile"}},
+	}
+	for _, test := range tests {
+		args, native := nativeMessagingArgs(newRootCommand(), test.args)
+		if native != test.native {
+			t.Errorf("%q: native messaging %v, want %v", test.args, native, test.native)
+			continue
+		}
+		if native && !slices.Equal(args, test.want) {
+			t.Errorf("%q: arguments %q, want %q", test.args, args, test.want)
+		}
+	}
+}
+
+func TestGlobalFlagsAfterCommand(t *testing.T) {
+	t.Cleanup(func() { configPathOverride, profileOverride, jsonOutput, quiet = "", "", false, false })
+
+	root := newRootCommand()
+	cmd, args, err := root.Find([]string{"pin", "abc", "--profile", "work", "--unpin"})
+	if err != nil || cmd.Name() != "pin" {
+		t.Fatalf("found %v, %v; want pin", cmd, err)
+	}
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	if profileOverride != "work" {
+		t.Errorf("profile %q, want work", profileOverride)
+	}
+	if unpin, _ := cmd.Flags().GetBool("unpin"); !unpin {
+		t.Error("--unpin was not parsed")
+	}
+	if positional := cmd.Flags().Args(); !slices.Equal(positional, []string{"abc"}) {
+		t.Errorf("positional arguments %q, want [abc]", positional)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrNoClipboardTool is returned when no supported clipboard program is installed
+var ErrNoClipboardTool = errors.New("no supported system clipboard tool found")
+
+// clipboardCommand is an external program used to access the system clipboard
+type clipboardCommand struct {
+	name string
+	args []string
+}
+
+// available reports whether the program is installed
+func (c clipboardCommand) available() bool {
+	_, err := exec.LookPath(c.name)
+	return err == nil
+}
+
+// writeSystemClipboard places text on the OS clipboard using the first
+// available platform clipboard program
+func writeSystemClipboard(text string) error {
+	for _, c := range clipboardWriteCommands() {
+		if !c.available() {
+			continue
+		}
+
+		cmd := exec.Command(c.name, c.args...)
+		cmd.Stdin = strings.NewReader(text)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %v: %s", c.name, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	return ErrNoClipboardTool
+}
+
+// writeSystemClipboardImage places an image on the OS clipboard. The image is
+// both piped to the clipboard program and written to a temporary file for
+// platforms whose tools can only load images from disk.
+func writeSystemClipboardImage(contentType string, image []byte) error {
+	imageFile, err := os.CreateTemp("", "tabd-image-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary image file: %v", err)
+	}
+	defer os.Remove(imageFile.Name())
+
+	if _, err := imageFile.Write(image); err != nil {
+		imageFile.Close()
+		return fmt.Errorf("failed to write temporary image file: %v", err)
+	}
+	imageFile.Close()
+
+	for _, c := range clipboardImageWriteCommands(contentType, imageFile.Name()) {
+		if !c.available() {
+			continue
+		}
+
+		cmd := exec.Command(c.name, c.args...)
+		cmd.Stdin = bytes.NewReader(image)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %v: %s", c.name, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	return ErrNoClipboardTool
+}
+
+// writeClipboardData places an entry on the OS clipboard as an image or text
+func writeClipboardData(data *ClipboardData) error {
+	if data.IsImage() {
+		image, err := data.Bytes()
+		if err != nil {
+			return err
+		}
+		return writeSystemClipboardImage(data.ContentType, image)
+	}
+
+	if data.Flavor("text/html") != "" || data.Flavor("text/rtf") != "" {
+		return writeSystemClipboardFlavors(data)
+	}
+
+	if data.Text == "" {
+		return nil
+	}
+	return writeSystemClipboard(data.Text)
+}
+
+// readSystemClipboard returns the current OS clipboard text using the first
+// available platform clipboard program
+func readSystemClipboard() (string, error) {
+	for _, c := range clipboardReadCommands() {
+		if !c.available() {
+			continue
+		}
+
+		cmd := exec.Command(c.name, c.args...)
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("%s failed: %v: %s", c.name, err, strings.TrimSpace(stderr.String()))
+		}
+		return string(output), nil
+	}
+
+	return "", ErrNoClipboardTool
+}
+
+// systemClipboardData reads the OS clipboard and wraps it as clipboard data
+func systemClipboardData() (*ClipboardData, error) {
+	text, err := readSystemClipboard()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClipboardData{
+		Type:      "system",
+		Text:      text,
+		Timestamp: time.Now().UnixMilli(),
+	}, nil
+}
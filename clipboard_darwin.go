@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// clipboardWriteCommands returns the programs used to write the macOS clipboard
+func clipboardWriteCommands() []clipboardCommand {
+	return []clipboardCommand{
+		{name: "pbcopy"},
+	}
+}
+
+// clipboardReadCommands returns the programs used to read the macOS clipboard
+func clipboardReadCommands() []clipboardCommand {
+	return []clipboardCommand{
+		{name: "pbpaste"},
+	}
+}
+
+// clipboardImageWriteCommands returns the programs used to place an image file
+// on the macOS clipboard
+func clipboardImageWriteCommands(contentType, path string) []clipboardCommand {
+	class := "PNGf"
+	if contentType == "image/jpeg" {
+		class = "JPEG"
+	}
+	script := fmt.Sprintf("set the clipboard to (read (POSIX file %q) as «class %s»)", path, class)
+	return []clipboardCommand{
+		{name: "osascript", args: []string{"-e", script}},
+	}
+}
+
+// writeSystemClipboardFlavors places the plain text, HTML and RTF flavors of
+// an entry on the macOS clipboard together, so each app pastes the richest
+// representation it understands
+func writeSystemClipboardFlavors(data *ClipboardData) error {
+	items := []string{}
+	if text := data.Flavor("text/plain"); text != "" {
+		items = append(items, fmt.Sprintf("«class utf8»:«data utf8%s»", strings.ToUpper(hex.EncodeToString([]byte(text)))))
+	}
+	if html := data.Flavor("text/html"); html != "" {
+		items = append(items, fmt.Sprintf("«class HTML»:«data HTML%s»", strings.ToUpper(hex.EncodeToString([]byte(html)))))
+	}
+	if rtf := data.Flavor("text/rtf"); rtf != "" {
+		items = append(items, fmt.Sprintf("«class RTF »:«data RTF %s»", strings.ToUpper(hex.EncodeToString([]byte(rtf)))))
+	}
+
+	// The script is passed on stdin since large payloads exceed argument limits
+	cmd := exec.Command("osascript", "-")
+	cmd.Stdin = strings.NewReader("set the clipboard to {" + strings.Join(items, ", ") + "}")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("osascript failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// clipboardWriteCommands returns the programs used to write the clipboard on
+// Linux and BSD, preferring Wayland tools when a Wayland session is active
+func clipboardWriteCommands() []clipboardCommand {
+	commands := []clipboardCommand{}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		commands = append(commands, clipboardCommand{name: "wl-copy"})
+	}
+	return append(commands,
+		clipboardCommand{name: "xclip", args: []string{"-selection", "clipboard"}},
+		clipboardCommand{name: "xsel", args: []string{"--clipboard", "--input"}},
+	)
+}
+
+// clipboardReadCommands returns the programs used to read the clipboard on
+// Linux and BSD, preferring Wayland tools when a Wayland session is active
+func clipboardReadCommands() []clipboardCommand {
+	commands := []clipboardCommand{}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		commands = append(commands, clipboardCommand{name: "wl-paste", args: []string{"--no-newline"}})
+	}
+	return append(commands,
+		clipboardCommand{name: "xclip", args: []string{"-selection", "clipboard", "-o"}},
+		clipboardCommand{name: "xsel", args: []string{"--clipboard", "--output"}},
+	)
+}
+
+// clipboardImageWriteCommands returns the programs used to place an image,
+// piped on stdin, on the clipboard on Linux and BSD
+func clipboardImageWriteCommands(contentType, path string) []clipboardCommand {
+	commands := []clipboardCommand{}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		commands = append(commands, clipboardCommand{name: "wl-copy", args: []string{"--type", contentType}})
+	}
+	return append(commands,
+		clipboardCommand{name: "xclip", args: []string{"-selection", "clipboard", "-t", contentType}},
+	)
+}
+
+// writeSystemClipboardFlavors places the richest flavor of an entry on the
+// clipboard on Linux and BSD. xclip and wl-copy can only offer one target per
+// invocation, so HTML is preferred over RTF, which is preferred over plain text.
+func writeSystemClipboardFlavors(data *ClipboardData) error {
+	mimeType := "text/plain"
+	for _, candidate := range []string{"text/html", "text/rtf"} {
+		if data.Flavor(candidate) != "" {
+			mimeType = candidate
+			break
+		}
+	}
+	if mimeType == "text/plain" {
+		return writeSystemClipboard(data.Text)
+	}
+
+	commands := []clipboardCommand{}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		commands = append(commands, clipboardCommand{name: "wl-copy", args: []string{"--type", mimeType}})
+	}
+	commands = append(commands, clipboardCommand{name: "xclip", args: []string{"-selection", "clipboard", "-t", mimeType}})
+
+	for _, c := range commands {
+		if !c.available() {
+			continue
+		}
+
+		cmd := exec.Command(c.name, c.args...)
+		cmd.Stdin = strings.NewReader(data.Flavor(mimeType))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %v: %s", c.name, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	// Fall back to plain text when no tool can set a typed selection
+	return writeSystemClipboard(data.Text)
+}
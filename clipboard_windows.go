@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// clipboardWriteCommands returns the programs used to write the Windows clipboard.
+// PowerShell is preferred over clip.exe because it handles UTF-8 input correctly.
+func clipboardWriteCommands() []clipboardCommand {
+	return []clipboardCommand{
+		{name: "powershell.exe", args: []string{"-NoProfile", "-NonInteractive", "-Command",
+			"[Console]::InputEncoding = [Text.Encoding]::UTF8; Set-Clipboard -Value ([Console]::In.ReadToEnd())"}},
+		{name: "clip.exe"},
+	}
+}
+
+// clipboardReadCommands returns the programs used to read the Windows clipboard
+func clipboardReadCommands() []clipboardCommand {
+	return []clipboardCommand{
+		{name: "powershell.exe", args: []string{"-NoProfile", "-NonInteractive", "-Command",
+			"[Console]::OutputEncoding = [Text.Encoding]::UTF8; [Console]::Out.Write((Get-Clipboard -Raw))"}},
+	}
+}
+
+// clipboardImageWriteCommands returns the programs used to place an image file
+// on the Windows clipboard. Windows Forms requires a single-threaded apartment.
+func clipboardImageWriteCommands(contentType, path string) []clipboardCommand {
+	quoted := "'" + strings.ReplaceAll(path, "'", "''") + "'"
+	return []clipboardCommand{
+		{name: "powershell.exe", args: []string{"-NoProfile", "-NonInteractive", "-STA", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms, System.Drawing; " +
+				"[System.Windows.Forms.Clipboard]::SetImage([System.Drawing.Image]::FromFile(" + quoted + "))"}},
+	}
+}
+
+// flavorsScript reads a JSON object of flavors from stdin and places them on
+// the Windows clipboard as a single data object
+const flavorsScript = `[Console]::InputEncoding = [Text.Encoding]::UTF8
+Add-Type -AssemblyName System.Windows.Forms
+$f = [Console]::In.ReadToEnd() | ConvertFrom-Json
+$d = New-Object System.Windows.Forms.DataObject
+if ($f.text) { $d.SetData([System.Windows.Forms.DataFormats]::UnicodeText, [string]$f.text) }
+if ($f.html) { $d.SetData([System.Windows.Forms.DataFormats]::Html, [string]$f.html) }
+if ($f.rtf) { $d.SetData([System.Windows.Forms.DataFormats]::Rtf, [string]$f.rtf) }
+[System.Windows.Forms.Clipboard]::SetDataObject($d, $true)`
+
+// cfHTML wraps an HTML fragment in the CF_HTML header Windows applications
+// expect, with byte offsets into the UTF-8 encoded document
+func cfHTML(fragment string) string {
+	const header = "Version:0.9\r\nStartHTML:%010d\r\nEndHTML:%010d\r\nStartFragment:%010d\r\nEndFragment:%010d\r\n"
+	const prefix = "<html><body><!--StartFragment-->"
+	const suffix = "<!--EndFragment--></body></html>"
+
+	headerLength := len(fmt.Sprintf(header, 0, 0, 0, 0))
+	startHTML := headerLength
+	startFragment := startHTML + len(prefix)
+	endFragment := startFragment + len(fragment)
+	endHTML := endFragment + len(suffix)
+
+	return fmt.Sprintf(header, startHTML, endHTML, startFragment, endFragment) + prefix + fragment + suffix
+}
+
+// writeSystemClipboardFlavors places the plain text, HTML and RTF flavors of
+// an entry on the Windows clipboard together, so each app pastes the richest
+// representation it understands
+func writeSystemClipboardFlavors(data *ClipboardData) error {
+	flavors := map[string]string{"text": data.Flavor("text/plain")}
+	if html := data.Flavor("text/html"); html != "" {
+		flavors["html"] = cfHTML(html)
+	}
+	if rtf := data.Flavor("text/rtf"); rtf != "" {
+		flavors["rtf"] = rtf
+	}
+
+	payload, err := json.Marshal(flavors)
+	if err != nil {
+		return fmt.Errorf("failed to marshal clipboard flavors: %v", err)
+	}
+
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-STA", "-Command", flavorsScript)
+	cmd.Stdin = strings.NewReader(string(payload))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("powershell.exe failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
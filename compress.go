@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+const (
+	// compressThreshold is the plaintext size from which blobs are compressed
+	// before encryption
+	compressThreshold = 4 * 1024
+
+	// compressedMagic starts a compressed plaintext. Stored JSON and tokens
+	// never begin with a NUL byte, so uncompressed blobs are told apart.
+	compressedMagic = "\x00gz1"
+
+	// maxDecompressedSize bounds how far a compressed blob may expand
+	maxDecompressedSize = 2 * maxTransferSize
+)
+
+// compressPayload gzips data of at least compressThreshold bytes behind the
+// compressed header, returning data unchanged if it is small or would not
+// get any smaller
+func compressPayload(data []byte) []byte {
+	if len(data) < compressThreshold {
+		return data
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(compressedMagic)
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return data
+	}
+	if err := writer.Close(); err != nil {
+		return data
+	}
+
+	if buf.Len() >= len(data) {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// decompressPayload reverses compressPayload, passing through plaintexts
+// without the compressed header
+func decompressPayload(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(compressedMagic)) {
+		return data, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data[len(compressedMagic):]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %v", err)
+	}
+	defer reader.Close()
+
+	plaintext, err := io.ReadAll(io.LimitReader(reader, maxDecompressedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %v", err)
+	}
+	if len(plaintext) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed data exceeds %d bytes", maxDecompressedSize)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds user settings loaded from ~/.tabd/config.json. Environment
+// variables take precedence over the file.
+type Config struct {
+	// StorageDir is where encrypted data, logs and sockets are kept
+	StorageDir string `json:"storageDir,omitempty"`
+
+	// MaxHistory caps the number of clipboard history entries
+	MaxHistory int `json:"maxHistory,omitempty"`
+
+	// LogLevel is the minimum level written to native-host.log: debug,
+	// info, warn, error or off
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// LogFormat selects text or json log lines
+	LogFormat string `json:"logFormat,omitempty"`
+
+	// LogMaxSize is the size in megabytes at which the log is rotated, and
+	// LogMaxBackups the number of rotated files kept
+	LogMaxSize    int `json:"logMaxSize,omitempty"`
+	LogMaxBackups int `json:"logMaxBackups,omitempty"`
+
+	// StorageBackend selects the encryption backend: auto, file, sqlite or
+	// dpapi
+	StorageBackend string `json:"storageBackend,omitempty"`
+
+	// DisableDedup stores every save as a new history entry, even when it
+	// repeats the content of the newest entry
+	DisableDedup bool `json:"disableDedup,omitempty"`
+
+	// BlockedOrigins lists pages whose clipboard data is never stored, and
+	// AllowedOrigins, when set, the only pages whose data is. Entries are
+	// host names, which also cover their subdomains, or exact origins such
+	// as https://bank.example.com.
+	BlockedOrigins []string `json:"blockedOrigins,omitempty"`
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+
+	// SensitiveAction is applied to content matching a sensitive pattern:
+	// tag (the default), redact, refuse or off
+	SensitiveAction string `json:"sensitiveAction,omitempty"`
+
+	// SensitivePatterns adds named regular expressions to the built-in
+	// credit card, AWS key, JWT and password detectors
+	SensitivePatterns map[string]string `json:"sensitivePatterns,omitempty"`
+
+	// Retention is how long unpinned history entries are kept, e.g. "30d".
+	// Entries are kept until the history cap is reached when unset.
+	Retention string `json:"retention,omitempty"`
+
+	// MaxMessageSize is the largest incoming native messaging frame accepted
+	MaxMessageSize int `json:"maxMessageSize,omitempty"`
+
+	// MaxTextLength caps the text stored for one entry, e.g. "256KB", or is
+	// "off". Longer text is truncated and marked unless saved with save_full.
+	MaxTextLength string `json:"maxTextLength,omitempty"`
+
+	// Webhooks are posted each clipboard entry as it is saved
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+
+	// Hooks are commands run with the entry on stdin on save or retrieve
+	Hooks []HookConfig `json:"hooks,omitempty"`
+
+	// Sync shares history with other machines through an encrypted relay
+	Sync *SyncConfig `json:"sync,omitempty"`
+
+	// Share is the paste service the share command uploads entries to
+	Share *ShareConfig `json:"share,omitempty"`
+
+	// Incognito keeps entries in memory only, wiping them after a TTL
+	Incognito *IncognitoConfig `json:"incognito,omitempty"`
+
+	// Cipher is the AEAD new data is encrypted with: aes-gcm (the default)
+	// or xchacha20-poly1305
+	Cipher string `json:"cipher,omitempty"`
+
+	// KDF sets the Argon2id parameters new data is encrypted with
+	KDF *KDFConfig `json:"kdf,omitempty"`
+
+	// LANPort is the TCP port daemon --lan accepts paired peers on
+	LANPort int `json:"lanPort,omitempty"`
+
+	// RateLimit is the sustained number of messages per second each session
+	// may send, or -1 for no limit, and RateBurst how many may arrive at once
+	RateLimit float64 `json:"rateLimit,omitempty"`
+	RateBurst int     `json:"rateBurst,omitempty"`
+
+	// Quota caps the size of the storage files, e.g. "100MB". The oldest
+	// unpinned history entries are evicted when a save exceeds it.
+	Quota string `json:"quota,omitempty"`
+
+	// IdleTimeout ends the native host after this long without a message from
+	// the browser, e.g. "30m". The host runs until stdin closes when unset.
+	IdleTimeout string `json:"idleTimeout,omitempty"`
+
+	// RequestTimeout bounds how long a message may take to handle, e.g.
+	// "30s", and SaveTimeout how long a save may take. Storage work still
+	// outstanding at the deadline is abandoned before its next write.
+	RequestTimeout string `json:"requestTimeout,omitempty"`
+	SaveTimeout    string `json:"saveTimeout,omitempty"`
+
+	// UnfurlLinks fetches the title and favicon of copied URLs so history
+	// shows readable link entries. It is off by default as it contacts the
+	// linked site.
+	UnfurlLinks bool `json:"unfurlLinks,omitempty"`
+
+	// AllowedExtensions lists the browser extensions the native host serves,
+	// as IDs or origins such as chrome-extension://<id>/, or "*" for any.
+	// Defaults to the Tab'd extension.
+	AllowedExtensions []string `json:"allowedExtensions,omitempty"`
+
+	// Permissions restricts extensions to the listed actions, keyed by
+	// extension ID or origin, or "*" for extensions without an entry.
+	// Extensions that are not restricted, and local clients such as the CLI,
+	// may send any action.
+	Permissions map[string][]string `json:"permissions,omitempty"`
+
+	// AuditLog records who read, wrote or deleted each stored key in a
+	// hash-chained log in the storage directory
+	AuditLog bool `json:"auditLog,omitempty"`
+
+	// Notifications lists the events shown as desktop notifications: saved,
+	// blocked or all
+	Notifications []string `json:"notifications,omitempty"`
+}
+
+// IdleTimeoutPeriod returns the parsed idle timeout, or zero if the host
+// never exits for being idle
+func (c *Config) IdleTimeoutPeriod() time.Duration {
+	if c.IdleTimeout == "" {
+		return 0
+	}
+	timeout, _ := parseAge(c.IdleTimeout)
+	return timeout
+}
+
+// RequestTimeoutFor returns how long an action may take to handle
+func (c *Config) RequestTimeoutFor(action string) time.Duration {
+	setting, fallback := c.RequestTimeout, defaultRequestTimeout
+	if action == "save" || action == "save_full" {
+		setting, fallback = c.SaveTimeout, defaultSaveTimeout
+	}
+	if setting == "" {
+		return fallback
+	}
+	timeout, _ := parseAge(setting)
+	return timeout
+}
+
+// QuotaBytes returns the parsed storage quota, or zero if there is none
+func (c *Config) QuotaBytes() int64 {
+	if c.Quota == "" {
+		return 0
+	}
+	quota, _ := parseSize(c.Quota)
+	return quota
+}
+
+// MaxTextBytes returns the longest text stored for one entry, or zero if
+// text is never truncated
+func (c *Config) MaxTextBytes() int {
+	switch c.MaxTextLength {
+	case "":
+		return defaultMaxTextLength
+	case "off":
+		return 0
+	}
+	limit, _ := parseSize(c.MaxTextLength)
+	return int(limit)
+}
+
+// configPathOverride is set by the global --config flag
+var configPathOverride string
+
+// defaultTabdDir returns ~/.tabd
+func defaultTabdDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %v", err)
+	}
+	return filepath.Join(homeDir, ".tabd"), nil
+}
+
+// configPath returns the config file location from --config, TABD_CONFIG or
+// the default, and whether it was chosen explicitly
+func configPath() (string, bool, error) {
+	if configPathOverride != "" {
+		return configPathOverride, true, nil
+	}
+	if path := os.Getenv("TABD_CONFIG"); path != "" {
+		return path, true, nil
+	}
+
+	tabdDir, err := defaultTabdDir()
+	if err != nil {
+		return "", false, err
+	}
+	return filepath.Join(tabdDir, "config.json"), false, nil
+}
+
+// LoadConfig reads the config file, applies environment overrides and fills
+// in defaults. A missing default config file is not an error.
+func LoadConfig() (*Config, error) {
+	path, explicit, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	configData, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || explicit {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	} else if err := json.Unmarshal(configData, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	config.applyEnv()
+
+	if err := config.applyDefaults(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// applyEnv overrides config values with environment variables
+func (c *Config) applyEnv() {
+	if value := os.Getenv("TABD_MAX_HISTORY"); value != "" {
+		if maxHistory, err := strconv.Atoi(value); err == nil && maxHistory > 0 {
+			c.MaxHistory = maxHistory
+		}
+	}
+	if value := os.Getenv("TABD_STORAGE"); value != "" {
+		c.StorageBackend = value
+	}
+	if value := os.Getenv("TABD_LOG_LEVEL"); value != "" {
+		c.LogLevel = value
+	}
+	if value := os.Getenv("TABD_LOG_FORMAT"); value != "" {
+		c.LogFormat = value
+	}
+	if os.Getenv("TABD_DISABLE_DEDUP") != "" {
+		c.DisableDedup = true
+	}
+	if value := os.Getenv("TABD_BLOCKED_ORIGINS"); value != "" {
+		c.BlockedOrigins = splitList(value)
+	}
+	if value := os.Getenv("TABD_ALLOWED_ORIGINS"); value != "" {
+		c.AllowedOrigins = splitList(value)
+	}
+	if value := os.Getenv("TABD_SENSITIVE_ACTION"); value != "" {
+		c.SensitiveAction = value
+	}
+	if value := os.Getenv("TABD_RETENTION"); value != "" {
+		c.Retention = value
+	}
+	if value := os.Getenv("TABD_QUOTA"); value != "" {
+		c.Quota = value
+	}
+	if value := os.Getenv("TABD_MAX_MESSAGE_SIZE"); value != "" {
+		if size, err := parseSize(value); err == nil {
+			c.MaxMessageSize = int(size)
+		}
+	}
+	if value := os.Getenv("TABD_MAX_TEXT_LENGTH"); value != "" {
+		c.MaxTextLength = value
+	}
+	if value := os.Getenv("TABD_IDLE_TIMEOUT"); value != "" {
+		c.IdleTimeout = value
+	}
+	if value := os.Getenv("TABD_REQUEST_TIMEOUT"); value != "" {
+		c.RequestTimeout = value
+	}
+	if value := os.Getenv("TABD_SAVE_TIMEOUT"); value != "" {
+		c.SaveTimeout = value
+	}
+	if os.Getenv("TABD_UNFURL_LINKS") != "" {
+		c.UnfurlLinks = true
+	}
+	if value := os.Getenv("TABD_NOTIFICATIONS"); value != "" {
+		c.Notifications = splitList(value)
+	}
+	if os.Getenv("TABD_AUDIT_LOG") != "" {
+		c.AuditLog = true
+	}
+	if value := os.Getenv("TABD_ALLOWED_EXTENSIONS"); value != "" {
+		c.AllowedExtensions = splitList(value)
+	}
+	if os.Getenv("TABD_DEBUG") != "" {
+		c.LogLevel = "debug"
+	}
+}
+
+// applyDefaults fills in unset values and validates the rest
+func (c *Config) applyDefaults() error {
+	if c.StorageDir == "" {
+		tabdDir, err := defaultTabdDir()
+		if err != nil {
+			return err
+		}
+		c.StorageDir = tabdDir
+	} else if rest, ok := strings.CutPrefix(c.StorageDir, "~"); ok {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get home directory: %v", err)
+		}
+		c.StorageDir = filepath.Join(homeDir, rest)
+	}
+
+	// Each profile keeps its own data in a namespace under the storage dir
+	if profile := currentProfile(); profile != "" {
+		if err := validateProfile(profile); err != nil {
+			return err
+		}
+		c.StorageDir = profileStorageDir(c.StorageDir, profile)
+	}
+
+	if c.MaxHistory <= 0 {
+		c.MaxHistory = defaultMaxHistory
+	}
+
+	if c.LogLevel == "" {
+		c.LogLevel = "error"
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	switch c.LogFormat {
+	case "":
+		c.LogFormat = "text"
+	case "text", "json":
+	default:
+		return fmt.Errorf("unknown log format: %s", c.LogFormat)
+	}
+	if c.LogMaxSize <= 0 {
+		c.LogMaxSize = defaultLogMaxSize
+	}
+	if c.LogMaxBackups <= 0 {
+		c.LogMaxBackups = defaultLogMaxBackups
+	}
+
+	if c.StorageBackend == "" {
+		c.StorageBackend = "auto"
+	}
+
+	switch c.SensitiveAction {
+	case "":
+		c.SensitiveAction = "tag"
+	case "tag", "redact", "refuse", "off":
+	default:
+		return fmt.Errorf("unknown sensitive action: %s", c.SensitiveAction)
+	}
+
+	if c.Retention != "" {
+		if _, err := parseAge(c.Retention); err != nil {
+			return fmt.Errorf("invalid retention: %v", err)
+		}
+	}
+
+	if c.Quota != "" {
+		if _, err := parseSize(c.Quota); err != nil {
+			return fmt.Errorf("invalid quota: %v", err)
+		}
+	}
+
+	if c.MaxTextLength != "" && c.MaxTextLength != "off" {
+		if _, err := parseSize(c.MaxTextLength); err != nil {
+			return fmt.Errorf("invalid maxTextLength: %v", err)
+		}
+	}
+
+	if c.IdleTimeout != "" {
+		if _, err := parseAge(c.IdleTimeout); err != nil {
+			return fmt.Errorf("invalid idle timeout: %v", err)
+		}
+	}
+
+	for name, value := range map[string]string{"requestTimeout": c.RequestTimeout, "saveTimeout": c.SaveTimeout} {
+		if value == "" {
+			continue
+		}
+		if timeout, err := parseAge(value); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		} else if timeout == 0 {
+			return fmt.Errorf("invalid %s: must be more than zero", name)
+		}
+	}
+
+	for _, event := range c.Notifications {
+		if !validNotificationEvent(event) {
+			return fmt.Errorf("unknown notification event %q (available: %s, all)", event, strings.Join(notificationEvents, ", "))
+		}
+	}
+
+	if len(c.AllowedExtensions) == 0 {
+		c.AllowedExtensions = []string{defaultExtensionID}
+	}
+
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = maxMessageSize
+	}
+	if c.MaxMessageSize > maxTransferSize {
+		return fmt.Errorf("maxMessageSize must not exceed %d bytes", maxTransferSize)
+	}
+
+	for i := range c.Webhooks {
+		if err := c.Webhooks[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i := range c.Hooks {
+		if err := c.Hooks[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.LANPort <= 0 {
+		c.LANPort = defaultLANPort
+	}
+
+	if c.RateLimit == 0 {
+		c.RateLimit = defaultRateLimit
+	}
+	if c.RateBurst <= 0 {
+		c.RateBurst = defaultRateBurst
+	}
+
+	if c.Sync != nil {
+		if err := c.Sync.applyDefaults(); err != nil {
+			return err
+		}
+	}
+	if c.Share != nil {
+		if err := c.Share.applyDefaults(); err != nil {
+			return err
+		}
+	}
+	if c.Incognito != nil {
+		if err := c.Incognito.applyDefaults(); err != nil {
+			return err
+		}
+	}
+	if _, err := parseCipherSuite(c.Cipher); err != nil {
+		return err
+	}
+	if c.KDF != nil {
+		if err := c.KDF.applyDefaults(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
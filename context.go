@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// defaultRequestTimeout bounds how long a message may take to handle
+	// unless requestTimeout is set
+	defaultRequestTimeout = 30 * time.Second
+
+	// defaultSaveTimeout bounds a save unless saveTimeout is set. It leaves
+	// room for a keyring call to time out and the save to fall back to a
+	// file.
+	defaultSaveTimeout = 2 * keyringTimeout
+)
+
+// errSessionStopped is the cause of the context of a stopped session
+var errSessionStopped = errors.New("the session is stopping")
+
+// contextErr returns why a context was cancelled, or nil if it was not.
+// Storage calls it before each write, so work abandoned at a deadline or
+// shutdown never leaves a write half done.
+func contextErr(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx)
+}
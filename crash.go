@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+const (
+	// crashLogName is the file in the storage directory recovered panics are
+	// written to, whatever the log level
+	crashLogName = "crash.log"
+
+	// maxCrashLogSize is the size at which the crash log is moved aside to
+	// crash.log.old and started afresh
+	maxCrashLogSize = 1024 * 1024
+)
+
+// recordPanic logs a value recovered from a panic and writes it to the crash
+// log with the stack trace of the panicking goroutine
+func (t *TabdNativeHost) recordPanic(where string, value interface{}) {
+	logErrorf("Recovered from panic in %s: %v", where, value)
+	if err := t.writeCrash(where, value, debug.Stack()); err != nil {
+		logErrorf("Error writing crash log: %v", err)
+	}
+}
+
+// writeCrash appends a panic and its stack trace to the crash log
+func (t *TabdNativeHost) writeCrash(where string, value interface{}, stack []byte) error {
+	path := filepath.Join(t.config.StorageDir, crashLogName)
+	if info, err := os.Stat(path); err == nil && info.Size() >= maxCrashLogSize {
+		if err := os.Rename(path, path+".old"); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s panic in %s (version %s): %v\n\n%s\n",
+		time.Now().Format(time.RFC3339), where, version, value, stack)
+	return err
+}
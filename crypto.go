@@ -0,0 +1,430 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// cipherSuite is the AEAD a blob is sealed with. Its value is stored in the
+// blob header, so it must never change.
+type cipherSuite byte
+
+const (
+	suiteAESGCM            cipherSuite = 0
+	suiteXChaCha20Poly1305 cipherSuite = 1
+)
+
+// cipherSuiteNames are the names of the suites in the cipher config setting
+var cipherSuiteNames = map[cipherSuite]string{
+	suiteAESGCM:            "aes-gcm",
+	suiteXChaCha20Poly1305: "xchacha20-poly1305",
+}
+
+// String returns the name of the suite in the cipher config setting
+func (s cipherSuite) String() string {
+	if name, ok := cipherSuiteNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", byte(s))
+}
+
+// parseCipherSuite returns the suite with the given name, AES-GCM if empty
+func parseCipherSuite(name string) (cipherSuite, error) {
+	if name == "" {
+		return suiteAESGCM, nil
+	}
+	for suite, suiteName := range cipherSuiteNames {
+		if name == suiteName {
+			return suite, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher %q: use aes-gcm or xchacha20-poly1305", name)
+}
+
+// aead creates the suite's AEAD for a 32 byte key
+func (s cipherSuite) aead(key []byte) (cipher.AEAD, error) {
+	switch s {
+	case suiteAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case suiteXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unknown cipher suite %d", byte(s))
+	}
+}
+
+// nonceSize is the length of the suite's nonces
+func (s cipherSuite) nonceSize() int {
+	if s == suiteXChaCha20Poly1305 {
+		return chacha20poly1305.NonceSizeX
+	}
+	return 12 // GCM standard nonce size
+}
+
+// sealWith encrypts plaintext under key with a random nonce, returning the
+// nonce + ciphertext
+func sealWith(suite cipherSuite, key, plaintext []byte) ([]byte, error) {
+	aead, err := suite.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openWith decrypts nonce + ciphertext produced by sealWith
+func openWith(suite cipherSuite, key, sealed []byte) ([]byte, error) {
+	aead, err := suite.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted data")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// envelopeMagic starts a blob whose data is sealed with a random data key of
+// its own, stored after the salt wrapped by the passphrase key. It is followed
+// by the suite as one byte and the KDF parameters. New blobs are always
+// written this way.
+var envelopeMagic = []byte("TBDW")
+
+// blobKeyMagic starts a blob sealed with a key of its own, derived from the
+// passphrase key and a random key ID stored after the salt, as written before
+// envelope blobs. It has the same fields as envelopeMagic.
+var blobKeyMagic = []byte("TBDE")
+
+// suiteMagic starts a blob sealed with a suite other than AES-GCM directly
+// under the passphrase key, as written before blob keys. It has the same
+// fields as blobKeyMagic.
+var suiteMagic = []byte("TBDC")
+
+const (
+	// blobKeyIDSize is the length of the random ID each blob key is derived
+	// from. With 128 bit IDs, blob keys do not repeat even across billions of
+	// blobs, so no key ever seals two blobs and nonce reuse cannot happen.
+	blobKeyIDSize = 16
+
+	// maxBlobsPerSalt bounds the blobs one cipher encrypts with keys derived
+	// from one salt before it switches to a new salt
+	maxBlobsPerSalt = 1 << 24
+
+	// dataKeySize is the length of the random key an envelope blob's data
+	// is sealed with
+	dataKeySize = 32
+
+	// aeadTagSize is the authentication tag length of both suites
+	aeadTagSize = 16
+)
+
+// blobFormat describes how a blob is encrypted
+type blobFormat struct {
+	suite  cipherSuite
+	params KDFParams
+
+	// blobKeys is set for blobs sealed with a derived key of their own, and
+	// envelope for blobs sealed with a wrapped data key
+	blobKeys bool
+	envelope bool
+}
+
+// legacyBlobFormat is the format of blobs without a header
+var legacyBlobFormat = blobFormat{suite: suiteAESGCM, params: defaultKDFParams}
+
+// header returns the header recording the format of a new blob
+func (f blobFormat) header() []byte {
+	header := append([]byte{}, envelopeMagic...)
+	header = append(header, byte(f.suite))
+	return append(header, f.params.marshal()...)
+}
+
+// parseBlobHeader splits the header from a blob. ok is false for blobs
+// without a valid header, which use legacyBlobFormat.
+func parseBlobHeader(data []byte) (blobFormat, []byte, bool) {
+	for _, magic := range [][]byte{envelopeMagic, blobKeyMagic, suiteMagic} {
+		if len(data) <= len(magic) || string(data[:len(magic)]) != string(magic) {
+			continue
+		}
+		suite := cipherSuite(data[len(magic)])
+		if _, known := cipherSuiteNames[suite]; !known {
+			return blobFormat{}, data, false
+		}
+		params, ok := unmarshalKDFParams(data[len(magic)+1:])
+		if !ok {
+			return blobFormat{}, data, false
+		}
+		format := blobFormat{
+			suite:    suite,
+			params:   params,
+			blobKeys: string(magic) == string(blobKeyMagic),
+			envelope: string(magic) == string(envelopeMagic),
+		}
+		return format, data[len(magic)+1+kdfParamsSize:], true
+	}
+	if params, rest, ok := parseKDFHeader(data); ok {
+		return blobFormat{suite: suiteAESGCM, params: params}, rest, true
+	}
+	return blobFormat{}, data, false
+}
+
+// deriveBlobKey derives the key sealing one blob from the passphrase key
+func deriveBlobKey(key, keyID []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, key, keyID, "tabd-native-host blob key", 32)
+}
+
+var (
+	blobMu sync.Mutex
+	// blobSuite and blobParams are what ciphers created from now on
+	// encrypt with
+	blobSuite  = suiteAESGCM
+	blobParams = defaultKDFParams
+)
+
+// configureBlobCiphers makes ciphers created from now on encrypt with the
+// suite and KDF parameters in the config
+func configureBlobCiphers(config *Config) {
+	// The config was validated when it was loaded
+	suite, _ := parseCipherSuite(config.Cipher)
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	blobSuite = suite
+	blobParams = config.KDF.params()
+}
+
+// BlobCipher encrypts storage blobs with an AEAD using keys derived from a
+// passphrase. Argon2 is deliberately expensive, so derived keys are cached by
+// salt and new blobs reuse one salt per process. Each blob is sealed with a
+// random data key of its own, which is stored in the blob wrapped by that
+// key, so moving a blob to another key only rewraps its data key and a data
+// key opens nothing but its own blob. Previous passphrases are only used
+// for decryption, so blobs stay readable while a key rotation completes.
+// Blobs record the suite and Argon2 parameters they were encrypted with, so
+// changing them leaves existing blobs readable.
+type BlobCipher struct {
+	passphrase string
+	previous   []string
+	suite      cipherSuite
+	params     KDFParams
+
+	mu          sync.Mutex
+	encryptSalt []byte
+	saltUses    int
+	keyCache    map[string][]byte
+}
+
+// NewBlobCipher creates a cipher for the given passphrase, also accepting
+// blobs encrypted with any of the previous passphrases. New blobs use the
+// configured suite and Argon2 parameters.
+func NewBlobCipher(passphrase string, previous ...string) *BlobCipher {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	return &BlobCipher{
+		passphrase: passphrase,
+		previous:   previous,
+		suite:      blobSuite,
+		params:     blobParams,
+		keyCache:   make(map[string][]byte),
+	}
+}
+
+// deriveKey derives the encryption key for a passphrase and salt using
+// Argon2, reusing a previously derived key when they have been seen before
+// with the same parameters
+func (c *BlobCipher) deriveKey(passphrase string, salt []byte, params KDFParams) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The marshalled parameters are exact, unlike their String form
+	cacheKey := passphrase + "\x00" + string(salt) + "\x00" + string(params.marshal())
+	if key, ok := c.keyCache[cacheKey]; ok {
+		return key
+	}
+
+	key := params.deriveKey(passphrase, salt)
+	c.keyCache[cacheKey] = key
+	return key
+}
+
+// nextSalt returns the salt for a new blob, generating a new one on first
+// use and after maxBlobsPerSalt blobs
+func (c *BlobCipher) nextSalt() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.encryptSalt == nil || c.saltUses >= maxBlobsPerSalt {
+		c.encryptSalt = make([]byte, 16)
+		rand.Read(c.encryptSalt)
+		c.saltUses = 0
+	}
+	c.saltUses++
+	return c.encryptSalt
+}
+
+// Encrypt seals data with a random data key and wraps that key with the key
+// derived from the passphrase, as header + salt + wrapped data key + nonce +
+// ciphertext. Large data is compressed first.
+func (c *BlobCipher) Encrypt(data []byte) ([]byte, error) {
+	data = compressPayload(data)
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	sealed, err := sealWith(c.suite, dataKey, data)
+	if err != nil {
+		return nil, err
+	}
+	return c.envelope(dataKey, sealed)
+}
+
+// envelope wraps a data key with the key derived from the passphrase and a
+// salt, and combines it with data sealed under it into a blob. Wrapping keys
+// are used for at most maxBlobsPerSalt data keys, far fewer than random
+// nonces can safely be drawn for.
+func (c *BlobCipher) envelope(dataKey, sealed []byte) ([]byte, error) {
+	salt := c.nextSalt()
+	wrapped, err := sealWith(c.suite, c.deriveKey(c.passphrase, salt, c.params), dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Combine header + salt + wrapped data key + nonce + ciphertext
+	header := blobFormat{suite: c.suite, params: c.params, envelope: true}.header()
+	result := make([]byte, 0, len(header)+len(salt)+len(wrapped)+len(sealed))
+	result = append(result, header...)
+	result = append(result, salt...)
+	result = append(result, wrapped...)
+	result = append(result, sealed...)
+
+	return result, nil
+}
+
+// Rewrap moves a blob to another cipher. An envelope blob keeps its sealed
+// data, only its data key being unwrapped and wrapped again for to; blobs in
+// older formats, or sealed with a different suite from the one to encrypts
+// with, are decrypted and encrypted again.
+func (c *BlobCipher) Rewrap(data []byte, to *BlobCipher) ([]byte, error) {
+	if format, rest, ok := parseBlobHeader(data); ok && format.envelope && format.suite == to.suite {
+		if dataKey, sealed, err := c.unwrapDataKey(rest, format); err == nil {
+			return to.envelope(dataKey, sealed)
+		}
+	}
+
+	plaintext, err := c.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return to.Encrypt(plaintext)
+}
+
+// Decrypt opens a blob produced by Encrypt
+func (c *BlobCipher) Decrypt(data []byte) ([]byte, error) {
+	// A blob without a header may start with a magic by chance, so fall back
+	// to the legacy format if the header does not work
+	if format, rest, ok := parseBlobHeader(data); ok {
+		if plaintext, err := c.decrypt(rest, format); err == nil {
+			return plaintext, nil
+		}
+	}
+	return c.decrypt(data, legacyBlobFormat)
+}
+
+// decrypt opens salt + key ID + nonce + ciphertext in the given format, the
+// key ID being present only for blobs with keys of their own, or salt +
+// wrapped data key + nonce + ciphertext for envelope blobs
+func (c *BlobCipher) decrypt(data []byte, format blobFormat) ([]byte, error) {
+	if format.envelope {
+		dataKey, sealed, err := c.unwrapDataKey(data, format)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := openWith(format.suite, dataKey, sealed)
+		if err != nil {
+			return nil, err
+		}
+		return decompressPayload(plaintext)
+	}
+
+	nonceSize := format.suite.nonceSize()
+	keyIDSize := 0
+	if format.blobKeys {
+		keyIDSize = blobKeyIDSize
+	}
+	if len(data) < 16+keyIDSize+nonceSize { // salt + key ID + nonce minimum
+		return nil, fmt.Errorf("invalid encrypted data")
+	}
+
+	// Extract components
+	salt := data[:16]
+	keyID := data[16 : 16+keyIDSize]
+	nonce := data[16+keyIDSize : 16+keyIDSize+nonceSize]
+	ciphertext := data[16+keyIDSize+nonceSize:]
+
+	plaintext, err := c.open(c.passphrase, salt, keyID, nonce, ciphertext, format)
+	for _, passphrase := range c.previous {
+		if err == nil {
+			break
+		}
+		plaintext, err = c.open(passphrase, salt, keyID, nonce, ciphertext, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decompressPayload(plaintext)
+}
+
+// open decrypts a ciphertext with the key derived from a passphrase
+func (c *BlobCipher) open(passphrase string, salt, keyID, nonce, ciphertext []byte, format blobFormat) ([]byte, error) {
+	// Derive key from passphrase, then the blob key
+	key := c.deriveKey(passphrase, salt, format.params)
+	if format.blobKeys {
+		var err error
+		if key, err = deriveBlobKey(key, keyID); err != nil {
+			return nil, err
+		}
+	}
+
+	aead, err := format.suite.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decrypt data
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// unwrapDataKey splits salt + wrapped data key from the data sealed under it
+// and unwraps the key with the current or a previous passphrase
+func (c *BlobCipher) unwrapDataKey(data []byte, format blobFormat) ([]byte, []byte, error) {
+	wrappedSize := format.suite.nonceSize() + dataKeySize + aeadTagSize
+	if len(data) < 16+wrappedSize+format.suite.nonceSize() { // salt + wrapped key + nonce minimum
+		return nil, nil, fmt.Errorf("invalid encrypted data")
+	}
+	salt := data[:16]
+	wrapped := data[16 : 16+wrappedSize]
+	sealed := data[16+wrappedSize:]
+
+	var dataKey []byte
+	var err error
+	for _, passphrase := range append([]string{c.passphrase}, c.previous...) {
+		if dataKey, err = openWith(format.suite, c.deriveKey(passphrase, salt, format.params), wrapped); err == nil {
+			return dataKey, sealed, nil
+		}
+	}
+	return nil, nil, err
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// testKDFParams keep key derivation cheap in tests
+var testKDFParams = KDFParams{Time: 1, MemoryKiB: minKDFMemoryMiB * 1024, Threads: 1}
+
+// testSuites are the suites every cipher test runs with
+var testSuites = []cipherSuite{suiteAESGCM, suiteXChaCha20Poly1305}
+
+// newTestCipher creates a cipher for suite without touching the configured
+// defaults
+func newTestCipher(suite cipherSuite, passphrase string, previous ...string) *BlobCipher {
+	return &BlobCipher{
+		passphrase: passphrase,
+		previous:   previous,
+		suite:      suite,
+		params:     testKDFParams,
+		keyCache:   make(map[string][]byte),
+	}
+}
+
+// sealLegacy writes data the way versions before envelope blobs did: with a
+// key derived per blob from a key ID under blobKeyMagic, under suiteMagic
+// with the passphrase key, under kdfMagic, or with no header at all
+func sealLegacy(t *testing.T, c *BlobCipher, magic []byte, data []byte) []byte {
+	t.Helper()
+
+	params := c.params
+	if magic == nil {
+		params = defaultKDFParams
+	}
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key := c.deriveKey(c.passphrase, salt, params)
+
+	var keyID []byte
+	if bytes.Equal(magic, blobKeyMagic) {
+		keyID = make([]byte, blobKeyIDSize)
+		rand.Read(keyID)
+		var err error
+		if key, err = deriveBlobKey(key, keyID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sealed, err := sealWith(c.suite, key, compressPayload(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var blob []byte
+	switch {
+	case magic == nil:
+	case bytes.Equal(magic, kdfMagic):
+		blob = append(append(blob, kdfMagic...), params.marshal()...)
+	default:
+		blob = append(append(blob, magic...), byte(c.suite))
+		blob = append(blob, params.marshal()...)
+	}
+	blob = append(blob, salt...)
+	blob = append(blob, keyID...)
+	return append(blob, sealed...)
+}
+
+func TestBlobCipherRoundTrip(t *testing.T) {
+	large := bytes.Repeat([]byte("compressible clipboard text "), 4096)
+	for _, suite := range testSuites {
+		for _, data := range [][]byte{{}, []byte("hello"), large} {
+			c := newTestCipher(suite, "passphrase")
+			blob, err := c.Encrypt(data)
+			if err != nil {
+				t.Fatalf("%s: Encrypt: %v", suite, err)
+			}
+			if !bytes.HasPrefix(blob, envelopeMagic) || blob[len(envelopeMagic)] != byte(suite) {
+				t.Fatalf("%s: blob does not start with an envelope header for its suite", suite)
+			}
+			if len(data) > 0 && bytes.Contains(blob, data) {
+				t.Fatalf("%s: blob contains the plaintext", suite)
+			}
+
+			plaintext, err := c.Decrypt(blob)
+			if err != nil {
+				t.Fatalf("%s: Decrypt: %v", suite, err)
+			}
+			if !bytes.Equal(plaintext, data) {
+				t.Fatalf("%s: Decrypt returned %d bytes, want %d", suite, len(plaintext), len(data))
+			}
+		}
+	}
+}
+
+func TestBlobCipherDataKeysDiffer(t *testing.T) {
+	c := newTestCipher(suiteAESGCM, "passphrase")
+	first, _ := c.Encrypt([]byte("same"))
+	second, _ := c.Encrypt([]byte("same"))
+	if bytes.Equal(first, second) {
+		t.Fatal("two blobs of the same data are identical")
+	}
+}
+
+func TestBlobCipherWrongPassphrase(t *testing.T) {
+	for _, suite := range testSuites {
+		blob, err := newTestCipher(suite, "right").Encrypt([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newTestCipher(suite, "wrong").Decrypt(blob); err == nil {
+			t.Fatalf("%s: blob decrypted with the wrong passphrase", suite)
+		}
+	}
+}
+
+func TestBlobCipherPreviousPassphrase(t *testing.T) {
+	blob, err := newTestCipher(suiteAESGCM, "old").Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := newTestCipher(suiteAESGCM, "new", "older", "old").Decrypt(blob)
+	if err != nil || string(plaintext) != "secret" {
+		t.Fatalf("Decrypt with a previous passphrase = %q, %v", plaintext, err)
+	}
+}
+
+func TestBlobCipherTamper(t *testing.T) {
+	for _, suite := range testSuites {
+		c := newTestCipher(suite, "passphrase")
+		blob, err := c.Encrypt([]byte("tamper with me"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Every byte after the magic is covered: the suite and KDF
+		// parameters select the key, and the salt, wrapped data key,
+		// nonce and ciphertext are authenticated
+		for i := len(envelopeMagic); i < len(blob); i++ {
+			tampered := bytes.Clone(blob)
+			tampered[i] ^= 0x01
+			if plaintext, err := c.Decrypt(tampered); err == nil {
+				t.Fatalf("%s: blob with byte %d flipped decrypted to %q", suite, i, plaintext)
+			}
+		}
+
+		if _, err := c.Decrypt(blob[:len(blob)-1]); err == nil {
+			t.Fatalf("%s: truncated blob decrypted", suite)
+		}
+	}
+}
+
+func TestBlobCipherRewrap(t *testing.T) {
+	for _, suite := range testSuites {
+		from := newTestCipher(suite, "old")
+		to := newTestCipher(suite, "new")
+		blob, err := from.Encrypt([]byte("rotate me"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rewrapped, err := from.Rewrap(blob, to)
+		if err != nil {
+			t.Fatalf("%s: Rewrap: %v", suite, err)
+		}
+
+		// Only the wrapped data key changes; the sealed data is kept
+		sealedSize := suite.nonceSize() + len("rotate me") + aeadTagSize
+		if !bytes.Equal(rewrapped[len(rewrapped)-sealedSize:], blob[len(blob)-sealedSize:]) {
+			t.Fatalf("%s: Rewrap changed the sealed data", suite)
+		}
+
+		plaintext, err := to.Decrypt(rewrapped)
+		if err != nil || string(plaintext) != "rotate me" {
+			t.Fatalf("%s: Decrypt after Rewrap = %q, %v", suite, plaintext, err)
+		}
+		if _, err := from.Decrypt(rewrapped); err == nil {
+			t.Fatalf("%s: rewrapped blob still opens with the old passphrase", suite)
+		}
+	}
+}
+
+func TestBlobCipherRewrapNewParams(t *testing.T) {
+	from := newTestCipher(suiteAESGCM, "old")
+	to := newTestCipher(suiteAESGCM, "new")
+	to.params.Time = 2
+	blob, _ := from.Encrypt([]byte("stronger"))
+
+	rewrapped, err := from.Rewrap(blob, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, _, ok := parseBlobHeader(rewrapped)
+	if !ok || format.params != to.params {
+		t.Fatalf("rewrapped blob records %v, want %v", format.params, to.params)
+	}
+	if plaintext, err := to.Decrypt(rewrapped); err != nil || string(plaintext) != "stronger" {
+		t.Fatalf("Decrypt = %q, %v", plaintext, err)
+	}
+}
+
+func TestBlobCipherRewrapChangesSuite(t *testing.T) {
+	from := newTestCipher(suiteAESGCM, "old")
+	to := newTestCipher(suiteXChaCha20Poly1305, "new")
+	blob, _ := from.Encrypt([]byte("convert me"))
+
+	rewrapped, err := from.Rewrap(blob, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped[len(envelopeMagic)] != byte(suiteXChaCha20Poly1305) {
+		t.Fatal("Rewrap kept the old suite")
+	}
+	if plaintext, err := to.Decrypt(rewrapped); err != nil || string(plaintext) != "convert me" {
+		t.Fatalf("Decrypt = %q, %v", plaintext, err)
+	}
+}
+
+func TestBlobCipherLegacyBlobs(t *testing.T) {
+	legacy := []struct {
+		name  string
+		magic []byte
+		suite cipherSuite
+	}{
+		{"blob keys", blobKeyMagic, suiteAESGCM},
+		{"blob keys xchacha", blobKeyMagic, suiteXChaCha20Poly1305},
+		{"suite header", suiteMagic, suiteXChaCha20Poly1305},
+		{"kdf header", kdfMagic, suiteAESGCM},
+		{"no header", nil, suiteAESGCM},
+	}
+	for _, tc := range legacy {
+		from := newTestCipher(tc.suite, "old")
+		blob := sealLegacy(t, from, tc.magic, []byte("written long ago"))
+
+		plaintext, err := from.Decrypt(blob)
+		if err != nil || string(plaintext) != "written long ago" {
+			t.Fatalf("%s: Decrypt = %q, %v", tc.name, plaintext, err)
+		}
+
+		// Rotation moves legacy blobs to envelope blobs
+		to := newTestCipher(suiteAESGCM, "new")
+		rewrapped, err := from.Rewrap(blob, to)
+		if err != nil {
+			t.Fatalf("%s: Rewrap: %v", tc.name, err)
+		}
+		if !bytes.HasPrefix(rewrapped, envelopeMagic) {
+			t.Fatalf("%s: Rewrap did not convert the blob to an envelope blob", tc.name)
+		}
+		if plaintext, err := to.Decrypt(rewrapped); err != nil || string(plaintext) != "written long ago" {
+			t.Fatalf("%s: Decrypt after Rewrap = %q, %v", tc.name, plaintext, err)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+// Daemon serves the native messaging protocol to any number of local clients
+// over a Unix domain socket (or a named pipe on Windows), and the gRPC API on
+// the same socket
+type Daemon struct {
+	host     *TabdNativeHost
+	listener net.Listener
+
+	// gRPC connections are told apart by their first bytes and passed to
+	// the gRPC server through grpcConns
+	grpc        *grpc.Server
+	grpcConns   *connListener
+	grpcService *grpcService
+
+	mu       sync.Mutex
+	sessions map[*Session]net.Conn
+
+	// Tracks running sessions so Shutdown can wait for them
+	wg sync.WaitGroup
+}
+
+// NewDaemon starts listening on the host's IPC endpoint
+func NewDaemon(host *TabdNativeHost) (*Daemon, error) {
+	address := ipcAddress(host.tabdDir)
+
+	// Refuse to replace a daemon that is still answering
+	if conn, err := dialIPC(address); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", address)
+	}
+
+	listener, err := listenIPC(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", address, err)
+	}
+
+	service := &grpcService{host: host, quit: make(chan struct{})}
+	return &Daemon{
+		host:        host,
+		listener:    listener,
+		grpc:        newGRPCServer(service),
+		grpcConns:   newConnListener(listener.Addr()),
+		grpcService: service,
+		sessions:    make(map[*Session]net.Conn),
+	}, nil
+}
+
+// Serve accepts client connections until the listener is closed
+func (d *Daemon) Serve() error {
+	logInfof("Daemon listening on %s", d.listener.Addr())
+	go d.grpc.Serve(d.grpcConns)
+
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %v", err)
+		}
+
+		d.wg.Add(1)
+		go d.serveConn(conn)
+	}
+}
+
+// serveConn runs a protocol session for one client connection, or passes it
+// to the gRPC server if it opens with the HTTP/2 preface
+func (d *Daemon) serveConn(conn net.Conn) {
+	defer d.wg.Done()
+
+	reader := bufio.NewReader(conn)
+	if isGRPC(reader) {
+		session := d.host.NewSession(nil, nil)
+		session.allowMsgpack = true
+		client := &grpcConn{Conn: conn, reader: reader, addr: &grpcClient{Addr: conn.RemoteAddr(), session: session}}
+		if !d.grpcConns.hand(client) {
+			conn.Close()
+		}
+		return
+	}
+	defer conn.Close()
+
+	session := d.host.NewSession(reader, conn)
+	session.allowMsgpack = true
+
+	d.mu.Lock()
+	d.sessions[session] = conn
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.sessions, session)
+		d.mu.Unlock()
+	}()
+
+	if err := session.serve(); err != nil {
+		logErrorf("Daemon session error: %v", err)
+	}
+}
+
+// Close stops accepting connections and disconnects all clients
+func (d *Daemon) Close() error {
+	err := d.listener.Close()
+	d.grpcConns.Close()
+	d.grpc.Stop()
+
+	d.mu.Lock()
+	for _, conn := range d.sessions {
+		conn.Close()
+	}
+	d.mu.Unlock()
+
+	return err
+}
+
+// Shutdown stops accepting connections and ends every session after its
+// in-flight message, waiting for them to finish
+func (d *Daemon) Shutdown(reason string) error {
+	err := d.listener.Close()
+
+	d.mu.Lock()
+	for session := range d.sessions {
+		session.Stop(reason)
+	}
+	d.mu.Unlock()
+
+	// Watch streams never finish on their own, so they are ended before
+	// waiting for gRPC calls
+	close(d.grpcService.quit)
+	d.grpc.GracefulStop()
+
+	d.wg.Wait()
+	return err
+}
+
+// forwardToDaemon relays the native messaging stream between stdio and a
+// running daemon, so the browser shares state with other local clients. It
+// returns false without forwarding if no daemon is reachable.
+func (t *TabdNativeHost) forwardToDaemon(stdin io.Reader, stdout io.Writer) (bool, error) {
+	conn, err := dialIPC(ipcAddress(t.tabdDir))
+	if err != nil {
+		return false, nil
+	}
+	defer conn.Close()
+
+	logInfof("Forwarding native messaging to daemon at %s", conn.RemoteAddr())
+
+	upstream := t.NewSession(conn, conn)
+	upstream.allowMsgpack = true
+	if negotiateMsgpack(upstream) {
+		return true, t.relayMsgpack(upstream, conn, stdin, stdout)
+	}
+
+	// Older daemons only speak JSON. The framing is identical on both legs,
+	// so bytes are copied verbatim. When the browser disconnects the write
+	// side is closed, and the daemon ends the session after answering any
+	// outstanding requests.
+	go func() {
+		io.Copy(conn, stdin)
+		closeWrite(conn)
+	}()
+
+	_, err = io.Copy(stdout, conn)
+	return true, err
+}
+
+// negotiateMsgpack asks the daemon to switch the relay's session to
+// MessagePack. The hello declares the defaults of a session that has not
+// negotiated, so the browser sees the same behaviour until it sends its own.
+func negotiateMsgpack(upstream *Session) bool {
+	hello, _ := json.Marshal(&Message{
+		Action:          "hello",
+		ProtocolVersion: minProtocolVersion,
+		Features:        hostFeatures(),
+		WireFormat:      wireMsgpack,
+	})
+	if err := upstream.sendMessage(hello); err != nil {
+		return false
+	}
+
+	responseData, err := upstream.readMessage(upstream.ctx)
+	if err != nil {
+		return false
+	}
+	var response struct {
+		Status string      `json:"status"`
+		Data   HelloResult `json:"data"`
+	}
+	if err := json.Unmarshal(responseData, &response); err != nil || response.Status != "success" {
+		return false
+	}
+	if response.Data.WireFormat != wireMsgpack {
+		return false
+	}
+
+	upstream.wireFormat = wireMsgpack
+	return true
+}
+
+// relayMsgpack relays between the browser's JSON and the daemon's MessagePack.
+// Chunked browser messages are reassembled here and sent to the daemon whole,
+// and large replies are chunked again for the browser.
+func (t *TabdNativeHost) relayMsgpack(upstream *Session, conn net.Conn, stdin io.Reader, stdout io.Writer) error {
+	browser := t.NewSession(stdin, stdout)
+
+	go func() {
+		defer closeWrite(conn)
+		for {
+			messageData, err := browser.readMessage(browser.ctx)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					logErrorf("Error reading message: %v", err)
+				}
+				return
+			}
+
+			var peek struct {
+				Action string `json:"action"`
+			}
+			if json.Unmarshal(messageData, &peek) == nil && peek.Action == "chunk" {
+				complete, err := browser.receiveChunk(messageData)
+				if err != nil {
+					logErrorf("Error handling chunk: %v", err)
+				}
+				if complete == nil {
+					continue
+				}
+				messageData = complete
+			}
+
+			packed, err := jsonToMsgpack(messageData)
+			if err != nil {
+				logErrorf("Error encoding message for daemon: %v", err)
+				continue
+			}
+			if err := upstream.sendMessage(packed); err != nil {
+				logErrorf("Error forwarding message: %v", err)
+				return
+			}
+		}
+	}()
+
+	for {
+		packed, err := upstream.readMessage(upstream.ctx)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		value, err := decodeMsgpack(packed)
+		if err != nil {
+			logErrorf("Error decoding daemon message: %v", err)
+			continue
+		}
+
+		// The browser leg stays JSON whatever the relay negotiated, and its
+		// frames are limited by this relay rather than by the daemon
+		if response, ok := value.(map[string]interface{}); ok && response["action"] == "hello" {
+			if data, ok := response["data"].(map[string]interface{}); ok {
+				data["wireFormat"] = wireJSON
+				data["maxMessageSize"] = browser.incomingLimit()
+				switch limit := data["maxResponseSize"].(type) {
+				case int64:
+					browser.frameLimit = int(limit)
+				case uint64:
+					browser.frameLimit = int(limit)
+				}
+			}
+		}
+
+		responseData, err := json.Marshal(value)
+		if err != nil {
+			logErrorf("Error encoding message for browser: %v", err)
+			continue
+		}
+		if err := browser.sendResponse(responseData); err != nil {
+			return err
+		}
+	}
+}
+
+// closeWrite half-closes a connection where supported, so the peer sees EOF
+// while its replies can still be read
+func closeWrite(conn net.Conn) {
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	} else {
+		conn.Close()
+	}
+}
+
+// newDaemonCommand creates the daemon command, which runs the IPC daemon in
+// the foreground
+func newDaemonCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run the IPC daemon shared by the browser and local clients",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	watch := flags.Bool("watch", false, "record OS clipboard changes and push them to connected clients")
+	interval := flags.Duration("interval", defaultWatchInterval, "clipboard polling interval for --watch")
+	lan := flags.Bool("lan", false, "share entries with paired hosts on the local network")
+	metrics := flags.String("metrics", "", "serve Prometheus metrics on this address, e.g. 127.0.0.1:9745")
+	webSocket := flags.String("websocket", "", "stream events to local web apps over WebSocket on this address, e.g. 127.0.0.1:8747")
+	takeover := flags.Bool("takeover", false, "replace a daemon already running for this storage directory")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		guard, err := acquireInstance(host.tabdDir, "daemon", *takeover)
+		if err != nil {
+			return err
+		}
+		defer guard.Release()
+
+		daemon, err := NewDaemon(host)
+		if err != nil {
+			return err
+		}
+		defer daemon.Close()
+		host.audit.SetActor("daemon")
+		host.startIncognito()
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go host.runRetention(stop)
+		go host.runSyncLoop(stop)
+		go host.watchConfig(stop)
+		go host.runLockWatch(stop)
+
+		if *watch {
+			go NewClipboardWatcher(host, *interval).Run(stop)
+		}
+
+		if *metrics != "" {
+			listener, err := net.Listen("tcp", *metrics)
+			if err != nil {
+				return fmt.Errorf("failed to listen for metrics on %s: %v", *metrics, err)
+			}
+			defer listener.Close()
+			go host.serveMetrics(listener)
+		}
+
+		if *webSocket != "" {
+			// Web apps authenticate with the HTTP API token
+			if !host.ensureUnlocked() {
+				return fmt.Errorf("failed to load the API token for --websocket: %v", host.lockError())
+			}
+			token, err := loadOrCreateAPIToken(host.storage(), false)
+			if err != nil {
+				return err
+			}
+			listener, err := net.Listen("tcp", *webSocket)
+			if err != nil {
+				return fmt.Errorf("failed to listen for WebSocket clients on %s: %v", *webSocket, err)
+			}
+			server := NewWebSocketServer(host, token)
+			defer server.Close()
+			go server.Serve(listener)
+			fmt.Fprintf(os.Stderr, "WebSocket endpoint on ws://%s/ws\n", *webSocket)
+			fmt.Fprintf(os.Stderr, "API token: %s\n", token)
+		}
+
+		if *lan {
+			share, err := NewLANShare(host)
+			if err != nil {
+				return err
+			}
+			host.lan = share
+			go share.Run(stop)
+		}
+
+		shutdown, stopped := shutdownRequests()
+		defer stopped()
+		go func() {
+			reason := <-shutdown
+			logInfof("Received %v, shutting down daemon", reason)
+			daemon.Shutdown(reason)
+		}()
+
+		fmt.Fprintf(os.Stderr, "Daemon listening on %s\n", ipcAddress(host.tabdDir))
+		if err := daemon.Serve(); err != nil {
+			return err
+		}
+
+		// Serve returns once Shutdown closes the listener; wait for the sessions
+		daemon.wg.Wait()
+		return nil
+	})
+	return cmd
+}
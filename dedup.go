@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// contentHash returns a digest of the clipboard content, ignoring metadata
+// such as the timestamp, source URL and title
+func contentHash(data *ClipboardData) string {
+	content, _ := json.Marshal(struct {
+		Text        string            `json:"text"`
+		ContentType string            `json:"contentType"`
+		Data        string            `json:"data"`
+		Flavors     map[string]string `json:"flavors"`
+	}{data.Text, data.ContentType, data.Data, data.Flavors})
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// findDuplicate returns the ID and data of the newest history entry if it
+// holds the same content as data. Only the newest entry is compared, so saving
+// content that was copied earlier still creates a new entry.
+func (t *TabdNativeHost) findDuplicate(data *ClipboardData) (string, *ClipboardData, bool) {
+	entries, err := t.historyStore().Query(HistoryQuery{Limit: 1})
+	if err != nil || len(entries) == 0 {
+		return "", nil, false
+	}
+
+	latest, err := t.historyStore().Get(entries[0].ID)
+	if err != nil {
+		return "", nil, false
+	}
+
+	// Copies from private browsing windows are kept apart from the rest
+	if latest.Incognito != data.Incognito || contentHash(latest) != contentHash(data) {
+		return "", nil, false
+	}
+	return entries[0].ID, latest, true
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// defaultDiffContext is the number of unchanged lines shown around each
+	// change
+	defaultDiffContext = 3
+
+	// maxDiffEdits bounds the number of changed lines a diff searches for,
+	// as the search needs memory growing with the square of it
+	maxDiffEdits = 4000
+)
+
+// diffOp is one line of an edit script: kept (' '), removed ('-') or added
+// ('+'). Lines keep their newline, so a missing one at the end of the text
+// counts as a change.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffResult is the data returned by a diff message
+type diffResult struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Diff    string `json:"diff"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+// splitLines splits text into lines, each with its newline except for an
+// unterminated last line
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script turning a into b, using Myers'
+// algorithm on the lines between any common prefix and suffix
+func diffLines(a, b []string) ([]diffOp, error) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	middle, err := myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	if err != nil {
+		return nil, err
+	}
+	ops = append(ops, middle...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops, nil
+}
+
+// myersDiff finds the edit script by searching for the furthest reaching
+// path with d edits on each diagonal k, for increasing d, then following the
+// recorded paths back
+func myersDiff(a, b []string) ([]diffOp, error) {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxDiffEdits {
+		limit = maxDiffEdits
+	}
+
+	// trace[d][k+d] is the furthest x reached on diagonal k with d edits
+	var trace [][]int32
+	var found bool
+	for d := 0; d <= limit && !found; d++ {
+		v := make([]int32, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && furthest(trace, d-1, k-1) < furthest(trace, d-1, k+1)) {
+				x = furthest(trace, d-1, k+1)
+			} else {
+				x = furthest(trace, d-1, k-1) + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+d] = int32(x)
+			if x >= n && y >= m {
+				found = true
+			}
+		}
+		trace = append(trace, v)
+	}
+	if !found {
+		return nil, fmt.Errorf("entries differ in more than %d lines", maxDiffEdits)
+	}
+
+	// Follow the path back from the end, collecting the edits in reverse
+	var reversed []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && furthest(trace, d-1, k-1) < furthest(trace, d-1, k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := furthest(trace, d-1, prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, diffOp{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			reversed = append(reversed, diffOp{'+', b[y]})
+		} else {
+			x--
+			reversed = append(reversed, diffOp{'-', a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		reversed = append(reversed, diffOp{' ', a[x]})
+	}
+
+	ops := make([]diffOp, len(reversed))
+	for i, op := range reversed {
+		ops[len(reversed)-1-i] = op
+	}
+	return ops, nil
+}
+
+// furthest returns the x recorded for diagonal k after d edits. The search
+// starts from the virtual point before the first line, on diagonal 1.
+func furthest(trace [][]int32, d, k int) int {
+	if d < 0 {
+		return 0
+	}
+	return int(trace[d][k+d])
+}
+
+// unifiedDiff renders the changes from one text to another in unified diff
+// format, with the given number of context lines around each change, and
+// counts the added and removed lines. It returns an empty diff for
+// identical texts.
+func unifiedDiff(fromName, toName, from, to string, context int) (string, int, int, error) {
+	ops, err := diffLines(splitLines(from), splitLines(to))
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	var changes []int
+	added, removed := 0, 0
+	for i, op := range ops {
+		switch op.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		default:
+			continue
+		}
+		changes = append(changes, i)
+	}
+	if len(changes) == 0 {
+		return "", 0, 0, nil
+	}
+
+	// fromLine[i] and toLine[i] count the lines of each text before op i
+	fromLine := make([]int, len(ops)+1)
+	toLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		fromLine[i+1], toLine[i+1] = fromLine[i], toLine[i]
+		if op.kind != '+' {
+			fromLine[i+1]++
+		}
+		if op.kind != '-' {
+			toLine[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for i := 0; i < len(changes); {
+		// Changes separated by no more than twice the context share a hunk
+		last := i
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*context+1 {
+			last++
+		}
+		start := max(changes[i]-context, 0)
+		end := min(changes[last]+context+1, len(ops))
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(fromLine[start], fromLine[end]-fromLine[start]),
+			hunkRange(toLine[start], toLine[end]-toLine[start]))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = last + 1
+	}
+	return out.String(), added, removed, nil
+}
+
+// hunkRange formats the line range of a hunk header. Ranges are 1-based,
+// except that an empty range names the line before it.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	default:
+		return fmt.Sprintf("%d,%d", before+1, count)
+	}
+}
+
+// diffEntries compares the text of two history entries
+func (t *TabdNativeHost) diffEntries(fromID, toID string, context int) (*diffResult, error) {
+	texts := make([]string, 2)
+	for i, id := range []string{fromID, toID} {
+		data, err := t.historyStore().Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve history entry %s: %w", id, err)
+		}
+		if data.Text == "" && data.Data != "" {
+			return nil, invalidRequestf("history entry %s has no text to compare", id)
+		}
+		texts[i] = data.Text
+	}
+
+	diff, added, removed, err := unifiedDiff(fromID, toID, texts[0], texts[1], context)
+	if err != nil {
+		return nil, invalidRequestf("cannot compare %s and %s: %v", fromID, toID, err)
+	}
+	return &diffResult{From: fromID, To: toID, Diff: diff, Added: added, Removed: removed}, nil
+}
+
+// handleDiff returns a unified diff from the text of one history entry to
+// another
+func (t *TabdNativeHost) handleDiff(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	if msg.From == "" || msg.To == "" {
+		return "", nil, invalidRequestf("Missing from or to entry id")
+	}
+	result, err := t.diffEntries(msg.From, msg.To, defaultDiffContext)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to compare entries: %w", err)
+	}
+	if result.Diff == "" {
+		return "Entries are identical", result, nil
+	}
+	return "Entries differ", result, nil
+}
+
+// newDiffCommand creates the diff command, which prints a unified diff from
+// the text of one history entry to another
+func newDiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff <id1> <id2>",
+		Short: "Show a unified diff between the text of two history entries",
+	}
+	flags := cmd.Flags()
+	context := flags.Int("context", defaultDiffContext, "number of unchanged lines shown around each change")
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		if len(positional) != 2 {
+			return fmt.Errorf("usage: diff [--context <n>] [--json] <id1> <id2>")
+		}
+		if *context < 0 {
+			return fmt.Errorf("--context must not be negative")
+		}
+
+		result, err := host.diffEntries(positional[0], positional[1], *context)
+		if err != nil {
+			return err
+		}
+		if jsonOutput {
+			return printJSON(result)
+		}
+		_, err = os.Stdout.WriteString(result.Diff)
+		return err
+	})
+	return cmd
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// doctorPipeTimeout bounds how long the stdin pipe check waits for a reply
+const doctorPipeTimeout = 10 * time.Second
+
+// doctorCheck is the outcome of a single doctor check. Fix tells the user how
+// to resolve a failure or warning.
+type doctorCheck struct {
+	name   string
+	status string // ok, warn, skip or fail
+	detail string
+	fix    string
+}
+
+// currentExecutable returns the resolved path of the running binary
+func currentExecutable() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+	return executable, nil
+}
+
+// sameFile reports whether two paths name the same existing file
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// checkManifest verifies that a browser's registration points to a manifest
+// for this host and that the manifest points to an existing binary
+func checkManifest(browser, executable string) *doctorCheck {
+	check := &doctorCheck{name: browserDisplayNames[browser] + " manifest", status: "fail"}
+	installFix := "run: tabd-native-host install --browser " + browser
+
+	location, err := browserManifestLocation(browser)
+	if err != nil {
+		check.detail = err.Error()
+		return check
+	}
+
+	manifestPath, err := registeredManifest(location)
+	if errors.Is(err, errManifestNotRegistered) {
+		if _, statErr := os.Stat(location.manifestPath); statErr == nil {
+			check.detail = fmt.Sprintf("%s exists but is not registered under HKCU\\%s", location.manifestPath, location.registryKey)
+			check.fix = installFix
+			return check
+		}
+		check.status = "skip"
+		check.detail = "not installed"
+		return check
+	}
+	if err != nil {
+		check.detail = err.Error()
+		return check
+	}
+
+	data, err := os.ReadFile(manifestPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if location.registryKey == "" {
+			check.status = "skip"
+			check.detail = "not installed"
+			return check
+		}
+		check.detail = fmt.Sprintf("registry key points to missing manifest %s", manifestPath)
+		check.fix = installFix
+		return check
+	}
+	if err != nil {
+		check.detail = fmt.Sprintf("failed to read manifest %s: %v", manifestPath, err)
+		return check
+	}
+
+	var manifest NativeMessagingManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		check.detail = fmt.Sprintf("invalid manifest %s: %v", manifestPath, err)
+		check.fix = installFix
+		return check
+	}
+	if manifest.Name != nativeHostName {
+		check.detail = fmt.Sprintf("manifest %s is for %q, not %q", manifestPath, manifest.Name, nativeHostName)
+		check.fix = installFix
+		return check
+	}
+
+	if !filepath.IsAbs(manifest.Path) {
+		check.detail = fmt.Sprintf("manifest binary path %q is not absolute", manifest.Path)
+		check.fix = installFix
+		return check
+	}
+	info, err := os.Stat(manifest.Path)
+	if err != nil {
+		check.detail = fmt.Sprintf("manifest points to missing binary %s", manifest.Path)
+		check.fix = installFix + " --path <binary>"
+		return check
+	}
+	if info.IsDir() || (runtime.GOOS != "windows" && info.Mode()&0111 == 0) {
+		check.detail = fmt.Sprintf("manifest binary %s is not executable", manifest.Path)
+		check.fix = "run: chmod +x " + manifest.Path
+		return check
+	}
+
+	check.detail = fmt.Sprintf("%s -> %s", manifestPath, manifest.Path)
+	if profile, ok := launcherProfile(manifest.Path); ok {
+		check.status = "ok"
+		check.detail += fmt.Sprintf(" (profile %s)", profile)
+		return check
+	}
+	if executable != "" && !sameFile(manifest.Path, executable) {
+		check.status = "warn"
+		check.detail += fmt.Sprintf(" (not this binary, %s)", executable)
+		check.fix = installFix + " to use this binary"
+		return check
+	}
+	check.status = "ok"
+	return check
+}
+
+// checkBinary verifies the running binary can be launched by a browser
+func checkBinary() (*doctorCheck, string) {
+	check := &doctorCheck{name: "Binary", status: "fail"}
+
+	executable, err := currentExecutable()
+	if err != nil {
+		check.detail = fmt.Sprintf("failed to determine executable path: %v", err)
+		return check, ""
+	}
+	info, err := os.Stat(executable)
+	if err != nil {
+		check.detail = fmt.Sprintf("failed to stat %s: %v", executable, err)
+		return check, ""
+	}
+	if runtime.GOOS != "windows" && info.Mode()&0111 == 0 {
+		check.detail = fmt.Sprintf("%s is not executable", executable)
+		check.fix = "run: chmod +x " + executable
+		return check, executable
+	}
+	if tempDir := os.TempDir(); strings.HasPrefix(executable, tempDir+string(filepath.Separator)) {
+		check.status = "warn"
+		check.detail = fmt.Sprintf("%s is in the temporary directory and may be removed", executable)
+		check.fix = "copy the binary to a permanent location and run install from there"
+		return check, executable
+	}
+
+	check.status = "ok"
+	check.detail = executable
+	return check, executable
+}
+
+// checkStorageDir verifies that the storage directory is private to the user
+func checkStorageDir(tabdDir string) *doctorCheck {
+	check := &doctorCheck{name: "Storage directory", status: "fail"}
+
+	info, err := os.Stat(tabdDir)
+	if errors.Is(err, os.ErrNotExist) {
+		check.status = "skip"
+		check.detail = tabdDir + " does not exist yet; it is created on first use"
+		return check
+	}
+	if err != nil {
+		check.detail = fmt.Sprintf("failed to stat %s: %v", tabdDir, err)
+		return check
+	}
+	if !info.IsDir() {
+		check.detail = tabdDir + " is not a directory"
+		check.fix = "move " + tabdDir + " out of the way"
+		return check
+	}
+
+	// Windows permissions are ACLs that the mode bits do not describe
+	if runtime.GOOS == "windows" {
+		check.status = "ok"
+		check.detail = tabdDir
+		return check
+	}
+
+	if info.Mode().Perm()&0022 != 0 {
+		check.detail = fmt.Sprintf("%s is writable by other users (%s)", tabdDir, info.Mode().Perm())
+		check.fix = "run: chmod go-w " + tabdDir
+		return check
+	}
+
+	exposed := []string{}
+	filepath.WalkDir(tabdDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().Perm()&0077 != 0 {
+			exposed = append(exposed, path)
+		}
+		return nil
+	})
+	if len(exposed) > 0 {
+		check.detail = fmt.Sprintf("%d file(s) readable by other users, e.g. %s", len(exposed), exposed[0])
+		check.fix = "run: chmod -R go-rwx " + tabdDir
+		return check
+	}
+
+	check.status = "ok"
+	check.detail = tabdDir
+	return check
+}
+
+// checkKeyring reports whether the master key can be kept in the system keyring
+func checkKeyring() *doctorCheck {
+	check := &doctorCheck{name: "Keyring"}
+	if os.Getenv("TABD_DISABLE_KEYRING") != "" {
+		check.status = "skip"
+		check.detail = "disabled by TABD_DISABLE_KEYRING; the master key is kept in a file"
+		return check
+	}
+
+	state, detail := probeKeyring()
+	check.status = "warn"
+	check.detail = detail + "; the master key is kept in a file"
+	switch state {
+	case keyringAvailable:
+		check.status = "ok"
+		check.detail = "available"
+	case keyringNoBus:
+		check.fix = "run from a desktop session, or start one with dbus-run-session, so DBUS_SESSION_BUS_ADDRESS is set"
+	case keyringNoDaemon:
+		check.fix = "install and start a Secret Service provider such as gnome-keyring or KeePassXC"
+	case keyringNoCollection:
+		check.fix = "create a default keyring, e.g. in Seahorse (Passwords and Keys)"
+	case keyringLocked:
+		check.detail = detail + "; keys are kept in files until it is unlocked"
+		check.fix = "run: tabd-native-host unlock --keyring"
+		if !hasDisplay() {
+			check.fix = "unlock it from a desktop session, or start gnome-keyring-daemon with --unlock, which reads the password from stdin"
+		}
+	default:
+		switch runtime.GOOS {
+		case "darwin", "windows":
+			check.fix = "make sure you are logged in to a desktop session"
+		default:
+			check.fix = "check that the Secret Service provider is working, e.g. with secret-tool"
+		}
+	}
+	return check
+}
+
+// checkEncryption encrypts and decrypts a test blob with the storage key, or
+// with DPAPI for the dpapi backend
+func checkEncryption(config *Config) *doctorCheck {
+	check := &doctorCheck{name: "Encryption", status: "fail"}
+	tabdDir := config.StorageDir
+
+	if config.StorageBackend == "dpapi" {
+		return checkDPAPI(check)
+	}
+
+	keys := newMasterKeyStore(tabdDir)
+	if !keys.protected() && len(keys.load(masterKeyName)) == 0 && keys.denied == nil {
+		check.status = "skip"
+		check.detail = "no storage key yet; it is created on first use"
+		return check
+	}
+
+	cipher, err := storageCipher(tabdDir)
+	if errors.Is(err, ErrLocked) {
+		check.status = "skip"
+		check.detail = "storage is locked"
+		check.fix = "run: tabd-native-host unlock"
+		return check
+	}
+	if errors.Is(err, ErrKeychainDenied) {
+		check.detail = err.Error()
+		check.fix = "run doctor again and choose Always Allow in the Keychain prompt"
+		return check
+	}
+	if err != nil {
+		check.detail = fmt.Sprintf("failed to load storage key: %v", err)
+		return check
+	}
+
+	blob := make([]byte, 64)
+	rand.Read(blob)
+	encrypted, err := cipher.Encrypt(blob)
+	if err != nil {
+		check.detail = fmt.Sprintf("failed to encrypt test blob: %v", err)
+		return check
+	}
+	decrypted, err := cipher.Decrypt(encrypted)
+	if err != nil || !bytes.Equal(decrypted, blob) {
+		check.detail = "test blob did not decrypt to the original data"
+		return check
+	}
+
+	check.status = "ok"
+	check.detail = "test blob encrypted and decrypted"
+	return check
+}
+
+// checkDPAPI protects and unprotects a test blob with DPAPI
+func checkDPAPI(check *doctorCheck) *doctorCheck {
+	blob := make([]byte, 64)
+	rand.Read(blob)
+	encrypted, err := dpapiProtect(blob)
+	if err != nil {
+		check.detail = fmt.Sprintf("failed to encrypt test blob: %v", err)
+		return check
+	}
+	decrypted, err := dpapiUnprotect(encrypted)
+	if err != nil || !bytes.Equal(decrypted, blob) {
+		check.detail = "test blob did not decrypt to the original data"
+		return check
+	}
+
+	check.status = "ok"
+	check.detail = "test blob encrypted and decrypted with DPAPI"
+	return check
+}
+
+// checkStdinPipe launches the binary the way a browser does and exchanges a
+// ping over its stdin and stdout pipes
+func checkStdinPipe(executable string) *doctorCheck {
+	check := &doctorCheck{name: "Stdin pipe", status: "fail"}
+	if executable == "" {
+		check.status = "skip"
+		check.detail = "executable path unknown"
+		return check
+	}
+
+	ping, _ := json.Marshal(Message{Action: "ping"})
+	input := new(bytes.Buffer)
+	binary.Write(input, binary.LittleEndian, uint32(len(ping)))
+	input.Write(ping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorPipeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, executable, "chrome-extension://"+defaultExtensionID+"/")
+	cmd.Stdin = input
+	cmd.Env = os.Environ()
+	if configPathOverride != "" {
+		cmd.Env = append(cmd.Env, "TABD_CONFIG="+configPathOverride)
+	}
+	if profileOverride != "" {
+		cmd.Env = append(cmd.Env, "TABD_PROFILE="+profileOverride)
+	}
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		check.detail = fmt.Sprintf("no reply within %s", doctorPipeTimeout)
+		check.fix = "check the log file for errors, or run with TABD_LOG_LEVEL=debug"
+		return check
+	}
+
+	reader := bytes.NewReader(output)
+	for {
+		var length uint32
+		if binary.Read(reader, binary.LittleEndian, &length) != nil {
+			break
+		}
+		frame := make([]byte, length)
+		if _, readErr := io.ReadFull(reader, frame); readErr != nil {
+			break
+		}
+
+		var response Response
+		if json.Unmarshal(frame, &response) != nil {
+			check.detail = "stdout contains data that is not a native messaging frame"
+			check.fix = "make sure nothing else writes to the host's stdout"
+			return check
+		}
+		if response.Action != "ping" {
+			continue
+		}
+		if response.Status != "success" {
+			check.detail = fmt.Sprintf("ping failed with %s: %s", response.Status, response.Message)
+			check.fix = "check the log file for errors, or run with TABD_LOG_LEVEL=debug"
+			return check
+		}
+		check.status = "ok"
+		check.detail = "ping answered over stdin and stdout"
+		return check
+	}
+
+	check.detail = "no pong reply to a ping message"
+	if err != nil {
+		check.detail += fmt.Sprintf(" (%v)", err)
+	}
+	check.fix = "check the log file for errors, or run with TABD_LOG_LEVEL=debug"
+	return check
+}
+
+// newDoctorCommand creates the doctor command, which checks the host
+// installation and prints fixes for any problems
+func newDoctorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose installation, storage and keyring problems",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	browserFlag := flags.String("browser", "all", "browser to check ("+strings.Join(supportedBrowsers, ", ")+", or all)")
+	skipPipe := flags.Bool("skip-pipe", false, "do not launch the host to test the stdin pipe")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		browsers, err := selectBrowsers(*browserFlag)
+		if err != nil {
+			return err
+		}
+
+		config, err := LoadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		binaryCheck, executable := checkBinary()
+		checks := []*doctorCheck{binaryCheck}
+
+		installed := 0
+		for _, browser := range browsers {
+			check := checkManifest(browser, executable)
+			if check.status != "skip" {
+				installed++
+			}
+			checks = append(checks, check)
+		}
+		if installed == 0 {
+			checks = append(checks, &doctorCheck{
+				name:   "Manifests",
+				status: "fail",
+				detail: "no browser manifests installed",
+				fix:    "run: tabd-native-host install",
+			})
+		}
+
+		checks = append(checks, checkStorageDir(config.StorageDir), checkKeyring(), checkEncryption(config))
+		if !*skipPipe {
+			checks = append(checks, checkStdinPipe(executable))
+		}
+
+		failed := 0
+		for _, check := range checks {
+			fmt.Printf("[%s] %s: %s\n", check.status, check.name, check.detail)
+			if check.fix != "" {
+				fmt.Printf("       fix: %s\n", check.fix)
+			}
+			if check.status == "fail" {
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("doctor found %d problem(s)", failed)
+		}
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// errNoDPAPI is returned when the dpapi backend is selected outside Windows
+var errNoDPAPI = errors.New("the dpapi storage backend is only available on Windows")
+
+// newDPAPIStorage fails, as DPAPI only exists on Windows
+func newDPAPIStorage(tabdDir string) (SecureStorage, error) {
+	return nil, errNoDPAPI
+}
+
+func dpapiProtect(data []byte) ([]byte, error) {
+	return nil, errNoDPAPI
+}
+
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	return nil, errNoDPAPI
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// dpapiEntropy is mixed into every DPAPI blob, so other programs running as
+// the user cannot decrypt the files without knowing it
+var dpapiEntropy = []byte("tabd-native-host storage")
+
+// DPAPIStorage keeps each key in a file encrypted with DPAPI, which derives
+// the key from the user's Windows credentials, so no storage key or
+// passphrase file is needed
+type DPAPIStorage struct {
+	storageDir string
+}
+
+// newDPAPIStorage creates DPAPI storage in tabdDir
+func newDPAPIStorage(tabdDir string) (SecureStorage, error) {
+	return &DPAPIStorage{storageDir: tabdDir}, nil
+}
+
+// dataBlob points a DPAPI blob at a byte slice
+func dataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeDataBlob copies a blob allocated by DPAPI and frees it
+func takeDataBlob(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	return append([]byte{}, unsafe.Slice(blob.Data, blob.Size)...)
+}
+
+// dpapiProtect encrypts data for the current user
+func dpapiProtect(data []byte) ([]byte, error) {
+	description, err := windows.UTF16PtrFromString(keyringServiceName)
+	if err != nil {
+		return nil, err
+	}
+	var out windows.DataBlob
+	err = windows.CryptProtectData(dataBlob(data), description, dataBlob(dpapiEntropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, fmt.Errorf("CryptProtectData failed: %v", err)
+	}
+	return takeDataBlob(&out), nil
+}
+
+// dpapiUnprotect decrypts data encrypted by dpapiProtect
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(dataBlob(data), nil, dataBlob(dpapiEntropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, fmt.Errorf("CryptUnprotectData failed: %v", err)
+	}
+	return takeDataBlob(&out), nil
+}
+
+// DPAPIStorage implementation. Files carry the same checksum header as
+// encrypted files and are replaced atomically.
+func (d *DPAPIStorage) Store(key string, data []byte) error {
+	encrypted, err := dpapiProtect(compressPayload(data))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt data: %v", err)
+	}
+
+	filePath := filepath.Join(d.storageDir, key+".dpapi")
+	return writeFileAtomic(filePath, encodeEncFile(encrypted), 0600)
+}
+
+func (d *DPAPIStorage) Retrieve(key string) ([]byte, error) {
+	filePath := filepath.Join(d.storageDir, key+".dpapi")
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := decodeEncFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(filePath), err)
+	}
+
+	plaintext, err := dpapiUnprotect(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", filepath.Base(filePath), ErrDecrypt, err)
+	}
+	return decompressPayload(plaintext)
+}
+
+func (d *DPAPIStorage) Delete(key string) error {
+	return os.Remove(filepath.Join(d.storageDir, key+".dpapi"))
+}
+
+// Wipe overwrites the DPAPI file before removing it
+func (d *DPAPIStorage) Wipe(key string) error {
+	return wipeFile(filepath.Join(d.storageDir, key+".dpapi"))
+}
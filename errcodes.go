@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+)
+
+// Error codes sent in the code field of responses that did not succeed, so the
+// extension can branch on the kind of failure instead of matching messages
+const (
+	codeStorageFailed  = protocol.CodeStorageFailed
+	codeDecryptFailed  = protocol.CodeDecryptFailed
+	codeCorrupted      = protocol.CodeCorrupted
+	codeTooLarge       = protocol.CodeTooLarge
+	codeLocked         = protocol.CodeLocked
+	codeKeychainDenied = protocol.CodeKeychainDenied
+	codeRateLimited    = protocol.CodeRateLimited
+	codeNotFound       = protocol.CodeNotFound
+	codeInvalidRequest = protocol.CodeInvalidRequest
+	codeUnknownAction  = protocol.CodeUnknownAction
+	codeNotPermitted   = protocol.CodeNotPermitted
+	codeBlocked        = protocol.CodeBlocked
+	codeRefused        = protocol.CodeRefused
+	codeClipboard      = protocol.CodeClipboard
+	codeUnsupported    = protocol.CodeUnsupported
+	codeInternal       = protocol.CodeInternal
+	codeTimeout        = protocol.CodeTimeout
+	codeCancelled      = protocol.CodeCancelled
+)
+
+// codedError attaches an error code to an error
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// invalidRequestf reports a malformed or invalid message
+func invalidRequestf(format string, args ...interface{}) error {
+	return &codedError{code: codeInvalidRequest, err: fmt.Errorf(format, args...)}
+}
+
+// errorCode returns the code for an error returned by a handler. Errors that
+// are not otherwise classified come from storage.
+func errorCode(err error) string {
+	var coded *codedError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, ErrLocked):
+		return codeLocked
+	case errors.Is(err, ErrKeychainDenied):
+		return codeKeychainDenied
+	case errors.Is(err, ErrCorrupted):
+		return codeCorrupted
+	case errors.Is(err, ErrDecrypt):
+		return codeDecryptFailed
+	case errors.Is(err, ErrEntryNotFound):
+		return codeNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return codeTimeout
+	case errors.Is(err, errSessionStopped), errors.Is(err, context.Canceled):
+		return codeCancelled
+	default:
+		return codeStorageFailed
+	}
+}
+
+// Exit codes of CLI commands, so scripts can branch on the kind of failure
+// instead of parsing the error message
+const (
+	exitError      = 1
+	exitNotFound   = 2
+	exitLocked     = 3
+	exitCorrupt    = 4
+	exitPermission = 5
+
+	// exitUpdateAvailable is the status of update --check when a newer
+	// release exists, which is not a failure
+	exitUpdateAvailable = 6
+)
+
+// exitStatus ends a command that succeeded with a non-zero exit code, which
+// is reported without an error message
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// exitCode returns the exit code for an error returned by a command
+func exitCode(err error) int {
+	var status exitStatus
+	switch {
+	case errors.As(err, &status):
+		return int(status)
+	case errors.Is(err, os.ErrNotExist):
+		return exitNotFound
+	case errors.Is(err, os.ErrPermission):
+		return exitPermission
+	}
+
+	// Errors errorCode cannot otherwise classify count as storage failures,
+	// which exit with the generic code
+	switch errorCode(err) {
+	case codeNotFound:
+		return exitNotFound
+	case codeLocked:
+		return exitLocked
+	case codeCorrupted, codeDecryptFailed:
+		return exitCorrupt
+	case codeKeychainDenied, codeNotPermitted, codeBlocked, codeRefused:
+		return exitPermission
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	// entryEventsFeature is the hello feature a session lists to be sent
+	// entry_saved, entry_deleted, locked and unlocked events
+	entryEventsFeature = "entry_events"
+
+	// lockPollInterval is how often the daemon checks the unlock session
+	lockPollInterval = 5 * time.Second
+)
+
+// entrySavedEvent is the data of an entry_saved event. The preview is left
+// out for sensitive and incognito entries.
+type entrySavedEvent struct {
+	ID        string   `json:"id"`
+	Kind      string   `json:"kind,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	URL       string   `json:"url,omitempty"`
+	Preview   string   `json:"preview,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Sensitive []string `json:"sensitive,omitempty"`
+	Incognito bool     `json:"incognito,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+// entryDeletedEvent is the data of an entry_deleted event: the entry
+// deleted, or the number of entries removed at once by clear or retention
+type entryDeletedEvent struct {
+	ID     string `json:"id,omitempty"`
+	Count  int    `json:"count"`
+	Reason string `json:"reason"`
+}
+
+// unlockEvent is the data of a locked or unlocked event
+type unlockEvent struct {
+	// Expires is when the unlock session ends, in milliseconds
+	Expires int64 `json:"expires,omitempty"`
+
+	// Reason is why storage locked: expired or ended
+	Reason string `json:"reason,omitempty"`
+}
+
+// broadcastEntryEvent sends an entry or lock event to the sessions that
+// asked for them
+func (t *TabdNativeHost) broadcastEntryEvent(name string, data interface{}) {
+	t.broadcastTo(entryEventsFeature, &Event{
+		Event:     name,
+		Data:      data,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// broadcastEntrySaved announces a saved entry
+func (t *TabdNativeHost) broadcastEntrySaved(id string, data *ClipboardData) {
+	event := &entrySavedEvent{
+		ID:        id,
+		Kind:      data.Kind,
+		Type:      data.Type,
+		URL:       data.URL,
+		Tags:      data.Tags,
+		Sensitive: data.Sensitive,
+		Incognito: data.Incognito,
+		Truncated: data.Truncated,
+		Timestamp: data.Timestamp,
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().UnixMilli()
+	}
+	if len(data.Sensitive) == 0 && !data.Incognito {
+		event.Preview = newEntryPreview(data).Text
+	}
+	t.broadcastEntryEvent("entry_saved", event)
+}
+
+// broadcastEntriesDeleted announces deleted entries, giving the ID when a
+// single entry was deleted
+func (t *TabdNativeHost) broadcastEntriesDeleted(id string, count int, reason string) {
+	if count > 0 {
+		t.broadcastEntryEvent("entry_deleted", &entryDeletedEvent{ID: id, Count: count, Reason: reason})
+	}
+}
+
+// runLockWatch follows the unlock session of passphrase protected storage
+// until stop is closed, announcing when it starts and ends. Storage is opened
+// as soon as a session starts; as with other hosts, the daemon keeps access
+// when the session ends.
+func (t *TabdNativeHost) runLockWatch(stop <-chan struct{}) {
+	keys := newMasterKeyStore(t.tabdDir)
+	var current *unlockSession
+	if session, ok := keys.session(); ok {
+		current = &session
+	}
+
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if !keys.protected() {
+			current = nil
+			continue
+		}
+		session, ok := keys.session()
+		switch {
+		case ok && (current == nil || session.Expires != current.Expires):
+			current = &session
+			if t.isLocked() {
+				t.ensureUnlocked()
+			}
+			t.broadcastEntryEvent("unlocked", &unlockEvent{Expires: session.Expires})
+		case !ok && current != nil:
+			reason := "ended"
+			if time.Now().UnixMilli() >= current.Expires {
+				reason = "expired"
+			}
+			current = nil
+			logInfof("Unlock session %s", reason)
+			t.broadcastEntryEvent("locked", &unlockEvent{Reason: reason})
+		}
+	}
+}
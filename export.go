@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// csvHeader lists the columns written by CSV exports
+var csvHeader = []string{"id", "timestamp", "type", "url", "title", "text", "contentType", "data", "flavors", "pinned", "tags", "note"}
+
+// parseDate parses a YYYY-MM-DD date or RFC 3339 timestamp in local time
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q (use YYYY-MM-DD or RFC 3339)", value)
+	}
+	return t, nil
+}
+
+// exportRecords loads the matching history entries, oldest first
+func exportRecords(history HistoryStore, since int64) ([]*HistoryRecord, error) {
+	entries, err := history.Query(HistoryQuery{Since: since})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clipboard history: %v", err)
+	}
+
+	records := make([]*HistoryRecord, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		data, err := history.Get(entry.ID)
+		if err != nil {
+			logWarnf("Skipping unreadable history entry %s: %v", entry.ID, err)
+			continue
+		}
+		if data.Timestamp == 0 {
+			data.Timestamp = entry.Timestamp
+		}
+		records = append(records, &HistoryRecord{ID: entry.ID, Pinned: entry.Pinned, ClipboardData: *data})
+	}
+
+	return records, nil
+}
+
+// writeRecords encodes history records in the given format
+func writeRecords(w io.Writer, records []*HistoryRecord, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, record := range records {
+			flavors := ""
+			if len(record.Flavors) > 0 {
+				flavorData, err := json.Marshal(record.Flavors)
+				if err != nil {
+					return err
+				}
+				flavors = string(flavorData)
+			}
+			row := []string{
+				record.ID,
+				strconv.FormatInt(record.Timestamp, 10),
+				record.Type,
+				record.URL,
+				record.Title,
+				record.Text,
+				record.ContentType,
+				record.Data,
+				flavors,
+				strconv.FormatBool(record.Pinned),
+				strings.Join(record.Tags, " "),
+				record.Note,
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unknown format: %s (use json, ndjson or csv)", format)
+	}
+}
+
+// readRecords decodes history records in the given format
+func readRecords(r io.Reader, format string) ([]*HistoryRecord, error) {
+	switch format {
+	case "json":
+		var records []*HistoryRecord
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, fmt.Errorf("invalid JSON export: %v", err)
+		}
+		return records, nil
+	case "ndjson":
+		records := []*HistoryRecord{}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxTransferSize)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var record HistoryRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return nil, fmt.Errorf("invalid NDJSON export on line %d: %v", line, err)
+			}
+			records = append(records, &record)
+		}
+		return records, scanner.Err()
+	case "csv":
+		reader := csv.NewReader(r)
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV export: %v", err)
+		}
+		if len(rows) == 0 {
+			return nil, nil
+		}
+
+		columns := make(map[string]int, len(rows[0]))
+		for i, name := range rows[0] {
+			columns[name] = i
+		}
+		field := func(row []string, name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+
+		records := make([]*HistoryRecord, 0, len(rows)-1)
+		for line, row := range rows[1:] {
+			record := &HistoryRecord{ID: field(row, "id")}
+			if value := field(row, "timestamp"); value != "" {
+				timestamp, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid timestamp on CSV row %d: %v", line+2, err)
+				}
+				record.Timestamp = timestamp
+			}
+			record.Type = field(row, "type")
+			record.URL = field(row, "url")
+			record.Title = field(row, "title")
+			record.Text = field(row, "text")
+			record.ContentType = field(row, "contentType")
+			record.Data = field(row, "data")
+			record.Pinned = field(row, "pinned") == "true"
+			record.Tags = strings.Fields(field(row, "tags"))
+			record.Note = field(row, "note")
+			if flavors := field(row, "flavors"); flavors != "" {
+				if err := json.Unmarshal([]byte(flavors), &record.Flavors); err != nil {
+					return nil, fmt.Errorf("invalid flavors on CSV row %d: %v", line+2, err)
+				}
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s (use json, ndjson or csv)", format)
+	}
+}
+
+// newExportCommand creates the export command, which writes clipboard history
+// to a file or stdout
+func newExportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export clipboard history as JSON, NDJSON or CSV",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	format := flags.String("format", "json", "output format: json, ndjson or csv")
+	sinceFlag := flags.String("since", "", "only export entries on or after this date (YYYY-MM-DD or RFC 3339)")
+	out := flags.String("out", "", "output file (defaults to stdout)")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		var since int64
+		if *sinceFlag != "" {
+			sinceTime, err := parseDate(*sinceFlag)
+			if err != nil {
+				return err
+			}
+			since = sinceTime.UnixMilli()
+		}
+
+		records, err := exportRecords(host.historyStore(), since)
+		if err != nil {
+			return err
+		}
+
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			file, err := os.OpenFile(*out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to create export file: %v", err)
+			}
+			defer file.Close()
+			w = file
+		}
+
+		if err := writeRecords(w, records, *format); err != nil {
+			return fmt.Errorf("failed to write export: %v", err)
+		}
+
+		if *out != "" {
+			notef("Exported %d entries to %s\n", len(records), *out)
+		}
+		return nil
+	})
+	return cmd
+}
+
+// newImportCommand creates the import command, which appends exported
+// clipboard history entries in file order
+func newImportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import clipboard history from an export",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	format := flags.String("format", "json", "input format: json, ndjson or csv")
+	in := flags.String("in", "", "input file (defaults to stdin)")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		var r io.Reader = os.Stdin
+		if *in != "" {
+			file, err := os.Open(*in)
+			if err != nil {
+				return fmt.Errorf("failed to open import file: %w", err)
+			}
+			defer file.Close()
+			r = file
+		}
+
+		records, err := readRecords(r, *format)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return errors.New("no entries to import")
+		}
+
+		if err := importRecords(host.historyStore(), records); err != nil {
+			return err
+		}
+
+		notef("Imported %d entries\n", len(records))
+		return nil
+	})
+	return cmd
+}
+
+// importRecords appends history records in order, pinning those that were
+// pinned. Entries are encrypted with this machine's storage key.
+func importRecords(history HistoryStore, records []*HistoryRecord) error {
+	for _, record := range records {
+		id, err := history.Append(&record.ClipboardData)
+		if err != nil {
+			return fmt.Errorf("failed to import entry %s: %v", record.ID, err)
+		}
+		if record.Pinned {
+			if err := history.Pin(id, true); err != nil {
+				return fmt.Errorf("failed to pin imported entry %s: %v", record.ID, err)
+			}
+		}
+	}
+	return nil
+}
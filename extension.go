@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// chromiumOriginPrefix starts the origin Chromium browsers pass to the host
+const chromiumOriginPrefix = "chrome-extension://"
+
+// callerExtension identifies the extension that started the host from the
+// arguments the browser passes, returning "" if there are none. Chromium
+// browsers pass the extension origin, such as chrome-extension://<id>/, and
+// on Windows a --parent-window handle; Firefox passes the path of the host
+// manifest followed by the add-on ID.
+func callerExtension(args []string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, chromiumOriginPrefix) {
+			return arg
+		}
+	}
+	if len(args) >= 2 && strings.HasSuffix(strings.ToLower(args[0]), ".json") {
+		return args[1]
+	}
+	return ""
+}
+
+// extensionID returns the ID part of an extension origin or ID
+func extensionID(extension string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(extension, chromiumOriginPrefix), "/")
+}
+
+// extensionAllowed reports whether the config allows an extension to use the
+// host. Entries are extension IDs or origins; "*" allows every extension.
+func (c *Config) extensionAllowed(extension string) bool {
+	id := extensionID(extension)
+	for _, allowed := range c.AllowedExtensions {
+		if allowed == "*" || extensionID(allowed) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCaller refuses to serve an extension the config does not allow. Any
+// extension can register the host name in its own manifest, so the browser's
+// allowed_origins check alone does not keep other extensions out.
+func (t *TabdNativeHost) checkCaller() error {
+	if t.caller == "" {
+		logDebugf("Started without an extension origin")
+		return nil
+	}
+	if !t.config.extensionAllowed(t.caller) {
+		return fmt.Errorf("refusing connection from extension %s, which is not in allowedExtensions", t.caller)
+	}
+	logDebugf("Connection from extension %s", t.caller)
+	return nil
+}
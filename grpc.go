@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/tabdpb"
+)
+
+// grpcPreface starts the HTTP/2 connection preface gRPC clients send. Read as
+// the length of a native messaging frame it exceeds maxTransferSize, so a
+// protocol session never starts with it.
+var grpcPreface = []byte("PRI ")
+
+// grpcCodes maps tabd error codes to gRPC status codes
+var grpcCodes = map[string]codes.Code{
+	codeStorageFailed:  codes.Internal,
+	codeDecryptFailed:  codes.DataLoss,
+	codeCorrupted:      codes.DataLoss,
+	codeTooLarge:       codes.ResourceExhausted,
+	codeLocked:         codes.FailedPrecondition,
+	codeKeychainDenied: codes.FailedPrecondition,
+	codeRateLimited:    codes.ResourceExhausted,
+	codeNotFound:       codes.NotFound,
+	codeInvalidRequest: codes.InvalidArgument,
+	codeUnknownAction:  codes.Unimplemented,
+	codeNotPermitted:   codes.PermissionDenied,
+	codeBlocked:        codes.PermissionDenied,
+	codeRefused:        codes.PermissionDenied,
+	codeClipboard:      codes.Unavailable,
+	codeUnsupported:    codes.Unimplemented,
+	codeInternal:       codes.Internal,
+	codeTimeout:        codes.DeadlineExceeded,
+	codeCancelled:      codes.Canceled,
+}
+
+// grpcConn is a daemon connection handed to the gRPC server after its first
+// bytes were read to tell it apart from a protocol session
+type grpcConn struct {
+	net.Conn
+	reader *bufio.Reader
+	addr   *grpcClient
+}
+
+func (c *grpcConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// RemoteAddr identifies the client, so calls on one connection share its
+// session
+func (c *grpcConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// grpcClient is the peer address of a gRPC connection, carrying the session
+// its calls are dispatched through
+type grpcClient struct {
+	net.Addr
+	session *Session
+}
+
+// connListener is a net.Listener accepting the connections passed to it, for
+// serving gRPC on connections accepted by the daemon
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// hand passes a connection to the server, returning false once the listener
+// is closed
+func (l *connListener) hand(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// isGRPC reports whether a connection opens with the HTTP/2 preface
+func isGRPC(reader *bufio.Reader) bool {
+	start, err := reader.Peek(len(grpcPreface))
+	return err == nil && bytes.Equal(start, grpcPreface)
+}
+
+// grpcService implements the Tabd gRPC service by dispatching each call as
+// the equivalent native messaging action
+type grpcService struct {
+	tabdpb.UnimplementedTabdServer
+	host *TabdNativeHost
+
+	// Closed when the daemon shuts down, ending watch streams
+	quit chan struct{}
+}
+
+// newGRPCServer creates the gRPC server of the daemon
+func newGRPCServer(service *grpcService) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.MaxRecvMsgSize(maxTransferSize),
+		grpc.MaxSendMsgSize(maxTransferSize),
+	)
+	tabdpb.RegisterTabdServer(server, service)
+	return server
+}
+
+// call dispatches an action for a gRPC call, returning its data or the failure
+// as a gRPC status with the tabd error code in the tabd-code trailer
+func (g *grpcService) call(ctx context.Context, msg *Message) (interface{}, error) {
+	session := g.session(ctx)
+	response := g.host.dispatch(ctx, session, msg)
+	if response.Status == "success" {
+		return response.Data, nil
+	}
+
+	grpc.SetTrailer(ctx, metadata.Pairs("tabd-code", response.Code))
+	code, ok := grpcCodes[response.Code]
+	if !ok {
+		code = codes.Unknown
+	}
+	return nil, status.Error(code, response.Message)
+}
+
+// session returns the session of the connection a call arrived on
+func (g *grpcService) session(ctx context.Context) *Session {
+	if p, ok := peer.FromContext(ctx); ok {
+		if client, ok := p.Addr.(*grpcClient); ok {
+			return client.session
+		}
+	}
+	session := g.host.NewSession(nil, nil)
+	session.allowMsgpack = true
+	return session
+}
+
+func (g *grpcService) Save(ctx context.Context, request *tabdpb.SaveRequest) (*tabdpb.SaveResponse, error) {
+	msg := &Message{
+		Action:          "save",
+		SystemClipboard: request.SystemClipboard,
+		Pin:             request.Pin,
+		Register:        request.Register,
+		ClipboardData:   clipboardDataFromProto(request.Entry),
+	}
+	if request.Full {
+		msg.Action = "save_full"
+	}
+
+	data, err := g.call(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := data.(*saveResult)
+	if !ok {
+		return nil, status.Error(codes.Internal, "unexpected save result")
+	}
+	response := &tabdpb.SaveResponse{
+		Id:         result.ID,
+		Register:   result.Register,
+		Truncated:  result.Truncated,
+		FullLength: int64(result.FullLength),
+	}
+	if result.SensitiveReport != nil {
+		response.Sensitive = result.SensitiveReport.Sensitive
+		response.Decision = result.SensitiveReport.Decision
+	}
+	return response, nil
+}
+
+func (g *grpcService) Get(ctx context.Context, request *tabdpb.GetRequest) (*tabdpb.Entry, error) {
+	data, err := g.call(ctx, &Message{Action: "get", ID: request.Id})
+	if err != nil {
+		return nil, err
+	}
+	switch entry := data.(type) {
+	case *HistoryRecord:
+		return clipboardDataToProto(entry.ID, &entry.ClipboardData), nil
+	case *ClipboardData:
+		return clipboardDataToProto("", entry), nil
+	default:
+		return nil, status.Error(codes.Internal, "unexpected get result")
+	}
+}
+
+func (g *grpcService) List(ctx context.Context, request *tabdpb.ListRequest) (*tabdpb.ListResponse, error) {
+	data, err := g.call(ctx, &Message{
+		Action: "list",
+		Limit:  int(request.Limit),
+		Offset: int(request.Offset),
+		Since:  request.Since,
+		Origin: request.Origin,
+		ClipboardData: ClipboardData{
+			Kind: request.Kind,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result, ok := data.(*listResult)
+	if !ok {
+		return nil, status.Error(codes.Internal, "unexpected list result")
+	}
+	response := &tabdpb.ListResponse{
+		Entries: make([]*tabdpb.EntrySummary, 0, len(result.Entries)),
+		Total:   int32(result.Total),
+		HasMore: result.HasMore,
+	}
+	for _, entry := range result.Entries {
+		response.Entries = append(response.Entries, &tabdpb.EntrySummary{
+			Id:          entry.ID,
+			Preview:     entry.Preview,
+			Kind:        entry.Kind,
+			Language:    entry.Language,
+			Timestamp:   entry.Timestamp,
+			Url:         entry.URL,
+			Title:       entry.Title,
+			Type:        entry.Type,
+			ContentType: entry.ContentType,
+			LinkTitle:   entry.LinkTitle,
+			Favicon:     entry.Favicon,
+			Pinned:      entry.Pinned,
+			Tags:        entry.Tags,
+			Incognito:   entry.Incognito,
+		})
+	}
+	return response, nil
+}
+
+func (g *grpcService) Watch(request *tabdpb.WatchRequest, stream grpc.ServerStreamingServer[tabdpb.WatchEvent]) error {
+	wanted := map[string]bool{}
+	for _, event := range request.Events {
+		wanted[event] = true
+	}
+
+	events, unsubscribe := g.host.subscribe(entryEventsFeature)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-g.quit:
+			return status.Error(codes.Unavailable, "the daemon is shutting down")
+		case event := <-events.events:
+			if len(wanted) > 0 && !wanted[event.Event] {
+				continue
+			}
+			if err := stream.Send(watchEventToProto(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// clipboardDataFromProto converts an entry sent to the gRPC API
+func clipboardDataFromProto(entry *tabdpb.Entry) ClipboardData {
+	if entry == nil {
+		return ClipboardData{}
+	}
+	data := ClipboardData{
+		Type:        entry.Type,
+		Text:        entry.Text,
+		Timestamp:   entry.Timestamp,
+		URL:         entry.Url,
+		Title:       entry.Title,
+		ContentType: entry.ContentType,
+		Flavors:     entry.Flavors,
+		Sensitive:   entry.Sensitive,
+		Tags:        entry.Tags,
+		Note:        entry.Note,
+		Kind:        entry.Kind,
+		Language:    entry.Language,
+		Incognito:   entry.Incognito,
+	}
+	if len(entry.Data) > 0 {
+		data.Data = base64.StdEncoding.EncodeToString(entry.Data)
+	}
+	return data
+}
+
+// clipboardDataToProto converts an entry returned by the gRPC API
+func clipboardDataToProto(id string, data *ClipboardData) *tabdpb.Entry {
+	entry := &tabdpb.Entry{
+		Id:          id,
+		Type:        data.Type,
+		Text:        data.Text,
+		Timestamp:   data.Timestamp,
+		Url:         data.URL,
+		Title:       data.Title,
+		ContentType: data.ContentType,
+		Flavors:     data.Flavors,
+		Sensitive:   data.Sensitive,
+		Tags:        data.Tags,
+		Note:        data.Note,
+		Kind:        data.Kind,
+		Language:    data.Language,
+		Incognito:   data.Incognito,
+		Truncated:   data.Truncated,
+		FullLength:  int64(data.FullLength),
+	}
+	if data.Data != "" {
+		entry.Data, _ = base64.StdEncoding.DecodeString(data.Data)
+	}
+	return entry
+}
+
+// watchEventToProto converts an entry or lock event for a watch stream
+func watchEventToProto(event *Event) *tabdpb.WatchEvent {
+	message := &tabdpb.WatchEvent{Event: event.Event, Timestamp: event.Timestamp}
+	switch data := event.Data.(type) {
+	case *entrySavedEvent:
+		message.Data = &tabdpb.WatchEvent_EntrySaved{EntrySaved: &tabdpb.EntrySaved{
+			Id:        data.ID,
+			Kind:      data.Kind,
+			Type:      data.Type,
+			Url:       data.URL,
+			Preview:   data.Preview,
+			Tags:      data.Tags,
+			Sensitive: data.Sensitive,
+			Incognito: data.Incognito,
+			Truncated: data.Truncated,
+			Timestamp: data.Timestamp,
+		}}
+	case *entryDeletedEvent:
+		message.Data = &tabdpb.WatchEvent_EntryDeleted{EntryDeleted: &tabdpb.EntryDeleted{
+			Id:     data.ID,
+			Count:  int32(data.Count),
+			Reason: data.Reason,
+		}}
+	case *unlockEvent:
+		message.Data = &tabdpb.WatchEvent_LockChanged{LockChanged: &tabdpb.LockChanged{
+			Expires: data.Expires,
+			Reason:  data.Reason,
+		}}
+	}
+	return message
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// protocolVersion is the newest native messaging protocol version the host speaks
+	protocolVersion = 1
+
+	// minProtocolVersion is the oldest protocol version the host still accepts
+	minProtocolVersion = 1
+)
+
+// HelloResult is returned in response to a hello message
+type HelloResult struct {
+	ProtocolVersion    int       `json:"protocolVersion"`
+	MinProtocolVersion int       `json:"minProtocolVersion"`
+	MaxProtocolVersion int       `json:"maxProtocolVersion"`
+	Features           []string  `json:"features"`
+	Actions            []string  `json:"actions"`
+	MaxMessageSize     int       `json:"maxMessageSize"`
+	MaxResponseSize    int       `json:"maxResponseSize"`
+	MaxTransferSize    int       `json:"maxTransferSize"`
+	Locked             bool      `json:"locked,omitempty"`
+	Profile            string    `json:"profile,omitempty"`
+	WireFormat         string    `json:"wireFormat"`
+	Host               BuildInfo `json:"host"`
+}
+
+// hostFeatures returns the optional features supported by this host
+func hostFeatures() []string {
+	return []string{"history", "system_clipboard", "images", "flavors", "chunking", "events", "flow_control", entryEventsFeature}
+}
+
+// negotiateVersion picks the protocol version to use with a peer. Peers that
+// don't send a version predate the handshake and are treated as version 1.
+func negotiateVersion(peerVersion int) (int, error) {
+	if peerVersion == 0 {
+		return minProtocolVersion, nil
+	}
+	if peerVersion < minProtocolVersion {
+		return 0, &codedError{
+			code: codeUnsupported,
+			err:  fmt.Errorf("Unsupported protocol version %d (host supports %d-%d)", peerVersion, minProtocolVersion, protocolVersion),
+		}
+	}
+	if peerVersion > protocolVersion {
+		// Newer peers are expected to fall back to the host's version
+		return protocolVersion, nil
+	}
+	return peerVersion, nil
+}
+
+// commonFeatures returns the host features also requested by the peer. A peer
+// that doesn't list features gets every host feature.
+func commonFeatures(peerFeatures []string) []string {
+	features := hostFeatures()
+	if peerFeatures == nil {
+		return features
+	}
+
+	requested := make(map[string]bool, len(peerFeatures))
+	for _, feature := range peerFeatures {
+		requested[feature] = true
+	}
+
+	common := []string{}
+	for _, feature := range features {
+		if requested[feature] {
+			common = append(common, feature)
+		}
+	}
+	return common
+}
+
+// hasFeature reports whether a feature was negotiated with the peer
+func (s *Session) hasFeature(feature string) bool {
+	for _, f := range s.features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// handleHello negotiates the protocol version and features with the extension
+func (t *TabdNativeHost) handleHello(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	version, err := negotiateVersion(msg.ProtocolVersion)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Only local clients can switch formats; a hello without one keeps the
+	// current format, so a relayed browser hello does not undo the switch
+	wireFormat := session.format()
+	switch msg.WireFormat {
+	case "":
+	case wireJSON:
+		wireFormat = wireJSON
+	case wireMsgpack:
+		if !session.allowMsgpack {
+			return "", nil, invalidRequestf("wire format %s is only available to daemon clients", msg.WireFormat)
+		}
+		wireFormat = wireMsgpack
+	default:
+		return "", nil, invalidRequestf("unsupported wire format: %s", msg.WireFormat)
+	}
+	if wireFormat != session.format() {
+		session.formatMu.Lock()
+		session.nextWireFormat = wireFormat
+		session.formatMu.Unlock()
+	}
+
+	// Peers may ask for smaller frames than the browser allows, never larger
+	frameLimit := maxMessageSize
+	if msg.MaxMessageSize != 0 {
+		if msg.MaxMessageSize < minMessageSize {
+			return "", nil, invalidRequestf("maxMessageSize must be at least %d bytes", minMessageSize)
+		}
+		frameLimit = min(msg.MaxMessageSize, maxMessageSize)
+	}
+
+	session.protocolVersion = version
+	session.features = commonFeatures(msg.Features)
+	session.frameLimit = frameLimit
+
+	// Only the actions this session may send are advertised
+	actions := []string{}
+	for _, action := range t.actionNames() {
+		if session.permitted(action) {
+			actions = append(actions, action)
+		}
+	}
+
+	return fmt.Sprintf("Using protocol version %d", version), &HelloResult{
+		ProtocolVersion:    version,
+		MinProtocolVersion: minProtocolVersion,
+		MaxProtocolVersion: protocolVersion,
+		Features:           session.features,
+		Actions:            actions,
+		MaxMessageSize:     session.incomingLimit(),
+		MaxResponseSize:    frameLimit,
+		MaxTransferSize:    maxTransferSize,
+		Locked:             t.isLocked(),
+		Profile:            currentProfile(),
+		WireFormat:         wireFormat,
+		Host:               buildInfo(),
+	}, nil
+}
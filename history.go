@@ -0,0 +1,669 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// historyIndexKey is the storage key holding the ordered list of history entries
+	historyIndexKey = "history_index"
+
+	// historyPinnedKey is the storage key holding the IDs of pinned entries
+	historyPinnedKey = "history_pinned"
+
+	// historyEntryPrefix is prepended to entry IDs to form their storage keys
+	historyEntryPrefix = "history_"
+
+	// defaultMaxHistory is the number of entries kept when no cap is configured
+	defaultMaxHistory = 500
+)
+
+// ErrEntryNotFound is returned when a history entry does not exist
+var ErrEntryNotFound = errors.New("history entry not found")
+
+// contextHistory is implemented by history stores that give up on an
+// operation whose context is done, checking it before anything is written
+type contextHistory interface {
+	AppendContext(ctx context.Context, data *ClipboardData) (string, error)
+	GetContext(ctx context.Context, id string) (*ClipboardData, error)
+}
+
+// appendEntry appends an entry for a request, passing its context on if the
+// history takes one and otherwise checking it first
+func appendEntry(ctx context.Context, history HistoryStore, data *ClipboardData) (string, error) {
+	if c, ok := history.(contextHistory); ok {
+		return c.AppendContext(ctx, data)
+	}
+	if err := contextErr(ctx); err != nil {
+		return "", err
+	}
+	return history.Append(data)
+}
+
+// getEntry retrieves an entry for a request as appendEntry appends one
+func getEntry(ctx context.Context, history HistoryStore, id string) (*ClipboardData, error) {
+	if c, ok := history.(contextHistory); ok {
+		return c.GetContext(ctx, id)
+	}
+	if err := contextErr(ctx); err != nil {
+		return nil, err
+	}
+	return history.Get(id)
+}
+
+// HistoryStore is implemented by clipboard history backends
+type HistoryStore interface {
+	// Append stores a new entry and prunes the oldest entries beyond the cap
+	Append(data *ClipboardData) (string, error)
+	// List returns all entries, newest first
+	List() ([]HistoryEntry, error)
+	// Query returns the entries matching a query, newest first
+	Query(query HistoryQuery) ([]HistoryEntry, error)
+	// Get retrieves a single entry by ID
+	Get(id string) (*ClipboardData, error)
+	// Update replaces the data of an existing entry, including its timestamp
+	Update(id string, data *ClipboardData) error
+	// Delete removes a single entry by ID
+	Delete(id string) error
+	// Prune removes the oldest entries beyond the configured cap
+	Prune() error
+	// Clear removes entries older than before (or all entries if before is
+	// zero), overwriting their stored contents first if wipe is set, and
+	// returns the number of entries removed
+	Clear(before int64, wipe bool) (int, error)
+	// Expire removes unpinned entries older than before and returns the
+	// number of entries removed
+	Expire(before int64) (int, error)
+	// Pin marks an entry as pinned, exempting it from expiry, or unpins it
+	Pin(id string, pinned bool) error
+}
+
+// HistoryQuery filters history entries. Zero-valued fields match everything
+// and a Limit of zero means no limit.
+type HistoryQuery struct {
+	URL  string
+	Type string
+
+	// Kind matches the detected content kind, such as url or code
+	Kind string
+
+	// Origin matches source pages as the origin rules do: an origin such as
+	// https://mail.example.org exactly, a bare host name with its subdomains
+	Origin string
+
+	Since  int64
+	Until  int64
+	Pinned bool
+	Tag    string
+	Limit  int
+	Offset int
+}
+
+// Matches reports whether an entry satisfies the query filters
+func (q HistoryQuery) Matches(entry HistoryEntry) bool {
+	if q.URL != "" && entry.URL != q.URL {
+		return false
+	}
+	if q.Type != "" && entry.Type != q.Type {
+		return false
+	}
+	if q.Kind != "" && entry.Kind != q.Kind {
+		return false
+	}
+	if q.Origin != "" && !urlMatchesOrigin(q.Origin, entry.URL) {
+		return false
+	}
+	if q.Since != 0 && entry.Timestamp < q.Since {
+		return false
+	}
+	if q.Until != 0 && entry.Timestamp > q.Until {
+		return false
+	}
+	if q.Pinned && !entry.Pinned {
+		return false
+	}
+	if q.Tag != "" && !entry.HasTag(q.Tag) {
+		return false
+	}
+	return true
+}
+
+// HistoryEntry describes a single clipboard item in the history index
+type HistoryEntry struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	URL       string `json:"url,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Pinned    bool   `json:"pinned,omitempty"`
+
+	// Tags are copied from the entry so history can be filtered by tag
+	// without decrypting every entry
+	Tags []string `json:"tags,omitempty"`
+
+	// Kind and Language are the content classification, copied for the same
+	// reason. Entries saved by older versions have none.
+	Kind     string `json:"kind,omitempty"`
+	Language string `json:"language,omitempty"`
+
+	// Preview summarises the content for listings. Entries saved by older
+	// versions have none.
+	Preview *EntryPreview `json:"preview,omitempty"`
+
+	// Incognito marks entries kept in memory only
+	Incognito bool `json:"incognito,omitempty"`
+}
+
+// HasTag reports whether the entry carries a tag
+func (e HistoryEntry) HasTag(tag string) bool {
+	for _, entryTag := range e.Tags {
+		if entryTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// HistoryRecord pairs stored clipboard data with its history ID
+type HistoryRecord struct {
+	ID     string `json:"id"`
+	Pinned bool   `json:"pinned,omitempty"`
+	ClipboardData
+}
+
+// History keeps every saved clipboard item as its own encrypted entry,
+// tracked by an index ordered from oldest to newest
+type History struct {
+	storage    SecureStorage
+	maxEntries int
+	mu         sync.Mutex
+
+	// lockPath is the advisory lock taken by changes, so that concurrent
+	// host instances do not overwrite each other's index updates
+	lockPath string
+}
+
+// NewHistory creates a history backed by the given storage, keeping at most
+// maxEntries items (or defaultMaxHistory if maxEntries is not positive).
+// Changes are serialised across processes through the lock file at lockPath.
+func NewHistory(storage SecureStorage, maxEntries int, lockPath string) *History {
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxHistory
+	}
+	return &History{
+		storage:    storage,
+		maxEntries: maxEntries,
+		lockPath:   lockPath,
+	}
+}
+
+// lock takes h.mu and the storage lock file, returning a func that releases
+// both. If the file cannot be locked, changes are only serialised within
+// this process. History kept in memory has no lock file.
+func (h *History) lock() func() {
+	h.mu.Lock()
+	if h.lockPath == "" {
+		return h.mu.Unlock
+	}
+	lock, err := acquireFileLock(h.lockPath)
+	if err != nil {
+		logWarnf("History changes are not locked against other processes: %v", err)
+		return h.mu.Unlock
+	}
+	return func() {
+		lock.Release()
+		h.mu.Unlock()
+	}
+}
+
+// generateEntryID creates a sortable, unique ID for a new history entry
+func generateEntryID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(suffix))
+}
+
+// validEntryID matches the IDs generateEntryID creates
+var validEntryID = regexp.MustCompile(`^[0-9]{1,20}-[0-9a-f]{8}$`)
+
+// validateEntryID rejects an ID that generateEntryID could not have created,
+// so one sent by a client cannot name a storage key outside the history
+func validateEntryID(id string) error {
+	if !validEntryID.MatchString(id) {
+		return invalidRequestf("invalid history entry ID %q", id)
+	}
+	return nil
+}
+
+// loadIndex reads the history index, returning an empty index if none exists
+func (h *History) loadIndex() ([]HistoryEntry, error) {
+	jsonData, err := h.storage.Retrieve(historyIndexKey)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []HistoryEntry{}, nil
+		}
+		return nil, fmt.Errorf("failed to retrieve history index: %v", err)
+	}
+
+	var index []HistoryEntry
+	if err := json.Unmarshal(jsonData, &index); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal history index: %v", err)
+	}
+
+	return index, nil
+}
+
+// saveIndex writes the history index back to storage
+func (h *History) saveIndex(index []HistoryEntry) error {
+	jsonData, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history index: %v", err)
+	}
+	return h.storage.Store(historyIndexKey, jsonData)
+}
+
+// Append stores a new entry and prunes the oldest entries beyond the cap
+func (h *History) Append(data *ClipboardData) (string, error) {
+	return h.AppendContext(context.Background(), data)
+}
+
+// AppendContext appends an entry unless ctx is done before it is stored. The
+// index is updated whatever the deadline once the entry is, so no entry is
+// left out of it.
+func (h *History) AppendContext(ctx context.Context, data *ClipboardData) (string, error) {
+	defer h.lock()()
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal clipboard data: %v", err)
+	}
+
+	id := generateEntryID()
+	if err := storeKey(ctx, h.storage, historyEntryPrefix+id, jsonData); err != nil {
+		if ctxErr := contextErr(ctx); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", fmt.Errorf("failed to store history entry: %v", err)
+	}
+
+	index, err := h.loadIndex()
+	if err != nil {
+		return "", err
+	}
+
+	timestamp := data.Timestamp
+	if timestamp == 0 {
+		timestamp = time.Now().UnixMilli()
+	}
+	kind, language := classifyContent(data)
+	index = append(index, HistoryEntry{ID: id, Timestamp: timestamp, URL: data.URL, Type: data.Type, Tags: data.Tags, Kind: kind, Language: language, Preview: newEntryPreview(data), Incognito: data.Incognito})
+
+	if err := h.saveIndex(h.prune(index)); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// List returns the history index, newest first
+func (h *History) List() ([]HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	index, err := h.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	pinned, err := h.loadPinned()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]HistoryEntry, len(index))
+	for i, entry := range index {
+		entry.Pinned = pinned[entry.ID]
+		entries[len(index)-1-i] = entry
+	}
+	return entries, nil
+}
+
+// Query returns the entries matching a query, newest first
+func (h *History) Query(query HistoryQuery) ([]HistoryEntry, error) {
+	entries, err := h.List()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []HistoryEntry{}
+	for _, entry := range entries {
+		// Entries saved before kinds were kept are classified on demand
+		if query.Kind != "" && entry.Kind == "" {
+			if data, err := h.Get(entry.ID); err == nil {
+				entry.Kind, entry.Language = classifyContent(data)
+			}
+		}
+		if query.Matches(entry) {
+			matches = append(matches, entry)
+		}
+	}
+
+	return paginate(matches, query.Offset, query.Limit), nil
+}
+
+// Get retrieves a single entry by ID
+func (h *History) Get(id string) (*ClipboardData, error) {
+	return h.GetContext(context.Background(), id)
+}
+
+// GetContext retrieves an entry unless ctx is done first
+func (h *History) GetContext(ctx context.Context, id string) (*ClipboardData, error) {
+	if err := validateEntryID(id); err != nil {
+		return nil, err
+	}
+	jsonData, err := retrieveKey(ctx, h.storage, historyEntryPrefix+id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrEntryNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve history entry: %w", err)
+	}
+
+	var data ClipboardData
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal history entry: %v", err)
+	}
+
+	return &data, nil
+}
+
+// Update replaces the data of an existing entry, including its timestamp
+func (h *History) Update(id string, data *ClipboardData) error {
+	defer h.lock()()
+
+	index, err := h.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	position := -1
+	for i, entry := range index {
+		if entry.ID == id {
+			position = i
+			break
+		}
+	}
+	if position < 0 {
+		return ErrEntryNotFound
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal clipboard data: %v", err)
+	}
+	if err := h.storage.Store(historyEntryPrefix+id, jsonData); err != nil {
+		return fmt.Errorf("failed to store history entry: %v", err)
+	}
+
+	timestamp := data.Timestamp
+	if timestamp == 0 {
+		timestamp = time.Now().UnixMilli()
+	}
+	kind, language := classifyContent(data)
+	index[position] = HistoryEntry{ID: id, Timestamp: timestamp, URL: data.URL, Type: data.Type, Tags: data.Tags, Kind: kind, Language: language, Preview: newEntryPreview(data), Incognito: data.Incognito}
+
+	return h.saveIndex(index)
+}
+
+// Delete removes a single entry by ID
+func (h *History) Delete(id string) error {
+	defer h.lock()()
+
+	index, err := h.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	remaining := index[:0]
+	for _, entry := range index {
+		if entry.ID == id {
+			found = true
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+	if !found {
+		return ErrEntryNotFound
+	}
+
+	if err := h.storage.Delete(historyEntryPrefix + id); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete history entry: %v", err)
+	}
+
+	pinned, err := h.loadPinned()
+	if err != nil {
+		return err
+	}
+	if pinned[id] {
+		delete(pinned, id)
+		if err := h.savePinned(pinned); err != nil {
+			return err
+		}
+	}
+
+	return h.saveIndex(remaining)
+}
+
+// Prune removes the oldest entries beyond the configured cap
+func (h *History) Prune() error {
+	defer h.lock()()
+
+	index, err := h.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	return h.saveIndex(h.prune(index))
+}
+
+// prune deletes the oldest unpinned entries beyond the cap and returns the
+// remaining index. Pinned entries do not count towards the cap. Callers must
+// hold h.mu.
+func (h *History) prune(index []HistoryEntry) []HistoryEntry {
+	if len(index) <= h.maxEntries {
+		return index
+	}
+
+	pinned, err := h.loadPinned()
+	if err != nil {
+		logWarnf("Error loading pinned entries, skipping prune: %v", err)
+		return index
+	}
+
+	// Only pins of entries still in the index count; a stale pin must not
+	// raise the cap
+	kept := 0
+	for _, entry := range index {
+		if pinned[entry.ID] {
+			kept++
+		}
+	}
+	excess := len(index) - kept - h.maxEntries
+	remaining := index[:0]
+	for _, entry := range index {
+		if excess > 0 && !pinned[entry.ID] {
+			h.storage.Delete(historyEntryPrefix + entry.ID)
+			excess--
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+
+	return remaining
+}
+
+// Clear removes entries older than before (or all entries if before is zero)
+func (h *History) Clear(before int64, wipe bool) (int, error) {
+	defer h.lock()()
+
+	index, err := h.loadIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	// Clearing everything removes the pinned set as a whole below
+	pinned := make(map[string]bool)
+	if before != 0 {
+		if pinned, err = h.loadPinned(); err != nil {
+			return 0, err
+		}
+	}
+
+	removed := 0
+	unpinned := false
+	remaining := index[:0]
+	for _, entry := range index {
+		if before != 0 && entry.Timestamp >= before {
+			remaining = append(remaining, entry)
+			continue
+		}
+		if pinned[entry.ID] {
+			delete(pinned, entry.ID)
+			unpinned = true
+		}
+
+		key := historyEntryPrefix + entry.ID
+		if wipe {
+			err = wipeKey(h.storage, key)
+		} else {
+			err = h.storage.Delete(key)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logWarnf("Failed to remove history entry %s: %v", entry.ID, err)
+		}
+		removed++
+	}
+
+	if before == 0 {
+		if err := h.storage.Delete(historyPinnedKey); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logWarnf("Failed to remove pinned entries: %v", err)
+		}
+	} else if unpinned {
+		if err := h.savePinned(pinned); err != nil {
+			logWarnf("Failed to remove pins of cleared entries: %v", err)
+		}
+	}
+
+	return removed, h.saveIndex(remaining)
+}
+
+// loadPinned reads the set of pinned entry IDs. Callers must hold h.mu.
+func (h *History) loadPinned() (map[string]bool, error) {
+	pinned := make(map[string]bool)
+
+	jsonData, err := h.storage.Retrieve(historyPinnedKey)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return pinned, nil
+		}
+		return nil, fmt.Errorf("failed to retrieve pinned entries: %v", err)
+	}
+
+	var ids []string
+	if err := json.Unmarshal(jsonData, &ids); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pinned entries: %v", err)
+	}
+	for _, id := range ids {
+		pinned[id] = true
+	}
+
+	return pinned, nil
+}
+
+// savePinned writes the set of pinned entry IDs. Callers must hold h.mu.
+func (h *History) savePinned(pinned map[string]bool) error {
+	ids := make([]string, 0, len(pinned))
+	for id := range pinned {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jsonData, err := json.Marshal(ids)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pinned entries: %v", err)
+	}
+	return h.storage.Store(historyPinnedKey, jsonData)
+}
+
+// Expire removes unpinned entries older than before
+func (h *History) Expire(before int64) (int, error) {
+	defer h.lock()()
+
+	index, err := h.loadIndex()
+	if err != nil {
+		return 0, err
+	}
+	pinned, err := h.loadPinned()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	remaining := index[:0]
+	for _, entry := range index {
+		if entry.Timestamp >= before || pinned[entry.ID] {
+			remaining = append(remaining, entry)
+			continue
+		}
+		if err := h.storage.Delete(historyEntryPrefix + entry.ID); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logWarnf("Failed to remove expired history entry %s: %v", entry.ID, err)
+		}
+		removed++
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, h.saveIndex(remaining)
+}
+
+// Pin marks an entry as pinned, or unpins it
+func (h *History) Pin(id string, pin bool) error {
+	defer h.lock()()
+
+	index, err := h.loadIndex()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, entry := range index {
+		if entry.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ErrEntryNotFound
+	}
+
+	pinned, err := h.loadPinned()
+	if err != nil {
+		return err
+	}
+	if pin {
+		pinned[id] = true
+	} else {
+		delete(pinned, id)
+	}
+
+	return h.savePinned(pinned)
+}
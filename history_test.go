@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateEntryID(t *testing.T) {
+	if err := validateEntryID(generateEntryID()); err != nil {
+		t.Fatalf("generated ID rejected: %v", err)
+	}
+	for _, id := range []string{
+		"",
+		"index",
+		"1718000000000000000",
+		"1718000000000000000-1A2B3C4D",
+		"1718000000000000000-1a2b3c4d/../x",
+		"/../../other/secret",
+		`..\..\other\secret`,
+	} {
+		if err := validateEntryID(id); err == nil {
+			t.Errorf("validateEntryID(%q) accepted the ID", id)
+		}
+	}
+}
+
+func TestHistoryGetOutsideStorage(t *testing.T) {
+	storage := newTestFileStorage(t, newTestCipher(suiteAESGCM, "passphrase"))
+	outside := filepath.Join(filepath.Dir(storage.storageDir), "other")
+	if err := os.MkdirAll(outside, 0700); err != nil {
+		t.Fatal(err)
+	}
+	secret := &EncryptedFileStorage{storageDir: outside, cipher: storage.cipher}
+	if err := secret.Store("secret", []byte(`{"text":"secret"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	history := NewHistory(storage, 0, "")
+	data, err := history.Get("/../../other/secret")
+	if err == nil {
+		t.Fatalf("Get read %q from outside the storage directory", data.Text)
+	}
+	if code := errorCode(err); code != codeInvalidRequest {
+		t.Fatalf("errorCode = %s, want %s", code, codeInvalidRequest)
+	}
+
+	id, err := history.Append(&ClipboardData{Text: "inside", Type: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := history.Get(id); err != nil || data.Text != "inside" {
+		t.Fatalf("Get(%s) = %v, %v", id, data, err)
+	}
+}
+
+func TestHistoryDeletedPinDoesNotRaiseCap(t *testing.T) {
+	storage := newTestFileStorage(t, newTestCipher(suiteAESGCM, "passphrase"))
+	history := NewHistory(storage, 2, "")
+
+	pinnedID, err := history.Append(&ClipboardData{Text: "pinned", Type: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := history.Pin(pinnedID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := history.Delete(pinnedID); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"one", "two", "three"} {
+		if _, err := history.Append(&ClipboardData{Text: text, Type: "text"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := history.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("history kept %d entries after a pinned entry was deleted, want 2", len(entries))
+	}
+	pinned, err := history.loadPinned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned[pinnedID] {
+		t.Fatal("deleted entry is still pinned")
+	}
+}
+
+func TestHistoryClearBeforeDropsPins(t *testing.T) {
+	storage := newTestFileStorage(t, newTestCipher(suiteAESGCM, "passphrase"))
+	history := NewHistory(storage, 2, "")
+
+	id, err := history.Append(&ClipboardData{Text: "old", Type: "text", Timestamp: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := history.Pin(id, true); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := history.Clear(2000, false); err != nil || removed != 1 {
+		t.Fatalf("Clear = %d, %v", removed, err)
+	}
+
+	pinned, err := history.loadPinned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 0 {
+		t.Fatalf("cleared entries are still pinned: %v", pinned)
+	}
+}
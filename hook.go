@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHookTimeout is how long a hook may run when it sets no timeout
+	defaultHookTimeout = 10 * time.Second
+
+	// hookOutputLimit caps the hook output included in log lines
+	hookOutputLimit = 1024
+)
+
+// hookEvents are the events hooks can run on
+var hookEvents = []string{"on_save", "on_retrieve"}
+
+// HookConfig is a command run on a clipboard event, receiving the entry as
+// JSON on stdin
+type HookConfig struct {
+	Event   string   `json:"event"`
+	Command []string `json:"command"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+// validate checks the hook event, command and timeout
+func (h *HookConfig) validate() error {
+	known := false
+	for _, event := range hookEvents {
+		known = known || h.Event == event
+	}
+	if !known {
+		return fmt.Errorf("unknown hook event %q: must be one of %s", h.Event, strings.Join(hookEvents, ", "))
+	}
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return fmt.Errorf("%s hook has no command", h.Event)
+	}
+	if h.Timeout != "" {
+		if timeout, err := time.ParseDuration(h.Timeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid %s hook timeout %q", h.Event, h.Timeout)
+		}
+	}
+	return nil
+}
+
+// timeout returns how long the hook may run
+func (h *HookConfig) timeout() time.Duration {
+	if timeout, err := time.ParseDuration(h.Timeout); err == nil && timeout > 0 {
+		return timeout
+	}
+	return defaultHookTimeout
+}
+
+// Hooks runs the configured hook commands in the background
+type Hooks struct {
+	hooks []HookConfig
+	wg    sync.WaitGroup
+}
+
+// NewHooks creates a runner for the config hooks
+func NewHooks(config *Config) *Hooks {
+	return &Hooks{hooks: config.Hooks}
+}
+
+// Run starts every hook registered for the event with the entry on stdin,
+// without waiting for them. Sensitive entries are passed redacted.
+func (h *Hooks) Run(event, id string, data *ClipboardData) {
+	var matched []HookConfig
+	for _, hook := range h.hooks {
+		if hook.Event == event {
+			matched = append(matched, hook)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	// Hooks receive the same payload as webhooks
+	input, err := json.Marshal(&WebhookPayload{
+		Event:     event,
+		ID:        id,
+		Entry:     redactSensitive(data),
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		logErrorf("Error encoding hook input: %v", err)
+		return
+	}
+
+	for _, hook := range matched {
+		h.wg.Add(1)
+		go func(hook HookConfig) {
+			defer h.wg.Done()
+			h.execute(hook, id, input)
+		}(hook)
+	}
+}
+
+// execute runs a single hook and logs its outcome and captured output
+func (h *Hooks) execute(hook HookConfig, id string, input []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.timeout())
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "TABD_HOOK_EVENT="+hook.Event, "TABD_ENTRY_ID="+id)
+	// Do not wait on pipes held open by children of a killed hook
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", hook.timeout())
+	}
+	if err != nil {
+		logWarnf("Error running %s hook %s: %v%s", hook.Event, hook.Command[0], err, hookOutput(&stderr))
+		return
+	}
+	logDebugf("Ran %s hook %s in %s%s", hook.Event, hook.Command[0], time.Since(start).Round(time.Millisecond), hookOutput(&stdout))
+}
+
+// hookOutput returns captured hook output trimmed for appending to a log
+// line, or an empty string if there was none
+func hookOutput(output *bytes.Buffer) string {
+	text := strings.TrimSpace(output.String())
+	if text == "" {
+		return ""
+	}
+	if len(text) > hookOutputLimit {
+		text = text[:hookOutputLimit] + "..."
+	}
+	return ": " + text
+}
+
+// Wait blocks until running hooks finish or the longest hook timeout passes
+func (h *Hooks) Wait() {
+	timeout := time.Duration(0)
+	for _, hook := range h.hooks {
+		timeout = max(timeout, hook.timeout())
+	}
+	if !waitTimeout(&h.wg, timeout+time.Second) {
+		logWarnf("Gave up waiting for hooks after %s", timeout)
+	}
+}
+
+// waitTimeout waits for a wait group, returning false if the timeout passes
+// first
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultIncognitoTTL is how long incognito entries stay in memory when
+	// no TTL is configured
+	defaultIncognitoTTL = 15 * time.Minute
+
+	// maxIncognitoTTL bounds how long incognito entries may stay in memory
+	maxIncognitoTTL = 24 * time.Hour
+)
+
+// incognitoKeyPrefixes are the storage keys holding clipboard content, which
+// incognito mode keeps in memory. Other keys, such as the sync key and the
+// LAN identity, still reach disk storage.
+var incognitoKeyPrefixes = []string{"latest_clipboard", historyEntryPrefix, registerPrefix, tabSessionIndexName, tabSessionPrefix}
+
+// IncognitoConfig configures incognito mode, in which entries are kept only
+// in process memory
+type IncognitoConfig struct {
+	// Enabled starts native messaging hosts and the daemon in incognito mode
+	Enabled bool `json:"enabled,omitempty"`
+
+	// TTL is how long an entry stays in memory before it is wiped, e.g.
+	// "15m"
+	TTL string `json:"ttl,omitempty"`
+}
+
+// applyDefaults validates the incognito TTL
+func (i *IncognitoConfig) applyDefaults() error {
+	if i.TTL != "" {
+		if _, err := parseIncognitoTTL(i.TTL); err != nil {
+			return fmt.Errorf("invalid incognito ttl: %v", err)
+		}
+	}
+	return nil
+}
+
+// TTLPeriod returns how long incognito entries stay in memory
+func (i *IncognitoConfig) TTLPeriod() time.Duration {
+	if i == nil || i.TTL == "" {
+		return defaultIncognitoTTL
+	}
+	period, _ := parseIncognitoTTL(i.TTL)
+	return period
+}
+
+// parseIncognitoTTL parses an incognito TTL, which must be positive and at
+// most maxIncognitoTTL
+func parseIncognitoTTL(value string) (time.Duration, error) {
+	period, err := parseAge(value)
+	if err != nil {
+		return 0, err
+	}
+	if period <= 0 || period > maxIncognitoTTL {
+		return 0, fmt.Errorf("ttl must be positive and at most 24h")
+	}
+	return period, nil
+}
+
+// MemoryStorage keeps data in process memory only
+type MemoryStorage struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+// NewMemoryStorage creates empty memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{data: make(map[string][]byte)}
+}
+
+func (m *MemoryStorage) Store(key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if previous, ok := m.data[key]; ok {
+		clear(previous)
+	}
+	m.data[key] = append([]byte{}, data...)
+	return nil
+}
+
+func (m *MemoryStorage) Retrieve(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.data[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s not in memory", os.ErrNotExist, key)
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (m *MemoryStorage) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[key]; !ok {
+		return fmt.Errorf("%w: %s not in memory", os.ErrNotExist, key)
+	}
+	delete(m.data, key)
+	return nil
+}
+
+// Wipe zeroes the stored data before removing it
+func (m *MemoryStorage) Wipe(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.data[key]
+	if !ok {
+		return fmt.Errorf("%w: %s not in memory", os.ErrNotExist, key)
+	}
+	clear(data)
+	delete(m.data, key)
+	return nil
+}
+
+// WipeAll zeroes and removes everything stored
+func (m *MemoryStorage) WipeAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, data := range m.data {
+		clear(data)
+		delete(m.data, key)
+	}
+}
+
+// IncognitoStorage keeps clipboard content in memory and passes other keys
+// through to disk storage, which is nil while storage is locked
+type IncognitoStorage struct {
+	memory *MemoryStorage
+	disk   SecureStorage
+}
+
+// route returns the storage a key belongs in
+func (s *IncognitoStorage) route(key string) (SecureStorage, error) {
+	for _, prefix := range incognitoKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return s.memory, nil
+		}
+	}
+	if s.disk == nil {
+		return nil, ErrLocked
+	}
+	return s.disk, nil
+}
+
+func (s *IncognitoStorage) Store(key string, data []byte) error {
+	return s.StoreContext(context.Background(), key, data)
+}
+
+func (s *IncognitoStorage) StoreContext(ctx context.Context, key string, data []byte) error {
+	storage, err := s.route(key)
+	if err != nil {
+		return err
+	}
+	return storeKey(ctx, storage, key, data)
+}
+
+func (s *IncognitoStorage) Retrieve(key string) ([]byte, error) {
+	return s.RetrieveContext(context.Background(), key)
+}
+
+func (s *IncognitoStorage) RetrieveContext(ctx context.Context, key string) ([]byte, error) {
+	storage, err := s.route(key)
+	if err != nil {
+		return nil, err
+	}
+	return retrieveKey(ctx, storage, key)
+}
+
+func (s *IncognitoStorage) Delete(key string) error {
+	storage, err := s.route(key)
+	if err != nil {
+		return err
+	}
+	return storage.Delete(key)
+}
+
+// Wipe securely removes a key from whichever storage holds it
+func (s *IncognitoStorage) Wipe(key string) error {
+	storage, err := s.route(key)
+	if err != nil {
+		return err
+	}
+	return wipeKey(storage, key)
+}
+
+// memoryEntries is a history kept in process memory, whose entries are wiped
+// after a TTL
+type memoryEntries struct {
+	memory  *MemoryStorage
+	history *History
+	ttl     time.Duration
+	stop    chan struct{}
+}
+
+// incognitoMode is the state of incognito mode while it is on: the memory
+// storage and history in use, and the disk storage they stand in for
+type incognitoMode struct {
+	memoryEntries
+	storage     *IncognitoStorage
+	diskStorage SecureStorage
+	diskHistory HistoryStore
+}
+
+// incognitoSweepInterval returns how often entries are checked for expiry,
+// a tenth of the TTL within one second and one minute
+func incognitoSweepInterval(ttl time.Duration) time.Duration {
+	return min(max(ttl/10, time.Second), time.Minute)
+}
+
+// expire wipes entries stored longer ago than ttl
+func (m *memoryEntries) expire(ttl time.Duration) {
+	before := time.Now().Add(-ttl).UnixMilli()
+	removed, err := m.history.Clear(before, true)
+	if err != nil {
+		logErrorf("Error wiping incognito entries: %v", err)
+	} else if removed > 0 {
+		logInfof("Wiped %d incognito entries older than %s", removed, ttl)
+	}
+
+	if jsonData, err := m.memory.Retrieve("latest_clipboard"); err == nil {
+		var latest ClipboardData
+		if json.Unmarshal(jsonData, &latest) != nil || latest.Timestamp < before {
+			m.memory.Wipe("latest_clipboard")
+		}
+		clear(jsonData)
+	}
+}
+
+// run wipes entries older than ttl until stop is closed
+func (m *memoryEntries) run(stop <-chan struct{}, ttl time.Duration) {
+	ticker := time.NewTicker(incognitoSweepInterval(ttl))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.expire(ttl)
+		}
+	}
+}
+
+// wipe stops the sweeper and wipes every entry, returning how many there
+// were
+func (m *memoryEntries) wipe() int {
+	close(m.stop)
+	entries, _ := m.history.List()
+	m.memory.WipeAll()
+	return len(entries)
+}
+
+// incognitoResult is the response data of an incognito message
+type incognitoResult struct {
+	Enabled bool   `json:"enabled"`
+	TTL     string `json:"ttl,omitempty"`
+
+	// Entries is the number of entries held in memory, or wiped when
+	// incognito mode was turned off
+	Entries int `json:"entries"`
+}
+
+// isIncognito reports whether incognito mode is on
+func (t *TabdNativeHost) isIncognito() bool {
+	t.lockMu.Lock()
+	defer t.lockMu.Unlock()
+	return t.incognito != nil
+}
+
+// enableIncognito switches the host to memory storage, wiping entries after
+// ttl, or changes the TTL if incognito mode is already on
+func (t *TabdNativeHost) enableIncognito(ttl time.Duration) {
+	t.lockMu.Lock()
+	defer t.lockMu.Unlock()
+
+	if t.incognito != nil {
+		close(t.incognito.stop)
+		t.incognito.ttl = ttl
+		t.incognito.stop = make(chan struct{})
+		go t.incognito.run(t.incognito.stop, ttl)
+		return
+	}
+
+	storage := &IncognitoStorage{memory: NewMemoryStorage(), disk: t.storage()}
+	mode := &incognitoMode{
+		memoryEntries: memoryEntries{
+			memory:  storage.memory,
+			history: NewHistory(storage, t.config.MaxHistory, ""),
+			ttl:     ttl,
+			stop:    make(chan struct{}),
+		},
+		storage:     storage,
+		diskStorage: t.storage(),
+		diskHistory: t.historyStore(),
+	}
+	t.incognito = mode
+	t.setStorage(mode.storage, mode.history)
+	go mode.run(mode.stop, ttl)
+	logInfof("Incognito mode on: entries are kept in memory for %s", ttl)
+}
+
+// disableIncognito wipes the entries kept in memory and switches the host
+// back to disk storage, returning the number of entries wiped
+func (t *TabdNativeHost) disableIncognito() int {
+	t.lockMu.Lock()
+	defer t.lockMu.Unlock()
+
+	mode := t.incognito
+	if mode == nil {
+		return 0
+	}
+	wiped := mode.wipe()
+
+	t.incognito = nil
+	t.setStorage(mode.diskStorage, mode.diskHistory)
+	logInfof("Incognito mode off: wiped %d entries from memory", wiped)
+	return wiped
+}
+
+// setDiskStorage installs storage opened after an unlock, behind the memory
+// storage while incognito mode is on. The caller holds lockMu.
+func (t *TabdNativeHost) setDiskStorage(secureStorage SecureStorage, history HistoryStore) {
+	if t.incognitoEntries != nil {
+		history = &incognitoHistory{memory: t.incognitoEntries.history, disk: history}
+	}
+	if t.incognito == nil {
+		t.setStorage(secureStorage, history)
+		return
+	}
+	t.incognito.storage.disk = secureStorage
+	t.incognito.diskStorage, t.incognito.diskHistory = secureStorage, history
+}
+
+// runRetrieveHooks runs the on_retrieve hooks for an entry unless it is an
+// incognito entry, which stays in this process
+func (t *TabdNativeHost) runRetrieveHooks(id string, data *ClipboardData) {
+	if !data.Incognito && !t.isIncognito() {
+		t.hooks.Run("on_retrieve", id, data)
+	}
+}
+
+// startIncognito turns incognito mode on for a native messaging host or the
+// daemon if the config enables it
+func (t *TabdNativeHost) startIncognito() {
+	if t.config.Incognito != nil && t.config.Incognito.Enabled {
+		t.enableIncognito(t.config.Incognito.TTLPeriod())
+	}
+}
+
+// handleIncognito turns incognito mode on or off as msg.Enabled asks, with
+// msg.TTL overriding the configured TTL, or reports whether it is on
+func (t *TabdNativeHost) handleIncognito(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	if msg.Enabled == nil {
+		if !t.isIncognito() {
+			return "Incognito mode is off", &incognitoResult{}, nil
+		}
+		t.lockMu.Lock()
+		ttl := t.incognito.ttl
+		history := t.incognito.history
+		t.lockMu.Unlock()
+		entries, _ := history.List()
+		return "Incognito mode is on", &incognitoResult{Enabled: true, TTL: ttl.String(), Entries: len(entries)}, nil
+	}
+
+	if !*msg.Enabled {
+		wiped := t.disableIncognito()
+		t.broadcastIncognito(false)
+		return fmt.Sprintf("Incognito mode off, wiped %d entries", wiped), &incognitoResult{Entries: wiped}, nil
+	}
+
+	ttl := t.config.Incognito.TTLPeriod()
+	if msg.TTL != "" {
+		var err error
+		if ttl, err = parseIncognitoTTL(msg.TTL); err != nil {
+			return "", nil, invalidRequestf("invalid ttl: %v", err)
+		}
+	}
+	t.enableIncognito(ttl)
+	t.broadcastIncognito(true)
+	return fmt.Sprintf("Incognito mode on, entries are wiped after %s", ttl), &incognitoResult{Enabled: true, TTL: ttl.String()}, nil
+}
+
+// broadcastIncognito tells connected clients that incognito mode changed
+func (t *TabdNativeHost) broadcastIncognito(enabled bool) {
+	t.broadcast(&Event{
+		Event:     "incognito_changed",
+		Data:      map[string]bool{"enabled": enabled},
+		Timestamp: time.Now().Unix(),
+	})
+}
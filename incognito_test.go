@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// newTestHost creates a host with storage in a temporary directory and the
+// system keyring disabled
+func newTestHost(t *testing.T) *TabdNativeHost {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("TABD_DISABLE_KEYRING", "1")
+	for _, name := range []string{"TABD_CONFIG", "TABD_PROFILE", "TABD_STORAGE"} {
+		t.Setenv(name, "")
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := NewTabdNativeHost(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host
+}
+
+func TestIncognitoKeepsEntriesOffDisk(t *testing.T) {
+	host := newTestHost(t)
+	session := host.NewSession(nil, nil)
+	ctx := context.Background()
+	on, off := true, false
+
+	if response := host.dispatch(ctx, session, &Message{Action: "save", ClipboardData: ClipboardData{Text: "on disk", Type: "text"}}); response.Status != "success" {
+		t.Fatalf("save: %s", response.Message)
+	}
+	if response := host.dispatch(ctx, session, &Message{Action: "incognito", Enabled: &on}); response.Status != "success" {
+		t.Fatalf("incognito on: %s", response.Message)
+	}
+	if response := host.dispatch(ctx, session, &Message{Action: "save", ClipboardData: ClipboardData{Text: "in memory", Type: "text"}}); response.Status != "success" {
+		t.Fatalf("incognito save: %s", response.Message)
+	}
+	if response := host.dispatch(ctx, session, &Message{Action: "incognito", Enabled: &off}); response.Status != "success" {
+		t.Fatalf("incognito off: %s", response.Message)
+	}
+
+	entries, err := host.historyStore().List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("history has %d entries after incognito mode, want 1", len(entries))
+	}
+	data, err := host.getClipboardData(ctx)
+	if err != nil || data.Text != "on disk" {
+		t.Fatalf("latest entry after incognito mode = %v, %v", data, err)
+	}
+}
+
+func TestIncognitoToggleWhileListing(t *testing.T) {
+	host := newTestHost(t)
+	toggler, lister := host.NewSession(nil, nil), host.NewSession(nil, nil)
+	ctx := context.Background()
+	on, off := true, false
+
+	// Run with -race: sessions read the storage while another swaps it
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			host.dispatch(ctx, toggler, &Message{Action: "incognito", Enabled: &on})
+			host.dispatch(ctx, toggler, &Message{Action: "incognito", Enabled: &off})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 40; i++ {
+			if response := host.dispatch(ctx, lister, &Message{Action: "list"}); response.Status != "success" {
+				t.Errorf("list: %s", response.Message)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// incognitoHistory layers the entries saved from private browsing windows,
+// kept in memory, over the history on disk. Entries are routed by their
+// Incognito flag, and IDs are looked up in memory first.
+type incognitoHistory struct {
+	memory *History
+	disk   HistoryStore
+}
+
+func (h *incognitoHistory) Append(data *ClipboardData) (string, error) {
+	return h.AppendContext(context.Background(), data)
+}
+
+func (h *incognitoHistory) AppendContext(ctx context.Context, data *ClipboardData) (string, error) {
+	if data.Incognito {
+		return h.memory.AppendContext(ctx, data)
+	}
+	return appendEntry(ctx, h.disk, data)
+}
+
+// List merges both histories, newest first
+func (h *incognitoHistory) List() ([]HistoryEntry, error) {
+	return h.Query(HistoryQuery{})
+}
+
+func (h *incognitoHistory) Query(query HistoryQuery) ([]HistoryEntry, error) {
+	offset, limit := query.Offset, query.Limit
+	query.Offset, query.Limit = 0, 0
+
+	memory, err := h.memory.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(memory) == 0 {
+		query.Offset, query.Limit = offset, limit
+		return h.disk.Query(query)
+	}
+	disk, err := h.disk.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := append(memory, disk...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp > entries[j].Timestamp })
+	return paginate(entries, offset, limit), nil
+}
+
+func (h *incognitoHistory) Get(id string) (*ClipboardData, error) {
+	return h.GetContext(context.Background(), id)
+}
+
+func (h *incognitoHistory) GetContext(ctx context.Context, id string) (*ClipboardData, error) {
+	data, err := h.memory.GetContext(ctx, id)
+	if errors.Is(err, ErrEntryNotFound) {
+		return getEntry(ctx, h.disk, id)
+	}
+	return data, err
+}
+
+func (h *incognitoHistory) Update(id string, data *ClipboardData) error {
+	err := h.memory.Update(id, data)
+	if errors.Is(err, ErrEntryNotFound) {
+		return h.disk.Update(id, data)
+	}
+	return err
+}
+
+func (h *incognitoHistory) Delete(id string) error {
+	err := h.memory.Delete(id)
+	if errors.Is(err, ErrEntryNotFound) {
+		return h.disk.Delete(id)
+	}
+	return err
+}
+
+func (h *incognitoHistory) Prune() error {
+	if err := h.memory.Prune(); err != nil {
+		return err
+	}
+	return h.disk.Prune()
+}
+
+func (h *incognitoHistory) Clear(before int64, wipe bool) (int, error) {
+	// Memory entries are always wiped
+	removed, err := h.memory.Clear(before, true)
+	if err != nil {
+		return removed, err
+	}
+	cleared, err := h.disk.Clear(before, wipe)
+	return removed + cleared, err
+}
+
+func (h *incognitoHistory) Expire(before int64) (int, error) {
+	removed, err := h.memory.Expire(before)
+	if err != nil {
+		return removed, err
+	}
+	expired, err := h.disk.Expire(before)
+	return removed + expired, err
+}
+
+func (h *incognitoHistory) Pin(id string, pinned bool) error {
+	err := h.memory.Pin(id, pinned)
+	if errors.Is(err, ErrEntryNotFound) {
+		return h.disk.Pin(id, pinned)
+	}
+	return err
+}
+
+// incognitoNamespace returns the memory namespace holding entries saved from
+// private browsing windows, creating it and layering its history over the
+// disk history on first use
+func (t *TabdNativeHost) incognitoNamespace() *memoryEntries {
+	t.lockMu.Lock()
+	defer t.lockMu.Unlock()
+
+	if t.incognitoEntries != nil {
+		return t.incognitoEntries
+	}
+
+	memory := NewMemoryStorage()
+	entries := &memoryEntries{
+		memory:  memory,
+		history: NewHistory(memory, t.config.MaxHistory, ""),
+		ttl:     t.config.Incognito.TTLPeriod(),
+		stop:    make(chan struct{}),
+	}
+	t.incognitoEntries = entries
+	if t.incognito != nil {
+		t.incognito.diskHistory = &incognitoHistory{memory: entries.history, disk: t.incognito.diskHistory}
+	} else {
+		t.setStorage(t.storage(), &incognitoHistory{memory: entries.history, disk: t.historyStore()})
+	}
+	go entries.run(entries.stop, entries.ttl)
+	logInfof("Keeping entries from private browsing windows in memory for %s", entries.ttl)
+	return entries
+}
+
+// latestStorages returns the storage holding the latest entry, and the
+// memory namespace if entries from private browsing windows were saved
+func (t *TabdNativeHost) latestStorages() []SecureStorage {
+	t.lockMu.Lock()
+	defer t.lockMu.Unlock()
+
+	storages := []SecureStorage{t.storage()}
+	if t.incognitoEntries != nil {
+		storages = append(storages, t.incognitoEntries.memory)
+	}
+	return storages
+}
+
+// latestIn reads the latest entry kept in storage
+func latestIn(ctx context.Context, storage SecureStorage) (*ClipboardData, error) {
+	jsonData, err := retrieveKey(ctx, storage, "latest_clipboard")
+	if err != nil {
+		return nil, err
+	}
+	defer clear(jsonData)
+
+	var data ClipboardData
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal clipboard data: %v", err)
+	}
+	return &data, nil
+}
+
+// wipeIncognitoEntries wipes the entries saved from private browsing windows
+func (t *TabdNativeHost) wipeIncognitoEntries() {
+	t.lockMu.Lock()
+	defer t.lockMu.Unlock()
+
+	if t.incognitoEntries != nil {
+		t.incognitoEntries.wipe()
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// nativeHostName is the name browsers use to look up the native messaging host
+	nativeHostName = "com.iann0036.tabd"
+
+	// defaultExtensionID is the Chrome Web Store ID of the Tab'd extension
+	defaultExtensionID = "lemjjpeploikbpmkodmmkdjcjodboidn"
+)
+
+// errManifestNotRegistered is returned when a browser has no registration for the host
+var errManifestNotRegistered = errors.New("manifest is not registered")
+
+// supportedBrowsers lists the browsers the installer can register with
+var supportedBrowsers = []string{"chrome", "chromium", "edge", "brave", "vivaldi", "firefox"}
+
+// browserDisplayNames maps browser identifiers to human readable names
+var browserDisplayNames = map[string]string{
+	"chrome":   "Chrome",
+	"chromium": "Chromium",
+	"edge":     "Edge",
+	"brave":    "Brave",
+	"vivaldi":  "Vivaldi",
+	"firefox":  "Firefox",
+}
+
+// NativeMessagingManifest is the JSON manifest browsers use to launch the host
+// Chromium-based browsers identify callers by origin, Firefox by extension ID.
+type NativeMessagingManifest struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Path              string   `json:"path"`
+	Type              string   `json:"type"`
+	AllowedOrigins    []string `json:"allowed_origins,omitempty"`
+	AllowedExtensions []string `json:"allowed_extensions,omitempty"`
+}
+
+// manifestLocation describes where a browser looks for the host manifest. On
+// Windows the manifest can live anywhere and registryKey points to it.
+type manifestLocation struct {
+	manifestPath string
+	registryKey  string
+}
+
+// newManifest builds the manifest for a browser, binary path and extension IDs
+func newManifest(browser, binaryPath string, extensionIDs []string) *NativeMessagingManifest {
+	manifest := &NativeMessagingManifest{
+		Name:        nativeHostName,
+		Description: "Native messaging host for Tab'd browser extension",
+		Path:        binaryPath,
+		Type:        "stdio",
+	}
+
+	if browser == "firefox" {
+		manifest.AllowedExtensions = extensionIDs
+		return manifest
+	}
+
+	for _, id := range extensionIDs {
+		manifest.AllowedOrigins = append(manifest.AllowedOrigins, "chrome-extension://"+id+"/")
+	}
+	return manifest
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// selectBrowsers expands the --browser flag value into browser identifiers
+func selectBrowsers(value string) ([]string, error) {
+	if value == "all" {
+		return supportedBrowsers, nil
+	}
+
+	browsers := []string{}
+	for _, browser := range strings.Split(value, ",") {
+		browser = strings.ToLower(strings.TrimSpace(browser))
+		if _, ok := browserDisplayNames[browser]; !ok {
+			return nil, fmt.Errorf("unsupported browser %q (supported: %s, all)", browser, strings.Join(supportedBrowsers, ", "))
+		}
+		browsers = append(browsers, browser)
+	}
+	return browsers, nil
+}
+
+// installManifest writes the manifest for a browser and registers it where required
+func installManifest(browser string, manifest *NativeMessagingManifest) (*manifestLocation, error) {
+	location, err := browserManifestLocation(browser)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(location.manifestPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create manifest directory: %v", err)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+
+	if err := os.WriteFile(location.manifestPath, append(manifestData, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %v", err)
+	}
+
+	if err := registerManifest(location); err != nil {
+		return nil, fmt.Errorf("failed to register manifest: %v", err)
+	}
+
+	return location, nil
+}
+
+// uninstallManifest removes the manifest for a browser and its registration
+func uninstallManifest(browser string) (*manifestLocation, error) {
+	location, err := browserManifestLocation(browser)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unregisterManifest(location); err != nil {
+		return nil, fmt.Errorf("failed to unregister manifest: %v", err)
+	}
+
+	if err := os.Remove(location.manifestPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove manifest: %v", err)
+	}
+
+	return location, nil
+}
+
+// newInstallCommand creates the install command, which writes native messaging
+// manifests for the selected browsers
+func newInstallCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install the native messaging manifest for browsers",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	browserFlag := flags.String("browser", "all", "browser to install for ("+strings.Join(supportedBrowsers, ", ")+", or all)")
+	extensionIDs := flags.String("extension-id", defaultExtensionID, "comma-separated Chromium extension IDs allowed to connect")
+	firefoxIDs := flags.String("firefox-extension-id", "", "comma-separated Firefox extension IDs allowed to connect (required for firefox)")
+	binaryPath := flags.String("path", "", "path to the native host binary (defaults to this executable)")
+	profile := flags.String("profile", currentProfile(), "store history from these browsers in a separate profile namespace")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		if *profile != "" {
+			if err := validateProfile(*profile); err != nil {
+				return err
+			}
+		}
+
+		browsers, err := selectBrowsers(*browserFlag)
+		if err != nil {
+			return err
+		}
+
+		if *binaryPath == "" {
+			executable, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("failed to determine executable path: %v", err)
+			}
+			*binaryPath = executable
+		}
+		absPath, err := filepath.Abs(*binaryPath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve binary path: %v", err)
+		}
+
+		// Manifests cannot pass arguments, so a profile is selected by a launcher
+		hostPath := absPath
+		if *profile != "" {
+			if hostPath, err = writeProfileLauncher(absPath, *profile); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote launcher for profile %s: %s\n", *profile, hostPath)
+		}
+
+		chromiumIDs := splitList(*extensionIDs)
+		geckoIDs := splitList(*firefoxIDs)
+
+		for _, browser := range browsers {
+			ids := chromiumIDs
+			if browser == "firefox" {
+				ids = geckoIDs
+			}
+			if len(ids) == 0 {
+				// Firefox is only part of "all" when an extension ID was given
+				if browser == "firefox" && *browserFlag == "all" {
+					continue
+				}
+				if browser == "firefox" {
+					return fmt.Errorf("Firefox: --firefox-extension-id is required")
+				}
+				return fmt.Errorf("%s: --extension-id is required", browserDisplayNames[browser])
+			}
+
+			location, err := installManifest(browser, newManifest(browser, hostPath, ids))
+			if err != nil {
+				return fmt.Errorf("%s: %v", browserDisplayNames[browser], err)
+			}
+			fmt.Printf("Installed manifest for %s: %s\n", browserDisplayNames[browser], location.manifestPath)
+		}
+
+		warnDisallowedExtensions(append(chromiumIDs, geckoIDs...))
+		return nil
+	}
+	return cmd
+}
+
+// warnDisallowedExtensions points out installed extension IDs the host would
+// refuse to serve under the current config
+func warnDisallowedExtensions(ids []string) {
+	config, err := LoadConfig()
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		if !config.extensionAllowed(id) {
+			fmt.Printf("Note: add %s to allowedExtensions in the config file so the host accepts it\n", id)
+		}
+	}
+}
+
+// newUninstallCommand creates the uninstall command, which removes native
+// messaging manifests for the selected browsers
+func newUninstallCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the native messaging manifest from browsers",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	browserFlag := flags.String("browser", "all", "browser to uninstall from ("+strings.Join(supportedBrowsers, ", ")+", or all)")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		browsers, err := selectBrowsers(*browserFlag)
+		if err != nil {
+			return err
+		}
+
+		for _, browser := range browsers {
+			location, err := uninstallManifest(browser)
+			if err != nil {
+				return fmt.Errorf("%s: %v", browserDisplayNames[browser], err)
+			}
+			fmt.Printf("Removed manifest for %s: %s\n", browserDisplayNames[browser], location.manifestPath)
+		}
+
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// browserConfigDirs maps browsers to their directories under ~/Library/Application Support
+var browserConfigDirs = map[string]string{
+	"chrome":   "Google/Chrome",
+	"chromium": "Chromium",
+	"edge":     "Microsoft Edge",
+	"brave":    "BraveSoftware/Brave-Browser",
+	"vivaldi":  "Vivaldi",
+	"firefox":  "Mozilla",
+}
+
+// browserManifestLocation returns where a browser looks for the host manifest on macOS
+func browserManifestLocation(browser string) (*manifestLocation, error) {
+	dir, ok := browserConfigDirs[browser]
+	if !ok {
+		return nil, fmt.Errorf("unsupported browser: %s", browser)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %v", err)
+	}
+
+	return &manifestLocation{
+		manifestPath: filepath.Join(homeDir, "Library", "Application Support", dir, "NativeMessagingHosts", nativeHostName+".json"),
+	}, nil
+}
+
+// registerManifest is a no-op on macOS, where browsers find manifests by path
+func registerManifest(location *manifestLocation) error {
+	return nil
+}
+
+// unregisterManifest is a no-op on macOS, where browsers find manifests by path
+func unregisterManifest(location *manifestLocation) error {
+	return nil
+}
+
+// registeredManifest returns the manifest path, which on macOS is fixed per browser
+func registeredManifest(location *manifestLocation) (string, error) {
+	return location.manifestPath, nil
+}
@@ -0,0 +1,65 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// browserConfigDirs maps browsers to their directories under ~/.config
+var browserConfigDirs = map[string]string{
+	"chrome":   "google-chrome",
+	"chromium": "chromium",
+	"edge":     "microsoft-edge",
+	"brave":    "BraveSoftware/Brave-Browser",
+	"vivaldi":  "vivaldi",
+}
+
+// browserManifestLocation returns where a browser looks for the host manifest on Linux and BSD
+func browserManifestLocation(browser string) (*manifestLocation, error) {
+	// Firefox uses a dot directory in $HOME rather than XDG_CONFIG_HOME
+	if browser == "firefox" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get home directory: %v", err)
+		}
+		return &manifestLocation{
+			manifestPath: filepath.Join(homeDir, ".mozilla", "native-messaging-hosts", nativeHostName+".json"),
+		}, nil
+	}
+
+	dir, ok := browserConfigDirs[browser]
+	if !ok {
+		return nil, fmt.Errorf("unsupported browser: %s", browser)
+	}
+
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get home directory: %v", err)
+		}
+		configDir = filepath.Join(homeDir, ".config")
+	}
+
+	return &manifestLocation{
+		manifestPath: filepath.Join(configDir, dir, "NativeMessagingHosts", nativeHostName+".json"),
+	}, nil
+}
+
+// registerManifest is a no-op on Linux and BSD, where browsers find manifests by path
+func registerManifest(location *manifestLocation) error {
+	return nil
+}
+
+// unregisterManifest is a no-op on Linux and BSD, where browsers find manifests by path
+func unregisterManifest(location *manifestLocation) error {
+	return nil
+}
+
+// registeredManifest returns the manifest path, which on Linux and BSD is fixed per browser
+func registeredManifest(location *manifestLocation) (string, error) {
+	return location.manifestPath, nil
+}
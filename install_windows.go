@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// browserRegistryKeys maps browsers to their HKCU native messaging registry keys.
+// Vivaldi reads the Chrome key, so it shares Chrome's manifest as well.
+var browserRegistryKeys = map[string]string{
+	"chrome":   `Software\Google\Chrome\NativeMessagingHosts`,
+	"chromium": `Software\Chromium\NativeMessagingHosts`,
+	"edge":     `Software\Microsoft\Edge\NativeMessagingHosts`,
+	"brave":    `Software\BraveSoftware\Brave-Browser\NativeMessagingHosts`,
+	"vivaldi":  `Software\Google\Chrome\NativeMessagingHosts`,
+	"firefox":  `Software\Mozilla\NativeMessagingHosts`,
+}
+
+// browserManifestLocation returns where the host manifest for a browser is
+// written on Windows and the registry key that points to it
+func browserManifestLocation(browser string) (*manifestLocation, error) {
+	key, ok := browserRegistryKeys[browser]
+	if !ok {
+		return nil, fmt.Errorf("unsupported browser: %s", browser)
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config directory: %v", err)
+	}
+
+	// Browsers that read another browser's key must use the same manifest,
+	// otherwise installing one would silently repoint the other
+	dir := browser
+	for _, other := range supportedBrowsers {
+		if browserRegistryKeys[other] == key {
+			dir = other
+			break
+		}
+	}
+
+	return &manifestLocation{
+		manifestPath: filepath.Join(configDir, "tabd", "manifests", dir, nativeHostName+".json"),
+		registryKey:  key + `\` + nativeHostName,
+	}, nil
+}
+
+// registerManifest points the browser's registry key at the manifest file
+func registerManifest(location *manifestLocation) error {
+	key, _, err := registry.CreateKey(registry.CURRENT_USER, location.registryKey, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	return key.SetStringValue("", location.manifestPath)
+}
+
+// unregisterManifest removes the browser's registry key for the host
+func unregisterManifest(location *manifestLocation) error {
+	err := registry.DeleteKey(registry.CURRENT_USER, location.registryKey)
+	if err != nil && !errors.Is(err, registry.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// registeredManifest returns the manifest path the browser's registry key
+// points to, preferring HKCU over the machine-wide HKLM registration
+func registeredManifest(location *manifestLocation) (string, error) {
+	for _, root := range []registry.Key{registry.CURRENT_USER, registry.LOCAL_MACHINE} {
+		key, err := registry.OpenKey(root, location.registryKey, registry.QUERY_VALUE)
+		if errors.Is(err, registry.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to open registry key: %v", err)
+		}
+
+		path, _, err := key.GetStringValue("")
+		key.Close()
+		if errors.Is(err, registry.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read registry key: %v", err)
+		}
+		return path, nil
+	}
+	return "", errManifestNotRegistered
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// takeoverTimeout bounds how long --takeover waits for the instance it
+	// replaces to exit
+	takeoverTimeout = 10 * time.Second
+
+	// takeoverPollInterval is how often --takeover checks whether the
+	// instance it replaces has exited
+	takeoverPollInterval = 100 * time.Millisecond
+)
+
+// instanceInfo is written to the pid file of a running instance
+type instanceInfo struct {
+	PID        int    `json:"pid"`
+	Executable string `json:"executable,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Started    int64  `json:"started"`
+}
+
+// instanceGuard keeps a mode such as serve or tray to one process per storage
+// directory. The lock file decides which process runs, as the lock is
+// dropped when its holder exits, however it ends. The pid file beside it
+// names the holder for error messages and --takeover.
+type instanceGuard struct {
+	lock    *fileLock
+	pidPath string
+}
+
+// instanceModes are the modes acquireInstance keeps to one process each
+var instanceModes = []string{"daemon", "serve", "tray"}
+
+// nativeHostLockPrefix starts the lock file each native host started by the
+// browser holds, named after its pid, as several browsers may run one at once
+const nativeHostLockPrefix = ".native-"
+
+// instancePaths returns the lock and pid files of a mode
+func instancePaths(tabdDir, mode string) (string, string) {
+	return filepath.Join(tabdDir, "."+mode+".lock"), filepath.Join(tabdDir, mode+".pid")
+}
+
+// readInstanceInfo reads a pid file, returning nil if there is none
+func readInstanceInfo(path string) *instanceInfo {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var info instanceInfo
+	if json.Unmarshal(data, &info) != nil || info.PID <= 0 {
+		return nil
+	}
+	return &info
+}
+
+// acquireInstance claims mode for this process. If another instance holds
+// it, acquireInstance fails unless takeover is set, in which case that
+// instance is asked to shut down and replaced once it has exited.
+func acquireInstance(tabdDir, mode string, takeover bool) (*instanceGuard, error) {
+	lockPath, pidPath := instancePaths(tabdDir, mode)
+	executable, _ := os.Executable()
+
+	lock, err := tryFileLock(lockPath)
+	if errors.Is(err, errLockHeld) {
+		info := readInstanceInfo(pidPath)
+		if !takeover {
+			return nil, instanceRunningError(mode, info, executable)
+		}
+		lock, err = takeOverInstance(lockPath, mode, info)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A pid file left by an instance that crashed is simply replaced
+	if stale := readInstanceInfo(pidPath); stale != nil {
+		logInfof("Replacing the stale %s pid file of pid %d", mode, stale.PID)
+	}
+
+	info, err := json.Marshal(&instanceInfo{
+		PID:        os.Getpid(),
+		Executable: executable,
+		Version:    version,
+		Started:    time.Now().Unix(),
+	})
+	if err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("failed to marshal pid file: %v", err)
+	}
+	if err := writeFileAtomic(pidPath, info, 0600); err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("failed to write %s: %v", filepath.Base(pidPath), err)
+	}
+	return &instanceGuard{lock: lock, pidPath: pidPath}, nil
+}
+
+// instanceRunningError describes the instance holding a mode, pointing out
+// when it was started from another binary, such as a second installation
+func instanceRunningError(mode string, info *instanceInfo, executable string) error {
+	if info == nil {
+		return fmt.Errorf("tabd %s is already running for this storage directory; pass --takeover to replace it", mode)
+	}
+	if info.Executable != "" && executable != "" && info.Executable != executable {
+		return fmt.Errorf("tabd %s is already running as pid %d from another binary, %s (this is %s); stop it or pass --takeover to replace it", mode, info.PID, info.Executable, executable)
+	}
+	return fmt.Errorf("tabd %s is already running as pid %d; pass --takeover to replace it", mode, info.PID)
+}
+
+// takeOverInstance asks the instance holding a mode to shut down and takes
+// its lock once it has exited
+func takeOverInstance(lockPath, mode string, info *instanceInfo) (*fileLock, error) {
+	if info == nil {
+		return nil, fmt.Errorf("tabd %s is already running but its pid file is missing; stop it manually", mode)
+	}
+	if info.PID == os.Getpid() {
+		return nil, fmt.Errorf("tabd %s is already running in this process", mode)
+	}
+
+	if processAlive(info.PID) {
+		logInfof("Taking over %s from pid %d", mode, info.PID)
+		if err := stopProcess(info.PID); err != nil {
+			return nil, fmt.Errorf("failed to stop tabd %s (pid %d): %v", mode, info.PID, err)
+		}
+	}
+
+	deadline := time.Now().Add(takeoverTimeout)
+	for {
+		lock, err := tryFileLock(lockPath)
+		if !errors.Is(err, errLockHeld) {
+			return lock, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("tabd %s (pid %d) did not exit within %s", mode, info.PID, takeoverTimeout)
+		}
+		time.Sleep(takeoverPollInterval)
+	}
+}
+
+// Release removes the pid file and gives up the lock
+func (g *instanceGuard) Release() {
+	if err := os.Remove(g.pidPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logWarnf("Failed to remove %s: %v", filepath.Base(g.pidPath), err)
+	}
+	g.lock.Release()
+}
+
+// claimNativeHost marks this process as a native host serving tabdDir for as
+// long as the returned lock is held
+func claimNativeHost(tabdDir string) (*fileLock, error) {
+	return tryFileLock(filepath.Join(tabdDir, fmt.Sprintf("%s%d.lock", nativeHostLockPrefix, os.Getpid())))
+}
+
+// runningInstances describes the instances and native hosts holding their
+// locks for tabdDir. Native host lock files left by hosts that exited are
+// removed.
+func runningInstances(tabdDir string) []string {
+	var running []string
+	for _, mode := range instanceModes {
+		lockPath, pidPath := instancePaths(tabdDir, mode)
+		if _, err := os.Stat(lockPath); err != nil {
+			continue
+		}
+		lock, err := tryFileLock(lockPath)
+		if err == nil {
+			lock.Release()
+			continue
+		}
+		if errors.Is(err, errLockHeld) {
+			if info := readInstanceInfo(pidPath); info != nil {
+				running = append(running, fmt.Sprintf("tabd %s (pid %d)", mode, info.PID))
+			} else {
+				running = append(running, "tabd "+mode)
+			}
+		}
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(tabdDir, nativeHostLockPrefix+"*.lock"))
+	for _, path := range paths {
+		lock, err := tryFileLock(path)
+		if err == nil {
+			lock.Release()
+			os.Remove(path)
+			continue
+		}
+		if errors.Is(err, errLockHeld) {
+			pid := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), nativeHostLockPrefix), ".lock")
+			running = append(running, fmt.Sprintf("the native host started by the browser (pid %s)", pid))
+		}
+	}
+	return running
+}
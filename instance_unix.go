@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive reports whether a process with the given pid exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// stopProcess asks a process to shut down, as Ctrl-C or a service manager
+// would
+func stopProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+)
+
+// ipcAddress returns the daemon's Unix domain socket path
+func ipcAddress(tabdDir string) string {
+	return protocol.DaemonAddress(tabdDir)
+}
+
+// listenIPC listens on the daemon socket, replacing a stale socket file left
+// behind by a daemon that exited uncleanly
+func listenIPC(address string) (net.Listener, error) {
+	os.Remove(address)
+
+	listener, err := net.Listen("unix", address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the current user may connect
+	if err := os.Chmod(address, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// dialIPC connects to the daemon socket
+func dialIPC(address string) (net.Conn, error) {
+	return protocol.DialDaemon(address)
+}
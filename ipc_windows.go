@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+)
+
+// ipcAddress returns the daemon's named pipe path, scoped to the current user
+func ipcAddress(tabdDir string) string {
+	return protocol.DaemonAddress(tabdDir)
+}
+
+// listenIPC listens on the daemon named pipe
+func listenIPC(address string) (net.Listener, error) {
+	return winio.ListenPipe(address, &winio.PipeConfig{
+		// Only the creating user may connect
+		SecurityDescriptor: "D:P(A;;GA;;;OW)",
+	})
+}
+
+// dialIPC connects to the daemon named pipe
+func dialIPC(address string) (net.Conn, error) {
+	return protocol.DialDaemon(address)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/argon2"
+)
+
+// KDFParams are the Argon2id parameters keys are derived with
+type KDFParams struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+}
+
+// defaultKDFParams are the parameters of blobs without a KDF header
+var defaultKDFParams = KDFParams{Time: 1, MemoryKiB: 64 * 1024, Threads: 4}
+
+// Bounds on configured parameters, which also keep a tampered blob header
+// from making a derivation take hours or exhaust memory
+const (
+	maxKDFTime      = 64
+	minKDFMemoryMiB = 8
+	maxKDFMemoryMiB = 4096
+)
+
+// kdfMagic starts an AES-GCM blob whose key was derived with parameters
+// other than the defaults, as written before blob keys. It is followed by the
+// parameters.
+var kdfMagic = []byte("TBDK")
+
+// kdfParamsSize is the length of marshalled parameters: the time and memory
+// as big endian uint32 and the threads as one byte
+const kdfParamsSize = 4 + 4 + 1
+
+// String describes the parameters for bench-kdf output
+func (p KDFParams) String() string {
+	return fmt.Sprintf("time=%d memory=%dMiB threads=%d", p.Time, p.MemoryKiB/1024, p.Threads)
+}
+
+// validate checks the parameters are within the supported bounds
+func (p KDFParams) validate() error {
+	if p.Time < 1 || p.Time > maxKDFTime {
+		return fmt.Errorf("time must be between 1 and %d", maxKDFTime)
+	}
+	if p.MemoryKiB < minKDFMemoryMiB*1024 || p.MemoryKiB > maxKDFMemoryMiB*1024 {
+		return fmt.Errorf("memoryMiB must be between %d and %d", minKDFMemoryMiB, maxKDFMemoryMiB)
+	}
+	if p.Threads < 1 {
+		return fmt.Errorf("threads must be at least 1")
+	}
+	return nil
+}
+
+// deriveKey derives a 32 byte key with Argon2id
+func (p KDFParams) deriveKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, p.Time, p.MemoryKiB, p.Threads, 32)
+}
+
+// marshal encodes the parameters for a blob header
+func (p KDFParams) marshal() []byte {
+	data := make([]byte, kdfParamsSize)
+	binary.BigEndian.PutUint32(data[0:], p.Time)
+	binary.BigEndian.PutUint32(data[4:], p.MemoryKiB)
+	data[8] = p.Threads
+	return data
+}
+
+// unmarshalKDFParams decodes parameters from a blob header, rejecting any
+// outside the supported bounds
+func unmarshalKDFParams(data []byte) (KDFParams, bool) {
+	if len(data) < kdfParamsSize {
+		return KDFParams{}, false
+	}
+	params := KDFParams{
+		Time:      binary.BigEndian.Uint32(data[0:]),
+		MemoryKiB: binary.BigEndian.Uint32(data[4:]),
+		Threads:   data[8],
+	}
+	return params, params.validate() == nil
+}
+
+// parseKDFHeader splits the KDF header from an AES-GCM blob. ok is false for
+// blobs without a valid header, which use the default parameters.
+func parseKDFHeader(data []byte) (params KDFParams, rest []byte, ok bool) {
+	if len(data) < len(kdfMagic) || string(data[:len(kdfMagic)]) != string(kdfMagic) {
+		return KDFParams{}, data, false
+	}
+	params, ok = unmarshalKDFParams(data[len(kdfMagic):])
+	if !ok {
+		return KDFParams{}, data, false
+	}
+	return params, data[len(kdfMagic)+kdfParamsSize:], true
+}
+
+// KDFConfig overrides the Argon2id parameters new blobs are encrypted with.
+// Zero fields keep the defaults.
+type KDFConfig struct {
+	Time      uint32 `json:"time,omitempty"`
+	MemoryMiB uint32 `json:"memoryMiB,omitempty"`
+	Threads   uint8  `json:"threads,omitempty"`
+}
+
+// params returns the configured parameters, filling in the defaults
+func (k *KDFConfig) params() KDFParams {
+	params := defaultKDFParams
+	if k == nil {
+		return params
+	}
+	if k.Time != 0 {
+		params.Time = k.Time
+	}
+	if k.MemoryMiB != 0 {
+		params.MemoryKiB = k.MemoryMiB * 1024
+	}
+	if k.Threads != 0 {
+		params.Threads = k.Threads
+	}
+	return params
+}
+
+// applyDefaults checks the configured parameters
+func (k *KDFConfig) applyDefaults() error {
+	if k.MemoryMiB > maxKDFMemoryMiB {
+		return fmt.Errorf("invalid kdf: memoryMiB must be between %d and %d", minKDFMemoryMiB, maxKDFMemoryMiB)
+	}
+	if err := k.params().validate(); err != nil {
+		return fmt.Errorf("invalid kdf: %v", err)
+	}
+	return nil
+}
+
+// benchKDF returns how long one derivation with the given parameters takes
+func benchKDF(params KDFParams) time.Duration {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	start := time.Now()
+	params.deriveKey("tabd-bench-kdf", salt)
+	return time.Since(start)
+}
+
+// suggestKDFParams measures derivations with growing memory, then more
+// passes, and returns the strongest parameters that stay within target with
+// no more than maxMemoryMiB of memory. report is called with each
+// measurement.
+func suggestKDFParams(target time.Duration, maxMemoryMiB uint32, report func(KDFParams, time.Duration)) KDFParams {
+	threads := uint8(min(runtime.NumCPU(), 4))
+	suggested := KDFParams{Time: 1, MemoryKiB: minKDFMemoryMiB * 1024, Threads: threads}
+
+	// Memory makes attacks on GPUs and ASICs costly, so spend the budget on
+	// it first
+	var elapsed time.Duration
+	for memory := uint32(minKDFMemoryMiB); memory <= maxMemoryMiB; memory *= 2 {
+		params := KDFParams{Time: 1, MemoryKiB: memory * 1024, Threads: threads}
+		took := benchKDF(params)
+		report(params, took)
+		if took > target {
+			break
+		}
+		suggested, elapsed = params, took
+	}
+
+	// Then add passes while they fit
+	for suggested.Time < maxKDFTime && elapsed > 0 {
+		params := suggested
+		params.Time++
+		if time.Duration(params.Time)*elapsed/time.Duration(suggested.Time) > target*3/2 {
+			break
+		}
+		took := benchKDF(params)
+		report(params, took)
+		if took > target {
+			break
+		}
+		suggested, elapsed = params, took
+	}
+	return suggested
+}
+
+// newBenchKDFCommand creates the bench-kdf command, which measures key
+// derivation on this machine and suggests Argon2id parameters for the kdf
+// config setting
+func newBenchKDFCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench-kdf",
+		Short: "Measure key derivation time and suggest Argon2 parameters",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	target := flags.Duration("target", 500*time.Millisecond, "longest a key derivation should take")
+	maxMemory := flags.Uint("max-memory", 1024, "most memory in MiB a derivation may use")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		if *target <= 0 {
+			return fmt.Errorf("--target must be positive")
+		}
+		if *maxMemory < minKDFMemoryMiB || *maxMemory > maxKDFMemoryMiB {
+			return fmt.Errorf("--max-memory must be between %d and %d", minKDFMemoryMiB, maxKDFMemoryMiB)
+		}
+
+		config, err := LoadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		current := config.KDF.params()
+
+		fmt.Printf("Measuring Argon2id with %d CPU(s), target %s\n", runtime.NumCPU(), *target)
+		fmt.Printf("  %-38s %s (current)\n", current, benchKDF(current).Round(time.Millisecond))
+		suggested := suggestKDFParams(*target, uint32(*maxMemory), func(params KDFParams, took time.Duration) {
+			fmt.Printf("  %-38s %s\n", params, took.Round(time.Millisecond))
+		})
+
+		if suggested == current {
+			fmt.Println("\nThe current parameters already suit this machine.")
+			return nil
+		}
+		fmt.Printf("\nSuggested config:\n  \"kdf\": {\"time\": %d, \"memoryMiB\": %d, \"threads\": %d}\n",
+			suggested.Time, suggested.MemoryKiB/1024, suggested.Threads)
+		fmt.Println("New data is encrypted with the new parameters; run rotate-key to move existing data to them.")
+		return nil
+	}
+	return cmd
+}
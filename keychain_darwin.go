@@ -0,0 +1,251 @@
+//go:build darwin && cgo
+
+package main
+
+/*
+#cgo CFLAGS: -Wno-deprecated-declarations
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+
+static CFStringRef tabdString(const char *s) {
+	return CFStringCreateWithCString(NULL, s, kCFStringEncodingUTF8);
+}
+
+// tabdItemQuery matches the generic password of an account in a service
+static CFMutableDictionaryRef tabdItemQuery(const char *service, const char *account) {
+	CFMutableDictionaryRef query = CFDictionaryCreateMutable(NULL, 0,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+	CFStringRef serviceRef = tabdString(service);
+	CFStringRef accountRef = tabdString(account);
+	CFDictionarySetValue(query, kSecClass, kSecClassGenericPassword);
+	CFDictionarySetValue(query, kSecAttrService, serviceRef);
+	CFDictionarySetValue(query, kSecAttrAccount, accountRef);
+	CFRelease(serviceRef);
+	CFRelease(accountRef);
+	return query;
+}
+
+// tabdRestrictedAccess creates an access control list trusting only the
+// calling binary, identified by its code signature
+static OSStatus tabdRestrictedAccess(const char *label, SecAccessRef *access) {
+	SecTrustedApplicationRef self = NULL;
+	OSStatus status = SecTrustedApplicationCreateFromPath(NULL, &self);
+	if (status != errSecSuccess) {
+		return status;
+	}
+	const void *apps[] = {self};
+	CFArrayRef trusted = CFArrayCreate(NULL, apps, 1, &kCFTypeArrayCallBacks);
+	CFStringRef labelRef = tabdString(label);
+	status = SecAccessCreate(labelRef, trusted, access);
+	CFRelease(labelRef);
+	CFRelease(trusted);
+	CFRelease(self);
+	return status;
+}
+
+// tabdKeychainStore replaces an item with one readable only by this binary.
+// An access list cannot be changed by an update, so any old item is deleted.
+static OSStatus tabdKeychainStore(const char *service, const char *account, const void *data, long length) {
+	CFMutableDictionaryRef query = tabdItemQuery(service, account);
+	SecItemDelete(query);
+
+	SecAccessRef access = NULL;
+	OSStatus status = tabdRestrictedAccess(service, &access);
+	if (status == errSecSuccess) {
+		CFDataRef value = CFDataCreate(NULL, data, length);
+		CFStringRef label = tabdString(service);
+		CFDictionarySetValue(query, kSecAttrLabel, label);
+		CFDictionarySetValue(query, kSecValueData, value);
+		CFDictionarySetValue(query, kSecAttrAccess, access);
+		status = SecItemAdd(query, NULL);
+		CFRelease(label);
+		CFRelease(value);
+		CFRelease(access);
+	}
+	CFRelease(query);
+	return status;
+}
+
+// tabdKeychainRetrieve copies the data of an item, which the caller releases
+static OSStatus tabdKeychainRetrieve(const char *service, const char *account, CFDataRef *data) {
+	CFMutableDictionaryRef query = tabdItemQuery(service, account);
+	CFDictionarySetValue(query, kSecReturnData, kCFBooleanTrue);
+	CFDictionarySetValue(query, kSecMatchLimit, kSecMatchLimitOne);
+	OSStatus status = SecItemCopyMatching(query, (CFTypeRef *)data);
+	CFRelease(query);
+	return status;
+}
+
+static OSStatus tabdKeychainDelete(const char *service, const char *account) {
+	CFMutableDictionaryRef query = tabdItemQuery(service, account);
+	OSStatus status = SecItemDelete(query);
+	CFRelease(query);
+	return status;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// keychainPromptTimeout bounds a Keychain call that may be waiting for the
+// user to answer an access prompt
+const keychainPromptTimeout = 2 * time.Minute
+
+// keychainACLStorage keeps the storage keys and clipboard data in Keychain
+// items whose access control list trusts only the tabd binary, so other
+// processes, including `security find-generic-password`, cannot read them
+// without a prompt. Items written by go-keyring through the security tool,
+// which trust that tool, are moved into restricted items the first time they
+// are read.
+type keychainACLStorage struct {
+	service string
+	legacy  *KeyringStorage
+}
+
+var (
+	// keychainDenied remembers the keys whose Keychain prompt was denied,
+	// so the user is not asked again by this process
+	keychainDeniedMu sync.Mutex
+	keychainDenied   = make(map[string]error)
+)
+
+// newMasterKeyring returns the storage for the master keys
+func newMasterKeyring() SecureStorage {
+	return &keychainACLStorage{
+		service: keyringService() + ".keys",
+		legacy:  &KeyringStorage{serviceName: keyringService()},
+	}
+}
+
+// newDataKeyring returns the keyring storage for clipboard data, history,
+// snippets and registers. The items live under their own service, so the
+// plain service only holds copies still to be moved.
+func newDataKeyring() SecureStorage {
+	return &keychainACLStorage{
+		service: keyringService() + ".data",
+		legacy:  &KeyringStorage{serviceName: keyringService()},
+	}
+}
+
+// keychainError converts a Keychain status into an error, remembering
+// denied prompts
+func (k *keychainACLStorage) keychainError(key string, status C.OSStatus) error {
+	var err error
+	switch status {
+	case C.errSecItemNotFound:
+		return fmt.Errorf("%w: %s not in the Keychain", os.ErrNotExist, key)
+	case C.errSecUserCanceled, C.errSecAuthFailed:
+		err = ErrKeychainDenied
+	case C.errSecInteractionNotAllowed:
+		err = fmt.Errorf("%w: the Keychain needs a confirmation but cannot show a prompt in this session", ErrKeychainDenied)
+	default:
+		return fmt.Errorf("keychain error %d", int(status))
+	}
+	keychainDeniedMu.Lock()
+	keychainDenied[key] = err
+	keychainDeniedMu.Unlock()
+	return err
+}
+
+// call runs a Keychain call for a key, giving up if a prompt goes
+// unanswered. The call keeps running in the background after a timeout, so
+// everything it uses is allocated and freed within it.
+func (k *keychainACLStorage) call(key string, fn func(service, account *C.char) (C.OSStatus, []byte)) ([]byte, error) {
+	keychainDeniedMu.Lock()
+	err := keychainDenied[key]
+	keychainDeniedMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	type outcome struct {
+		status C.OSStatus
+		data   []byte
+	}
+	result := make(chan outcome, 1)
+	go func() {
+		service, account := C.CString(k.service), C.CString(key)
+		defer C.free(unsafe.Pointer(service))
+		defer C.free(unsafe.Pointer(account))
+		status, data := fn(service, account)
+		result <- outcome{status, data}
+	}()
+
+	select {
+	case out := <-result:
+		if out.status != C.errSecSuccess {
+			return nil, k.keychainError(key, out.status)
+		}
+		return out.data, nil
+	case <-time.After(keychainPromptTimeout):
+		return nil, fmt.Errorf("%w: the Keychain prompt was not answered", ErrKeychainDenied)
+	}
+}
+
+func (k *keychainACLStorage) Store(key string, data []byte) error {
+	_, err := k.call(key, func(service, account *C.char) (C.OSStatus, []byte) {
+		var ptr unsafe.Pointer
+		if len(data) > 0 {
+			ptr = C.CBytes(data)
+			defer C.free(ptr)
+		}
+		return C.tabdKeychainStore(service, account, ptr, C.long(len(data))), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Drop any copy readable through the security tool
+	if err := k.legacy.Delete(key); err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errKeyringUnavailable) {
+		logWarnf("Failed to remove the unrestricted Keychain copy of %s: %v", key, err)
+	}
+	return nil
+}
+
+func (k *keychainACLStorage) Retrieve(key string) ([]byte, error) {
+	data, err := k.call(key, func(service, account *C.char) (C.OSStatus, []byte) {
+		var value C.CFDataRef
+		status := C.tabdKeychainRetrieve(service, account, &value)
+		if status != C.errSecSuccess {
+			return status, nil
+		}
+		defer C.CFRelease(C.CFTypeRef(value))
+		return status, C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(value)), C.int(C.CFDataGetLength(value)))
+	})
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return data, err
+	}
+
+	// Move an item written before access was restricted
+	data, err = k.legacy.Retrieve(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Store(key, data); err != nil {
+		logWarnf("Failed to restrict %s in the Keychain to this binary: %v", key, err)
+	} else {
+		logInfof("Restricted %s in the Keychain to this binary", key)
+	}
+	return data, nil
+}
+
+func (k *keychainACLStorage) Delete(key string) error {
+	_, err := k.call(key, func(service, account *C.char) (C.OSStatus, []byte) {
+		return C.tabdKeychainDelete(service, account), nil
+	})
+	legacyErr := k.legacy.Delete(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return legacyErr
+	}
+	return err
+}
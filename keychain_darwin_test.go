@@ -0,0 +1,18 @@
+//go:build darwin && cgo
+
+package main
+
+import "testing"
+
+func TestDataKeyringRestricted(t *testing.T) {
+	storage, ok := newDataKeyring().(*keychainACLStorage)
+	if !ok {
+		t.Fatalf("clipboard data is kept in %T, want Keychain items restricted to this binary", newDataKeyring())
+	}
+	if storage.service == storage.legacy.serviceName {
+		t.Fatal("restricted data items share the service of unrestricted go-keyring items")
+	}
+	if storage.service == newMasterKeyring().(*keychainACLStorage).service {
+		t.Fatal("data items share the service of the master keys")
+	}
+}
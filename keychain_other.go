@@ -0,0 +1,16 @@
+//go:build !darwin || !cgo
+
+package main
+
+// newMasterKeyring returns the storage for the master keys. Only macOS builds
+// with cgo can restrict Keychain items to the tabd binary; elsewhere the keys
+// are kept like any other keyring entry.
+func newMasterKeyring() SecureStorage {
+	return &KeyringStorage{serviceName: keyringService()}
+}
+
+// newDataKeyring returns the keyring storage for clipboard data, history,
+// snippets and registers
+func newDataKeyring() SecureStorage {
+	return &KeyringStorage{serviceName: keyringService()}
+}
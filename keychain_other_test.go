@@ -0,0 +1,12 @@
+//go:build !darwin || !cgo
+
+package main
+
+import "testing"
+
+func TestDataKeyringService(t *testing.T) {
+	storage, ok := newDataKeyring().(*KeyringStorage)
+	if !ok || storage.serviceName != keyringService() {
+		t.Fatalf("clipboard data is kept in %#v, want the keyring service %s", newDataKeyring(), keyringService())
+	}
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// lanIdentityName and lanPeersName are the secure storage keys holding
+	// this host's TLS identity and the peers it has paired with
+	lanIdentityName = "lan_identity"
+	lanPeersName    = "lan_peers"
+
+	// defaultLANPort is the TCP port the daemon accepts peers on
+	defaultLANPort = 8746
+
+	// lanDialTimeout bounds connecting and sending an entry to a peer
+	lanDialTimeout = 5 * time.Second
+
+	// lanBrowseInterval is how often the daemon looks for paired peers, and
+	// lanBrowseTimeout how long each lookup collects answers
+	lanBrowseInterval = time.Minute
+	lanBrowseTimeout  = 2 * time.Second
+
+	// lanReceivedTTL is how long an entry received from a peer is kept from
+	// being shared again, so entries do not bounce between hosts
+	lanReceivedTTL = time.Minute
+
+	// pairCodeAlphabet and pairCodeLength give pairing codes 60 bits
+	pairCodeAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	pairCodeLength   = 12
+)
+
+// lanPeerActions are the only actions paired peers may send
+var lanPeerActions = map[string]bool{"hello": true, "ping": true, "save": true}
+
+// LANPeer is a host paired for clipboard sharing, identified by the SHA-256
+// fingerprint of its certificate
+type LANPeer struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	Paired      int64  `json:"paired"`
+}
+
+// certFingerprint returns the hex SHA-256 of a DER certificate
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// lanIdentity returns this host's TLS certificate and its fingerprint,
+// generating a self-signed one on first use
+func (t *TabdNativeHost) lanIdentity() (tls.Certificate, string, error) {
+	data, err := t.storage().Retrieve(lanIdentityName)
+	if errors.Is(err, os.ErrNotExist) {
+		if data, err = newLANIdentity(); err == nil {
+			err = t.storage().Store(lanIdentityName, data)
+		}
+	}
+	if err != nil {
+		return tls.Certificate{}, "", fmt.Errorf("failed to load LAN identity: %v", err)
+	}
+
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return tls.Certificate{}, "", fmt.Errorf("failed to parse LAN identity: %v", err)
+	}
+	return cert, certFingerprint(cert.Certificate[0]), nil
+}
+
+// newLANIdentity generates a self-signed certificate and key as PEM
+func newLANIdentity() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "tabd " + hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(20, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...), nil
+}
+
+// lanPeers returns the paired peers
+func (t *TabdNativeHost) lanPeers() ([]LANPeer, error) {
+	data, err := t.storage().Retrieve(lanPeersName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read paired peers: %v", err)
+	}
+	var peers []LANPeer
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("failed to parse paired peers: %v", err)
+	}
+	return peers, nil
+}
+
+// saveLANPeers replaces the paired peers
+func (t *TabdNativeHost) saveLANPeers(peers []LANPeer) error {
+	data, err := json.Marshal(peers)
+	if err != nil {
+		return err
+	}
+	return t.storage().Store(lanPeersName, data)
+}
+
+// addLANPeer records a newly paired peer, replacing any with the same
+// fingerprint
+func (t *TabdNativeHost) addLANPeer(peer LANPeer) error {
+	peers, err := t.lanPeers()
+	if err != nil {
+		return err
+	}
+	kept := []LANPeer{peer}
+	for _, existing := range peers {
+		if existing.Fingerprint != peer.Fingerprint {
+			kept = append(kept, existing)
+		}
+	}
+	return t.saveLANPeers(kept)
+}
+
+// findLANPeer returns the paired peer with a fingerprint
+func (t *TabdNativeHost) findLANPeer(fingerprint string) (*LANPeer, error) {
+	peers, err := t.lanPeers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range peers {
+		if peers[i].Fingerprint == fingerprint {
+			return &peers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("certificate %s is not from a paired peer", fingerprint[:16])
+}
+
+// peerTLSConfig returns a TLS config presenting cert and accepting only a
+// peer certificate approved by verify. Peers use self-signed certificates,
+// so the fingerprint check replaces chain verification.
+func peerTLSConfig(cert tls.Certificate, verify func(fingerprint string) error) *tls.Config {
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		ClientAuth:         tls.RequireAnyClientCert,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("peer sent no certificate")
+			}
+			return verify(certFingerprint(rawCerts[0]))
+		},
+	}
+}
+
+// peerFingerprint returns the certificate fingerprint of a connected peer
+func peerFingerprint(conn *tls.Conn) string {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	return certFingerprint(certs[0].Raw)
+}
+
+// LANShare accepts entries from paired peers on the local network and sends
+// them entries saved on this host
+type LANShare struct {
+	host        *TabdNativeHost
+	cert        tls.Certificate
+	fingerprint string
+	listener    net.Listener
+
+	// Last known address of each paired peer, by fingerprint
+	addrMu sync.Mutex
+	addrs  map[string]string
+
+	// Content hashes recently received from peers, with when they arrived
+	receivedMu sync.Mutex
+	received   map[string]time.Time
+}
+
+// NewLANShare starts listening for paired peers on the configured port
+func NewLANShare(host *TabdNativeHost) (*LANShare, error) {
+	cert, fingerprint, err := host.lanIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	share := &LANShare{
+		host:        host,
+		cert:        cert,
+		fingerprint: fingerprint,
+		addrs:       make(map[string]string),
+		received:    make(map[string]time.Time),
+	}
+
+	verify := func(fingerprint string) error {
+		_, err := host.findLANPeer(fingerprint)
+		return err
+	}
+	address := net.JoinHostPort("", strconv.Itoa(host.config.LANPort))
+	share.listener, err = tls.Listen("tcp", address, peerTLSConfig(cert, verify))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for LAN peers on %s: %v", address, err)
+	}
+	return share, nil
+}
+
+// Run serves peers, advertises this host and looks for paired peers until
+// stop is closed
+func (l *LANShare) Run(stop <-chan struct{}) {
+	go func() {
+		<-stop
+		l.listener.Close()
+	}()
+
+	hostname, _ := os.Hostname()
+	go func() {
+		service := &mdnsService{
+			Service:  lanServiceType,
+			Instance: hostname,
+			Port:     l.host.config.LANPort,
+			TXT:      []string{"fp=" + l.fingerprint},
+		}
+		if err := advertiseMDNS(service, stop); err != nil {
+			logErrorf("Error advertising on the local network: %v", err)
+		}
+	}()
+	go l.browse(stop)
+
+	logInfof("Sharing clipboard entries with LAN peers on %s", l.listener.Addr())
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logErrorf("Error accepting LAN peer: %v", err)
+			}
+			return
+		}
+		go l.serveConn(conn.(*tls.Conn))
+	}
+}
+
+// browse periodically looks up the addresses of paired peers
+func (l *LANShare) browse(stop <-chan struct{}) {
+	ticker := time.NewTicker(lanBrowseInterval)
+	defer ticker.Stop()
+
+	for {
+		l.lookup()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lookup refreshes the addresses of peers answering on the local network
+func (l *LANShare) lookup() {
+	results, err := browseMDNS(lanServiceType, lanBrowseTimeout)
+	if err != nil {
+		logWarnf("Error looking for LAN peers: %v", err)
+		return
+	}
+
+	l.addrMu.Lock()
+	defer l.addrMu.Unlock()
+	for _, result := range results {
+		fingerprint := result.TXT["fp"]
+		if fingerprint != "" && fingerprint != l.fingerprint {
+			l.addrs[fingerprint] = result.Addr
+		}
+	}
+}
+
+// addr returns the last known address of a peer
+func (l *LANShare) addr(fingerprint string) string {
+	l.addrMu.Lock()
+	defer l.addrMu.Unlock()
+	return l.addrs[fingerprint]
+}
+
+// serveConn runs a restricted protocol session for a paired peer
+func (l *LANShare) serveConn(conn *tls.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(lanDialTimeout))
+	if err := conn.Handshake(); err != nil {
+		logWarnf("Rejected LAN connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	peer, err := l.host.findLANPeer(peerFingerprint(conn))
+	if err != nil {
+		logWarnf("Rejected LAN connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	logDebugf("LAN peer %s connected from %s", peer.Name, conn.RemoteAddr())
+
+	session := l.host.NewSession(conn, conn)
+	session.peer = peer.Name
+	session.allowedActions = lanPeerActions
+	if err := session.serve(); err != nil {
+		logErrorf("LAN session error: %v", err)
+	}
+}
+
+// noteReceived records an entry received from a peer so it is not shared back
+func (l *LANShare) noteReceived(data *ClipboardData) {
+	l.receivedMu.Lock()
+	defer l.receivedMu.Unlock()
+
+	now := time.Now()
+	for hash, at := range l.received {
+		if now.Sub(at) > lanReceivedTTL {
+			delete(l.received, hash)
+		}
+	}
+	l.received[contentHash(data)] = now
+}
+
+// Share sends an entry saved on this host to every reachable paired peer in
+// the background. Sensitive entries and entries just received from a peer
+// are not sent.
+func (l *LANShare) Share(data *ClipboardData) {
+	if len(data.Sensitive) > 0 {
+		return
+	}
+
+	l.receivedMu.Lock()
+	_, echo := l.received[contentHash(data)]
+	l.receivedMu.Unlock()
+	if echo {
+		return
+	}
+
+	peers, err := l.host.lanPeers()
+	if err != nil {
+		logErrorf("Error sharing with LAN peers: %v", err)
+		return
+	}
+
+	message, err := json.Marshal(&Message{Action: "save", ClipboardData: *data})
+	if err != nil {
+		logErrorf("Error encoding entry for LAN peers: %v", err)
+		return
+	}
+
+	go func() {
+		// Look for peers that have come online since the last lookup
+		for _, peer := range peers {
+			if l.addr(peer.Fingerprint) == "" {
+				l.lookup()
+				break
+			}
+		}
+
+		for _, peer := range peers {
+			addr := l.addr(peer.Fingerprint)
+			if addr == "" {
+				continue
+			}
+			go func(peer LANPeer, addr string) {
+				if err := l.send(peer, addr, message); err != nil {
+					logWarnf("Error sharing entry with LAN peer %s at %s: %v", peer.Name, addr, err)
+					l.addrMu.Lock()
+					delete(l.addrs, peer.Fingerprint)
+					l.addrMu.Unlock()
+				}
+			}(peer, addr)
+		}
+	}()
+}
+
+// send delivers a save message to a peer and checks its response
+func (l *LANShare) send(peer LANPeer, addr string, message []byte) error {
+	verify := func(fingerprint string) error {
+		if fingerprint != peer.Fingerprint {
+			return fmt.Errorf("certificate %s does not match the paired peer", fingerprint[:16])
+		}
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: lanDialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, peerTLSConfig(l.cert, verify))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(lanDialTimeout))
+
+	upstream := l.host.NewSession(conn, conn)
+	if err := upstream.sendResponse(message); err != nil {
+		return err
+	}
+	responseData, err := upstream.readMessage(upstream.ctx)
+	if err != nil {
+		return err
+	}
+
+	var response Response
+	if err := json.Unmarshal(responseData, &response); err != nil {
+		return err
+	}
+	if response.Status != "success" {
+		return fmt.Errorf("peer answered %s: %s", response.Status, response.Message)
+	}
+	logDebugf("Shared entry with LAN peer %s", peer.Name)
+	return nil
+}
+
+// pairHello is exchanged during pairing to prove both sides know the code
+type pairHello struct {
+	Name  string `json:"name"`
+	Proof string `json:"proof"`
+	Error string `json:"error,omitempty"`
+}
+
+// newPairCode generates a pairing code such as ABCD-EFGH-JKLM
+func newPairCode() string {
+	random := make([]byte, pairCodeLength)
+	rand.Read(random)
+	var code strings.Builder
+	for i, b := range random {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(pairCodeAlphabet[int(b)%len(pairCodeAlphabet)])
+	}
+	return code.String()
+}
+
+// normalizePairCode strips separators and case from a typed pairing code
+func normalizePairCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
+
+// pairProof proves knowledge of the code for one side of a pairing. The key
+// is bound to both certificates, so a proof cannot be relayed through a
+// third host, and is derived with Argon2 so captured proofs are slow to
+// brute force.
+func pairProof(code, listener, joiner, role string) string {
+	salt := sha256.Sum256([]byte("tabd-pair\x00" + listener + "\x00" + joiner))
+	key := argon2.IDKey([]byte(normalizePairCode(code)), salt[:], 1, 64*1024, 4, 32)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(role))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// acceptPairing waits for a host to join with the code, returning the paired
+// peer. A wrong proof ends pairing, so the code cannot be guessed online.
+func (t *TabdNativeHost) acceptPairing(code string, timeout time.Duration) (*LANPeer, error) {
+	cert, fingerprint, err := t.lanIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := tls.Listen("tcp", ":0", peerTLSConfig(cert, func(string) error { return nil }))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for pairing: %v", err)
+	}
+	defer listener.Close()
+
+	hostname, _ := os.Hostname()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		service := &mdnsService{
+			Service:  lanPairServiceType,
+			Instance: hostname,
+			Port:     listener.Addr().(*net.TCPAddr).Port,
+		}
+		if err := advertiseMDNS(service, stop); err != nil {
+			logErrorf("Error advertising pairing: %v", err)
+		}
+	}()
+
+	time.AfterFunc(timeout, func() { listener.Close() })
+	netConn, err := listener.Accept()
+	if err != nil {
+		return nil, errors.New("timed out waiting for another host to join")
+	}
+	conn := netConn.(*tls.Conn)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	if err := conn.Handshake(); err != nil {
+		return nil, fmt.Errorf("pairing handshake failed: %v", err)
+	}
+	joiner := peerFingerprint(conn)
+
+	var hello pairHello
+	if err := json.NewDecoder(conn).Decode(&hello); err != nil {
+		return nil, fmt.Errorf("failed to read pairing request: %v", err)
+	}
+	encoder := json.NewEncoder(conn)
+	if !hmac.Equal([]byte(hello.Proof), []byte(pairProof(code, fingerprint, joiner, "joiner"))) {
+		encoder.Encode(&pairHello{Error: "wrong pairing code"})
+		return nil, fmt.Errorf("%s sent the wrong pairing code; run pair again for a new code", conn.RemoteAddr())
+	}
+	if err := encoder.Encode(&pairHello{Name: hostname, Proof: pairProof(code, fingerprint, joiner, "listener")}); err != nil {
+		return nil, fmt.Errorf("failed to answer pairing request: %v", err)
+	}
+
+	peer := &LANPeer{Name: hello.Name, Fingerprint: joiner, Paired: time.Now().Unix()}
+	return peer, t.addLANPeer(*peer)
+}
+
+// joinPairing finds a host waiting to pair and pairs with it using the code
+func (t *TabdNativeHost) joinPairing(code string) (*LANPeer, error) {
+	cert, fingerprint, err := t.lanIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := browseMDNS(lanPairServiceType, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no host is waiting to pair; run pair without a code on the other host first")
+	}
+
+	hostname, _ := os.Hostname()
+	var lastErr error
+	for _, result := range results {
+		dialer := &net.Dialer{Timeout: lanDialTimeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", result.Addr, peerTLSConfig(cert, func(string) error { return nil }))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		peer, err := func() (*LANPeer, error) {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Minute))
+			listener := peerFingerprint(conn)
+
+			if err := json.NewEncoder(conn).Encode(&pairHello{Name: hostname, Proof: pairProof(code, listener, fingerprint, "joiner")}); err != nil {
+				return nil, err
+			}
+			var answer pairHello
+			if err := json.NewDecoder(conn).Decode(&answer); err != nil {
+				return nil, fmt.Errorf("no pairing answer: %v", err)
+			}
+			if answer.Error != "" {
+				return nil, errors.New(answer.Error)
+			}
+			if !hmac.Equal([]byte(answer.Proof), []byte(pairProof(code, listener, fingerprint, "listener"))) {
+				return nil, errors.New("the other host could not prove it knows the pairing code")
+			}
+			return &LANPeer{Name: answer.Name, Fingerprint: listener, Paired: time.Now().Unix()}, nil
+		}()
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", result.Instance, err)
+			continue
+		}
+		return peer, t.addLANPeer(*peer)
+	}
+	return nil, fmt.Errorf("pairing failed: %v", lastErr)
+}
+
+// newPairCommand creates the pair command, which pairs with another host on
+// the local network, or lists and removes paired peers
+func newPairCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pair [<code>]",
+		Short: "Pair with another host on the local network",
+	}
+	flags := cmd.Flags()
+	list := flags.Bool("list", false, "list paired peers")
+	remove := flags.String("remove", "", "unpair the peer with this name or fingerprint")
+	timeout := flags.Duration("timeout", 5*time.Minute, "how long to wait for the other host to join")
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		switch {
+		case *list:
+			peers, err := host.lanPeers()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tFINGERPRINT\tPAIRED")
+			for _, peer := range peers {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", peer.Name, peer.Fingerprint[:16], time.Unix(peer.Paired, 0).Format(time.RFC3339))
+			}
+			return w.Flush()
+
+		case *remove != "":
+			peers, err := host.lanPeers()
+			if err != nil {
+				return err
+			}
+			var kept []LANPeer
+			for _, peer := range peers {
+				if peer.Name != *remove && !strings.HasPrefix(peer.Fingerprint, strings.ToLower(*remove)) {
+					kept = append(kept, peer)
+				}
+			}
+			if len(kept) == len(peers) {
+				return fmt.Errorf("no paired peer matches %q", *remove)
+			}
+			if err := host.saveLANPeers(kept); err != nil {
+				return fmt.Errorf("failed to update paired peers: %v", err)
+			}
+			notef("Removed %d paired peer(s)\n", len(peers)-len(kept))
+			return nil
+
+		case len(positional) == 0:
+			code := newPairCode()
+			fmt.Printf("Pairing code: %s\n", code)
+			fmt.Fprintf(os.Stderr, "Run `tabd-native-host pair %s` on the other host within %s\n", code, *timeout)
+			peer, err := host.acceptPairing(code, *timeout)
+			if err != nil {
+				return err
+			}
+			notef("Paired with %s\n", peer.Name)
+			return nil
+
+		case len(positional) == 1:
+			if len(normalizePairCode(positional[0])) != pairCodeLength {
+				return errors.New("invalid pairing code")
+			}
+			peer, err := host.joinPairing(positional[0])
+			if err != nil {
+				return err
+			}
+			notef("Paired with %s\n", peer.Name)
+			return nil
+		}
+
+		return errors.New("usage: pair [<code>] [--list] [--remove <name>]")
+	})
+	return cmd
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// launcherFormats are the history output formats for application launchers
+var launcherFormats = []string{"alfred", "raycast"}
+
+// alfredItem is an item of Alfred's Script Filter JSON format
+type alfredItem struct {
+	UID       string            `json:"uid"`
+	Title     string            `json:"title"`
+	Subtitle  string            `json:"subtitle"`
+	Arg       string            `json:"arg"`
+	Match     string            `json:"match,omitempty"`
+	Text      *alfredText       `json:"text,omitempty"`
+	Variables map[string]string `json:"variables"`
+}
+
+// alfredText is what Alfred copies with ⌘C and shows as large type with ⌘L
+type alfredText struct {
+	Copy      string `json:"copy"`
+	LargeType string `json:"largetype"`
+}
+
+// raycastItem has the fields of a Raycast List.Item, plus the value to paste
+type raycastItem struct {
+	ID          string              `json:"id"`
+	Title       string              `json:"title"`
+	Subtitle    string              `json:"subtitle,omitempty"`
+	Arg         string              `json:"arg"`
+	Keywords    []string            `json:"keywords,omitempty"`
+	Accessories []*raycastAccessory `json:"accessories,omitempty"`
+}
+
+// raycastAccessory is an accessory shown on the right of a Raycast list item
+type raycastAccessory struct {
+	Date string `json:"date,omitempty"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+// launcherTitle is the title of a history entry in a launcher. Sensitive
+// entries show the kinds detected instead of their content.
+func launcherTitle(record *HistoryRecord) string {
+	if len(record.Sensitive) > 0 {
+		return "Sensitive: " + strings.Join(record.Sensitive, ", ")
+	}
+	if title := previewText(&record.ClipboardData); title != "" {
+		return title
+	}
+	return "(empty)"
+}
+
+// launcherArg is the value a launcher pastes for an entry: its text, or its
+// ID for an image or other binary entry
+func launcherArg(record *HistoryRecord) string {
+	if record.Text == "" && record.Data != "" {
+		return record.ID
+	}
+	return record.Text
+}
+
+// launcherSubtitle describes where and when an entry was copied
+func launcherSubtitle(record *HistoryRecord) string {
+	parts := []string{}
+	if record.Pinned {
+		parts = append(parts, "pinned")
+	}
+	if record.Kind != "" {
+		parts = append(parts, record.Kind)
+	}
+	if source, err := url.Parse(record.URL); err == nil && source.Host != "" {
+		parts = append(parts, source.Host)
+	}
+	if record.Timestamp > 0 {
+		parts = append(parts, time.UnixMilli(record.Timestamp).Format("2006-01-02 15:04"))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// writeLauncherItems writes history entries in the JSON shape a launcher
+// reads list items from
+func writeLauncherItems(w io.Writer, format string, records []*HistoryRecord) error {
+	var items interface{}
+	switch format {
+	case "alfred":
+		alfred := make([]*alfredItem, 0, len(records))
+		for _, record := range records {
+			item := &alfredItem{
+				UID:       record.ID,
+				Title:     launcherTitle(record),
+				Subtitle:  launcherSubtitle(record),
+				Arg:       launcherArg(record),
+				Variables: map[string]string{"id": record.ID},
+			}
+			if len(record.Sensitive) == 0 {
+				words := slices.DeleteFunc(append([]string{item.Title, record.URL, record.Title}, record.Tags...), func(word string) bool { return word == "" })
+				item.Match = strings.Join(words, " ")
+				if record.Text != "" {
+					item.Text = &alfredText{Copy: record.Text, LargeType: record.Text}
+				}
+			}
+			alfred = append(alfred, item)
+		}
+		items = alfred
+	case "raycast":
+		raycast := make([]*raycastItem, 0, len(records))
+		for _, record := range records {
+			item := &raycastItem{
+				ID:       record.ID,
+				Title:    launcherTitle(record),
+				Subtitle: record.URL,
+				Arg:      launcherArg(record),
+				Keywords: record.Tags,
+			}
+			if record.Kind != "" {
+				item.Accessories = append(item.Accessories, &raycastAccessory{Tag: record.Kind})
+			}
+			if record.Timestamp > 0 {
+				item.Accessories = append(item.Accessories, &raycastAccessory{Date: time.UnixMilli(record.Timestamp).Format(time.RFC3339)})
+			}
+			raycast = append(raycast, item)
+		}
+		items = raycast
+	default:
+		return fmt.Errorf("unknown launcher format %q", format)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(map[string]interface{}{"items": items})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// storageLockName is the file in the storage directory that host instances
+// and the CLI lock before changing stored data
+const storageLockName = ".storage.lock"
+
+// errLockHeld is returned by tryLockFile when another process holds the lock
+var errLockHeld = errors.New("lock is held by another process")
+
+// fileLock is an exclusive advisory lock held on a file. It excludes other
+// processes as well as other holders within the same process.
+type fileLock struct {
+	file *os.File
+}
+
+// storageLockPath returns the lock file for a storage directory
+func storageLockPath(tabdDir string) string {
+	return filepath.Join(tabdDir, storageLockName)
+}
+
+// acquireFileLock blocks until it holds the exclusive lock on path, creating
+// the file if needed
+func acquireFileLock(path string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", filepath.Base(path), err)
+	}
+	return &fileLock{file: file}, nil
+}
+
+// tryFileLock takes the exclusive lock on path like acquireFileLock, but
+// returns errLockHeld instead of waiting for another process
+func tryFileLock(path string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := tryLockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, errLockHeld) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to lock %s: %v", filepath.Base(path), err)
+	}
+	return &fileLock{file: file}, nil
+}
+
+// Release gives up the lock
+func (l *fileLock) Release() error {
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLogMaxSize is the size in megabytes at which the log is rotated
+	defaultLogMaxSize = 10
+
+	// defaultLogMaxBackups is the number of rotated log files kept
+	defaultLogMaxBackups = 3
+
+	// levelOff disables logging entirely
+	levelOff = slog.Level(100)
+)
+
+// logLevel is the minimum level logged, which a config reload may change
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel converts a configured level name to a slog level
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "", "error":
+		return slog.LevelError, nil
+	case "off", "none":
+		return levelOff, nil
+	default:
+		return 0, fmt.Errorf("unknown log level: %s", name)
+	}
+}
+
+// setupLogging routes log output at or above the configured level to a
+// rotating native-host.log in the storage directory. The file is only created
+// once something is logged, so with the level off it is never written.
+func setupLogging(config *Config) (io.Closer, error) {
+	level, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	logLevel.Set(level)
+
+	writer := &rotatingWriter{
+		path:       filepath.Join(config.StorageDir, "native-host.log"),
+		maxSize:    int64(config.LogMaxSize) * 1024 * 1024,
+		maxBackups: config.LogMaxBackups,
+	}
+
+	options := &slog.HandlerOptions{
+		Level:     logLevel,
+		AddSource: true,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if source, ok := attr.Value.Any().(*slog.Source); ok {
+				return slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", filepath.Base(source.File), source.Line))
+			}
+			return attr
+		},
+	}
+
+	var handler slog.Handler
+	switch config.LogFormat {
+	case "json":
+		handler = slog.NewJSONHandler(writer, options)
+	default:
+		handler = slog.NewTextHandler(writer, options)
+	}
+	slog.SetDefault(slog.New(handler))
+
+	return writer, nil
+}
+
+// setLogLevel changes the minimum level logged from now on
+func setLogLevel(name string) error {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+	return nil
+}
+
+// logf writes a formatted message at the given level, attributing it to the
+// caller of the log helper
+func logf(level slog.Level, format string, args ...interface{}) {
+	logger := slog.Default()
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+
+	record := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, args...), pcs[0])
+	logger.Handler().Handle(context.Background(), record)
+}
+
+// logAttrs writes a message with structured attributes at the given level,
+// attributing it to the caller
+func logAttrs(level slog.Level, message string, attrs ...slog.Attr) {
+	logger := slog.Default()
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+
+	record := slog.NewRecord(time.Now(), level, message, pcs[0])
+	record.AddAttrs(attrs...)
+	logger.Handler().Handle(context.Background(), record)
+}
+
+// logDebugf logs a formatted debug message
+func logDebugf(format string, args ...interface{}) {
+	logf(slog.LevelDebug, format, args...)
+}
+
+// logInfof logs a formatted informational message
+func logInfof(format string, args ...interface{}) {
+	logf(slog.LevelInfo, format, args...)
+}
+
+// logWarnf logs a formatted warning
+func logWarnf(format string, args ...interface{}) {
+	logf(slog.LevelWarn, format, args...)
+}
+
+// logErrorf logs a formatted error
+func logErrorf(format string, args ...interface{}) {
+	logf(slog.LevelError, format, args...)
+}
+
+// rotatingWriter appends to a log file, rotating it to path.1, path.2, ...
+// once it exceeds maxSize bytes and keeping at most maxBackups old files
+type rotatingWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Write appends p to the log, opening or rotating the file as needed
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize && w.size > 0 {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// open opens the log file for appending. Callers must hold w.mu.
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// rotate shifts existing backups, moves the current log aside and starts a
+// new file. Callers must hold w.mu.
+func (w *rotatingWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	if w.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", w.path, w.maxBackups))
+		for i := w.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+		os.Rename(w.path, w.path+".1")
+	} else {
+		os.Remove(w.path)
+	}
+
+	return w.open()
+}
+
+// Close closes the current log file
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -1,260 +1,434 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
-)
 
-// ClipboardData represents the simplified data structure received from the browser extension
-type ClipboardData struct {
-	Type      string `json:"type"`
-	Text      string `json:"text"`
-	Timestamp int64  `json:"timestamp"`
-	URL       string `json:"url"`
-	Title     string `json:"title"`
-}
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+)
 
-// Response represents the response sent back to the browser extension
-type Response struct {
-	Status    string `json:"status"`
-	Message   string `json:"message,omitempty"`
-	Timestamp int64  `json:"timestamp"`
-}
+// The protocol types are shared with other tools through pkg/protocol
+type (
+	ClipboardData = protocol.ClipboardData
+	LinkPreview   = protocol.LinkPreview
+	Message       = protocol.Message
+	Response      = protocol.Response
+)
 
 // TabdNativeHost handles native messaging communication
 type TabdNativeHost struct {
-	tabdDir       string
-	logFile       *os.File
+	config          *Config
+	tabdDir         string
+	logFile         io.Closer
+	sensitive       *SensitiveScanner
+	origins         *OriginPolicy
+	webhooks        *Webhooks
+	hooks           *Hooks
+	unfurler        *Unfurler
+	notifier        *Notifier
+	audit           *AuditLog
+	metrics         *Metrics
+	systemClipboard bool
+	actions         map[string]actionHandler
+
+	// caller is the extension that started the host, from the arguments the
+	// browser passes
+	caller string
+
+	// lan shares saved entries with paired hosts while the daemon runs
+	// with --lan
+	lan *LANShare
+
+	// startTime is when the host was created, reported as uptime
+	startTime time.Time
+
+	// The retention period in effect, which a config reload may change
+	retentionMu sync.Mutex
+	retention   string
+
+	// The storage and history in use, which unlocking and incognito mode
+	// swap while sessions are using them. Read them through storage and
+	// historyStore.
+	storageMu     sync.RWMutex
 	secureStorage SecureStorage
+	history       HistoryStore
+
+	// Whether storage is waiting for a passphrase unlock, or could not be
+	// opened because the Keychain prompt for its key was denied
+	lockMu    sync.Mutex
+	locked    bool
+	lockedErr error
+
+	// incognitoEntries holds entries saved from private browsing windows
+	// once there are any, guarded by lockMu
+	incognitoEntries *memoryEntries
+
+	// incognito is set while entries are kept in memory only, guarded by
+	// lockMu as it swaps the storage
+	incognito *incognitoMode
+
+	// Serializes quota enforcement between concurrent saves
+	quotaMu sync.Mutex
+
+	// Sessions currently connected, for pushing events
+	sessionsMu sync.Mutex
+	sessions   map[*Session]bool
+
+	// Event streams of gRPC watch calls, also guarded by sessionsMu
+	streams map[*eventStream]bool
+
+	// Last text known to be on the OS clipboard, used by the watcher
+	clipboardMu       sync.Mutex
+	lastClipboardText string
 }
 
 // NewTabdNativeHost creates a new native host instance
-func NewTabdNativeHost() (*TabdNativeHost, error) {
-	// Get home directory
-	homeDir, err := os.UserHomeDir()
+func NewTabdNativeHost(config *Config) (*TabdNativeHost, error) {
+	// Create the storage directory (~/.tabd by default)
+	tabdDir := config.StorageDir
+	if err := os.MkdirAll(tabdDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create .tabd directory: %v", err)
+	}
+
+	logFile, err := setupLogging(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %v", err)
+		return nil, err
 	}
 
-	// Create ~/.tabd directory
-	tabdDir := filepath.Join(homeDir, ".tabd")
-	if err := os.MkdirAll(tabdDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create .tabd directory: %v", err)
+	sensitive, err := NewSensitiveScanner(config)
+	if err != nil {
+		return nil, err
 	}
 
-	var logFile *os.File
+	configureBlobCiphers(config)
 
-	// Only set up logging if debug environment variable is set
-	if os.Getenv("TABD_DEBUG") != "" {
-		// Open log file
-		logPath := filepath.Join(tabdDir, "native-host.log")
-		logFile, err = os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open log file: %v", err)
-		}
+	// A passphrase protected store stays closed until it is unlocked
+	secureStorage, history, err := openStorage(tabdDir, config.StorageBackend, config.MaxHistory)
+	locked := errors.Is(err, ErrLocked) || errors.Is(err, ErrKeychainDenied)
+	if err != nil && !locked {
+		return nil, err
+	}
 
-		// Set up logging
-		log.SetOutput(logFile)
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
-	} else {
-		// Disable logging by sending to a discard writer
-		log.SetOutput(io.Discard)
+	audit := NewAuditLog(config)
+	secureStorage, history = auditStorage(audit, secureStorage, history)
+
+	host := &TabdNativeHost{
+		config:          config,
+		tabdDir:         tabdDir,
+		logFile:         logFile,
+		secureStorage:   secureStorage,
+		history:         history,
+		sensitive:       sensitive,
+		origins:         NewOriginPolicy(config),
+		webhooks:        NewWebhooks(config),
+		hooks:           NewHooks(config),
+		unfurler:        NewUnfurler(config),
+		notifier:        NewNotifier(config),
+		audit:           audit,
+		metrics:         NewMetrics(),
+		systemClipboard: os.Getenv("TABD_SYSTEM_CLIPBOARD") != "",
+		locked:          locked,
+		lockedErr:       err,
+		startTime:       time.Now(),
+		retention:       config.Retention,
 	}
+	host.actions = host.registerActions()
+	host.sessions = make(map[*Session]bool)
+	host.streams = make(map[*eventStream]bool)
+
+	return host, nil
+}
+
+// storage returns the storage in use
+func (t *TabdNativeHost) storage() SecureStorage {
+	t.storageMu.RLock()
+	defer t.storageMu.RUnlock()
+	return t.secureStorage
+}
 
-	return &TabdNativeHost{
-		tabdDir:       tabdDir,
-		logFile:       logFile,
-		secureStorage: NewSecureStorage(tabdDir),
-	}, nil
+// historyStore returns the history in use
+func (t *TabdNativeHost) historyStore() HistoryStore {
+	t.storageMu.RLock()
+	defer t.storageMu.RUnlock()
+	return t.history
+}
+
+// setStorage replaces the storage and history in use. The caller holds
+// lockMu, so swaps do not race each other.
+func (t *TabdNativeHost) setStorage(secureStorage SecureStorage, history HistoryStore) {
+	t.storageMu.Lock()
+	defer t.storageMu.Unlock()
+	t.secureStorage, t.history = secureStorage, history
 }
 
 // Close closes the native host resources
 func (t *TabdNativeHost) Close() {
+	t.disableIncognito()
+	t.wipeIncognitoEntries()
+	t.webhooks.Wait(webhookTimeout)
+	t.hooks.Wait()
+	t.unfurler.Wait(unfurlTimeout)
+	t.notifier.Wait(notifyTimeout)
+	if closer, ok := unwrapStorage(t.storage()).(io.Closer); ok {
+		closer.Close()
+	}
 	if t.logFile != nil {
 		t.logFile.Close()
 	}
 }
 
-// readMessage reads a message from stdin using Chrome's native messaging format
-func (t *TabdNativeHost) readMessage() ([]byte, error) {
-	// Read the message length (4 bytes, little-endian)
-	var length uint32
-	if err := binary.Read(os.Stdin, binary.LittleEndian, &length); err != nil {
-		if err == io.EOF {
-			return nil, err
-		}
-		return nil, fmt.Errorf("failed to read message length: %v", err)
-	}
+// saveClipboardData appends clipboard data to the history and stores it as the
+// latest entry, returning the history ID and, if the save went over the
+// storage quota, what was evicted. Content identical to the newest entry
+// refreshes that entry instead of adding a duplicate. Entries from private
+// browsing windows are kept in memory, as in incognito mode. Text beyond the
+// configured maxTextLength is truncated. Nothing is saved if ctx is done
+// before the entry is written.
+func (t *TabdNativeHost) saveClipboardData(ctx context.Context, data *ClipboardData) (string, *QuotaReport, error) {
+	return t.storeClipboardData(ctx, data, t.config.MaxTextBytes())
+}
 
-	// Validate message length
-	if length == 0 || length > 1024*1024 { // Max 1MB message
-		return nil, fmt.Errorf("invalid message length: %d", length)
+// storeClipboardData saves clipboard data as saveClipboardData does,
+// truncating text longer than maxText bytes unless maxText is zero
+func (t *TabdNativeHost) storeClipboardData(ctx context.Context, data *ClipboardData, maxText int) (string, *QuotaReport, error) {
+	if truncateText(data, maxText) {
+		logInfof("Truncated a %d byte entry to %d bytes", data.FullLength, len(data.Text))
 	}
 
-	// Read the message data
-	message := make([]byte, length)
-	if _, err := io.ReadFull(os.Stdin, message); err != nil {
-		return nil, fmt.Errorf("failed to read message data: %v", err)
+	tags, err := normalizeTags(data.Tags)
+	if err != nil {
+		return "", nil, err
+	}
+	data.Tags = tags
+	data.Kind, data.Language = classifyContent(data)
+
+	latest := t.storage()
+	if t.isIncognito() {
+		data.Incognito = true
+	} else if data.Incognito {
+		latest = t.incognitoNamespace().memory
 	}
 
-	return message, nil
-}
+	var id string
+	if !t.config.DisableDedup {
+		if existing, previous, ok := t.findDuplicate(data); ok {
+			// Keep the tags and note given to the entry unless the save
+			// brings its own, and any link details already fetched
+			if len(data.Tags) == 0 && data.Note == "" {
+				data.Tags, data.Note = previous.Tags, previous.Note
+			}
+			if data.Link == nil {
+				data.Link = previous.Link
+			}
 
-// sendMessage sends a message to stdout using Chrome's native messaging format
-func (t *TabdNativeHost) sendMessage(message []byte) error {
-	// Write message length (4 bytes, little-endian)
-	length := uint32(len(message))
-	if err := binary.Write(os.Stdout, binary.LittleEndian, length); err != nil {
-		return fmt.Errorf("failed to write message length: %v", err)
+			// Update the existing entry with the new timestamp and metadata
+			if err := contextErr(ctx); err != nil {
+				return "", nil, err
+			}
+			if err := t.historyStore().Update(existing, data); err != nil {
+				t.metrics.storageError("save")
+				return "", nil, err
+			}
+			logDebugf("Refreshed duplicate history entry %s", existing)
+			id = existing
+		}
 	}
 
-	// Write message data
-	if _, err := os.Stdout.Write(message); err != nil {
-		return fmt.Errorf("failed to write message data: %v", err)
+	// Append to history
+	if id == "" {
+		if id, err = appendEntry(ctx, t.historyStore(), data); err != nil {
+			if contextErr(ctx) == nil {
+				t.metrics.storageError("save")
+			}
+			return "", nil, err
+		}
 	}
 
-	return nil
-}
-
-// saveClipboardData saves clipboard data to secure storage
-func (t *TabdNativeHost) saveClipboardData(data *ClipboardData) error {
-	// Convert to JSON
+	// Store in secure storage. Once the entry is in the history the save
+	// goes through whatever the deadline, so the latest entry matches it.
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal clipboard data: %v", err)
+		return "", nil, fmt.Errorf("failed to marshal clipboard data: %v", err)
+	}
+	if err := latest.Store("latest_clipboard", jsonData); err != nil {
+		t.metrics.storageError("save")
+		return "", nil, err
 	}
 
-	// Store in secure storage
-	return t.secureStorage.Store("latest_clipboard", jsonData)
-}
+	t.broadcastEntrySaved(id, data)
 
-// getClipboardData retrieves clipboard data from secure storage
-func (t *TabdNativeHost) getClipboardData() (*ClipboardData, error) {
-	// Retrieve from secure storage
-	jsonData, err := t.secureStorage.Retrieve("latest_clipboard")
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve clipboard data: %v", err)
+	// Incognito entries stay in this process
+	if data.Incognito {
+		return id, nil, nil
 	}
 
-	// Parse JSON
-	var data ClipboardData
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal clipboard data: %v", err)
+	quota := t.enforceQuota(id)
+
+	t.webhooks.Notify("clipboard.saved", id, data)
+	t.hooks.Run("on_save", id, data)
+	t.unfurler.Unfurl(t, id, data)
+	if t.lan != nil {
+		t.lan.Share(data)
 	}
 
-	return &data, nil
+	return id, quota, nil
 }
 
-// handleMessage processes incoming messages from the browser extension
-func (t *TabdNativeHost) handleMessage(messageData []byte) error {
-	// Parse the message
-	var data ClipboardData
-	if err := json.Unmarshal(messageData, &data); err != nil {
-		return fmt.Errorf("failed to parse message: %v", err)
+// getClipboardData retrieves the latest clipboard data from secure storage,
+// or from memory if an entry from a private browsing window is newer
+func (t *TabdNativeHost) getClipboardData(ctx context.Context) (*ClipboardData, error) {
+	var latest *ClipboardData
+	var latestErr error
+	for i, storage := range t.latestStorages() {
+		data, err := latestIn(ctx, storage)
+		if i == 0 {
+			latest, latestErr = data, err
+		} else if err == nil && (latest == nil || data.Timestamp >= latest.Timestamp) {
+			latest, latestErr = data, nil
+		}
 	}
 
-	// Save to secure storage
-	if err := t.saveClipboardData(&data); err != nil {
-		log.Printf("Error saving clipboard data: %v", err)
-
-		// Send error response
-		response := Response{
-			Status:    "error",
-			Message:   fmt.Sprintf("Failed to save clipboard data: %v", err),
-			Timestamp: time.Now().Unix(),
+	if latestErr != nil {
+		if !errors.Is(latestErr, os.ErrNotExist) {
+			t.metrics.storageError("retrieve")
 		}
-
-		responseData, _ := json.Marshal(response)
-		return t.sendMessage(responseData)
+		return nil, fmt.Errorf("failed to retrieve clipboard data: %w", latestErr)
 	}
+	return latest, nil
+}
 
-	// Send success response
-	response := Response{
-		Status:    "success",
-		Message:   "Clipboard data saved successfully",
-		Timestamp: time.Now().Unix(),
-	}
+// run starts the native messaging loop on stdin and stdout
+func (t *TabdNativeHost) run() error {
+	logInfof("Tab'd Native Host started")
 
-	responseData, err := json.Marshal(response)
+	if err := t.checkCaller(); err != nil {
+		return err
+	}
+	permitted, err := t.callerPermissions()
 	if err != nil {
-		return fmt.Errorf("failed to marshal response: %v", err)
+		return err
+	}
+	if t.caller != "" {
+		t.audit.SetActor(t.caller)
 	}
 
-	return t.sendMessage(responseData)
-}
+	// Frames go to the guarded stdout only; anything else printed from here
+	// on goes to stderr
+	stdout, err := claimStdout()
+	if err != nil {
+		return err
+	}
 
-// run starts the native messaging loop
-func (t *TabdNativeHost) run() error {
-	log.Println("Tab'd Native Host started")
-
-	for {
-		// Read message from browser extension
-		messageData, err := t.readMessage()
-		if err != nil {
-			if err == io.EOF {
-				log.Println("Browser extension disconnected")
-				break
-			}
-			log.Printf("Error reading message: %v", err)
-			continue
+	// A browser that disconnects mid-write makes writes fail with EPIPE, which
+	// the session handles, rather than killing the host with SIGPIPE
+	signal.Ignore(syscall.SIGPIPE)
+
+	// Share state with other local clients through a running daemon. The
+	// daemon cannot tell which extension a relayed connection belongs to, so
+	// extensions with restricted permissions are served here instead.
+	if permitted != nil {
+		logInfof("Extension %s is restricted to: %s", t.caller, strings.Join(sortedKeys(permitted), ", "))
+	} else if os.Getenv("TABD_NO_DAEMON") == "" {
+		forwarded, err := t.forwardToDaemon(os.Stdin, stdout)
+		if forwarded {
+			return err
 		}
+	}
 
-		// Handle the message
-		if err := t.handleMessage(messageData); err != nil {
-			log.Printf("Error handling message: %v", err)
-		}
+	// Keeps rotate-key from replacing the key this host encrypts with
+	if hostLock, err := claimNativeHost(t.tabdDir); err != nil {
+		logWarnf("Failed to claim the native host lock: %v", err)
+	} else {
+		defer func() {
+			hostLock.Release()
+			os.Remove(hostLock.file.Name())
+		}()
 	}
 
-	return nil
-}
+	if err := t.lockError(); errors.Is(err, ErrKeychainDenied) {
+		logErrorf("Storage cannot be opened: %v", err)
+	} else if t.isLocked() {
+		logInfof("Storage is locked until unlocked with a passphrase")
+	} else {
+		t.expireHistory()
+	}
+	t.startIncognito()
 
-func main() {
-	// Check if this is a getclipboard command
-	if len(os.Args) > 1 && os.Args[1] == "getclipboard" {
-		// Handle getclipboard command
-		host, err := NewTabdNativeHost()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create native host: %v\n", err)
-			os.Exit(1)
-		}
-		defer host.Close()
+	if os.Getenv("TABD_WATCH_CLIPBOARD") != "" && !t.isLocked() {
+		stop := make(chan struct{})
+		defer close(stop)
+		go NewClipboardWatcher(t, watchIntervalFromEnv()).Run(stop)
+	}
 
-		// Retrieve clipboard data
-		data, err := host.getClipboardData()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to retrieve clipboard data: %v\n", err)
-			os.Exit(1)
-		}
+	// Stop after the in-flight message on SIGINT or SIGTERM, so storage and
+	// the log are closed cleanly instead of relying on stdin EOF
+	session := t.NewSession(os.Stdin, stdout)
+	session.allowedActions = permitted
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		logInfof("Received %v, shutting down", sig)
+		session.Stop(sig.String())
+	}()
+
+	// Exit once the browser stops sending messages; it starts the host again
+	// the next time the extension connects
+	if timeout := t.config.IdleTimeoutPeriod(); timeout > 0 {
+		go session.stopWhenIdle(timeout)
+	}
 
-		// Output as JSON
-		encoder := json.NewEncoder(os.Stdout)
-		encoder.SetIndent("", "  ")
-		if err := encoder.Encode(data); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to encode clipboard data: %v\n", err)
-			os.Exit(1)
+	err = session.serve()
+	if session.stopped() == "idle" {
+		t.logStatistics()
+	}
+	return err
+}
+
+func main() {
+	root := newRootCommand()
+	args, native := nativeMessagingArgs(root, os.Args[1:])
+	if !native {
+		if err := root.Execute(); err != nil {
+			var status exitStatus
+			if !quiet && !errors.As(err, &status) {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			os.Exit(exitCode(err))
 		}
 		return
 	}
 
+	config, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create native host for native messaging
-	host, err := NewTabdNativeHost()
+	host, err := NewTabdNativeHost(config)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create native host: %v\n", err)
 		os.Exit(1)
 	}
 	defer host.Close()
+	host.caller = callerExtension(args)
 
 	// Run the native messaging loop
 	if err := host.run(); err != nil {
-		log.Printf("Native host error: %v", err)
+		logErrorf("Native host error: %v", err)
 		os.Exit(1)
 	}
 
-	log.Println("Tab'd Native Host shutdown")
+	logInfof("Tab'd Native Host shutdown")
 }
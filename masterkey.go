@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// masterKeyName identifies the passphrase that encrypts stored data
+	masterKeyName = "master_key"
+
+	// previousKeyName identifies the passphrase being replaced while a key
+	// rotation is in progress
+	previousKeyName = "master_key_previous"
+
+	// wrappedKeyName identifies the master key encrypted with a user
+	// passphrase, which replaces masterKeyName when storage is protected
+	wrappedKeyName = "master_key_wrapped"
+
+	// sessionKeyName identifies the master key cached by unlock
+	sessionKeyName = "unlock_session"
+)
+
+// masterKeyFiles maps each key to the file used when no keyring is available
+var masterKeyFiles = map[string]string{
+	masterKeyName:   ".passphrase",
+	previousKeyName: ".passphrase.old",
+	wrappedKeyName:  ".passphrase.wrapped",
+	sessionKeyName:  ".unlock_session",
+}
+
+// masterKeyStore keeps the storage passphrases in the system keyring when one
+// is available, so they do not sit on disk next to the data they protect, and
+// in restricted files in tabdDir otherwise
+type masterKeyStore struct {
+	tabdDir string
+	keyring SecureStorage
+
+	// denied is set once the user refuses a Keychain prompt for a key, which
+	// must not be mistaken for the key not existing
+	denied error
+}
+
+// newMasterKeyStore creates a key store for tabdDir
+func newMasterKeyStore(tabdDir string) *masterKeyStore {
+	keys := &masterKeyStore{tabdDir: tabdDir}
+	if os.Getenv("TABD_DISABLE_KEYRING") == "" {
+		keys.keyring = newMasterKeyring()
+	}
+	return keys
+}
+
+// path returns the file holding a key when the keyring is not used
+func (m *masterKeyStore) path(name string) string {
+	return filepath.Join(m.tabdDir, masterKeyFiles[name])
+}
+
+// load returns every copy of a key, keyring first. A file copy is moved into
+// the keyring if the keyring works but does not hold the key yet.
+func (m *masterKeyStore) load(name string) []string {
+	var found []string
+
+	keyringErr := errKeyringUnavailable
+	if m.keyring != nil {
+		data, err := m.keyring.Retrieve(name)
+		if err == nil {
+			found = append(found, string(data))
+		} else if errors.Is(err, ErrKeychainDenied) {
+			m.denied = err
+		}
+		keyringErr = err
+	}
+
+	path := m.path(name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return found
+	}
+
+	if errors.Is(keyringErr, os.ErrNotExist) {
+		if err := m.keyring.Store(name, data); err == nil {
+			os.Remove(path)
+			logInfof("Moved %s into the system keyring", filepath.Base(path))
+		} else {
+			logWarnf("Failed to move %s into the system keyring: %v", filepath.Base(path), err)
+		}
+	}
+
+	if len(found) == 0 || found[0] != string(data) {
+		found = append(found, string(data))
+	}
+	return found
+}
+
+// save stores a key in the keyring, removing any file copy, or in a file if
+// the keyring cannot be used
+func (m *masterKeyStore) save(name, value string) error {
+	path := m.path(name)
+
+	if m.keyring != nil {
+		err := m.keyring.Store(name, []byte(value))
+		if err == nil {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logWarnf("Failed to remove %s: %v", filepath.Base(path), err)
+			}
+			return nil
+		}
+		if !errors.Is(err, errKeyringUnavailable) {
+			logWarnf("Failed to store %s in the system keyring, using a file: %v", name, err)
+		}
+	}
+
+	return writeFileAtomic(path, []byte(value), 0600)
+}
+
+// delete removes a key from both the keyring and its file
+func (m *masterKeyStore) delete(name string) error {
+	if m.keyring != nil {
+		if err := m.keyring.Delete(name); err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errKeyringUnavailable) {
+			return err
+		}
+	}
+	if err := os.Remove(m.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// protected reports whether the master key is wrapped by a user passphrase
+func (m *masterKeyStore) protected() bool {
+	return len(m.load(wrappedKeyName)) > 0
+}
+
+// wrap encrypts the master key with a user passphrase and removes the
+// unwrapped copy
+func (m *masterKeyStore) wrap(masterKey, userPassphrase string) error {
+	wrapped, err := NewBlobCipher(userPassphrase).Encrypt([]byte(masterKey))
+	if err != nil {
+		return fmt.Errorf("failed to wrap master key: %v", err)
+	}
+	if err := m.save(wrappedKeyName, base64.StdEncoding.EncodeToString(wrapped)); err != nil {
+		return fmt.Errorf("failed to save wrapped master key: %v", err)
+	}
+	return m.delete(masterKeyName)
+}
+
+// unwrap decrypts the master key with a user passphrase
+func (m *masterKeyStore) unwrap(userPassphrase string) (string, error) {
+	found := m.load(wrappedKeyName)
+	if len(found) == 0 {
+		return "", errors.New("storage is not protected by a passphrase")
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(found[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid wrapped master key: %v", err)
+	}
+	masterKey, err := NewBlobCipher(userPassphrase).Decrypt(wrapped)
+	if err != nil {
+		return "", errors.New("incorrect passphrase")
+	}
+	return string(masterKey), nil
+}
+
+// setMasterKey stores a new master key, wrapping it and refreshing the
+// unlock session if a user passphrase is given
+func (m *masterKeyStore) setMasterKey(masterKey, userPassphrase string) error {
+	if userPassphrase == "" {
+		return m.save(masterKeyName, masterKey)
+	}
+
+	if err := m.wrap(masterKey, userPassphrase); err != nil {
+		return err
+	}
+	expires := time.Now().Add(defaultUnlockTimeout)
+	if session, ok := m.session(); ok {
+		expires = time.UnixMilli(session.Expires)
+	}
+	return m.saveSession(unlockSession{Key: masterKey, Expires: expires.UnixMilli()})
+}
+
+// session returns the current unlock session, removing it once expired
+func (m *masterKeyStore) session() (unlockSession, bool) {
+	var session unlockSession
+
+	found := m.load(sessionKeyName)
+	if len(found) == 0 {
+		return session, false
+	}
+	if err := json.Unmarshal([]byte(found[0]), &session); err != nil {
+		return session, false
+	}
+	if time.Now().UnixMilli() >= session.Expires {
+		m.delete(sessionKeyName)
+		return session, false
+	}
+	return session, true
+}
+
+// sessionKey returns the master key cached by an unexpired unlock session
+func (m *masterKeyStore) sessionKey() (string, bool) {
+	session, ok := m.session()
+	return session.Key, ok
+}
+
+// saveSession caches the unwrapped master key until the session expires
+func (m *masterKeyStore) saveSession(session unlockSession) error {
+	jsonData, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal unlock session: %v", err)
+	}
+	return m.save(sessionKeyName, string(jsonData))
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// A minimal DNS-SD over multicast DNS implementation, enough for tabd hosts
+// to find each other. Responders answer PTR queries for their service with
+// the SRV and TXT records of their instance, always unicast to the querier,
+// and browsers connect to the address the answer came from, so no address
+// records are needed.
+
+const (
+	// lanServiceType is advertised by hosts sharing clipboard entries, and
+	// lanPairServiceType by a host waiting to pair
+	lanServiceType     = "_tabd._tcp.local."
+	lanPairServiceType = "_tabd-pair._tcp.local."
+
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+	dnsClassIN = 1
+
+	// mdnsTTL is the record lifetime in responses, in seconds
+	mdnsTTL = 120
+)
+
+// mdnsGroup is the IPv4 multicast address mDNS queries are sent to
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsService is a service instance advertised on the local network
+type mdnsService struct {
+	Service  string
+	Instance string
+	Port     int
+	TXT      []string
+}
+
+// mdnsResult is a service instance found by browsing
+type mdnsResult struct {
+	Instance string
+	Addr     string
+	TXT      map[string]string
+}
+
+// instanceName returns the fully qualified instance name
+func (s *mdnsService) instanceName() string {
+	return dnsLabel(s.Instance) + "." + s.Service
+}
+
+// dnsLabel makes an instance name safe to use as a single DNS label
+func dnsLabel(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '.' {
+			return '-'
+		}
+		return r
+	}, name)
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// appendDNSName encodes a dotted name as DNS labels
+func appendDNSName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// appendDNSRecord encodes a resource record
+func appendDNSRecord(b []byte, name string, recordType uint16, rdata []byte) []byte {
+	b = appendDNSName(b, name)
+	b = binary.BigEndian.AppendUint16(b, recordType)
+	b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+	b = binary.BigEndian.AppendUint32(b, mdnsTTL)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+// mdnsQuery builds a PTR query for a service type
+func mdnsQuery(service string) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	b = appendDNSName(b, service)
+	b = binary.BigEndian.AppendUint16(b, dnsTypePTR)
+	return binary.BigEndian.AppendUint16(b, dnsClassIN)
+}
+
+// mdnsResponse builds the answer for a service to a query with the given ID,
+// echoing the question as legacy unicast responses must
+func mdnsResponse(id uint16, service *mdnsService) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], 0x8400) // response, authoritative
+	binary.BigEndian.PutUint16(b[4:], 1)
+	binary.BigEndian.PutUint16(b[6:], 3)
+
+	b = appendDNSName(b, service.Service)
+	b = binary.BigEndian.AppendUint16(b, dnsTypePTR)
+	b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+
+	instance := service.instanceName()
+	b = appendDNSRecord(b, service.Service, dnsTypePTR, appendDNSName(nil, instance))
+
+	srv := make([]byte, 6)
+	binary.BigEndian.PutUint16(srv[4:], uint16(service.Port))
+	srv = appendDNSName(srv, dnsLabel(service.Instance)+".local.")
+	b = appendDNSRecord(b, instance, dnsTypeSRV, srv)
+
+	var txt []byte
+	for _, entry := range service.TXT {
+		txt = append(txt, byte(len(entry)))
+		txt = append(txt, entry...)
+	}
+	return appendDNSRecord(b, instance, dnsTypeTXT, txt)
+}
+
+// dnsRecord is a parsed resource record or question
+type dnsRecord struct {
+	Name  string
+	Type  uint16
+	RData []byte
+}
+
+// dnsMessage is a parsed DNS message
+type dnsMessage struct {
+	ID        uint16
+	Response  bool
+	Questions []dnsRecord
+	Records   []dnsRecord
+}
+
+// readDNSName decodes a possibly compressed name at offset, returning it and
+// the offset following it
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("truncated name")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) {
+				return "", 0, errors.New("truncated name pointer")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("name compression loop")
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("truncated label")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// parseDNSMessage decodes a DNS message
+func parseDNSMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("short message")
+	}
+	parsed := &dnsMessage{
+		ID:       binary.BigEndian.Uint16(msg[0:]),
+		Response: msg[2]&0x80 != 0,
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil || next+4 > len(msg) {
+			return nil, fmt.Errorf("malformed question")
+		}
+		parsed.Questions = append(parsed.Questions, dnsRecord{Name: name, Type: binary.BigEndian.Uint16(msg[next:])})
+		offset = next + 4
+	}
+	for i := 0; i < records; i++ {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil || next+10 > len(msg) {
+			return nil, fmt.Errorf("malformed record")
+		}
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		if next+10+length > len(msg) {
+			return nil, fmt.Errorf("truncated record")
+		}
+		parsed.Records = append(parsed.Records, dnsRecord{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(msg[next:]),
+			RData: msg[next+10 : next+10+length],
+		})
+		offset = next + 10 + length
+	}
+	return parsed, nil
+}
+
+// advertiseMDNS answers queries for a service until stop is closed
+func advertiseMDNS(service *mdnsService, stop <-chan struct{}) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return fmt.Errorf("failed to join mDNS group: %v", err)
+	}
+	go func() {
+		<-stop
+		conn.Close()
+	}()
+
+	buffer := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to read mDNS query: %v", err)
+		}
+
+		msg, err := parseDNSMessage(buffer[:n])
+		if err != nil || msg.Response {
+			continue
+		}
+		for _, question := range msg.Questions {
+			if strings.EqualFold(question.Name, service.Service) && (question.Type == dnsTypePTR || question.Type == dnsTypeANY) {
+				if _, err := conn.WriteToUDP(mdnsResponse(msg.ID, service), from); err != nil {
+					logDebugf("Error answering mDNS query from %s: %v", from, err)
+				}
+				break
+			}
+		}
+	}
+}
+
+// browseMDNS queries the local network for instances of a service, collecting
+// answers until the timeout passes
+func browseMDNS(service string, timeout time.Duration) ([]mdnsResult, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mDNS socket: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(mdnsQuery(service), mdnsGroup); err != nil {
+		return nil, fmt.Errorf("failed to send mDNS query: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	found := map[string]*mdnsResult{}
+	var order []string
+	buffer := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			break
+		}
+		msg, err := parseDNSMessage(buffer[:n])
+		if err != nil || !msg.Response {
+			continue
+		}
+
+		for _, record := range msg.Records {
+			if record.Type != dnsTypeSRV || len(record.RData) < 6 {
+				continue
+			}
+			port := binary.BigEndian.Uint16(record.RData[4:])
+			key := record.Name + "@" + from.IP.String()
+			if _, ok := found[key]; !ok {
+				order = append(order, key)
+			}
+			found[key] = &mdnsResult{
+				Instance: strings.TrimSuffix(strings.TrimSuffix(record.Name, service), "."),
+				Addr:     net.JoinHostPort(from.IP.String(), fmt.Sprint(port)),
+				TXT:      parseTXT(msg.Records, record.Name),
+			}
+		}
+	}
+
+	results := make([]mdnsResult, 0, len(order))
+	for _, key := range order {
+		results = append(results, *found[key])
+	}
+	return results, nil
+}
+
+// parseTXT collects the key=value pairs of an instance's TXT record
+func parseTXT(records []dnsRecord, instance string) map[string]string {
+	values := map[string]string{}
+	for _, record := range records {
+		if record.Type != dnsTypeTXT || !strings.EqualFold(record.Name, instance) {
+			continue
+		}
+		for data := record.RData; len(data) > 0; {
+			length := int(data[0])
+			if 1+length > len(data) {
+				break
+			}
+			if key, value, ok := strings.Cut(string(data[1:1+length]), "="); ok {
+				values[key] = value
+			}
+			data = data[1+length:]
+		}
+	}
+	return values
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// defaultMergeSeparator is placed between merged entries when no
+	// separator is given
+	defaultMergeSeparator = "\n"
+
+	// maxMergeEntries bounds the number of entries one merge may combine
+	maxMergeEntries = 100
+)
+
+// mergeResult is the data returned by a merge: the new entry and the number
+// of entries combined into it
+type mergeResult struct {
+	ID     string `json:"id"`
+	Merged int    `json:"merged"`
+	*QuotaReport
+}
+
+// newestEntryIDs returns the IDs of the newest count history entries,
+// oldest first so they merge in the order they were copied
+func (t *TabdNativeHost) newestEntryIDs(count int) ([]string, error) {
+	entries, err := t.historyStore().Query(HistoryQuery{Limit: count})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clipboard history: %v", err)
+	}
+	ids := make([]string, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		ids = append(ids, entries[i].ID)
+	}
+	return ids, nil
+}
+
+// mergeEntries saves the text of the given history entries, joined by
+// separator in the order given, as a new entry. The new entry is tagged with
+// every kind of sensitive content found in the entries it combines.
+func (t *TabdNativeHost) mergeEntries(ctx context.Context, ids []string, separator string) (string, *ClipboardData, *QuotaReport, error) {
+	if len(ids) < 2 {
+		return "", nil, nil, invalidRequestf("merge needs at least two entries")
+	}
+	if len(ids) > maxMergeEntries {
+		return "", nil, nil, invalidRequestf("merge combines at most %d entries", maxMergeEntries)
+	}
+
+	texts := make([]string, 0, len(ids))
+	sensitive := map[string]bool{}
+	incognito := false
+	for _, id := range ids {
+		data, err := getEntry(ctx, t.historyStore(), id)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("failed to retrieve history entry %s: %w", id, err)
+		}
+		text := entryPlainText(data)
+		if text == "" {
+			return "", nil, nil, invalidRequestf("history entry %s has no text to merge", id)
+		}
+		texts = append(texts, text)
+		for _, kind := range data.Sensitive {
+			sensitive[kind] = true
+		}
+		incognito = incognito || data.Incognito
+	}
+
+	// Merging an entry from a private browsing window keeps the result in
+	// memory too
+	merged := &ClipboardData{
+		Text:      strings.Join(texts, separator),
+		Timestamp: time.Now().UnixMilli(),
+		Incognito: incognito,
+	}
+	for kind := range sensitive {
+		merged.Sensitive = append(merged.Sensitive, kind)
+	}
+	sort.Strings(merged.Sensitive)
+
+	id, quota, err := t.saveClipboardData(ctx, merged)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to save merged entry: %w", err)
+	}
+	return id, merged, quota, nil
+}
+
+// handleMerge combines the entries listed in ids, or the newest limit
+// entries, into a new entry, optionally placing it on the OS clipboard
+func (t *TabdNativeHost) handleMerge(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	ids := msg.IDs
+	if len(ids) == 0 && msg.Limit > 0 {
+		if msg.Limit > maxMergeEntries {
+			return "", nil, invalidRequestf("merge combines at most %d entries", maxMergeEntries)
+		}
+		var err error
+		if ids, err = t.newestEntryIDs(msg.Limit); err != nil {
+			return "", nil, err
+		}
+	}
+	separator := defaultMergeSeparator
+	if msg.Separator != nil {
+		separator = *msg.Separator
+	}
+
+	id, merged, quota, err := t.mergeEntries(ctx, ids, separator)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to merge entries: %w", err)
+	}
+	result := &mergeResult{ID: id, Merged: len(ids), QuotaReport: quota}
+
+	if t.systemClipboard || (msg.SystemClipboard && session.hasFeature("system_clipboard")) {
+		t.noteClipboardText(merged.Text)
+		if err := writeClipboardData(merged); err != nil {
+			logWarnf("Error writing system clipboard: %v", err)
+			return "Entries merged, but writing the system clipboard failed", result, nil
+		}
+	}
+	return fmt.Sprintf("Merged %d entries", len(ids)), result, nil
+}
+
+// newMergeCommand creates the merge command, which combines history entries
+// into a new entry
+func newMergeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "merge [flags] <id>... | --last <n>",
+		Short: "Combine history entries into a new entry, optionally copying it",
+	}
+	flags := cmd.Flags()
+	separator := flags.String("separator", `\n`, `text placed between entries; \n and \t are newline and tab`)
+	last := flags.Int("last", 0, "merge the newest n entries, oldest first, instead of the given IDs")
+	copyResult := flags.Bool("copy", false, "also place the merged entry on the OS clipboard")
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		if (*last > 0) == (len(positional) > 0) {
+			return fmt.Errorf("usage: merge [--separator <text>] [--copy] <id>... | --last <n>")
+		}
+
+		ids := positional
+		if *last > 0 {
+			var err error
+			if ids, err = host.newestEntryIDs(*last); err != nil {
+				return err
+			}
+		}
+		sep := strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(*separator)
+
+		id, merged, _, err := host.mergeEntries(context.Background(), ids, sep)
+		if err != nil {
+			return err
+		}
+		if *copyResult {
+			if err := writeClipboardData(merged); err != nil {
+				return fmt.Errorf("merged %d entries into %s, but writing the system clipboard failed: %v", len(ids), id, err)
+			}
+		}
+		fmt.Printf("Merged %d entries into %s\n", len(ids), id)
+		return nil
+	})
+	return cmd
+}
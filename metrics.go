@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsBuckets are the upper bounds, in seconds, of the action duration
+// histogram
+var metricsBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// histogram accumulates observations into cumulative buckets
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Metrics counts the messages handled by the host for the Prometheus
+// /metrics endpoint
+type Metrics struct {
+	mu            sync.Mutex
+	messages      map[[2]string]uint64
+	durations     map[string]*histogram
+	storageErrors map[string]uint64
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		messages:      make(map[[2]string]uint64),
+		durations:     make(map[string]*histogram),
+		storageErrors: make(map[string]uint64),
+	}
+}
+
+// observe records a handled message
+func (m *Metrics) observe(action, status string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages[[2]string{action, status}]++
+
+	h := m.durations[action]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(metricsBuckets))}
+		m.durations[action] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range metricsBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// storageError records a failed storage operation
+func (m *Metrics) storageError(operation string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.storageErrors[operation]++
+}
+
+// totals returns the number of messages handled and how many of them failed
+func (m *Metrics) totals() (messages, failed uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, count := range m.messages {
+		messages += count
+		if key[1] != "success" {
+			failed += count
+		}
+	}
+	return messages, failed
+}
+
+// logStatistics logs a summary of the work done since the host started
+func (t *TabdNativeHost) logStatistics() {
+	messages, failed := t.metrics.totals()
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	logAttrs(slog.LevelInfo, "Host statistics",
+		slog.Uint64("messages", messages),
+		slog.Uint64("failed", failed),
+		slog.Duration("uptime", time.Since(t.startTime).Round(time.Second)),
+		slog.Uint64("heapBytes", memory.HeapAlloc))
+}
+
+// metricLabel escapes a label value for the text exposition format
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeMetrics writes the host metrics in the Prometheus text format
+func (t *TabdNativeHost) writeMetrics(w io.Writer) {
+	m := t.metrics
+	m.mu.Lock()
+
+	fmt.Fprintln(w, "# HELP tabd_messages_total Messages handled, by action and response status.")
+	fmt.Fprintln(w, "# TYPE tabd_messages_total counter")
+	keys := make([][2]string, 0, len(m.messages))
+	for key := range m.messages {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "tabd_messages_total{action=\"%s\",status=\"%s\"} %d\n", metricLabel(key[0]), metricLabel(key[1]), m.messages[key])
+	}
+
+	fmt.Fprintln(w, "# HELP tabd_action_duration_seconds Time taken to handle a message, by action.")
+	fmt.Fprintln(w, "# TYPE tabd_action_duration_seconds histogram")
+	for _, action := range sortedKeys(m.durations) {
+		h := m.durations[action]
+		label := metricLabel(action)
+		for i, bound := range metricsBuckets {
+			fmt.Fprintf(w, "tabd_action_duration_seconds_bucket{action=\"%s\",le=\"%g\"} %d\n", label, bound, h.counts[i])
+		}
+		fmt.Fprintf(w, "tabd_action_duration_seconds_bucket{action=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "tabd_action_duration_seconds_sum{action=\"%s\"} %g\n", label, h.sum)
+		fmt.Fprintf(w, "tabd_action_duration_seconds_count{action=\"%s\"} %d\n", label, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP tabd_storage_errors_total Failed storage operations.")
+	fmt.Fprintln(w, "# TYPE tabd_storage_errors_total counter")
+	for _, operation := range sortedKeys(m.storageErrors) {
+		fmt.Fprintf(w, "tabd_storage_errors_total{operation=\"%s\"} %d\n", metricLabel(operation), m.storageErrors[operation])
+	}
+	m.mu.Unlock()
+
+	// Gauges are read from the host without holding the metrics lock
+	if !t.isLocked() {
+		if entries, err := t.historyStore().List(); err == nil {
+			pinned := 0
+			for _, entry := range entries {
+				if entry.Pinned {
+					pinned++
+				}
+			}
+			fmt.Fprintln(w, "# HELP tabd_history_entries Clipboard history entries stored.")
+			fmt.Fprintln(w, "# TYPE tabd_history_entries gauge")
+			fmt.Fprintf(w, "tabd_history_entries %d\n", len(entries))
+			fmt.Fprintln(w, "# HELP tabd_history_pinned_entries Pinned clipboard history entries.")
+			fmt.Fprintln(w, "# TYPE tabd_history_pinned_entries gauge")
+			fmt.Fprintf(w, "tabd_history_pinned_entries %d\n", pinned)
+		}
+	}
+
+	t.sessionsMu.Lock()
+	sessions := len(t.sessions)
+	t.sessionsMu.Unlock()
+	fmt.Fprintln(w, "# HELP tabd_sessions Connected protocol sessions.")
+	fmt.Fprintln(w, "# TYPE tabd_sessions gauge")
+	fmt.Fprintf(w, "tabd_sessions %d\n", sessions)
+
+	fmt.Fprintln(w, "# HELP tabd_uptime_seconds Time since the host started.")
+	fmt.Fprintln(w, "# TYPE tabd_uptime_seconds gauge")
+	fmt.Fprintf(w, "tabd_uptime_seconds %g\n", time.Since(t.startTime).Seconds())
+
+	fmt.Fprintln(w, "# HELP tabd_build_info Build information of the running host.")
+	fmt.Fprintln(w, "# TYPE tabd_build_info gauge")
+	fmt.Fprintf(w, "tabd_build_info{version=\"%s\"} 1\n", metricLabel(version))
+}
+
+// handleMetrics serves the metrics in the Prometheus text format
+func (t *TabdNativeHost) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	t.writeMetrics(w)
+}
+
+// serveMetrics serves only /metrics on a listener, for daemon --metrics
+func (t *TabdNativeHost) serveMetrics(listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", t.handleMetrics)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.Serve(listener)
+}
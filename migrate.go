@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// storageFormatFile records the format version of the storage directory.
+	// It is kept in the clear so it can be checked before storage is opened.
+	storageFormatFile = ".format"
+
+	// currentStorageFormat is the format written by this version
+	currentStorageFormat = 2
+)
+
+// storageFormat is the content of the storage format file
+type storageFormat struct {
+	Version  int   `json:"version"`
+	Migrated int64 `json:"migrated,omitempty"`
+}
+
+// storageMigration upgrades storage from the previous format version to
+// version. Migrations must be safe to run again on storage they have already
+// upgraded, as a crash can leave the format file behind the data.
+type storageMigration struct {
+	version     int
+	description string
+	run         func(storage SecureStorage, history HistoryStore) error
+}
+
+// storageMigrations are applied in order to storage older than their version
+var storageMigrations = []storageMigration{
+	{
+		// Format 1 kept only the latest entry; format 2 added the history index
+		version:     2,
+		description: "copy the latest entry into the history index",
+		run:         migrateLatestToHistory,
+	},
+}
+
+// readStorageFormat returns the format version of a storage directory.
+// Directories written before the format file existed are format 1.
+func readStorageFormat(tabdDir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(tabdDir, storageFormatFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read storage format: %v", err)
+	}
+	var format storageFormat
+	if err := json.Unmarshal(data, &format); err != nil || format.Version < 1 {
+		return 0, fmt.Errorf("invalid storage format file %s", filepath.Join(tabdDir, storageFormatFile))
+	}
+	return format.Version, nil
+}
+
+// writeStorageFormat records the format version of a storage directory
+func writeStorageFormat(tabdDir string, version int) error {
+	data, err := json.Marshal(&storageFormat{Version: version, Migrated: time.Now().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(tabdDir, storageFormatFile), append(data, '\n'), 0600)
+}
+
+// checkStorageFormat refuses storage written by a newer version, which this
+// version could misread or damage
+func checkStorageFormat(tabdDir string) (int, error) {
+	version, err := readStorageFormat(tabdDir)
+	if err != nil {
+		return 0, err
+	}
+	if version > currentStorageFormat {
+		return 0, fmt.Errorf("storage in %s uses format %d, newer than this version of tabd-native-host supports (%d); update it to read this data", tabdDir, version, currentStorageFormat)
+	}
+	return version, nil
+}
+
+// migrateStorage brings opened storage up to the current format, recording
+// the version reached after each step so an interrupted upgrade resumes
+// where it stopped
+func migrateStorage(tabdDir string, version int, storage SecureStorage, history HistoryStore) error {
+	if version == currentStorageFormat {
+		return nil
+	}
+	for _, migration := range storageMigrations {
+		if migration.version <= version {
+			continue
+		}
+		logInfof("Migrating storage to format %d: %s", migration.version, migration.description)
+		if err := migration.run(storage, history); err != nil {
+			return fmt.Errorf("failed to migrate storage to format %d: %v", migration.version, err)
+		}
+		if err := writeStorageFormat(tabdDir, migration.version); err != nil {
+			return fmt.Errorf("failed to record storage format %d: %v", migration.version, err)
+		}
+		version = migration.version
+	}
+	return nil
+}
+
+// migrateLatestToHistory adds the latest entry of format 1 storage to the
+// otherwise empty history, so it shows up in listings and searches
+func migrateLatestToHistory(storage SecureStorage, history HistoryStore) error {
+	entries, err := history.List()
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return nil
+	}
+
+	jsonData, err := storage.Retrieve("latest_clipboard")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read latest entry: %v", err)
+	}
+	var data ClipboardData
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return fmt.Errorf("failed to parse latest entry: %v", err)
+	}
+	if _, err := history.Append(&data); err != nil {
+		return err
+	}
+	logInfof("Copied the latest entry into history")
+	return nil
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Wire formats a session can be switched to through the hello handshake.
+// The browser leg always uses JSON, which native messaging requires.
+const (
+	wireJSON    = "json"
+	wireMsgpack = "msgpack"
+)
+
+// msgpackMaxDepth bounds nesting when decoding untrusted MessagePack
+const msgpackMaxDepth = 64
+
+// errMsgpackTruncated is returned when MessagePack data ends mid-value
+var errMsgpackTruncated = errors.New("msgpack: unexpected end of data")
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// msgpackMarshal encodes v as MessagePack, naming struct fields by their JSON
+// tags so both formats carry the same documents. String fields tagged
+// `msgpack:"bin"` hold base64 and are sent as raw binary.
+func msgpackMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeMsgpack(&buf, reflect.ValueOf(v), false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// msgpackUnmarshal decodes MessagePack into v. Binary values become base64
+// strings, matching how the JSON encoding carries them.
+func msgpackUnmarshal(data []byte, v interface{}) error {
+	value, err := decodeMsgpack(data)
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, v)
+}
+
+// jsonToMsgpack transcodes a JSON document to MessagePack
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return msgpackMarshal(value)
+}
+
+// encodeMsgpack appends the encoding of v. bin marks a base64 string field
+// to emit as binary.
+func encodeMsgpack(buf *bytes.Buffer, v reflect.Value, bin bool) error {
+	if !v.IsValid() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+
+	if number, ok := v.Interface().(json.Number); ok {
+		if i, err := number.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := number.Float64()
+		if err != nil {
+			return err
+		}
+		writeMsgpackFloat(buf, f)
+		return nil
+	}
+
+	// Types with custom JSON encodings keep them
+	if v.Kind() != reflect.Pointer && v.Kind() != reflect.Interface && v.Type().Implements(jsonMarshalerType) {
+		data, err := json.Marshal(v.Interface())
+		if err != nil {
+			return err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		return encodeMsgpack(buf, reflect.ValueOf(value), false)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		return encodeMsgpack(buf, v.Elem(), bin)
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeMsgpackInt(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeMsgpackUint(buf, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeMsgpackFloat(buf, v.Float())
+	case reflect.String:
+		if bin {
+			if data, err := base64.StdEncoding.DecodeString(v.String()); err == nil {
+				writeMsgpackBin(buf, data)
+				return nil
+			}
+		}
+		writeMsgpackString(buf, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(data), v)
+			writeMsgpackBin(buf, data)
+			return nil
+		}
+		writeMsgpackHeader(buf, v.Len(), 0x90, 0xdc, 0xdd, 16)
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeMsgpack(buf, v.Index(i), false); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		keys := make([]string, 0, v.Len())
+		values := make(map[string]reflect.Value, v.Len())
+		for _, key := range v.MapKeys() {
+			name := fmt.Sprint(key.Interface())
+			keys = append(keys, name)
+			values[name] = v.MapIndex(key)
+		}
+		sort.Strings(keys)
+		writeMsgpackHeader(buf, len(keys), 0x80, 0xde, 0xdf, 16)
+		for _, key := range keys {
+			writeMsgpackString(buf, key)
+			if err := encodeMsgpack(buf, values[key], false); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := msgpackFields(v)
+		writeMsgpackHeader(buf, len(fields), 0x80, 0xde, 0xdf, 16)
+		for _, field := range fields {
+			writeMsgpackString(buf, field.name)
+			if err := encodeMsgpack(buf, field.value, field.bin); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// msgpackField is a struct field selected for encoding
+type msgpackField struct {
+	name  string
+	value reflect.Value
+	bin   bool
+}
+
+// msgpackFields lists the fields of a struct the way encoding/json would:
+// by JSON name, skipping "-" and empty omitempty fields, and promoting the
+// fields of untagged embedded structs unless the outer struct has them
+func msgpackFields(v reflect.Value) []msgpackField {
+	fields := []msgpackField{}
+	seen := map[string]bool{}
+	embedded := []reflect.Value{}
+
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		tag := structField.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if structField.Anonymous && name == "" {
+			inner := v.Field(i)
+			if inner.Kind() == reflect.Pointer {
+				if inner.IsNil() {
+					continue
+				}
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct {
+				embedded = append(embedded, inner)
+				continue
+			}
+		}
+		if !structField.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = structField.Name
+		}
+		seen[name] = true
+		value := v.Field(i)
+		if strings.Contains(options, "omitempty") && isEmptyValue(value) {
+			continue
+		}
+		fields = append(fields, msgpackField{name: name, value: value, bin: structField.Tag.Get("msgpack") == "bin"})
+	}
+
+	for _, inner := range embedded {
+		for _, field := range msgpackFields(inner) {
+			if !seen[field.name] {
+				seen[field.name] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// isEmptyValue reports whether omitempty drops v, as encoding/json decides
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Pointer, reflect.Interface:
+		return v.IsZero()
+	}
+	return false
+}
+
+// writeMsgpackInt writes i in the smallest integer form that holds it
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0:
+		writeMsgpackUint(buf, uint64(i))
+	case i >= -32:
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// writeMsgpackUint writes u in the smallest unsigned form that holds it
+func writeMsgpackUint(buf *bytes.Buffer, u uint64) {
+	switch {
+	case u < 128:
+		buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(u))
+	case u <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(u))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, u)
+	}
+}
+
+// writeMsgpackFloat writes f as a float 64
+func writeMsgpackFloat(buf *bytes.Buffer, f float64) {
+	buf.WriteByte(0xcb)
+	binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+}
+
+// writeMsgpackString writes a str value
+func writeMsgpackString(buf *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.WriteString(s)
+}
+
+// writeMsgpackBin writes a bin value
+func writeMsgpackBin(buf *bytes.Buffer, data []byte) {
+	switch n := len(data); {
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xc4)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xc5)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xc6)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.Write(data)
+}
+
+// writeMsgpackHeader writes an array or map header: the fix form for fewer
+// than fixLimit items, else the 16 or 32 bit form
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix, code16, code32 byte, fixLimit int) {
+	switch {
+	case n < fixLimit:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// msgpackDecoder reads MessagePack values into generic Go values
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// decodeMsgpack decodes a single MessagePack document
+func decodeMsgpack(data []byte) (interface{}, error) {
+	decoder := &msgpackDecoder{data: data}
+	value, err := decoder.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if decoder.pos != len(data) {
+		return nil, fmt.Errorf("msgpack: %d trailing bytes", len(data)-decoder.pos)
+	}
+	return value, nil
+}
+
+// read consumes n bytes
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// length reads a big-endian length of size bytes
+func (d *msgpackDecoder) length(size int) (int, error) {
+	b, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), nil
+	}
+}
+
+// value decodes the next value
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	if depth > msgpackMaxDepth {
+		return nil, fmt.Errorf("msgpack: nesting exceeds %d levels", msgpackMaxDepth)
+	}
+
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	code := b[0]
+
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return d.mapValue(int(code&0x0f), depth)
+	case code&0xf0 == 0x90:
+		return d.arrayValue(int(code&0x0f), depth)
+	case code&0xe0 == 0xa0:
+		return d.stringValue(int(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.length(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.read(n)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(data), nil
+	case 0xca:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := d.read(1 << (code - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		b, err := d.read(size)
+		if err != nil {
+			return nil, err
+		}
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		// Sign-extend from the encoded width
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.stringValue(n)
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(n, depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type code 0x%02x", code)
+}
+
+// stringValue reads a str body of n bytes
+func (d *msgpackDecoder) stringValue(n int) (interface{}, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// arrayValue reads n array elements
+func (d *msgpackDecoder) arrayValue(n, depth int) (interface{}, error) {
+	// Every element takes at least one byte
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// mapValue reads n key/value pairs. Non-string keys are formatted as
+// strings, as JSON object keys must be.
+func (d *msgpackDecoder) mapValue(n, depth int) (interface{}, error) {
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, errMsgpackTruncated
+	}
+	items := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			name = fmt.Sprint(key)
+		}
+		items[name] = value
+	}
+	return items, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyTimeout bounds each notification command
+const notifyTimeout = 10 * time.Second
+
+// notificationEvents are the events desktop notifications can be shown for:
+// saved entries, and saves blocked by the origin rules or refused by the
+// sensitive content policy
+var notificationEvents = []string{"saved", "blocked"}
+
+// Notifier shows desktop notifications for the events selected in the config
+type Notifier struct {
+	events map[string]bool
+	wg     sync.WaitGroup
+}
+
+// NewNotifier creates a notifier for the config, or returns nil if no
+// notifications are enabled
+func NewNotifier(config *Config) *Notifier {
+	if len(config.Notifications) == 0 {
+		return nil
+	}
+	events := map[string]bool{}
+	for _, event := range config.Notifications {
+		if event == "all" {
+			for _, event := range notificationEvents {
+				events[event] = true
+			}
+			continue
+		}
+		events[event] = true
+	}
+	return &Notifier{events: events}
+}
+
+// validNotificationEvent reports whether event can be chosen in the config
+func validNotificationEvent(event string) bool {
+	if event == "all" {
+		return true
+	}
+	for _, known := range notificationEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify shows a notification for an event in the background, if the event
+// is enabled. Failures are only logged at debug level, as notifications are
+// best effort.
+func (n *Notifier) Notify(event, title, body string) {
+	if n == nil || !n.events[event] {
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+		cmd := notificationCommand(ctx, title, body)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			logDebugf("Error showing %s notification with %s: %v: %s", event, cmd.Path, err, strings.TrimSpace(string(output)))
+		}
+	}()
+}
+
+// Wait waits up to timeout for notifications still being shown
+func (n *Notifier) Wait(timeout time.Duration) {
+	if n == nil {
+		return
+	}
+	if !waitTimeout(&n.wg, timeout) {
+		logWarnf("Gave up waiting for notifications after %s", timeout)
+	}
+}
+
+// notifySaved shows that an entry was saved. The content of entries tagged
+// as sensitive is left out, as notifications can show on a locked screen.
+func (n *Notifier) notifySaved(data *ClipboardData) {
+	if n == nil {
+		return
+	}
+	body := previewText(data)
+	if len(data.Sensitive) > 0 {
+		body = fmt.Sprintf("Content tagged as sensitive (%s)", strings.Join(data.Sensitive, ", "))
+	}
+	n.Notify("saved", "Saved to Tab'd", body)
+}
+
+// notifyBlocked shows that a save was not stored and why
+func (n *Notifier) notifyBlocked(reason string) {
+	n.Notify("blocked", "Not saved to Tab'd", reason)
+}
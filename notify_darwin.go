@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// notificationCommand shows a notification in Notification Center. The title
+// and body are passed as script arguments so they are never parsed as
+// AppleScript.
+func notificationCommand(ctx context.Context, title, body string) *exec.Cmd {
+	return exec.CommandContext(ctx, "osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, body)
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// notificationCommand shows a notification through libnotify's notify-send
+func notificationCommand(ctx context.Context, title, body string) *exec.Cmd {
+	return exec.CommandContext(ctx, "notify-send", "--app-name=Tab'd", "--", title, body)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a toast notification as PowerShell, which Windows allows
+// to notify without registering an application of its own
+const toastScript = `$ErrorActionPreference = 'Stop'
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:TABD_NOTIFY_TITLE)) | Out-Null
+$text.Item(1).AppendChild($template.CreateTextNode($env:TABD_NOTIFY_BODY)) | Out-Null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe').Show($toast)`
+
+// notificationCommand shows a toast notification. The title and body are
+// passed in the environment so they are never parsed as PowerShell.
+func notificationCommand(ctx context.Context, title, body string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "TABD_NOTIFY_TITLE="+title, "TABD_NOTIFY_BODY="+body)
+	return cmd
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// OriginPolicy decides which source pages may have their clipboard data
+// persisted, based on the URL sent with each save
+type OriginPolicy struct {
+	// mu guards the rules, which a config reload may replace
+	mu      sync.RWMutex
+	allowed []string
+	blocked []string
+}
+
+// NewOriginPolicy creates a policy from the config allow and block lists
+func NewOriginPolicy(config *Config) *OriginPolicy {
+	return &OriginPolicy{
+		allowed: config.AllowedOrigins,
+		blocked: config.BlockedOrigins,
+	}
+}
+
+// update replaces the rules with those of a reloaded config
+func (p *OriginPolicy) update(config *Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.allowed = config.AllowedOrigins
+	p.blocked = config.BlockedOrigins
+}
+
+// originMatches reports whether a rule covers the page URL. Rules with a
+// scheme, such as https://bank.example.com, must match the origin exactly;
+// bare host names also match their subdomains.
+func originMatches(rule string, page *url.URL) bool {
+	rule = strings.ToLower(strings.TrimSpace(rule))
+	if rule == "" {
+		return false
+	}
+
+	if strings.Contains(rule, "://") {
+		ruleURL, err := url.Parse(rule)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(ruleURL.Scheme, page.Scheme) && strings.EqualFold(ruleURL.Host, page.Host)
+	}
+
+	host := strings.ToLower(page.Hostname())
+	rule = strings.TrimPrefix(rule, "*.")
+	return host == rule || strings.HasSuffix(host, "."+rule)
+}
+
+// urlMatchesOrigin reports whether a rule covers a page URL, as
+// originMatches does; URLs without a host match nothing
+func urlMatchesOrigin(rule, pageURL string) bool {
+	page, err := url.Parse(pageURL)
+	if err != nil || page.Host == "" {
+		return false
+	}
+	return originMatches(rule, page)
+}
+
+// Check returns an error describing why data from pageURL must not be stored,
+// or nil if it may be
+func (p *OriginPolicy) Check(pageURL string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.allowed) == 0 && len(p.blocked) == 0 {
+		return nil
+	}
+
+	page, err := url.Parse(pageURL)
+	if err != nil || page.Host == "" {
+		if len(p.allowed) > 0 {
+			return fmt.Errorf("source page is not in the allowed origins")
+		}
+		return nil
+	}
+
+	for _, rule := range p.blocked {
+		if originMatches(rule, page) {
+			return fmt.Errorf("%s is a blocked origin", page.Host)
+		}
+	}
+
+	if len(p.allowed) > 0 {
+		for _, rule := range p.allowed {
+			if originMatches(rule, page) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not in the allowed origins", page.Host)
+	}
+
+	return nil
+}
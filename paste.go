@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// textTransform rewrites the text of an entry for paste
+type textTransform func(text string) (string, error)
+
+var (
+	// invisibleCharacters are dropped by the plain transform: zero-width
+	// spaces and joiners, byte order marks and soft hyphens
+	invisibleCharacters = strings.NewReplacer("\u200b", "", "\u200c", "", "\u200d", "", "\u2060", "", "\ufeff", "", "\u00ad", "")
+
+	// typographicCharacters are replaced by their ASCII equivalents: no-break
+	// and narrow spaces, curly quotes, dashes and ellipses, and Windows line
+	// endings
+	typographicCharacters = strings.NewReplacer(
+		"\u00a0", " ", "\u202f", " ", "\u2009", " ",
+		"\u2018", "'", "\u2019", "'", "\u201c", `"`, "\u201d", `"`,
+		"\u2013", "-", "\u2014", "-", "\u2026", "...",
+		"\r\n", "\n", "\r", "\n",
+	)
+
+	htmlBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	htmlTagPattern   = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// textTransforms are the transformations paste can apply, by name
+var textTransforms = map[string]textTransform{
+	"trim": func(text string) (string, error) {
+		return strings.TrimSpace(text), nil
+	},
+	"lower": func(text string) (string, error) {
+		return strings.ToLower(text), nil
+	},
+	"plain": func(text string) (string, error) {
+		return typographicCharacters.Replace(invisibleCharacters.Replace(text)), nil
+	},
+	"json-pretty": func(text string) (string, error) {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, []byte(strings.TrimSpace(text)), "", "  "); err != nil {
+			return "", fmt.Errorf("entry is not valid JSON: %v", err)
+		}
+		return pretty.String(), nil
+	},
+}
+
+// parseTransforms looks up a comma-separated list of transformations
+func parseTransforms(list string) ([]textTransform, error) {
+	transforms := []textTransform{}
+	for _, name := range splitList(list) {
+		transform, ok := textTransforms[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q (available: %s)", name, strings.Join(transformNames(), ", "))
+		}
+		transforms = append(transforms, transform)
+	}
+	return transforms, nil
+}
+
+// transformNames lists the available transformations alphabetically
+func transformNames() []string {
+	names := make([]string, 0, len(textTransforms))
+	for name := range textTransforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// entryPlainText returns the text of an entry, falling back to the text of
+// its HTML flavor for entries copied without a plain text representation
+func entryPlainText(data *ClipboardData) string {
+	if data.Text != "" {
+		return data.Text
+	}
+	markup := data.Flavor("text/html")
+	if markup == "" {
+		return ""
+	}
+	markup = htmlBreakPattern.ReplaceAllString(markup, "\n")
+	return html.UnescapeString(htmlTagPattern.ReplaceAllString(markup, ""))
+}
+
+// newPasteCommand creates the paste command, which puts the latest entry on
+// the OS clipboard, or prints it, after applying transformations in the order
+// given
+func newPasteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "paste",
+		Short: "Put the latest entry on the OS clipboard or stdout, optionally transformed",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	transformList := flags.String("transform", "", "comma-separated transformations to apply in order: "+strings.Join(transformNames(), ", "))
+	toStdout := flags.Bool("stdout", false, "write to stdout instead of the OS clipboard")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		transforms, err := parseTransforms(*transformList)
+		if err != nil {
+			return err
+		}
+
+		data, err := host.getClipboardData(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to retrieve clipboard data: %w", err)
+		}
+
+		// Untransformed entries keep their formatting and images on the way back
+		// to the clipboard
+		if len(transforms) == 0 && !*toStdout {
+			if err := writeClipboardData(data); err != nil {
+				return fmt.Errorf("failed to write system clipboard: %v", err)
+			}
+			return nil
+		}
+
+		text := entryPlainText(data)
+		if text == "" {
+			return fmt.Errorf("clipboard entry has no text")
+		}
+		for _, transform := range transforms {
+			if text, err = transform(text); err != nil {
+				return err
+			}
+		}
+
+		if *toStdout {
+			_, err := io.WriteString(os.Stdout, text)
+			return err
+		}
+		if err := writeSystemClipboard(text); err != nil {
+			return fmt.Errorf("failed to write system clipboard: %v", err)
+		}
+		return nil
+	})
+	return cmd
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// alwaysPermittedActions may be sent by any extension, so a restricted one can
+// still negotiate the protocol and learn what it may do
+var alwaysPermittedActions = []string{"hello", "ping"}
+
+// callerPermissions returns the actions the calling extension may send, or
+// nil if it may send any. Permissions are keyed by extension ID or origin,
+// with "*" covering extensions that have no entry of their own; extensions
+// without permissions, and local clients, have full access.
+func (t *TabdNativeHost) callerPermissions() (map[string]bool, error) {
+	if t.caller == "" || len(t.config.Permissions) == 0 {
+		return nil, nil
+	}
+
+	key := ""
+	for name := range t.config.Permissions {
+		if name != "*" && extensionID(name) == extensionID(t.caller) {
+			key = name
+			break
+		}
+	}
+	if key == "" {
+		if _, ok := t.config.Permissions["*"]; !ok {
+			return nil, nil
+		}
+		key = "*"
+	}
+
+	permitted := map[string]bool{}
+	for _, action := range t.config.Permissions[key] {
+		if action == "*" {
+			return nil, nil
+		}
+		if _, ok := t.actions[action]; !ok {
+			return nil, fmt.Errorf("permissions for %s: unknown action %q (available: %s)", key, action, strings.Join(t.actionNames(), ", "))
+		}
+		permitted[action] = true
+	}
+	for _, action := range alwaysPermittedActions {
+		permitted[action] = true
+	}
+	return permitted, nil
+}
+
+// actionNames returns the names of the registered actions, sorted
+func (t *TabdNativeHost) actionNames() []string {
+	names := make([]string, 0, len(t.actions))
+	for action := range t.actions {
+		names = append(names, action)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// defaultPicker is the interactive picker pick runs when TABD_PICKER is unset
+const defaultPicker = "fzf"
+
+// pickLine formats an entry for a picker: its ID, a tab and its preview
+func pickLine(host *TabdNativeHost, entry HistoryEntry) (string, error) {
+	preview := entry.Preview
+	if preview == nil {
+		data, err := host.historyStore().Get(entry.ID)
+		if err != nil {
+			return "", err
+		}
+		preview = newEntryPreview(data)
+	}
+	text := preview.Text
+	if entry.Pinned {
+		text = "📌 " + text
+	}
+	return entry.ID + "\t" + text, nil
+}
+
+// pickedID returns the entry ID at the start of a line chosen in a picker
+func pickedID(line string) string {
+	if fields := strings.Fields(line); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// runPicker runs an interactive picker such as fzf on lines, returning the
+// line chosen. fzf draws on the terminal itself, so only its input and
+// output are redirected.
+func runPicker(picker string, lines []string) (string, error) {
+	args := strings.Fields(picker)
+	if picker == defaultPicker {
+		args = append(args, "--delimiter", "\t", "--with-nth", "2..", "--no-sort", "--prompt", "tabd> ")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	cmd.Stderr = os.Stderr
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		// fzf exits with 1 when nothing matched and 130 when cancelled
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && (exitErr.ExitCode() == 1 || exitErr.ExitCode() == 130) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to run %s: %v", args[0], err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// promptPick lists entries numbered on stderr and reads a number or ID, for
+// terminals without a picker installed
+func promptPick(lines []string, input io.Reader) (string, error) {
+	for i, line := range lines {
+		_, preview, _ := strings.Cut(line, "\t")
+		fmt.Fprintf(os.Stderr, "%3d  %s\n", i+1, preview)
+	}
+	fmt.Fprint(os.Stderr, "Pick an entry: ")
+
+	answer, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if n, err := strconv.Atoi(answer); err == nil {
+		if n < 1 || n > len(lines) {
+			return "", fmt.Errorf("pick a number from 1 to %d", len(lines))
+		}
+		return lines[n-1], nil
+	}
+	return answer, nil
+}
+
+// newPickCommand creates the pick command, which lets the user choose a
+// history entry and copies it to the OS clipboard. In a terminal it runs fzf
+// or TABD_PICKER, or prompts for a number without one; with --list it prints
+// the entries for another picker, and the ID of the entry chosen there is read
+// back on stdin.
+func newPickCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pick",
+		Short: "Choose a history entry with fzf or a prompt and copy it",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	list := flags.Bool("list", false, "print entries as ID<TAB>preview lines and exit")
+	limit := flags.Int("limit", 100, "maximum number of entries to offer (0 for all)")
+	tag := flags.String("tag", "", "only offer entries with this tag")
+	kind := flags.String("type", "", "only offer entries of this kind: "+strings.Join(contentKinds, ", "))
+	toStdout := flags.Bool("stdout", false, "print the chosen entry's text instead of copying it")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		if *limit < 0 {
+			return fmt.Errorf("--limit must not be negative")
+		}
+		if *kind != "" && !validKind(*kind) {
+			return fmt.Errorf("--type must be one of %s", strings.Join(contentKinds, ", "))
+		}
+
+		var line string
+		if *list || term.IsTerminal(int(os.Stdin.Fd())) {
+			entries, err := host.historyStore().Query(HistoryQuery{Tag: *tag, Kind: *kind, Limit: *limit})
+			if err != nil {
+				return fmt.Errorf("failed to list clipboard history: %w", err)
+			}
+			lines := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				line, err := pickLine(host, entry)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Skipping unreadable history entry %s: %v\n", entry.ID, err)
+					continue
+				}
+				lines = append(lines, line)
+			}
+
+			if *list {
+				for _, line := range lines {
+					fmt.Println(line)
+				}
+				return nil
+			}
+			if len(lines) == 0 {
+				return &codedError{code: codeNotFound, err: fmt.Errorf("clipboard history is empty")}
+			}
+
+			picker := os.Getenv("TABD_PICKER")
+			if strings.TrimSpace(picker) == "" {
+				picker = defaultPicker
+			}
+			if _, err := exec.LookPath(strings.Fields(picker)[0]); err == nil {
+				line, err = runPicker(picker, lines)
+			} else {
+				line, err = promptPick(lines, os.Stdin)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			// The choice made in a picker fed by pick --list
+			input, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("failed to read the chosen entry: %v", err)
+			}
+			line = input
+		}
+
+		id := pickedID(line)
+		if id == "" {
+			return fmt.Errorf("no entry chosen")
+		}
+		data, err := host.historyStore().Get(id)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve history entry: %w", err)
+		}
+		host.runRetrieveHooks(id, data)
+
+		if *toStdout {
+			text := entryPlainText(data)
+			if text == "" {
+				return fmt.Errorf("history entry %s has no text", id)
+			}
+			_, err := io.WriteString(os.Stdout, text)
+			return err
+		}
+		if err := writeClipboardData(data); err != nil {
+			return fmt.Errorf("failed to write system clipboard: %v", err)
+		}
+		notef("Copied %s\n", previewText(data))
+		return nil
+	})
+	return cmd
+}
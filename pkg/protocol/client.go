@@ -0,0 +1,232 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxReassembledSize bounds a chunked message received by a client
+const maxReassembledSize = 64 * 1024 * 1024
+
+// Client sends requests over the native messaging protocol and reads their
+// responses, as the browser extension does. It speaks JSON, chunking large
+// requests and reassembling chunked responses.
+type Client struct {
+	reader io.Reader
+	writer io.Writer
+	closer io.Closer
+	mu     sync.Mutex
+
+	// transfer holds the chunks received so far of a chunked message, which
+	// events may be interleaved with
+	transfer []byte
+
+	// FrameLimit is the largest frame sent before a request is chunked, by
+	// default MaxFrameSize
+	FrameLimit int
+
+	// OnEvent, if set, is called with each event that arrives while waiting
+	// for a response. Events are dropped otherwise.
+	OnEvent func(*Event)
+}
+
+// NewClient creates a client reading responses from r and writing requests
+// to w, such as the stdout and stdin of a native host process
+func NewClient(r io.Reader, w io.Writer) *Client {
+	return &Client{reader: r, writer: w, FrameLimit: MaxFrameSize}
+}
+
+// Dial connects to the daemon serving the storage directory tabdDir
+func Dial(tabdDir string) (*Client, error) {
+	conn, err := DialDaemon(DaemonAddress(tabdDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to daemon: %v", err)
+	}
+	client := NewClient(conn, conn)
+	client.closer = conn
+	return client, nil
+}
+
+// Close closes the connection of a client created by Dial
+func (c *Client) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+// Call sends a request and waits for its response. If data is not nil, the
+// response's data is decoded into it and the returned Response refers to it.
+// Responses that did not succeed are returned with a nil error; check Status
+// and Code.
+func (c *Client) Call(msg *Message, data interface{}) (*Response, error) {
+	request, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	return c.CallJSON(request, data)
+}
+
+// CallJSON is like Call for a request that is already encoded as JSON, such
+// as one carrying fields Message does not know about
+func (c *Client) CallJSON(request []byte, data interface{}) (*Response, error) {
+	var msg struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(request, &msg); err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.send(request); err != nil {
+		return nil, err
+	}
+
+	for {
+		frame, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		envelope, err := parseEnvelope(frame)
+		if err != nil {
+			return nil, err
+		}
+
+		if envelope.Event != "" {
+			if c.OnEvent != nil {
+				c.OnEvent(envelope.event())
+			}
+			continue
+		}
+
+		// Acknowledgements of the request's own chunks
+		if envelope.Action == "chunk" && envelope.Status == "success" && msg.Action != "chunk" {
+			continue
+		}
+
+		response := envelope.Response
+		if len(envelope.Data) > 0 {
+			target := data
+			if target == nil {
+				target = &response.Data
+			}
+			if err := json.Unmarshal(envelope.Data, target); err != nil {
+				return nil, fmt.Errorf("failed to parse response data: %v", err)
+			}
+			if data != nil {
+				response.Data = data
+			}
+		}
+		return &response, nil
+	}
+}
+
+// ReadEvent waits for the next event, such as those a daemon sends to a client
+// that asked for them in its hello message. Responses arriving meanwhile are
+// dropped.
+func (c *Client) ReadEvent() (*Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		frame, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		envelope, err := parseEnvelope(frame)
+		if err != nil {
+			return nil, err
+		}
+		if envelope.Event != "" {
+			return envelope.event(), nil
+		}
+	}
+}
+
+// envelope is an incoming frame, which is either a response or an event
+type envelope struct {
+	Response
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// parseEnvelope decodes an incoming frame, leaving its data encoded
+func parseEnvelope(frame []byte) (*envelope, error) {
+	var e envelope
+	if err := json.Unmarshal(frame, &e); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	return &e, nil
+}
+
+// event returns the event an envelope carries
+func (e *envelope) event() *Event {
+	event := &Event{Event: e.Event, Timestamp: e.Timestamp}
+	if len(e.Data) > 0 {
+		json.Unmarshal(e.Data, &event.Data)
+	}
+	return event
+}
+
+// send writes a request, splitting it into chunks when it exceeds the frame
+// limit
+func (c *Client) send(request []byte) error {
+	limit := c.FrameLimit
+	if limit <= 0 {
+		limit = MaxFrameSize
+	}
+	if len(request) <= limit {
+		return WriteFrame(c.writer, request)
+	}
+
+	frames, err := SplitChunks(request, limit)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		if err := WriteFrame(c.writer, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive reads the next frame, reassembling messages the host sent in chunks
+func (c *Client) receive() ([]byte, error) {
+	for {
+		frame, err := ReadFrame(c.reader, maxReassembledSize)
+		if err != nil {
+			return nil, err
+		}
+
+		// Chunks from the host carry no status, unlike acknowledgements
+		var chunk struct {
+			Chunk
+			Status string `json:"status"`
+		}
+		if json.Unmarshal(frame, &chunk) != nil || chunk.Action != "chunk" || chunk.Status != "" {
+			return frame, nil
+		}
+
+		payload, err := base64.StdEncoding.DecodeString(chunk.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk payload: %v", err)
+		}
+		if len(c.transfer)+len(payload) > maxReassembledSize {
+			c.transfer = nil
+			return nil, fmt.Errorf("chunked message exceeds %d bytes", maxReassembledSize)
+		}
+		c.transfer = append(c.transfer, payload...)
+		if chunk.Final {
+			complete := c.transfer
+			c.transfer = nil
+			return complete, nil
+		}
+	}
+}
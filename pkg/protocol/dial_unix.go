@@ -0,0 +1,19 @@
+//go:build !windows
+
+package protocol
+
+import (
+	"net"
+	"path/filepath"
+)
+
+// DaemonAddress returns the Unix domain socket path of the daemon serving
+// the storage directory tabdDir
+func DaemonAddress(tabdDir string) string {
+	return filepath.Join(tabdDir, "tabd.sock")
+}
+
+// DialDaemon connects to the daemon socket
+func DialDaemon(address string) (net.Conn, error) {
+	return net.Dial("unix", address)
+}
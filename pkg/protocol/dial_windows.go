@@ -0,0 +1,21 @@
+package protocol
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// DaemonAddress returns the daemon's named pipe path, scoped to the current
+// user. There is one daemon per user, whatever the storage directory.
+func DaemonAddress(tabdDir string) string {
+	return `\\.\pipe\tabd-native-host-` + os.Getenv("USERNAME")
+}
+
+// DialDaemon connects to the daemon named pipe
+func DialDaemon(address string) (net.Conn, error) {
+	timeout := time.Second
+	return winio.DialPipe(address, &timeout)
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// MaxFrameSize is the largest frame a browser accepts from a native host.
+// Larger messages must be split with SplitChunks.
+const MaxFrameSize = 1024 * 1024
+
+// FrameTooLargeError is returned by ReadFrame for a frame over the limit. The
+// frame's body has been skipped, so the next frame can still be read.
+type FrameTooLargeError struct {
+	Size  uint32
+	Limit int64
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("message of %d bytes exceeds the limit of %d bytes", e.Size, e.Limit)
+}
+
+// ReadFrame reads one length-prefixed frame: a 4 byte little-endian length
+// followed by that many bytes. It returns io.EOF if the stream ends cleanly
+// before a frame.
+func ReadFrame(r io.Reader, limit int64) ([]byte, error) {
+	// Read the message length (4 bytes, little-endian)
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read message length: %w", err)
+	}
+
+	if length == 0 {
+		return nil, fmt.Errorf("invalid message length: %d", length)
+	}
+
+	// Skip the body of an oversized message so the next one can be read
+	if int64(length) > limit {
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return nil, fmt.Errorf("failed to skip oversized message: %w", err)
+		}
+		return nil, &FrameTooLargeError{Size: length, Limit: limit}
+	}
+
+	// Read the message data
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, fmt.Errorf("failed to read message data: %w", err)
+	}
+
+	return message, nil
+}
+
+// WriteFrame writes one length-prefixed frame. Callers sharing a writer must
+// serialise calls themselves.
+func WriteFrame(w io.Writer, message []byte) error {
+	// Write message length (4 bytes, little-endian)
+	length := uint32(len(message))
+	if err := binary.Write(w, binary.LittleEndian, length); err != nil {
+		return fmt.Errorf("failed to write message length: %v", err)
+	}
+
+	// Write message data
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("failed to write message data: %v", err)
+	}
+
+	return nil
+}
+
+// SplitChunks splits an encoded message into chunk frames of at most
+// frameLimit bytes. Each chunk carries half the limit in raw bytes, leaving
+// room for base64 expansion and the chunk envelope.
+func SplitChunks(message []byte, frameLimit int) ([][]byte, error) {
+	chunkSize := frameLimit / 2
+
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	transferID := hex.EncodeToString(idBytes)
+
+	frames := [][]byte{}
+	for seq, offset := 0, 0; offset < len(message); seq, offset = seq+1, offset+chunkSize {
+		end := offset + chunkSize
+		if end > len(message) {
+			end = len(message)
+		}
+
+		frame, err := json.Marshal(&Chunk{
+			Action:     "chunk",
+			TransferID: transferID,
+			Seq:        seq,
+			Final:      end == len(message),
+			Payload:    base64.StdEncoding.EncodeToString(message[offset:end]),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal chunk: %v", err)
+		}
+		frames = append(frames, frame)
+	}
+
+	return frames, nil
+}
@@ -0,0 +1,219 @@
+// Package protocol holds the message types and framing of the Tab'd native
+// messaging protocol, so other tools can talk to the native host or its
+// daemon, or stand in for the browser extension in tests.
+package protocol
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Error codes sent in the code field of responses that did not succeed
+const (
+	CodeStorageFailed  = "STORAGE_FAILED"
+	CodeDecryptFailed  = "DECRYPT_FAILED"
+	CodeCorrupted      = "STORAGE_CORRUPTED"
+	CodeTooLarge       = "TOO_LARGE"
+	CodeLocked         = "LOCKED"
+	CodeKeychainDenied = "KEYCHAIN_DENIED"
+	CodeRateLimited    = "RATE_LIMITED"
+	CodeNotFound       = "NOT_FOUND"
+	CodeInvalidRequest = "INVALID_REQUEST"
+	CodeUnknownAction  = "UNKNOWN_ACTION"
+	CodeNotPermitted   = "NOT_PERMITTED"
+	CodeBlocked        = "BLOCKED"
+	CodeRefused        = "REFUSED"
+	CodeClipboard      = "CLIPBOARD_FAILED"
+	CodeUnsupported    = "UNSUPPORTED_VERSION"
+	CodeInternal       = "INTERNAL_ERROR"
+	CodeTimeout        = "TIMEOUT"
+	CodeCancelled      = "CANCELLED"
+)
+
+// ClipboardData represents the simplified data structure received from the browser extension
+type ClipboardData struct {
+	Type      string `json:"type"`
+	Text      string `json:"text"`
+	Timestamp int64  `json:"timestamp"`
+	URL       string `json:"url"`
+	Title     string `json:"title"`
+
+	// ContentType and Data carry binary payloads such as images, with Data
+	// base64 encoded. Text-only entries leave both empty.
+	ContentType string `json:"contentType,omitempty"`
+	Data        string `json:"data,omitempty" msgpack:"bin"`
+
+	// Flavors holds alternative representations keyed by MIME type, such as
+	// text/html and text/rtf, so formatting survives a round trip
+	Flavors map[string]string `json:"flavors,omitempty"`
+
+	// Sensitive lists the kinds of sensitive content detected, if any
+	Sensitive []string `json:"sensitive,omitempty"`
+
+	// Tags and Note are assigned by the user to organise entries
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+
+	// Kind classifies the content as text, url, email, json, markdown, code,
+	// image or binary, and Language guesses the language of code. The host
+	// detects both when an entry is saved unless the extension supplies them.
+	Kind     string `json:"kind,omitempty"`
+	Language string `json:"language,omitempty"`
+
+	// Link holds the title and favicon of the page a URL entry points to,
+	// when link unfurling is enabled
+	Link *LinkPreview `json:"link,omitempty"`
+
+	// Incognito marks content copied in a private browsing window. The host
+	// keeps such entries in memory only and wipes them after the incognito
+	// TTL.
+	Incognito bool `json:"incognito,omitempty"`
+
+	// Truncated marks text the host cut to its maxTextLength, with
+	// FullLength the length in bytes of the text that was copied
+	Truncated  bool `json:"truncated,omitempty"`
+	FullLength int  `json:"fullLength,omitempty"`
+}
+
+// LinkPreview describes the page behind a copied link. Favicon is a data URL.
+type LinkPreview struct {
+	Title   string `json:"title"`
+	Favicon string `json:"favicon,omitempty"`
+}
+
+// Flavor returns the representation for a MIME type, falling back to Text for text/plain
+func (d *ClipboardData) Flavor(mimeType string) string {
+	if value, ok := d.Flavors[mimeType]; ok {
+		return value
+	}
+	if mimeType == "text/plain" {
+		return d.Text
+	}
+	return ""
+}
+
+// IsImage reports whether the entry carries an image payload
+func (d *ClipboardData) IsImage() bool {
+	return strings.HasPrefix(d.ContentType, "image/") && d.Data != ""
+}
+
+// Bytes decodes the binary payload of the entry
+func (d *ClipboardData) Bytes() ([]byte, error) {
+	payload, err := base64.StdEncoding.DecodeString(d.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 data: %v", err)
+	}
+	return payload, nil
+}
+
+// Tab is an open browser tab captured in a session
+type Tab struct {
+	URL    string `json:"url"`
+	Title  string `json:"title,omitempty"`
+	Pinned bool   `json:"pinned,omitempty"`
+
+	// WindowID groups tabs that were open in the same browser window, and
+	// Group names the tab group they belonged to, if any
+	WindowID int    `json:"windowId"`
+	Group    string `json:"group,omitempty"`
+}
+
+// Message represents a request received from the browser extension. Messages
+// without an action are treated as "save" so older extension versions keep working.
+type Message struct {
+	Action string `json:"action,omitempty"`
+	ID     string `json:"id,omitempty"`
+
+	// SystemClipboard requests that the text also be placed on the OS clipboard
+	SystemClipboard bool `json:"systemClipboard,omitempty"`
+
+	// Pin exempts a saved entry from retention expiry
+	Pin bool `json:"pin,omitempty"`
+
+	// Register names the register, a letter a-z, that a save also stores
+	// the entry in and that get_register reads
+	Register string `json:"register,omitempty"`
+
+	// Items holds the entries of a batch message
+	Items []ClipboardData `json:"items,omitempty"`
+
+	// Limit, Offset, Since and Origin page through and filter the entries
+	// returned by a list message
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	Since  int64  `json:"since,omitempty"`
+	Origin string `json:"origin,omitempty"`
+
+	// Before, All and Wipe select the entries removed by a clear message
+	Before string `json:"before,omitempty"`
+	All    bool   `json:"all,omitempty"`
+	Wipe   bool   `json:"wipe,omitempty"`
+
+	// ProtocolVersion and Features are sent by the extension in a hello message
+	ProtocolVersion int      `json:"protocolVersion,omitempty"`
+	Features        []string `json:"features,omitempty"`
+
+	// MaxMessageSize is the largest frame the peer accepts from the host,
+	// sent in a hello message to have responses chunked below it
+	MaxMessageSize int `json:"maxMessageSize,omitempty"`
+
+	// WireFormat asks a daemon client session to switch to "msgpack" (or
+	// back to "json") after the hello response
+	WireFormat string `json:"wireFormat,omitempty"`
+
+	// IDs lists the history entries combined by a merge message, in order,
+	// and Separator the text placed between them (a newline when unset)
+	IDs       []string `json:"ids,omitempty"`
+	Separator *string  `json:"separator,omitempty"`
+
+	// From and To are the history entries compared by a diff message
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	// Name and Tabs carry a tab session for save_session and get_session
+	Name string `json:"name,omitempty"`
+	Tabs []Tab  `json:"tabs,omitempty"`
+
+	// Enabled turns incognito mode on or off, keeping entries in memory for
+	// TTL, e.g. "10m", when set; an incognito message without it reports
+	// the current mode
+	Enabled *bool  `json:"enabled,omitempty"`
+	TTL     string `json:"ttl,omitempty"`
+
+	ClipboardData
+}
+
+// Response represents the response sent back to the browser extension
+type Response struct {
+	Status    string      `json:"status"`
+	Action    string      `json:"action,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+
+	// Code identifies the kind of failure for responses that did not
+	// succeed, e.g. STORAGE_FAILED or LOCKED; Data may carry details
+	Code string `json:"code,omitempty"`
+
+	// Version is the host version, so the extension can detect mismatches
+	Version string `json:"version,omitempty"`
+}
+
+// Event is an unsolicited message pushed from the host to connected clients
+type Event struct {
+	Event     string      `json:"event"`
+	Data      interface{} `json:"data,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// Chunk carries one base64 encoded piece of a message too large for a single
+// native messaging frame. Chunks of a transfer are numbered from zero and the
+// last one has Final set.
+type Chunk struct {
+	Action     string `json:"action"`
+	TransferID string `json:"transferId"`
+	Seq        int    `json:"seq"`
+	Final      bool   `json:"final"`
+	Payload    string `json:"payload" msgpack:"bin"`
+}
@@ -0,0 +1,5 @@
+// Package tabdpb holds the Go code generated from proto/tabd/v1/tabd.proto,
+// the gRPC API served by the daemon
+package tabdpb
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=github.com/iann0036/tabd-extension/tabd-native-host --go-grpc_out=../.. --go-grpc_opt=module=github.com/iann0036/tabd-extension/tabd-native-host tabd/v1/tabd.proto
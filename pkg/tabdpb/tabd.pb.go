@@ -0,0 +1,1291 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: tabd/v1/tabd.proto
+
+// tabd.v1 is the gRPC API of the tabd daemon, served on the daemon socket
+// beside the native messaging protocol. Regenerate the Go code in pkg/tabdpb
+// with go generate ./pkg/tabdpb.
+
+package tabdpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Entry is a clipboard entry
+type Entry struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type  string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Text  string                 `protobuf:"bytes,3,opt,name=text,proto3" json:"text,omitempty"`
+	// timestamp is when the entry was copied, in Unix milliseconds
+	Timestamp int64  `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Url       string `protobuf:"bytes,5,opt,name=url,proto3" json:"url,omitempty"`
+	Title     string `protobuf:"bytes,6,opt,name=title,proto3" json:"title,omitempty"`
+	// content_type and data carry binary payloads such as images
+	ContentType string `protobuf:"bytes,7,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Data        []byte `protobuf:"bytes,8,opt,name=data,proto3" json:"data,omitempty"`
+	// flavors holds alternative representations keyed by MIME type
+	Flavors map[string]string `protobuf:"bytes,9,rep,name=flavors,proto3" json:"flavors,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// sensitive lists the kinds of sensitive content detected
+	Sensitive []string `protobuf:"bytes,10,rep,name=sensitive,proto3" json:"sensitive,omitempty"`
+	Tags      []string `protobuf:"bytes,11,rep,name=tags,proto3" json:"tags,omitempty"`
+	Note      string   `protobuf:"bytes,12,opt,name=note,proto3" json:"note,omitempty"`
+	// kind classifies the content, e.g. text, url or code, and language
+	// guesses the language of code
+	Kind     string `protobuf:"bytes,13,opt,name=kind,proto3" json:"kind,omitempty"`
+	Language string `protobuf:"bytes,14,opt,name=language,proto3" json:"language,omitempty"`
+	// incognito entries are kept in daemon memory only
+	Incognito bool `protobuf:"varint,15,opt,name=incognito,proto3" json:"incognito,omitempty"`
+	// truncated is set when text was cut to maxTextLength, with full_length
+	// the length in bytes of the text sent
+	Truncated     bool  `protobuf:"varint,16,opt,name=truncated,proto3" json:"truncated,omitempty"`
+	FullLength    int64 `protobuf:"varint,17,opt,name=full_length,json=fullLength,proto3" json:"full_length,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Entry) Reset() {
+	*x = Entry{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Entry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Entry) ProtoMessage() {}
+
+func (x *Entry) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Entry.ProtoReflect.Descriptor instead.
+func (*Entry) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Entry) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Entry) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Entry) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *Entry) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Entry) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Entry) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Entry) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *Entry) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *Entry) GetFlavors() map[string]string {
+	if x != nil {
+		return x.Flavors
+	}
+	return nil
+}
+
+func (x *Entry) GetSensitive() []string {
+	if x != nil {
+		return x.Sensitive
+	}
+	return nil
+}
+
+func (x *Entry) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Entry) GetNote() string {
+	if x != nil {
+		return x.Note
+	}
+	return ""
+}
+
+func (x *Entry) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Entry) GetLanguage() string {
+	if x != nil {
+		return x.Language
+	}
+	return ""
+}
+
+func (x *Entry) GetIncognito() bool {
+	if x != nil {
+		return x.Incognito
+	}
+	return false
+}
+
+func (x *Entry) GetTruncated() bool {
+	if x != nil {
+		return x.Truncated
+	}
+	return false
+}
+
+func (x *Entry) GetFullLength() int64 {
+	if x != nil {
+		return x.FullLength
+	}
+	return 0
+}
+
+type SaveRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Entry *Entry                 `protobuf:"bytes,1,opt,name=entry,proto3" json:"entry,omitempty"`
+	// system_clipboard also places the text on the OS clipboard
+	SystemClipboard bool `protobuf:"varint,2,opt,name=system_clipboard,json=systemClipboard,proto3" json:"system_clipboard,omitempty"`
+	// pin exempts the entry from retention expiry
+	Pin bool `protobuf:"varint,3,opt,name=pin,proto3" json:"pin,omitempty"`
+	// register is a letter a-z also naming the entry
+	Register string `protobuf:"bytes,4,opt,name=register,proto3" json:"register,omitempty"`
+	// full keeps text longer than maxTextLength whole, as save_full does
+	Full          bool `protobuf:"varint,5,opt,name=full,proto3" json:"full,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SaveRequest) Reset() {
+	*x = SaveRequest{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SaveRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SaveRequest) ProtoMessage() {}
+
+func (x *SaveRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SaveRequest.ProtoReflect.Descriptor instead.
+func (*SaveRequest) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SaveRequest) GetEntry() *Entry {
+	if x != nil {
+		return x.Entry
+	}
+	return nil
+}
+
+func (x *SaveRequest) GetSystemClipboard() bool {
+	if x != nil {
+		return x.SystemClipboard
+	}
+	return false
+}
+
+func (x *SaveRequest) GetPin() bool {
+	if x != nil {
+		return x.Pin
+	}
+	return false
+}
+
+func (x *SaveRequest) GetRegister() string {
+	if x != nil {
+		return x.Register
+	}
+	return ""
+}
+
+func (x *SaveRequest) GetFull() bool {
+	if x != nil {
+		return x.Full
+	}
+	return false
+}
+
+type SaveResponse struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Id         string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Register   string                 `protobuf:"bytes,2,opt,name=register,proto3" json:"register,omitempty"`
+	Truncated  bool                   `protobuf:"varint,3,opt,name=truncated,proto3" json:"truncated,omitempty"`
+	FullLength int64                  `protobuf:"varint,4,opt,name=full_length,json=fullLength,proto3" json:"full_length,omitempty"`
+	// sensitive lists the kinds of sensitive content detected, and decision
+	// what the host did about it
+	Sensitive     []string `protobuf:"bytes,5,rep,name=sensitive,proto3" json:"sensitive,omitempty"`
+	Decision      string   `protobuf:"bytes,6,opt,name=decision,proto3" json:"decision,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SaveResponse) Reset() {
+	*x = SaveResponse{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SaveResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SaveResponse) ProtoMessage() {}
+
+func (x *SaveResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SaveResponse.ProtoReflect.Descriptor instead.
+func (*SaveResponse) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SaveResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *SaveResponse) GetRegister() string {
+	if x != nil {
+		return x.Register
+	}
+	return ""
+}
+
+func (x *SaveResponse) GetTruncated() bool {
+	if x != nil {
+		return x.Truncated
+	}
+	return false
+}
+
+func (x *SaveResponse) GetFullLength() int64 {
+	if x != nil {
+		return x.FullLength
+	}
+	return 0
+}
+
+func (x *SaveResponse) GetSensitive() []string {
+	if x != nil {
+		return x.Sensitive
+	}
+	return nil
+}
+
+func (x *SaveResponse) GetDecision() string {
+	if x != nil {
+		return x.Decision
+	}
+	return ""
+}
+
+type GetRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// id names a history entry; leave it empty for the latest entry
+	Id            string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRequest) Reset() {
+	*x = GetRequest{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRequest) ProtoMessage() {}
+
+func (x *GetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRequest.ProtoReflect.Descriptor instead.
+func (*GetRequest) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ListRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// limit defaults to 50 and may be at most 500
+	Limit  int32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset int32 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	// since only lists entries saved since this time, in Unix milliseconds
+	Since int64 `protobuf:"varint,3,opt,name=since,proto3" json:"since,omitempty"`
+	// origin and kind filter entries as the list action does
+	Origin        string `protobuf:"bytes,4,opt,name=origin,proto3" json:"origin,omitempty"`
+	Kind          string `protobuf:"bytes,5,opt,name=kind,proto3" json:"kind,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRequest) Reset() {
+	*x = ListRequest{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRequest) ProtoMessage() {}
+
+func (x *ListRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRequest.ProtoReflect.Descriptor instead.
+func (*ListRequest) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ListRequest) GetSince() int64 {
+	if x != nil {
+		return x.Since
+	}
+	return 0
+}
+
+func (x *ListRequest) GetOrigin() string {
+	if x != nil {
+		return x.Origin
+	}
+	return ""
+}
+
+func (x *ListRequest) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+type ListResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Entries []*EntrySummary        `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	// total is the number of entries matching the filters
+	Total         int32 `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	HasMore       bool  `protobuf:"varint,3,opt,name=has_more,json=hasMore,proto3" json:"has_more,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListResponse) Reset() {
+	*x = ListResponse{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListResponse) ProtoMessage() {}
+
+func (x *ListResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListResponse.ProtoReflect.Descriptor instead.
+func (*ListResponse) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListResponse) GetEntries() []*EntrySummary {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
+func (x *ListResponse) GetTotal() int32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *ListResponse) GetHasMore() bool {
+	if x != nil {
+		return x.HasMore
+	}
+	return false
+}
+
+// EntrySummary describes a history entry without its full contents
+type EntrySummary struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Preview       string                 `protobuf:"bytes,2,opt,name=preview,proto3" json:"preview,omitempty"`
+	Kind          string                 `protobuf:"bytes,3,opt,name=kind,proto3" json:"kind,omitempty"`
+	Language      string                 `protobuf:"bytes,4,opt,name=language,proto3" json:"language,omitempty"`
+	Timestamp     int64                  `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Url           string                 `protobuf:"bytes,6,opt,name=url,proto3" json:"url,omitempty"`
+	Title         string                 `protobuf:"bytes,7,opt,name=title,proto3" json:"title,omitempty"`
+	Type          string                 `protobuf:"bytes,8,opt,name=type,proto3" json:"type,omitempty"`
+	ContentType   string                 `protobuf:"bytes,9,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	LinkTitle     string                 `protobuf:"bytes,10,opt,name=link_title,json=linkTitle,proto3" json:"link_title,omitempty"`
+	Favicon       string                 `protobuf:"bytes,11,opt,name=favicon,proto3" json:"favicon,omitempty"`
+	Pinned        bool                   `protobuf:"varint,12,opt,name=pinned,proto3" json:"pinned,omitempty"`
+	Tags          []string               `protobuf:"bytes,13,rep,name=tags,proto3" json:"tags,omitempty"`
+	Incognito     bool                   `protobuf:"varint,14,opt,name=incognito,proto3" json:"incognito,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EntrySummary) Reset() {
+	*x = EntrySummary{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EntrySummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EntrySummary) ProtoMessage() {}
+
+func (x *EntrySummary) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EntrySummary.ProtoReflect.Descriptor instead.
+func (*EntrySummary) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *EntrySummary) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetPreview() string {
+	if x != nil {
+		return x.Preview
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetLanguage() string {
+	if x != nil {
+		return x.Language
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *EntrySummary) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetLinkTitle() string {
+	if x != nil {
+		return x.LinkTitle
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetFavicon() string {
+	if x != nil {
+		return x.Favicon
+	}
+	return ""
+}
+
+func (x *EntrySummary) GetPinned() bool {
+	if x != nil {
+		return x.Pinned
+	}
+	return false
+}
+
+func (x *EntrySummary) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *EntrySummary) GetIncognito() bool {
+	if x != nil {
+		return x.Incognito
+	}
+	return false
+}
+
+type WatchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// events names the events to stream: entry_saved, entry_deleted, locked
+	// and unlocked. All are streamed when it is empty.
+	Events        []string `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *WatchRequest) GetEvents() []string {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type WatchEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Event string                 `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	// timestamp is when the event was sent, in Unix seconds
+	Timestamp int64 `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// Types that are valid to be assigned to Data:
+	//
+	//	*WatchEvent_EntrySaved
+	//	*WatchEvent_EntryDeleted
+	//	*WatchEvent_LockChanged
+	Data          isWatchEvent_Data `protobuf_oneof:"data"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchEvent) Reset() {
+	*x = WatchEvent{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEvent) ProtoMessage() {}
+
+func (x *WatchEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEvent.ProtoReflect.Descriptor instead.
+func (*WatchEvent) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WatchEvent) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *WatchEvent) GetData() isWatchEvent_Data {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *WatchEvent) GetEntrySaved() *EntrySaved {
+	if x != nil {
+		if x, ok := x.Data.(*WatchEvent_EntrySaved); ok {
+			return x.EntrySaved
+		}
+	}
+	return nil
+}
+
+func (x *WatchEvent) GetEntryDeleted() *EntryDeleted {
+	if x != nil {
+		if x, ok := x.Data.(*WatchEvent_EntryDeleted); ok {
+			return x.EntryDeleted
+		}
+	}
+	return nil
+}
+
+func (x *WatchEvent) GetLockChanged() *LockChanged {
+	if x != nil {
+		if x, ok := x.Data.(*WatchEvent_LockChanged); ok {
+			return x.LockChanged
+		}
+	}
+	return nil
+}
+
+type isWatchEvent_Data interface {
+	isWatchEvent_Data()
+}
+
+type WatchEvent_EntrySaved struct {
+	EntrySaved *EntrySaved `protobuf:"bytes,3,opt,name=entry_saved,json=entrySaved,proto3,oneof"`
+}
+
+type WatchEvent_EntryDeleted struct {
+	EntryDeleted *EntryDeleted `protobuf:"bytes,4,opt,name=entry_deleted,json=entryDeleted,proto3,oneof"`
+}
+
+type WatchEvent_LockChanged struct {
+	LockChanged *LockChanged `protobuf:"bytes,5,opt,name=lock_changed,json=lockChanged,proto3,oneof"`
+}
+
+func (*WatchEvent_EntrySaved) isWatchEvent_Data() {}
+
+func (*WatchEvent_EntryDeleted) isWatchEvent_Data() {}
+
+func (*WatchEvent_LockChanged) isWatchEvent_Data() {}
+
+// EntrySaved describes a saved entry. The preview is left out for sensitive
+// and incognito entries.
+type EntrySaved struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Kind          string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	Type          string                 `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	Url           string                 `protobuf:"bytes,4,opt,name=url,proto3" json:"url,omitempty"`
+	Preview       string                 `protobuf:"bytes,5,opt,name=preview,proto3" json:"preview,omitempty"`
+	Tags          []string               `protobuf:"bytes,6,rep,name=tags,proto3" json:"tags,omitempty"`
+	Sensitive     []string               `protobuf:"bytes,7,rep,name=sensitive,proto3" json:"sensitive,omitempty"`
+	Incognito     bool                   `protobuf:"varint,8,opt,name=incognito,proto3" json:"incognito,omitempty"`
+	Truncated     bool                   `protobuf:"varint,9,opt,name=truncated,proto3" json:"truncated,omitempty"`
+	Timestamp     int64                  `protobuf:"varint,10,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EntrySaved) Reset() {
+	*x = EntrySaved{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EntrySaved) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EntrySaved) ProtoMessage() {}
+
+func (x *EntrySaved) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EntrySaved.ProtoReflect.Descriptor instead.
+func (*EntrySaved) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *EntrySaved) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *EntrySaved) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *EntrySaved) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *EntrySaved) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *EntrySaved) GetPreview() string {
+	if x != nil {
+		return x.Preview
+	}
+	return ""
+}
+
+func (x *EntrySaved) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *EntrySaved) GetSensitive() []string {
+	if x != nil {
+		return x.Sensitive
+	}
+	return nil
+}
+
+func (x *EntrySaved) GetIncognito() bool {
+	if x != nil {
+		return x.Incognito
+	}
+	return false
+}
+
+func (x *EntrySaved) GetTruncated() bool {
+	if x != nil {
+		return x.Truncated
+	}
+	return false
+}
+
+func (x *EntrySaved) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+// EntryDeleted names the entry deleted, or counts the entries removed at once
+// by clear or retention
+type EntryDeleted struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Count         int32                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	Reason        string                 `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EntryDeleted) Reset() {
+	*x = EntryDeleted{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EntryDeleted) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EntryDeleted) ProtoMessage() {}
+
+func (x *EntryDeleted) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EntryDeleted.ProtoReflect.Descriptor instead.
+func (*EntryDeleted) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *EntryDeleted) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *EntryDeleted) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *EntryDeleted) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// LockChanged is sent when the unlock session of passphrase protected storage
+// starts, with when it expires, or ends, with why
+type LockChanged struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Expires       int64                  `protobuf:"varint,1,opt,name=expires,proto3" json:"expires,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LockChanged) Reset() {
+	*x = LockChanged{}
+	mi := &file_tabd_v1_tabd_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LockChanged) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LockChanged) ProtoMessage() {}
+
+func (x *LockChanged) ProtoReflect() protoreflect.Message {
+	mi := &file_tabd_v1_tabd_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LockChanged.ProtoReflect.Descriptor instead.
+func (*LockChanged) Descriptor() ([]byte, []int) {
+	return file_tabd_v1_tabd_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *LockChanged) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+func (x *LockChanged) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+var File_tabd_v1_tabd_proto protoreflect.FileDescriptor
+
+const file_tabd_v1_tabd_proto_rawDesc = "" +
+	"\n" +
+	"\x12tabd/v1/tabd.proto\x12\atabd.v1\"\x82\x04\n" +
+	"\x05Entry\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x12\n" +
+	"\x04text\x18\x03 \x01(\tR\x04text\x12\x1c\n" +
+	"\ttimestamp\x18\x04 \x01(\x03R\ttimestamp\x12\x10\n" +
+	"\x03url\x18\x05 \x01(\tR\x03url\x12\x14\n" +
+	"\x05title\x18\x06 \x01(\tR\x05title\x12!\n" +
+	"\fcontent_type\x18\a \x01(\tR\vcontentType\x12\x12\n" +
+	"\x04data\x18\b \x01(\fR\x04data\x125\n" +
+	"\aflavors\x18\t \x03(\v2\x1b.tabd.v1.Entry.FlavorsEntryR\aflavors\x12\x1c\n" +
+	"\tsensitive\x18\n" +
+	" \x03(\tR\tsensitive\x12\x12\n" +
+	"\x04tags\x18\v \x03(\tR\x04tags\x12\x12\n" +
+	"\x04note\x18\f \x01(\tR\x04note\x12\x12\n" +
+	"\x04kind\x18\r \x01(\tR\x04kind\x12\x1a\n" +
+	"\blanguage\x18\x0e \x01(\tR\blanguage\x12\x1c\n" +
+	"\tincognito\x18\x0f \x01(\bR\tincognito\x12\x1c\n" +
+	"\ttruncated\x18\x10 \x01(\bR\ttruncated\x12\x1f\n" +
+	"\vfull_length\x18\x11 \x01(\x03R\n" +
+	"fullLength\x1a:\n" +
+	"\fFlavorsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xa0\x01\n" +
+	"\vSaveRequest\x12$\n" +
+	"\x05entry\x18\x01 \x01(\v2\x0e.tabd.v1.EntryR\x05entry\x12)\n" +
+	"\x10system_clipboard\x18\x02 \x01(\bR\x0fsystemClipboard\x12\x10\n" +
+	"\x03pin\x18\x03 \x01(\bR\x03pin\x12\x1a\n" +
+	"\bregister\x18\x04 \x01(\tR\bregister\x12\x12\n" +
+	"\x04full\x18\x05 \x01(\bR\x04full\"\xb3\x01\n" +
+	"\fSaveResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1a\n" +
+	"\bregister\x18\x02 \x01(\tR\bregister\x12\x1c\n" +
+	"\ttruncated\x18\x03 \x01(\bR\ttruncated\x12\x1f\n" +
+	"\vfull_length\x18\x04 \x01(\x03R\n" +
+	"fullLength\x12\x1c\n" +
+	"\tsensitive\x18\x05 \x03(\tR\tsensitive\x12\x1a\n" +
+	"\bdecision\x18\x06 \x01(\tR\bdecision\"\x1c\n" +
+	"\n" +
+	"GetRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"}\n" +
+	"\vListRequest\x12\x14\n" +
+	"\x05limit\x18\x01 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x05R\x06offset\x12\x14\n" +
+	"\x05since\x18\x03 \x01(\x03R\x05since\x12\x16\n" +
+	"\x06origin\x18\x04 \x01(\tR\x06origin\x12\x12\n" +
+	"\x04kind\x18\x05 \x01(\tR\x04kind\"p\n" +
+	"\fListResponse\x12/\n" +
+	"\aentries\x18\x01 \x03(\v2\x15.tabd.v1.EntrySummaryR\aentries\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x05R\x05total\x12\x19\n" +
+	"\bhas_more\x18\x03 \x01(\bR\ahasMore\"\xe8\x02\n" +
+	"\fEntrySummary\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\apreview\x18\x02 \x01(\tR\apreview\x12\x12\n" +
+	"\x04kind\x18\x03 \x01(\tR\x04kind\x12\x1a\n" +
+	"\blanguage\x18\x04 \x01(\tR\blanguage\x12\x1c\n" +
+	"\ttimestamp\x18\x05 \x01(\x03R\ttimestamp\x12\x10\n" +
+	"\x03url\x18\x06 \x01(\tR\x03url\x12\x14\n" +
+	"\x05title\x18\a \x01(\tR\x05title\x12\x12\n" +
+	"\x04type\x18\b \x01(\tR\x04type\x12!\n" +
+	"\fcontent_type\x18\t \x01(\tR\vcontentType\x12\x1d\n" +
+	"\n" +
+	"link_title\x18\n" +
+	" \x01(\tR\tlinkTitle\x12\x18\n" +
+	"\afavicon\x18\v \x01(\tR\afavicon\x12\x16\n" +
+	"\x06pinned\x18\f \x01(\bR\x06pinned\x12\x12\n" +
+	"\x04tags\x18\r \x03(\tR\x04tags\x12\x1c\n" +
+	"\tincognito\x18\x0e \x01(\bR\tincognito\"&\n" +
+	"\fWatchRequest\x12\x16\n" +
+	"\x06events\x18\x01 \x03(\tR\x06events\"\xf9\x01\n" +
+	"\n" +
+	"WatchEvent\x12\x14\n" +
+	"\x05event\x18\x01 \x01(\tR\x05event\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x126\n" +
+	"\ventry_saved\x18\x03 \x01(\v2\x13.tabd.v1.EntrySavedH\x00R\n" +
+	"entrySaved\x12<\n" +
+	"\rentry_deleted\x18\x04 \x01(\v2\x15.tabd.v1.EntryDeletedH\x00R\fentryDeleted\x129\n" +
+	"\flock_changed\x18\x05 \x01(\v2\x14.tabd.v1.LockChangedH\x00R\vlockChangedB\x06\n" +
+	"\x04data\"\xfc\x01\n" +
+	"\n" +
+	"EntrySaved\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x10\n" +
+	"\x03url\x18\x04 \x01(\tR\x03url\x12\x18\n" +
+	"\apreview\x18\x05 \x01(\tR\apreview\x12\x12\n" +
+	"\x04tags\x18\x06 \x03(\tR\x04tags\x12\x1c\n" +
+	"\tsensitive\x18\a \x03(\tR\tsensitive\x12\x1c\n" +
+	"\tincognito\x18\b \x01(\bR\tincognito\x12\x1c\n" +
+	"\ttruncated\x18\t \x01(\bR\ttruncated\x12\x1c\n" +
+	"\ttimestamp\x18\n" +
+	" \x01(\x03R\ttimestamp\"L\n" +
+	"\fEntryDeleted\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x05R\x05count\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"?\n" +
+	"\vLockChanged\x12\x18\n" +
+	"\aexpires\x18\x01 \x01(\x03R\aexpires\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason2\xd3\x01\n" +
+	"\x04Tabd\x123\n" +
+	"\x04Save\x12\x14.tabd.v1.SaveRequest\x1a\x15.tabd.v1.SaveResponse\x12*\n" +
+	"\x03Get\x12\x13.tabd.v1.GetRequest\x1a\x0e.tabd.v1.Entry\x123\n" +
+	"\x04List\x12\x14.tabd.v1.ListRequest\x1a\x15.tabd.v1.ListResponse\x125\n" +
+	"\x05Watch\x12\x15.tabd.v1.WatchRequest\x1a\x13.tabd.v1.WatchEvent0\x01B@Z>github.com/iann0036/tabd-extension/tabd-native-host/pkg/tabdpbb\x06proto3"
+
+var (
+	file_tabd_v1_tabd_proto_rawDescOnce sync.Once
+	file_tabd_v1_tabd_proto_rawDescData []byte
+)
+
+func file_tabd_v1_tabd_proto_rawDescGZIP() []byte {
+	file_tabd_v1_tabd_proto_rawDescOnce.Do(func() {
+		file_tabd_v1_tabd_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tabd_v1_tabd_proto_rawDesc), len(file_tabd_v1_tabd_proto_rawDesc)))
+	})
+	return file_tabd_v1_tabd_proto_rawDescData
+}
+
+var file_tabd_v1_tabd_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_tabd_v1_tabd_proto_goTypes = []any{
+	(*Entry)(nil),        // 0: tabd.v1.Entry
+	(*SaveRequest)(nil),  // 1: tabd.v1.SaveRequest
+	(*SaveResponse)(nil), // 2: tabd.v1.SaveResponse
+	(*GetRequest)(nil),   // 3: tabd.v1.GetRequest
+	(*ListRequest)(nil),  // 4: tabd.v1.ListRequest
+	(*ListResponse)(nil), // 5: tabd.v1.ListResponse
+	(*EntrySummary)(nil), // 6: tabd.v1.EntrySummary
+	(*WatchRequest)(nil), // 7: tabd.v1.WatchRequest
+	(*WatchEvent)(nil),   // 8: tabd.v1.WatchEvent
+	(*EntrySaved)(nil),   // 9: tabd.v1.EntrySaved
+	(*EntryDeleted)(nil), // 10: tabd.v1.EntryDeleted
+	(*LockChanged)(nil),  // 11: tabd.v1.LockChanged
+	nil,                  // 12: tabd.v1.Entry.FlavorsEntry
+}
+var file_tabd_v1_tabd_proto_depIdxs = []int32{
+	12, // 0: tabd.v1.Entry.flavors:type_name -> tabd.v1.Entry.FlavorsEntry
+	0,  // 1: tabd.v1.SaveRequest.entry:type_name -> tabd.v1.Entry
+	6,  // 2: tabd.v1.ListResponse.entries:type_name -> tabd.v1.EntrySummary
+	9,  // 3: tabd.v1.WatchEvent.entry_saved:type_name -> tabd.v1.EntrySaved
+	10, // 4: tabd.v1.WatchEvent.entry_deleted:type_name -> tabd.v1.EntryDeleted
+	11, // 5: tabd.v1.WatchEvent.lock_changed:type_name -> tabd.v1.LockChanged
+	1,  // 6: tabd.v1.Tabd.Save:input_type -> tabd.v1.SaveRequest
+	3,  // 7: tabd.v1.Tabd.Get:input_type -> tabd.v1.GetRequest
+	4,  // 8: tabd.v1.Tabd.List:input_type -> tabd.v1.ListRequest
+	7,  // 9: tabd.v1.Tabd.Watch:input_type -> tabd.v1.WatchRequest
+	2,  // 10: tabd.v1.Tabd.Save:output_type -> tabd.v1.SaveResponse
+	0,  // 11: tabd.v1.Tabd.Get:output_type -> tabd.v1.Entry
+	5,  // 12: tabd.v1.Tabd.List:output_type -> tabd.v1.ListResponse
+	8,  // 13: tabd.v1.Tabd.Watch:output_type -> tabd.v1.WatchEvent
+	10, // [10:14] is the sub-list for method output_type
+	6,  // [6:10] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_tabd_v1_tabd_proto_init() }
+func file_tabd_v1_tabd_proto_init() {
+	if File_tabd_v1_tabd_proto != nil {
+		return
+	}
+	file_tabd_v1_tabd_proto_msgTypes[8].OneofWrappers = []any{
+		(*WatchEvent_EntrySaved)(nil),
+		(*WatchEvent_EntryDeleted)(nil),
+		(*WatchEvent_LockChanged)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tabd_v1_tabd_proto_rawDesc), len(file_tabd_v1_tabd_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tabd_v1_tabd_proto_goTypes,
+		DependencyIndexes: file_tabd_v1_tabd_proto_depIdxs,
+		MessageInfos:      file_tabd_v1_tabd_proto_msgTypes,
+	}.Build()
+	File_tabd_v1_tabd_proto = out.File
+	file_tabd_v1_tabd_proto_goTypes = nil
+	file_tabd_v1_tabd_proto_depIdxs = nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// previewLength is the number of characters of text kept in a preview
+const previewLength = 120
+
+// EntryPreview is computed when an entry is saved and kept with the history
+// index, so listings can be shown without decrypting every entry
+type EntryPreview struct {
+	Text        string `json:"text"`
+	Title       string `json:"title,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+
+	// LinkTitle and Favicon describe the page a URL entry links to, once
+	// it has been unfurled
+	LinkTitle string `json:"linkTitle,omitempty"`
+	Favicon   string `json:"favicon,omitempty"`
+}
+
+// newEntryPreview builds the preview of an entry's content
+func newEntryPreview(data *ClipboardData) *EntryPreview {
+	preview := &EntryPreview{
+		Text:        previewText(data),
+		Title:       data.Title,
+		ContentType: data.ContentType,
+	}
+	if data.Link != nil {
+		preview.LinkTitle = data.Link.Title
+		preview.Favicon = data.Link.Favicon
+	}
+	return preview
+}
+
+// previewText returns a short single-line description of an entry's content
+// for history listings
+func previewText(data *ClipboardData) string {
+	if data.Text == "" && data.Data != "" {
+		size := int64(len(data.Data)) * 3 / 4
+		contentType := data.ContentType
+		if contentType == "" {
+			contentType = "binary"
+		}
+		return fmt.Sprintf("[%s, %s]", contentType, formatBytes(size))
+	}
+
+	text := strings.Join(strings.Fields(data.Text), " ")
+	if utf8.RuneCountInString(text) <= previewLength {
+		return text
+	}
+	return string([]rune(text)[:previewLength]) + "…"
+}
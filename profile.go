@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// launcherPrefix starts the file name of every profile launcher
+const launcherPrefix = "tabd-native-host-"
+
+// profileOverride is set by the global --profile flag
+var profileOverride string
+
+// validProfile matches the profile names accepted by --profile
+var validProfile = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// currentProfile returns the profile from --profile or TABD_PROFILE, or ""
+// for the default namespace
+func currentProfile() string {
+	if profileOverride != "" {
+		return profileOverride
+	}
+	return os.Getenv("TABD_PROFILE")
+}
+
+// validateProfile checks that a profile name is usable as a directory name
+func validateProfile(profile string) error {
+	if !validProfile.MatchString(profile) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '-' and '_'", profile)
+	}
+	return nil
+}
+
+// profileStorageDir returns the namespace for a profile under storageDir
+func profileStorageDir(storageDir, profile string) string {
+	if profile == "" {
+		return storageDir
+	}
+	return filepath.Join(storageDir, "profiles", profile)
+}
+
+// keyringService returns the keyring service for the current profile, so
+// each profile keeps its own master key and entries
+func keyringService() string {
+	if profile := currentProfile(); profile != "" {
+		return keyringServiceName + "." + profile
+	}
+	return keyringServiceName
+}
+
+// launchersDir returns where profile launchers are written
+func launchersDir() (string, error) {
+	tabdDir, err := defaultTabdDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(tabdDir, "launchers"), nil
+}
+
+// writeProfileLauncher writes a script that starts binaryPath with the
+// profile selected. Browsers cannot pass extra arguments to a native host,
+// so manifests for a profile point to the launcher instead of the binary.
+func writeProfileLauncher(binaryPath, profile string) (string, error) {
+	dir, err := launchersDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create launcher directory: %v", err)
+	}
+
+	configArgs, err := configPathArgs()
+	if err != nil {
+		return "", err
+	}
+	args := append([]string{"--profile", profile}, configArgs...)
+
+	var path, script string
+	if runtime.GOOS == "windows" {
+		path = filepath.Join(dir, launcherPrefix+profile+".cmd")
+		script = "@echo off\r\n\"" + binaryPath + "\""
+		for _, arg := range args {
+			script += " \"" + arg + "\""
+		}
+		script += " %*\r\n"
+	} else {
+		path = filepath.Join(dir, launcherPrefix+profile+".sh")
+		script = "#!/bin/sh\nexec " + shellQuote(binaryPath)
+		for _, arg := range args {
+			script += " " + shellQuote(arg)
+		}
+		script += " \"$@\"\n"
+	}
+
+	if err := writeFileAtomic(path, []byte(script), 0700); err != nil {
+		return "", fmt.Errorf("failed to write launcher: %v", err)
+	}
+	return path, nil
+}
+
+// configPathArgs returns the global flags that select the config file given
+// by --config, for commands started later by a launcher or service manager
+func configPathArgs() ([]string, error) {
+	if configPathOverride == "" {
+		return nil, nil
+	}
+	configPath, err := filepath.Abs(configPathOverride)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config path: %v", err)
+	}
+	return []string{"--config", configPath}, nil
+}
+
+// launcherProfile returns the profile a manifest path launches, if it is one
+// of the launchers written by install
+func launcherProfile(path string) (string, bool) {
+	dir, err := launchersDir()
+	if err != nil || filepath.Dir(path) != dir {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".cmd"), ".sh")
+	return strings.CutPrefix(name, launcherPrefix)
+}
+
+// shellQuote quotes a value for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+)
+
+// defaultQRSize is the width and height in pixels of a QR code PNG
+const defaultQRSize = 256
+
+// qrResult is the data returned by a qr message: the code as a PNG image and
+// as block characters for a terminal. Dark modules are left blank in the
+// text, so it reads correctly as light text on a dark background.
+type qrResult struct {
+	ID          string `json:"id,omitempty"`
+	ContentType string `json:"contentType"`
+	Data        string `json:"data"`
+	Text        string `json:"text"`
+}
+
+// entryQRCode encodes the text of an entry as a QR code. Entries without
+// text, such as images, cannot be encoded.
+func entryQRCode(data *ClipboardData) (*qrcode.QRCode, error) {
+	text := entryPlainText(data)
+	if text == "" {
+		return nil, invalidRequestf("clipboard entry has no text to encode")
+	}
+	code, err := qrcode.New(text, qrcode.Medium)
+	if err != nil {
+		return nil, invalidRequestf("failed to encode QR code: %v", err)
+	}
+	return code, nil
+}
+
+// qrEntry returns a history entry by ID, or the latest entry if id is empty
+func (t *TabdNativeHost) qrEntry(ctx context.Context, id string) (*ClipboardData, error) {
+	if id == "" {
+		return t.getClipboardData(ctx)
+	}
+	return t.historyStore().Get(id)
+}
+
+// handleQR renders a history entry, or the latest entry, as a QR code
+func (t *TabdNativeHost) handleQR(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	data, err := t.qrEntry(ctx, msg.ID)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to retrieve clipboard data: %w", err)
+	}
+	code, err := entryQRCode(data)
+	if err != nil {
+		return "", nil, err
+	}
+	image, err := code.PNG(defaultQRSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to render QR code: %v", err)
+	}
+	return "", &qrResult{
+		ID:          msg.ID,
+		ContentType: "image/png",
+		Data:        base64.StdEncoding.EncodeToString(image),
+		Text:        code.ToSmallString(false),
+	}, nil
+}
+
+// newQRCommand creates the qr command, which prints the latest entry, or one
+// given by ID, as a QR code in the terminal or writes it as a PNG image
+func newQRCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "qr",
+		Short: "Show the latest entry as a QR code in the terminal or as a PNG",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	id := flags.String("id", "", "history entry to encode instead of the latest")
+	format := flags.String("format", "terminal", "output format: terminal or png")
+	size := flags.Int("size", defaultQRSize, "width and height of the PNG in pixels")
+	output := flags.String("output", "", "write to this file instead of stdout")
+	invert := flags.Bool("invert", false, "draw dark modules in the terminal text colour, for light backgrounds")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		if *size <= 0 {
+			return fmt.Errorf("--size must be positive")
+		}
+
+		data, err := host.qrEntry(context.Background(), *id)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve clipboard data: %w", err)
+		}
+		code, err := entryQRCode(data)
+		if err != nil {
+			return err
+		}
+
+		var rendered []byte
+		switch *format {
+		case "terminal":
+			rendered = []byte(code.ToSmallString(*invert))
+		case "png":
+			if rendered, err = code.PNG(*size); err != nil {
+				return fmt.Errorf("failed to render QR code: %v", err)
+			}
+		default:
+			return fmt.Errorf("unknown format: %s", *format)
+		}
+
+		if *output != "" {
+			return os.WriteFile(*output, rendered, 0600)
+		}
+		_, err = os.Stdout.Write(rendered)
+		return err
+	})
+	return cmd
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// QuotaReport is returned with a save that went over the storage quota
+type QuotaReport struct {
+	// Evicted is the number of unpinned entries removed to make room
+	Evicted int `json:"evicted,omitempty"`
+	// QuotaExceeded is set when the storage is still over quota, because
+	// only pinned entries remain
+	QuotaExceeded bool `json:"quotaExceeded,omitempty"`
+}
+
+// parseSize parses a size such as "100MB", "1.5GB" or "512KB". Units are
+// binary, so 1MB is 1024KB, and a bare number is a count of bytes.
+func parseSize(value string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for i, suffix := range []string{"KB", "MB", "GB", "TB"} {
+		if trimmed, ok := strings.CutSuffix(number, suffix); ok {
+			number = trimmed
+			multiplier = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+	number = strings.TrimSpace(strings.TrimSuffix(number, "B"))
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 100MB or 2GB)", value)
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
+// storageFilePatterns match the files holding stored entries and keys.
+// Logs, the audit log and launchers are left out of the quota, as evicting
+// entries does not shrink them.
+var storageFilePatterns = []string{"*.enc", "*.dpapi", "tabd.db", "tabd.db-wal"}
+
+// storageUsage returns the size of the storage files in dir
+func storageUsage(dir string) int64 {
+	var total int64
+	for _, pattern := range storageFilePatterns {
+		paths, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, path := range paths {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				total += info.Size()
+			}
+		}
+	}
+	return total
+}
+
+// quotaUsage returns the space counted against the quota: the storage files,
+// less free database pages that new entries will reuse
+func (t *TabdNativeHost) quotaUsage() int64 {
+	sqliteStorage, ok := unwrapStorage(t.storage()).(*SQLiteStorage)
+	if !ok {
+		return storageUsage(t.tabdDir)
+	}
+
+	if err := sqliteStorage.Checkpoint(); err != nil {
+		logDebugf("Error checkpointing database: %v", err)
+	}
+	return storageUsage(t.tabdDir) - sqliteStorage.FreeSpace()
+}
+
+// entrySize returns the space evicting a history entry frees
+func (t *TabdNativeHost) entrySize(id string) int64 {
+	if sqliteStorage, ok := unwrapStorage(t.storage()).(*SQLiteStorage); ok {
+		return sqliteStorage.EntrySize(id)
+	}
+
+	var size int64
+	for _, ext := range []string{".enc", ".dpapi"} {
+		if info, err := os.Stat(filepath.Join(t.tabdDir, historyEntryPrefix+id+ext)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// enforceQuota evicts the oldest unpinned history entries, other than keep,
+// until the storage files fit within the quota. It returns nil when no quota
+// is set or the storage was already within it.
+func (t *TabdNativeHost) enforceQuota(keep string) *QuotaReport {
+	quota := t.config.QuotaBytes()
+	if quota == 0 {
+		return nil
+	}
+
+	t.quotaMu.Lock()
+	defer t.quotaMu.Unlock()
+
+	usage := t.quotaUsage()
+	if usage <= quota {
+		return nil
+	}
+
+	entries, err := t.historyStore().List()
+	if err != nil {
+		logWarnf("Error listing history to enforce the storage quota: %v", err)
+		return &QuotaReport{QuotaExceeded: true}
+	}
+
+	report := &QuotaReport{}
+	for i := len(entries) - 1; i >= 0 && usage > quota; i-- {
+		if entries[i].Pinned || entries[i].ID == keep {
+			continue
+		}
+		size := t.entrySize(entries[i].ID)
+		if err := t.historyStore().Delete(entries[i].ID); err != nil {
+			logWarnf("Error evicting history entry %s: %v", entries[i].ID, err)
+			continue
+		}
+		report.Evicted++
+		t.broadcastEntriesDeleted(entries[i].ID, 1, "quota")
+		usage -= size
+	}
+
+	report.QuotaExceeded = usage > quota
+	if report.Evicted > 0 {
+		logInfof("Evicted %d history entries to stay within the %s storage quota", report.Evicted, formatBytes(quota))
+	}
+	if report.QuotaExceeded {
+		logWarnf("Storage uses %s, over the %s quota", formatBytes(usage), formatBytes(quota))
+	}
+	return report
+}
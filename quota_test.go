@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestQuotaIgnoresLogs(t *testing.T) {
+	host := newTestHost(t)
+	host.config.Quota = "64KB"
+	session := host.NewSession(nil, nil)
+	ctx := context.Background()
+
+	// Logs do not shrink when entries are evicted, so they must not count
+	if err := os.WriteFile(filepath.Join(host.tabdDir, "audit.log"), make([]byte, 1<<20), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"one", "two", "three"} {
+		response := host.dispatch(ctx, session, &Message{Action: "save", ClipboardData: ClipboardData{Text: text, Type: "text"}})
+		if response.Status != "success" {
+			t.Fatalf("save: %s", response.Message)
+		}
+	}
+
+	entries, err := host.historyStore().List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("history has %d entries with a large log, want 3", len(entries))
+	}
+}
+
+func TestQuotaEvictsOldest(t *testing.T) {
+	host := newTestHost(t)
+	session := host.NewSession(nil, nil)
+	ctx := context.Background()
+
+	for _, text := range []string{"one", "two", "three", "four"} {
+		response := host.dispatch(ctx, session, &Message{Action: "save", ClipboardData: ClipboardData{Text: text, Type: "text"}})
+		if response.Status != "success" {
+			t.Fatalf("save: %s", response.Message)
+		}
+	}
+
+	// Going just over the quota evicts the oldest entry and no more
+	entries, _ := host.historyStore().List()
+	oldest := entries[len(entries)-1].ID
+	host.config.Quota = strconv.FormatInt(host.quotaUsage()-host.entrySize(oldest)/2, 10)
+	report := host.enforceQuota(entries[0].ID)
+	if report == nil || report.Evicted != 1 || report.QuotaExceeded {
+		t.Fatalf("enforceQuota = %+v, want one entry evicted", report)
+	}
+	if _, err := host.historyStore().Get(oldest); err == nil {
+		t.Fatal("the oldest entry was not the one evicted")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRateLimit is the sustained number of messages per second a
+	// session may send, and defaultRateBurst how many it may send at once
+	defaultRateLimit = 50
+	defaultRateBurst = 200
+)
+
+// tokenBucket limits how often a session's messages are handled. Each
+// message takes a token; tokens refill at rate per second up to burst.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// limited is set while messages are being refused, so the limit is
+	// logged once per episode rather than for every message
+	limited bool
+}
+
+// newTokenBucket creates a full bucket, or returns nil for an unlimited one
+// when rate is not positive
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token if one is available. Otherwise it returns false and
+// how long until the next token, and whether this starts a run of refusals.
+func (b *tokenBucket) Allow() (bool, time.Duration, bool) {
+	if b == nil {
+		return true, 0, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, 0, false
+	}
+
+	started := !b.limited
+	b.limited = true
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait, started
+}
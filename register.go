@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// registerPrefix starts the secure storage key holding each register
+const registerPrefix = "register_"
+
+// RegisterEntry is the content kept in a named register, with the history
+// entry it was saved as
+type RegisterEntry struct {
+	Register string `json:"register"`
+	ID       string `json:"id,omitempty"`
+	Saved    int64  `json:"saved"`
+	ClipboardData
+}
+
+// validateRegister checks that a register is named by a single letter a–z,
+// as in vim
+func validateRegister(name string) error {
+	if len(name) != 1 || name[0] < 'a' || name[0] > 'z' {
+		return invalidRequestf("invalid register %q: use a single letter a-z", name)
+	}
+	return nil
+}
+
+// storeRegister replaces the content of a register
+func (t *TabdNativeHost) storeRegister(name, id string, data *ClipboardData) error {
+	if err := validateRegister(name); err != nil {
+		return err
+	}
+	entry, err := json.Marshal(&RegisterEntry{Register: name, ID: id, Saved: time.Now().UnixMilli(), ClipboardData: *data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal register: %v", err)
+	}
+	if err := t.storage().Store(registerPrefix+name, entry); err != nil {
+		return fmt.Errorf("failed to store register %s: %v", name, err)
+	}
+	return nil
+}
+
+// getRegister returns the content of a register
+func (t *TabdNativeHost) getRegister(name string) (*RegisterEntry, error) {
+	if err := validateRegister(name); err != nil {
+		return nil, err
+	}
+	data, err := t.storage().Retrieve(registerPrefix + name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &codedError{code: codeNotFound, err: fmt.Errorf("register %s is empty", name)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read register %s: %v", name, err)
+	}
+	var entry RegisterEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse register %s: %v", name, err)
+	}
+	return &entry, nil
+}
+
+// handleGetRegister returns the content of the register named by the message
+func (t *TabdNativeHost) handleGetRegister(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	if msg.Register == "" {
+		return "", nil, invalidRequestf("Missing register")
+	}
+	entry, err := t.getRegister(msg.Register)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to get register: %w", err)
+	}
+	t.runRetrieveHooks(entry.ID, &entry.ClipboardData)
+	return "", entry, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configReloadDelay lets an editor finish writing the config file, which it
+// may do in several steps, before it is reloaded
+const configReloadDelay = 250 * time.Millisecond
+
+// reloadableSettings are the config file keys a running daemon applies when
+// the file changes. The rest take effect when it restarts.
+var reloadableSettings = map[string]bool{
+	"logLevel":          true,
+	"retention":         true,
+	"blockedOrigins":    true,
+	"allowedOrigins":    true,
+	"sensitiveAction":   true,
+	"sensitivePatterns": true,
+	"webhooks":          true,
+}
+
+// configReloader applies changes to the config file to a running host
+type configReloader struct {
+	host *TabdNativeHost
+	path string
+
+	// current is the config last applied
+	current *Config
+}
+
+// watchConfig reloads the config file whenever it changes, until stop is
+// closed. The directory is watched rather than the file, as editors often
+// replace the file and it may not exist yet.
+func (t *TabdNativeHost) watchConfig(stop <-chan struct{}) {
+	path, _, err := configPath()
+	if err != nil {
+		logWarnf("Not watching the config file for changes: %v", err)
+		return
+	}
+	path = filepath.Clean(path)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logWarnf("Not watching %s for changes: %v", path, err)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		logWarnf("Not watching %s for changes: %v", path, err)
+		return
+	}
+	logDebugf("Watching %s for changes", path)
+
+	reloader := &configReloader{host: t, path: path, current: t.config}
+	timer := time.NewTimer(configReloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != path || event.Op == fsnotify.Chmod {
+				continue
+			}
+			timer.Reset(configReloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logWarnf("Error watching %s for changes: %v", path, err)
+		case <-timer.C:
+			reloader.reload()
+		}
+	}
+}
+
+// reload reads the config file and applies the reloadable settings that
+// changed, telling connected clients with a config_reloaded event. An invalid
+// config is logged and the current one kept.
+func (r *configReloader) reload() {
+	config, err := LoadConfig()
+	if err != nil {
+		logErrorf("Not reloading config, keeping the current one: %v", err)
+		return
+	}
+	sensitive, err := NewSensitiveScanner(config)
+	if err != nil {
+		logErrorf("Not reloading config, keeping the current one: %v", err)
+		return
+	}
+
+	changed, restart := changedSettings(r.current, config)
+	r.current = config
+	if len(changed) > 0 {
+		// Applied first so the new log level covers the messages below
+		r.host.applyConfig(config, sensitive)
+	}
+	if len(restart) > 0 {
+		logWarnf("Changes to %s in %s take effect when the daemon restarts", strings.Join(restart, ", "), r.path)
+	}
+	if len(changed) == 0 {
+		logDebugf("Config file changed without changes to reloadable settings")
+		return
+	}
+
+	logInfof("Reloaded config from %s: %s changed", r.path, strings.Join(changed, ", "))
+	r.host.broadcast(&Event{
+		Event:     "config_reloaded",
+		Data:      map[string]interface{}{"changed": changed},
+		Timestamp: time.Now().Unix(),
+	})
+
+	if slices.Contains(changed, "retention") {
+		r.host.expireHistory()
+	}
+}
+
+// applyConfig switches the host to the reloadable settings of a config
+func (t *TabdNativeHost) applyConfig(config *Config, sensitive *SensitiveScanner) {
+	setLogLevel(config.LogLevel)
+	t.setRetention(config.Retention)
+	t.origins.update(config)
+	t.sensitive.replace(sensitive)
+	t.webhooks.update(config)
+}
+
+// changedSettings compares two configs by their config file keys, returning
+// the changed keys that can be reloaded and those that need a restart
+func changedSettings(old, updated *Config) (changed, restart []string) {
+	oldFields, updatedFields := configFields(old), configFields(updated)
+	keys := make(map[string]bool)
+	for key := range oldFields {
+		keys[key] = true
+	}
+	for key := range updatedFields {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		if reflect.DeepEqual(oldFields[key], updatedFields[key]) {
+			continue
+		}
+		if reloadableSettings[key] {
+			changed = append(changed, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(restart)
+	return changed, restart
+}
+
+// configFields returns a config's values keyed as in the config file
+func configFields(config *Config) map[string]interface{} {
+	fields := make(map[string]interface{})
+	data, err := json.Marshal(config)
+	if err == nil {
+		json.Unmarshal(data, &fields)
+	}
+	return fields
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"time"
+)
+
+// retentionSweepInterval is how often a long-running daemon expires entries
+const retentionSweepInterval = time.Hour
+
+// retentionPeriod returns the retention in effect as configured and parsed,
+// with a zero period if history entries do not expire
+func (t *TabdNativeHost) retentionPeriod() (string, time.Duration) {
+	t.retentionMu.Lock()
+	defer t.retentionMu.Unlock()
+	period, _ := parseAge(t.retention)
+	return t.retention, period
+}
+
+// setRetention changes the retention period, as read from a reloaded config
+func (t *TabdNativeHost) setRetention(retention string) {
+	t.retentionMu.Lock()
+	defer t.retentionMu.Unlock()
+	t.retention = retention
+}
+
+// expireHistory removes unpinned history entries older than the configured
+// retention period
+func (t *TabdNativeHost) expireHistory() {
+	retention, period := t.retentionPeriod()
+	if period <= 0 {
+		return
+	}
+
+	before := time.Now().Add(-period).UnixMilli()
+	removed, err := t.historyStore().Expire(before)
+	if err != nil {
+		logErrorf("Error expiring clipboard history: %v", err)
+		return
+	}
+	if removed > 0 {
+		logInfof("Expired %d clipboard history entries older than %s", removed, retention)
+	}
+	t.broadcastEntriesDeleted("", removed, "expired")
+}
+
+// runRetention expires history on startup and then periodically until stop
+// is closed. It keeps running without a retention period, as a config
+// reload may set one.
+func (t *TabdNativeHost) runRetention(stop <-chan struct{}) {
+	t.expireHistory()
+
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			t.expireHistory()
+		}
+	}
+}
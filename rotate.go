@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// rotateSuffix marks re-encrypted files staged during a key rotation
+const rotateSuffix = ".rotate"
+
+// writeFileAtomic writes data to a temporary file and renames it into place,
+// so readers never observe a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// stageFileRotation moves every encrypted file in tabdDir to a new cipher in a
+// staged copy next to it, returning the paths of the original files
+func stageFileRotation(tabdDir string, from, to *BlobCipher) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(tabdDir, "*.enc"))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			var encrypted []byte
+			if encrypted, err = decodeEncFile(data); err == nil {
+				if encrypted, err = from.Rewrap(encrypted, to); err == nil {
+					err = writeFileAtomic(path+rotateSuffix, encodeEncFile(encrypted), 0600)
+				}
+			}
+		}
+		if err != nil {
+			discardFileRotation(paths[:i])
+			return nil, fmt.Errorf("failed to re-encrypt %s: %v", filepath.Base(path), err)
+		}
+	}
+
+	return paths, nil
+}
+
+// discardFileRotation removes staged copies after a failed rotation
+func discardFileRotation(paths []string) {
+	for _, path := range paths {
+		os.Remove(path + rotateSuffix)
+	}
+}
+
+// rotateKey replaces the storage passphrase and moves all data to it. Only
+// the data keys of blobs are wrapped again; their data stays as it is, unless
+// it is in an older format or the configured cipher changed.
+// The old passphrase is kept as the previous key until every blob has been
+// swapped, so an interrupted rotation never loses data and can simply
+// be run again. When storage is protected, the new key is wrapped with the
+// given user passphrase.
+func rotateKey(host *TabdNativeHost, userPassphrase string) (int, error) {
+	tabdDir := host.tabdDir
+
+	// Keep other host instances from writing files while they are swapped
+	lock, err := acquireFileLock(storageLockPath(tabdDir))
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Release()
+
+	keys := newMasterKeyStore(tabdDir)
+	from, err := storageCipher(tabdDir)
+	if err != nil {
+		return 0, err
+	}
+	oldPassphrase, err := generateOrRetrievePassphrase(tabdDir)
+	if err != nil {
+		return 0, err
+	}
+	passphrase := newPassphrase()
+	to := NewBlobCipher(passphrase)
+
+	paths, err := stageFileRotation(tabdDir, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	// Persist the new key, keeping the old one readable until the swap ends
+	commit := func() error {
+		if err := keys.save(previousKeyName, oldPassphrase); err != nil {
+			return fmt.Errorf("failed to save previous passphrase: %v", err)
+		}
+		if err := keys.setMasterKey(passphrase, userPassphrase); err != nil {
+			return fmt.Errorf("failed to save new passphrase: %v", err)
+		}
+		return nil
+	}
+
+	count := len(paths)
+	if sqliteStorage, ok := unwrapStorage(host.storage()).(*SQLiteStorage); ok {
+		rows, err := sqliteStorage.Reencrypt(to, commit)
+		if err != nil {
+			discardFileRotation(paths)
+			return 0, err
+		}
+		count += rows
+	} else if err := commit(); err != nil {
+		discardFileRotation(paths)
+		return 0, err
+	}
+
+	var failed []string
+	for _, path := range paths {
+		if err := os.Rename(path+rotateSuffix, path); err != nil {
+			failed = append(failed, filepath.Base(path))
+		}
+	}
+	if len(failed) > 0 {
+		return count, fmt.Errorf("failed to replace %s; run rotate-key again", strings.Join(failed, ", "))
+	}
+
+	if err := keys.delete(previousKeyName); err != nil {
+		return count, fmt.Errorf("failed to remove previous passphrase: %v", err)
+	}
+
+	return count, nil
+}
+
+// newRotateKeyCommand creates the rotate-key command, which generates a new
+// storage passphrase and moves all data to it
+func newRotateKeyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Generate a new storage key and move all data to it",
+		Args:  cobra.NoArgs,
+	}
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		if host.config.StorageBackend == "dpapi" {
+			return errNoStorageKey
+		}
+
+		// A running daemon, server, tray or browser host would keep encrypting
+		// with the old key, which is deleted once the rotation ends
+		if conn, err := dialIPC(ipcAddress(host.tabdDir)); err == nil {
+			conn.Close()
+			return errors.New("a daemon is running; stop it before rotating the key")
+		}
+		if running := runningInstances(host.tabdDir); len(running) > 0 {
+			return fmt.Errorf("stop %s before rotating the key, as running hosts keep encrypting with the current one", strings.Join(running, ", "))
+		}
+
+		// A protected key must be wrapped again with the user passphrase
+		var userPassphrase string
+		if keys := newMasterKeyStore(host.tabdDir); keys.protected() {
+			var err error
+			if userPassphrase, err = readPassphrase("Passphrase: "); err != nil {
+				return err
+			}
+			if _, err := keys.unwrap(userPassphrase); err != nil {
+				return err
+			}
+		}
+
+		count, err := rotateKey(host, userPassphrase)
+		if err != nil {
+			return err
+		}
+
+		notef("Moved %d items to a new key\n", count)
+		return nil
+	})
+	return cmd
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+)
+
+// searchContext is the number of bytes of context kept around each match
+const searchContext = 40
+
+// SearchMatch is one occurrence of the search pattern in an entry field.
+// Start and End are byte offsets of the match within Snippet.
+type SearchMatch struct {
+	Field   string `json:"field"`
+	Snippet string `json:"snippet"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+}
+
+// SearchResult is a history entry with at least one match
+type SearchResult struct {
+	ID        string        `json:"id"`
+	Timestamp int64         `json:"timestamp"`
+	URL       string        `json:"url,omitempty"`
+	Title     string        `json:"title,omitempty"`
+	Tags      []string      `json:"tags,omitempty"`
+	Matches   []SearchMatch `json:"matches"`
+}
+
+// compileSearchPattern builds the matcher for a search, quoting the pattern
+// unless it is a regular expression
+func compileSearchPattern(pattern string, isRegex, caseSensitive bool) (*regexp.Regexp, error) {
+	if !isRegex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if !caseSensitive {
+		pattern = "(?i)" + pattern
+	}
+
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %v", err)
+	}
+	return matcher, nil
+}
+
+// searchEntry finds pattern matches in the text, title, URL and note of an
+// entry
+func searchEntry(matcher *regexp.Regexp, data *ClipboardData) []SearchMatch {
+	matches := []SearchMatch{}
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"text", data.Text},
+		{"title", data.Title},
+		{"url", data.URL},
+		{"note", data.Note},
+	}
+
+	for _, field := range fields {
+		for _, loc := range matcher.FindAllStringIndex(field.value, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			matches = append(matches, newSearchMatch(field.name, field.value, loc[0], loc[1]))
+		}
+	}
+
+	return matches
+}
+
+// newSearchMatch cuts a snippet around a match without splitting UTF-8 characters
+func newSearchMatch(field, value string, start, end int) SearchMatch {
+	snippetStart := start - searchContext
+	if snippetStart < 0 {
+		snippetStart = 0
+	}
+	for snippetStart > 0 && !utf8.RuneStart(value[snippetStart]) {
+		snippetStart--
+	}
+
+	snippetEnd := end + searchContext
+	if snippetEnd > len(value) {
+		snippetEnd = len(value)
+	}
+	for snippetEnd < len(value) && !utf8.RuneStart(value[snippetEnd]) {
+		snippetEnd++
+	}
+
+	return SearchMatch{
+		Field:   field,
+		Snippet: value[snippetStart:snippetEnd],
+		Start:   start - snippetStart,
+		End:     end - snippetStart,
+	}
+}
+
+// searchHistory scans the history entries selected by query, newest first,
+// returning up to limit matching entries (all of them if limit is zero)
+func searchHistory(history HistoryStore, query HistoryQuery, matcher *regexp.Regexp, limit int) ([]*SearchResult, error) {
+	entries, err := history.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clipboard history: %v", err)
+	}
+
+	results := []*SearchResult{}
+	for _, entry := range entries {
+		data, err := history.Get(entry.ID)
+		if err != nil {
+			logWarnf("Skipping unreadable history entry %s: %v", entry.ID, err)
+			continue
+		}
+
+		matches := searchEntry(matcher, data)
+		if len(matches) == 0 {
+			continue
+		}
+
+		results = append(results, &SearchResult{
+			ID:        entry.ID,
+			Timestamp: entry.Timestamp,
+			URL:       data.URL,
+			Title:     data.Title,
+			Tags:      data.Tags,
+			Matches:   matches,
+		})
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// newSearchCommand creates the search command, which prints history entries
+// matching a pattern as JSON lines
+func newSearchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "search <pattern>",
+		Short: "Search clipboard history text, titles and URLs",
+	}
+	flags := cmd.Flags()
+	caseSensitive := flags.Bool("case-sensitive", false, "match case exactly")
+	isRegex := flags.Bool("regex", false, "treat the pattern as a regular expression")
+	limit := flags.Int("limit", 0, "maximum number of entries to print (0 for all)")
+	tag := flags.String("tag", "", "only search entries with this tag")
+	kind := flags.String("type", "", "only search entries of this kind: "+strings.Join(contentKinds, ", "))
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		if len(positional) != 1 {
+			return fmt.Errorf("usage: tabd-native-host search [flags] <pattern>")
+		}
+		if *kind != "" && !validKind(*kind) {
+			return fmt.Errorf("--type must be one of %s", strings.Join(contentKinds, ", "))
+		}
+
+		matcher, err := compileSearchPattern(positional[0], *isRegex, *caseSensitive)
+		if err != nil {
+			return err
+		}
+
+		results, err := searchHistory(host.historyStore(), HistoryQuery{Tag: *tag, Kind: *kind}, matcher, *limit)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		for _, result := range results {
+			if err := encoder.Encode(result); err != nil {
+				return fmt.Errorf("failed to encode search result: %v", err)
+			}
+		}
+		return nil
+	})
+	return cmd
+}
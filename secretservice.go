@@ -0,0 +1,195 @@
+//go:build (dragonfly && cgo) || (freebsd && cgo) || linux || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	secretServiceName      = "org.freedesktop.secrets"
+	secretServicePath      = "/org/freedesktop/secrets"
+	secretServiceInterface = "org.freedesktop.Secret.Service"
+	secretPromptInterface  = "org.freedesktop.Secret.Prompt"
+
+	// loginCollectionPath is the collection go-keyring stores items in when
+	// it exists, falling back to the default collection
+	loginCollectionPath = "/org/freedesktop/secrets/collection/login"
+
+	// keyringUnlockTimeout bounds how long the CLI waits for the user to
+	// answer the keyring's unlock prompt
+	keyringUnlockTimeout = 2 * time.Minute
+)
+
+// diagnoseKeyring reports the state of the Secret Service on the D-Bus
+// session bus: no bus, no provider, no collection, a locked collection or
+// available, with a description for logs and doctor
+func diagnoseKeyring() (keyringState, string) {
+	if !hasSessionBus() {
+		return keyringNoBus, "no D-Bus session bus: DBUS_SESSION_BUS_ADDRESS is not set and $XDG_RUNTIME_DIR/bus does not exist"
+	}
+
+	type diagnosis struct {
+		state  keyringState
+		detail string
+	}
+	result := make(chan diagnosis, 1)
+	go func() {
+		state, detail := querySecretService()
+		result <- diagnosis{state, detail}
+	}()
+
+	select {
+	case d := <-result:
+		return d.state, d.detail
+	case <-time.After(keyringTimeout):
+		return keyringNoDaemon, "timed out waiting for the Secret Service provider"
+	}
+}
+
+// querySecretService asks the session bus for the Secret Service provider and
+// the lock state of its collection
+func querySecretService() (keyringState, string) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return keyringNoBus, fmt.Sprintf("cannot connect to the D-Bus session bus: %v", err)
+	}
+	defer conn.Close()
+
+	// A provider that is not running may still be started on demand
+	var running bool
+	if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, secretServiceName).Store(&running); err != nil {
+		return keyringNoDaemon, fmt.Sprintf("failed to look up %s on the session bus: %v", secretServiceName, err)
+	}
+	if !running {
+		var activatable []string
+		conn.BusObject().Call("org.freedesktop.DBus.ListActivatableNames", 0).Store(&activatable)
+		if !slices.Contains(activatable, secretServiceName) {
+			return keyringNoDaemon, "no Secret Service provider, such as gnome-keyring or KeePassXC, is running on the session bus"
+		}
+	}
+
+	path, err := secretCollection(conn)
+	if err != nil {
+		return keyringNoDaemon, fmt.Sprintf("the Secret Service provider did not answer: %v", err)
+	}
+	if path == "/" {
+		return keyringNoCollection, "the Secret Service has no login or default collection to store keys in"
+	}
+
+	locked, err := conn.Object(secretServiceName, path).GetProperty("org.freedesktop.Secret.Collection.Locked")
+	if err != nil {
+		return keyringNoDaemon, fmt.Sprintf("failed to read the lock state of %s: %v", path, err)
+	}
+	if value, ok := locked.Value().(bool); ok && value {
+		return keyringLocked, fmt.Sprintf("the keyring collection %s is locked", path)
+	}
+	return keyringAvailable, fmt.Sprintf("Secret Service collection %s is unlocked", path)
+}
+
+// secretCollection returns the path of the collection go-keyring uses, or
+// "/" if there is none
+func secretCollection(conn *dbus.Conn) (dbus.ObjectPath, error) {
+	service := conn.Object(secretServiceName, secretServicePath)
+	collections, err := service.GetProperty(secretServiceInterface + ".Collections")
+	if err != nil {
+		return "", err
+	}
+	if paths, ok := collections.Value().([]dbus.ObjectPath); ok && slices.Contains(paths, loginCollectionPath) {
+		return loginCollectionPath, nil
+	}
+
+	var path dbus.ObjectPath
+	if err := service.Call(secretServiceInterface+".ReadAlias", 0, "default").Store(&path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// hasSessionBus reports whether a D-Bus session bus appears to be reachable
+func hasSessionBus() bool {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+		return true
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		if _, err := os.Stat(filepath.Join(runtimeDir, "bus")); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDisplay reports whether the keyring can show its unlock prompt
+func hasDisplay() bool {
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// unlockKeyring asks the Secret Service to unlock its collection, which
+// shows the provider's own password prompt, and waits for the user to
+// answer it
+func unlockKeyring() error {
+	if !hasDisplay() {
+		return errors.New("the keyring is locked and cannot show its unlock prompt without a display; unlock it from a desktop session, or start gnome-keyring-daemon with --unlock, which reads the password from stdin")
+	}
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return fmt.Errorf("cannot connect to the D-Bus session bus: %v", err)
+	}
+	defer conn.Close()
+
+	path, err := secretCollection(conn)
+	if err != nil {
+		return fmt.Errorf("the Secret Service provider did not answer: %v", err)
+	}
+	if path == "/" {
+		return errors.New("the Secret Service has no login or default collection to unlock")
+	}
+
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	service := conn.Object(secretServiceName, secretServicePath)
+	if err := service.Call(secretServiceInterface+".Unlock", 0, []dbus.ObjectPath{path}).Store(&unlocked, &prompt); err != nil {
+		return fmt.Errorf("failed to unlock %s: %v", path, err)
+	}
+	if prompt == "/" {
+		return nil
+	}
+
+	// Subscribe before prompting, so the answer cannot be missed
+	match := []dbus.MatchOption{dbus.WithMatchObjectPath(prompt), dbus.WithMatchInterface(secretPromptInterface)}
+	if err := conn.AddMatchSignal(match...); err != nil {
+		return fmt.Errorf("failed to watch the unlock prompt: %v", err)
+	}
+	defer conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	if err := conn.Object(secretServiceName, prompt).Call(secretPromptInterface+".Prompt", 0, "").Err; err != nil {
+		return fmt.Errorf("failed to show the unlock prompt: %v", err)
+	}
+
+	timeout := time.After(keyringUnlockTimeout)
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != prompt || signal.Name != secretPromptInterface+".Completed" {
+				continue
+			}
+			if len(signal.Body) > 0 && signal.Body[0] == true {
+				return errors.New("the keyring unlock prompt was dismissed")
+			}
+			return nil
+		case <-timeout:
+			return errors.New("the keyring unlock prompt was not answered")
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !((dragonfly && cgo) || (freebsd && cgo) || linux || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// diagnoseKeyring reports nothing that keeps the Keychain, the Windows
+// Credential Manager or go-keyring's fallback from being used; probing them
+// tells whether they work
+func diagnoseKeyring() (keyringState, string) {
+	return keyringAvailable, "available"
+}
+
+// unlockKeyring fails, as only Secret Service collections are unlocked by
+// tabd
+func unlockKeyring() error {
+	return errors.New("only Secret Service keyrings on Linux and BSD can be unlocked by tabd-native-host")
+}
+
+// hasDisplay reports true, as the desktop shows keyring prompts itself
+func hasDisplay() bool {
+	return true
+}
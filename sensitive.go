@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// redactedText replaces sensitive matches when redaction is enabled
+const redactedText = "[REDACTED]"
+
+// sensitiveDetector finds one kind of sensitive content
+type sensitiveDetector struct {
+	name    string
+	pattern *regexp.Regexp
+
+	// validate optionally filters regex matches, e.g. with a checksum
+	validate func(match string) bool
+
+	// fallback detectors only run when no other detector matched
+	fallback bool
+}
+
+// builtinDetectors recognise common secrets and personal data
+var builtinDetectors = []sensitiveDetector{
+	{
+		name:     "credit_card",
+		pattern:  regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		validate: luhnValid,
+	},
+	{
+		name:    "aws_access_key",
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	},
+	{
+		name:    "aws_secret_key",
+		pattern: regexp.MustCompile(`(?i)aws_secret_access_key\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`),
+	},
+	{
+		name:    "jwt",
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+	},
+	{
+		name:    "password",
+		pattern: regexp.MustCompile(`(?i)\b(?:password|passwd|pwd)\s*[:=]\s*\S+`),
+	},
+	{
+		// A lone token mixing every character class, as copied from a
+		// password manager
+		name:     "password",
+		pattern:  regexp.MustCompile(`^\s*\S{8,64}\s*$`),
+		validate: looksLikePassword,
+		fallback: true,
+	},
+}
+
+// SensitiveScanner applies the configured policy to clipboard content that
+// matches a sensitive pattern
+type SensitiveScanner struct {
+	// mu guards the policy, which a config reload may replace
+	mu        sync.RWMutex
+	action    string
+	detectors []sensitiveDetector
+}
+
+// SensitiveReport describes the sensitive content found in a save
+type SensitiveReport struct {
+	Decision  string   `json:"decision"`
+	Sensitive []string `json:"sensitive"`
+}
+
+// NewSensitiveScanner builds a scanner from the built-in detectors and the
+// custom patterns in the config
+func NewSensitiveScanner(config *Config) (*SensitiveScanner, error) {
+	scanner := &SensitiveScanner{action: config.SensitiveAction}
+	var fallbacks []sensitiveDetector
+	for _, detector := range builtinDetectors {
+		if detector.fallback {
+			fallbacks = append(fallbacks, detector)
+		} else {
+			scanner.detectors = append(scanner.detectors, detector)
+		}
+	}
+
+	names := make([]string, 0, len(config.SensitivePatterns))
+	for name := range config.SensitivePatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		pattern, err := regexp.Compile(config.SensitivePatterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sensitive pattern %s: %v", name, err)
+		}
+		scanner.detectors = append(scanner.detectors, sensitiveDetector{name: name, pattern: pattern})
+	}
+
+	// Fallback detectors run last so they can defer to every other match
+	scanner.detectors = append(scanner.detectors, fallbacks...)
+
+	return scanner, nil
+}
+
+// matches returns the spans of text matched by any detector and the names of
+// the detectors that matched
+func (s *SensitiveScanner) matches(text string, kinds map[string]bool) [][]int {
+	var spans [][]int
+	for _, detector := range s.detectors {
+		if detector.fallback && len(spans) > 0 {
+			continue
+		}
+		for _, span := range detector.pattern.FindAllStringIndex(text, -1) {
+			if detector.validate != nil && !detector.validate(text[span[0]:span[1]]) {
+				continue
+			}
+			kinds[detector.name] = true
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// redact replaces the given spans of text, merging any overlaps
+func redact(text string, spans [][]int) string {
+	if len(spans) == 0 {
+		return text
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	var builder strings.Builder
+	last := 0
+	for _, span := range spans {
+		if span[1] <= last {
+			continue
+		}
+		if span[0] > last {
+			builder.WriteString(text[last:span[0]])
+		}
+		builder.WriteString(redactedText)
+		last = span[1]
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+// Apply scans the text and flavors of data, tagging or redacting it in place
+// according to the policy. It returns nil when nothing sensitive was found,
+// and a report with the "refused" decision when the data must not be stored.
+func (s *SensitiveScanner) Apply(data *ClipboardData) *SensitiveReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.action == "off" {
+		return nil
+	}
+
+	kinds := make(map[string]bool)
+	textSpans := s.matches(data.Text, kinds)
+	flavorSpans := make(map[string][][]int, len(data.Flavors))
+	for mimeType, value := range data.Flavors {
+		flavorSpans[mimeType] = s.matches(value, kinds)
+	}
+	if len(kinds) == 0 {
+		return nil
+	}
+
+	report := &SensitiveReport{Sensitive: make([]string, 0, len(kinds))}
+	for kind := range kinds {
+		report.Sensitive = append(report.Sensitive, kind)
+	}
+	sort.Strings(report.Sensitive)
+
+	switch s.action {
+	case "refuse":
+		report.Decision = "refused"
+	case "redact":
+		report.Decision = "redacted"
+		data.Text = redact(data.Text, textSpans)
+		for mimeType, spans := range flavorSpans {
+			data.Flavors[mimeType] = redact(data.Flavors[mimeType], spans)
+		}
+		data.Sensitive = report.Sensitive
+	default:
+		report.Decision = "tagged"
+		data.Sensitive = report.Sensitive
+	}
+
+	return report
+}
+
+// redactSensitive returns the entry as sent outside the host. An entry marked
+// sensitive is copied with its text and flavors replaced, so content the host
+// only tagged does not leave it; its sensitive kinds are kept.
+func redactSensitive(data *ClipboardData) *ClipboardData {
+	if len(data.Sensitive) == 0 {
+		return data
+	}
+	redacted := *data
+	redacted.Text = redactedText
+	redacted.Flavors = nil
+	return &redacted
+}
+
+// replace switches to the policy of another scanner, as built from a
+// reloaded config
+func (s *SensitiveScanner) replace(other *SensitiveScanner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.action = other.action
+	s.detectors = other.detectors
+}
+
+// luhnValid reports whether a card number passes the Luhn checksum
+func luhnValid(number string) bool {
+	sum := 0
+	digits := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+		double = !double
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// looksLikePassword reports whether a token mixes lower and upper case
+// letters, digits and symbols
+func looksLikePassword(token string) bool {
+	token = strings.TrimSpace(token)
+	if strings.Contains(token, "://") {
+		return false
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range token {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	return lower && upper && digit && symbol
+}
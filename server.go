@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// defaultListenAddress is where the HTTP API listens unless --listen is given
+	defaultListenAddress = "127.0.0.1:8745"
+
+	// apiTokenKey is the storage key holding the HTTP API bearer token
+	apiTokenKey = "api_token"
+)
+
+// httpStatusCodes maps tabd error codes to HTTP status codes. Codes missing
+// here, such as storage failures, are internal server errors.
+var httpStatusCodes = map[string]int{
+	codeTooLarge:       http.StatusRequestEntityTooLarge,
+	codeLocked:         http.StatusLocked,
+	codeKeychainDenied: http.StatusForbidden,
+	codeRateLimited:    http.StatusTooManyRequests,
+	codeNotFound:       http.StatusNotFound,
+	codeInvalidRequest: http.StatusBadRequest,
+	codeNotPermitted:   http.StatusForbidden,
+	codeBlocked:        http.StatusForbidden,
+	codeRefused:        http.StatusForbidden,
+	codeTimeout:        http.StatusServiceUnavailable,
+	codeCancelled:      http.StatusServiceUnavailable,
+}
+
+// APIServer exposes clipboard storage over a local HTTP API
+type APIServer struct {
+	host  *TabdNativeHost
+	token string
+	mux   *http.ServeMux
+
+	// Each client address gets a session of its own, so one busy client
+	// does not use up the rate limit of the others. Only clients holding
+	// the token get one, so the map stays small.
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewAPIServer creates an API server authenticating requests with token
+func NewAPIServer(host *TabdNativeHost, token string) *APIServer {
+	s := &APIServer{
+		host:     host,
+		token:    token,
+		mux:      http.NewServeMux(),
+		sessions: make(map[string]*Session),
+	}
+
+	s.mux.HandleFunc("GET /clipboard/latest", s.handleLatest)
+	s.mux.HandleFunc("POST /clipboard", s.handleSave)
+	s.mux.HandleFunc("GET /history", s.handleHistory)
+	s.mux.HandleFunc("GET /metrics", host.handleMetrics)
+
+	return s
+}
+
+// loadOrCreateAPIToken retrieves the API token from secure storage, creating
+// a new random token on first use or when rotate is set
+func loadOrCreateAPIToken(storage SecureStorage, rotate bool) (string, error) {
+	if !rotate {
+		token, err := storage.Retrieve(apiTokenKey)
+		if err == nil {
+			return string(token), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to retrieve API token: %v", err)
+		}
+	}
+
+	tokenBytes := make([]byte, 32)
+	rand.Read(tokenBytes)
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	if err := storage.Store(apiTokenKey, []byte(token)); err != nil {
+		return "", fmt.Errorf("failed to store API token: %v", err)
+	}
+	return token, nil
+}
+
+// ServeHTTP authenticates the request and routes it to an endpoint
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tabd"`)
+		writeJSONError(w, http.StatusUnauthorized, codeNotPermitted, "missing or invalid bearer token")
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized checks the bearer token in constant time
+func (s *APIServer) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// session returns the session of the client that sent a request
+func (s *APIServer) session(r *http.Request) *Session {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[client]
+	if !ok {
+		session = s.host.NewSession(nil, nil)
+		s.sessions[client] = session
+	}
+	return session
+}
+
+// handleLatest returns the latest clipboard entry
+func (s *APIServer) handleLatest(w http.ResponseWriter, r *http.Request) {
+	data, err := s.host.getClipboardData(r.Context())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeJSONError(w, http.StatusNotFound, codeNotFound, "no clipboard data stored")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, errorCode(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, data)
+}
+
+// handleSave stores a clipboard entry posted as JSON
+func (s *APIServer) handleSave(w http.ResponseWriter, r *http.Request) {
+	var msg Message
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTransferSize)).Decode(&msg.ClipboardData); err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("invalid clipboard data: %v", err))
+		return
+	}
+	msg.Action = "save"
+
+	response := s.host.dispatch(r.Context(), s.session(r), &msg)
+	if response.Status != "success" {
+		status, ok := httpStatusCodes[response.Code]
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		if limited, ok := response.Data.(*rateLimitResult); ok {
+			// Retry-After is in whole seconds
+			w.Header().Set("Retry-After", strconv.FormatInt((limited.RetryAfter+999)/1000, 10))
+		}
+		writeJSON(w, status, response)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// handleHistory returns clipboard history filtered by query parameters
+func (s *APIServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	query, err := parseHistoryQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	entries, err := s.host.historyStore().Query(query)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errorCode(err), err.Error())
+		return
+	}
+
+	records := make([]*HistoryRecord, 0, len(entries))
+	for _, entry := range entries {
+		data, err := s.host.historyStore().Get(entry.ID)
+		if err != nil {
+			logWarnf("Skipping unreadable history entry %s: %v", entry.ID, err)
+			continue
+		}
+		records = append(records, &HistoryRecord{ID: entry.ID, Pinned: entry.Pinned, ClipboardData: *data})
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
+
+// parseHistoryQuery reads history filters from the request's query string
+func parseHistoryQuery(r *http.Request) (HistoryQuery, error) {
+	values := r.URL.Query()
+	query := HistoryQuery{
+		URL:    values.Get("url"),
+		Type:   values.Get("type"),
+		Origin: values.Get("origin"),
+		Kind:   values.Get("kind"),
+		Tag:    values.Get("tag"),
+		Limit:  20,
+	}
+
+	intParams := map[string]*int{"limit": &query.Limit, "offset": &query.Offset}
+	for name, target := range intParams {
+		if value := values.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return query, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			*target = parsed
+		}
+	}
+
+	int64Params := map[string]*int64{"since": &query.Since, "until": &query.Until}
+	for name, target := range int64Params {
+		if value := values.Get(name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			*target = parsed
+		}
+	}
+
+	if query.Kind != "" && !validKind(query.Kind) {
+		return query, fmt.Errorf("invalid kind: %q", query.Kind)
+	}
+
+	return query, nil
+}
+
+// writeJSON writes a JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeJSONError writes a JSON error response with an error code
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, &Response{
+		Status:    "error",
+		Code:      code,
+		Message:   message,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// newServeCommand creates the serve command, which starts the local HTTP API
+// server
+func newServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the local HTTP API server",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	listen := flags.String("listen", defaultListenAddress, "address to listen on")
+	rotateToken := flags.Bool("rotate-token", false, "generate a new API token before starting")
+	takeover := flags.Bool("takeover", false, "replace an HTTP API server already running for this storage directory")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		guard, err := acquireInstance(host.tabdDir, "serve", *takeover)
+		if err != nil {
+			return err
+		}
+		defer guard.Release()
+
+		host.audit.SetActor("http")
+		token, err := loadOrCreateAPIToken(host.storage(), *rotateToken)
+		if err != nil {
+			return err
+		}
+
+		server := &http.Server{
+			Addr:              *listen,
+			Handler:           NewAPIServer(host, token),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		shutdown, stopped := shutdownRequests()
+		defer stopped()
+		go func() {
+			reason := <-shutdown
+			logInfof("Received %v, shutting down HTTP API", reason)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+		}()
+
+		fmt.Fprintf(os.Stderr, "Listening on http://%s\n", *listen)
+		fmt.Fprintf(os.Stderr, "API token: %s\n", token)
+
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	})
+	return cmd
+}
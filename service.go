@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// serviceCommands are the long-running commands that can be installed as a
+// service
+var serviceCommands = []string{"daemon", "serve"}
+
+// serviceDefinition describes a command registered with the OS service
+// manager
+type serviceDefinition struct {
+	// name identifies the service, e.g. tabd-daemon or tabd-daemon-work for
+	// a profile
+	name        string
+	description string
+	executable  string
+	args        []string
+}
+
+// serviceName returns the name a command is registered under for the
+// current profile
+func serviceName(command string) string {
+	name := "tabd-" + command
+	if profile := currentProfile(); profile != "" {
+		name += "-" + profile
+	}
+	return name
+}
+
+// newServiceDefinition describes a service running command with args from
+// this executable, selecting the current profile and config file
+func newServiceDefinition(command string, args []string) (*serviceDefinition, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine executable path: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	globalArgs, err := configPathArgs()
+	if err != nil {
+		return nil, err
+	}
+	if profile := currentProfile(); profile != "" {
+		globalArgs = append(globalArgs, "--profile", profile)
+	}
+
+	description := "Tab'd clipboard daemon"
+	if command == "serve" {
+		description = "Tab'd clipboard HTTP API"
+	}
+	return &serviceDefinition{
+		name:        serviceName(command),
+		description: description,
+		executable:  executable,
+		args:        append(append(globalArgs, command), args...),
+	}, nil
+}
+
+// newServiceCommand creates the service command, which registers the daemon or
+// HTTP API with the OS service manager (systemd --user, launchd or the Windows
+// Service Manager) and controls it
+func newServiceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service install [-- <command flags>] | uninstall | start | stop",
+		Short: "Install, uninstall, start or stop the daemon or HTTP API as a service",
+	}
+	flags := cmd.Flags()
+	command := flags.String("run", "daemon", "command to run as a service: "+strings.Join(serviceCommands, " or "))
+	cmd.RunE = func(_ *cobra.Command, positional []string) error {
+		if !validServiceCommand(*command) {
+			return fmt.Errorf("--run must be %s", strings.Join(serviceCommands, " or "))
+		}
+
+		usage := fmt.Errorf("usage: service [--run daemon|serve] install [-- <command flags>] | uninstall | start | stop")
+		if len(positional) == 0 {
+			return usage
+		}
+		name := serviceName(*command)
+
+		switch positional[0] {
+		case "install":
+			definition, err := newServiceDefinition(*command, positional[1:])
+			if err != nil {
+				return err
+			}
+			if err := installService(definition); err != nil {
+				return fmt.Errorf("failed to install service %s: %v", name, err)
+			}
+			fmt.Printf("Installed and started service %s: %s\n", name, strings.Join(definition.args, " "))
+			return nil
+		case "uninstall", "start", "stop":
+			if len(positional) != 1 {
+				return usage
+			}
+			actions := map[string]struct {
+				run  func(string) error
+				done string
+			}{
+				"uninstall": {uninstallService, "Uninstalled"},
+				"start":     {startService, "Started"},
+				"stop":      {stopService, "Stopped"},
+			}
+			action := actions[positional[0]]
+			if err := action.run(name); err != nil {
+				return fmt.Errorf("failed to %s service %s: %v", positional[0], name, err)
+			}
+			fmt.Printf("%s service %s\n", action.done, name)
+			return nil
+		default:
+			return fmt.Errorf("unknown service command: %s", positional[0])
+		}
+	}
+	return cmd
+}
+
+// validServiceCommand reports whether command can run as a service
+func validServiceCommand(command string) bool {
+	for _, known := range serviceCommands {
+		if command == known {
+			return true
+		}
+	}
+	return false
+}
+
+// runServiceManager runs a service manager command, including its output in
+// the error if it fails
+func runServiceManager(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, message)
+		}
+		return fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// shutdownRequests delivers the reason a long-running command is asked to
+// stop: SIGINT, SIGTERM, or a stop request from the Windows Service Manager
+// when running as a service. The returned function must be called once the
+// command has shut down.
+func shutdownRequests() (<-chan string, func()) {
+	reasons := make(chan string, 1)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	serviceStop, serviceDone := serviceStopRequests()
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			reasons <- sig.String()
+		case <-serviceStop:
+			reasons <- "service stop"
+		case <-done:
+		}
+	}()
+
+	return reasons, func() {
+		signal.Stop(signals)
+		close(done)
+		serviceDone()
+	}
+}
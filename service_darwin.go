@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// launchdLabel returns the launchd label of a service
+func launchdLabel(name string) string {
+	return nativeHostName + "." + name
+}
+
+// launchAgentPath returns where the launch agent for a service is written
+func launchAgentPath(name string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %v", err)
+	}
+	return filepath.Join(homeDir, "Library", "LaunchAgents", launchdLabel(name)+".plist"), nil
+}
+
+// launchdDomain is the launchd domain of the logged-in user's agents
+func launchdDomain() string {
+	return fmt.Sprintf("gui/%d", os.Getuid())
+}
+
+// plistString returns a plist string element holding value
+func plistString(value string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(value))
+	return "<string>" + escaped.String() + "</string>"
+}
+
+// installService writes a launch agent and loads it, starting it now and at
+// every login. It is restarted if it exits with an error.
+func installService(definition *serviceDefinition) error {
+	path, err := launchAgentPath(definition.name)
+	if err != nil {
+		return err
+	}
+
+	var arguments bytes.Buffer
+	arguments.WriteString("\t\t" + plistString(definition.executable) + "\n")
+	for _, arg := range definition.args {
+		arguments.WriteString("\t\t" + plistString(arg) + "\n")
+	}
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	%s
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+</dict>
+</plist>
+`, plistString(launchdLabel(definition.name)), arguments.String())
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create LaunchAgents directory: %v", err)
+	}
+	// Unload an earlier version first, as bootstrap refuses loaded agents
+	runServiceManager("launchctl", "bootout", launchdDomain()+"/"+launchdLabel(definition.name))
+	if err := writeFileAtomic(path, []byte(plist), 0644); err != nil {
+		return fmt.Errorf("failed to write launch agent: %v", err)
+	}
+	return runServiceManager("launchctl", "bootstrap", launchdDomain(), path)
+}
+
+// uninstallService unloads the launch agent and removes it
+func uninstallService(name string) error {
+	path, err := launchAgentPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("not installed (no launch agent at %s)", path)
+	}
+	if err := runServiceManager("launchctl", "bootout", launchdDomain()+"/"+launchdLabel(name)); err != nil {
+		logWarnf("Error unloading service %s: %v", name, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove launch agent: %v", err)
+	}
+	return nil
+}
+
+// startService loads the launch agent if it was stopped and starts it
+func startService(name string) error {
+	path, err := launchAgentPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("not installed (no launch agent at %s)", path)
+	}
+	// Bootstrap fails harmlessly if the agent is still loaded
+	runServiceManager("launchctl", "bootstrap", launchdDomain(), path)
+	return runServiceManager("launchctl", "kickstart", launchdDomain()+"/"+launchdLabel(name))
+}
+
+// stopService unloads the launch agent, which stops it until it is started
+// again or the user next logs in
+func stopService(name string) error {
+	return runServiceManager("launchctl", "bootout", launchdDomain()+"/"+launchdLabel(name))
+}
+
+// serviceStopRequests returns no stop requests: launchd stops agents with
+// SIGTERM
+func serviceStopRequests() (<-chan struct{}, func()) {
+	return nil, func() {}
+}
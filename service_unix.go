@@ -0,0 +1,101 @@
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemdUnitPath returns where the systemd user unit for a service is written
+func systemdUnitPath(name string) (string, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %v", err)
+		}
+		configDir = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configDir, "systemd", "user", name+".service"), nil
+}
+
+// systemdQuote quotes an argument for a unit's ExecStart line, escaping the
+// characters systemd would otherwise expand
+func systemdQuote(arg string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$")
+	return `"` + replacer.Replace(arg) + `"`
+}
+
+// installService writes a systemd user unit and enables it, starting it now
+// and at every login
+func installService(definition *serviceDefinition) error {
+	path, err := systemdUnitPath(definition.name)
+	if err != nil {
+		return err
+	}
+
+	execStart := []string{systemdQuote(definition.executable)}
+	for _, arg := range definition.args {
+		execStart = append(execStart, systemdQuote(arg))
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=%s
+
+[Service]
+ExecStart=%s
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=default.target
+`, definition.description, strings.Join(execStart, " "))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create unit directory: %v", err)
+	}
+	if err := writeFileAtomic(path, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("failed to write unit: %v", err)
+	}
+	if err := runServiceManager("systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	return runServiceManager("systemctl", "--user", "enable", "--now", definition.name+".service")
+}
+
+// uninstallService stops and disables the unit and removes it
+func uninstallService(name string) error {
+	path, err := systemdUnitPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("not installed (no unit at %s)", path)
+	}
+	if err := runServiceManager("systemctl", "--user", "disable", "--now", name+".service"); err != nil {
+		logWarnf("Error disabling service %s: %v", name, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove unit: %v", err)
+	}
+	return runServiceManager("systemctl", "--user", "daemon-reload")
+}
+
+// startService starts the installed unit
+func startService(name string) error {
+	return runServiceManager("systemctl", "--user", "start", name+".service")
+}
+
+// stopService stops the running unit; it starts again at the next login
+func stopService(name string) error {
+	return runServiceManager("systemctl", "--user", "stop", name+".service")
+}
+
+// serviceStopRequests returns no stop requests: systemd stops services with
+// SIGTERM
+func serviceStopRequests() (<-chan struct{}, func()) {
+	return nil, func() {}
+}
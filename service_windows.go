@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopTimeout is how long the Service Manager is told a stop may take
+const serviceStopTimeout = 30 * time.Second
+
+// installService registers a service that starts automatically and runs as
+// the current user, so it shares the user's storage, keyring and clipboard.
+// Windows requires that user's password to start a service as them.
+func installService(definition *serviceDefinition) error {
+	current, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("failed to determine current user: %v", err)
+	}
+	password, err := readPassphrase(fmt.Sprintf("Windows password for %s: ", current.Username))
+	if err != nil {
+		return err
+	}
+
+	manager, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the Service Manager (run as administrator): %v", err)
+	}
+	defer manager.Disconnect()
+
+	if existing, err := manager.OpenService(definition.name); err == nil {
+		existing.Close()
+		return fmt.Errorf("already installed; uninstall it first")
+	}
+
+	service, err := manager.CreateService(definition.name, definition.executable, mgr.Config{
+		DisplayName:      definition.description,
+		Description:      definition.description,
+		StartType:        mgr.StartAutomatic,
+		ServiceStartName: current.Username,
+		Password:         password,
+	}, definition.args...)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	// Restart the service if it fails, backing off a little
+	recovery := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+	}
+	if err := service.SetRecoveryActions(recovery, uint32((24 * time.Hour).Seconds())); err != nil {
+		logWarnf("Error setting recovery actions for service %s: %v", definition.name, err)
+	}
+	return service.Start()
+}
+
+// openService opens an installed service by name
+func openService(name string) (*mgr.Mgr, *mgr.Service, error) {
+	manager, err := mgr.Connect()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to the Service Manager (run as administrator): %v", err)
+	}
+	service, err := manager.OpenService(name)
+	if err != nil {
+		manager.Disconnect()
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			return nil, nil, errors.New("not installed")
+		}
+		return nil, nil, err
+	}
+	return manager, service, nil
+}
+
+// uninstallService stops the service if it is running and deletes it
+func uninstallService(name string) error {
+	manager, service, err := openService(name)
+	if err != nil {
+		return err
+	}
+	defer manager.Disconnect()
+	defer service.Close()
+
+	if err := stopRunningService(service); err != nil {
+		logWarnf("Error stopping service %s: %v", name, err)
+	}
+	return service.Delete()
+}
+
+// startService starts the installed service
+func startService(name string) error {
+	manager, service, err := openService(name)
+	if err != nil {
+		return err
+	}
+	defer manager.Disconnect()
+	defer service.Close()
+	return service.Start()
+}
+
+// stopService stops the running service; it starts again at the next boot
+func stopService(name string) error {
+	manager, service, err := openService(name)
+	if err != nil {
+		return err
+	}
+	defer manager.Disconnect()
+	defer service.Close()
+	return stopRunningService(service)
+}
+
+// stopRunningService asks a service to stop and waits until it has
+func stopRunningService(service *mgr.Service) error {
+	status, err := service.Query()
+	if err != nil {
+		return err
+	}
+	if status.State == svc.Stopped {
+		return nil
+	}
+	if status, err = service.Control(svc.Stop); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("still running after %s", serviceStopTimeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = service.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceHandler reports the state of a command running as a service to the
+// Service Manager and passes on its stop requests
+type serviceHandler struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopTimeout.Milliseconds())}
+				close(h.stop)
+				select {
+				case <-h.done:
+				case <-time.After(serviceStopTimeout):
+				}
+				return false, 0
+			}
+		case <-h.done:
+			// The command stopped by itself, e.g. after an error
+			return false, 0
+		}
+	}
+}
+
+// serviceStopRequests starts answering the Service Manager if the process
+// was started as a service, returning a channel closed when it asks the
+// service to stop and a function to call once the command has stopped
+func serviceStopRequests() (<-chan struct{}, func()) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return nil, func() {}
+	}
+
+	handler := &serviceHandler{stop: make(chan struct{}), done: make(chan struct{})}
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		// The name is ignored for services that run in their own process
+		if err := svc.Run("tabd", handler); err != nil {
+			logErrorf("Error running as a service: %v", err)
+		}
+	}()
+
+	var once sync.Once
+	return handler.stop, func() {
+		once.Do(func() {
+			close(handler.done)
+			<-finished
+		})
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+)
+
+const (
+	// messageQueueSize is the number of messages read ahead of processing
+	messageQueueSize = 32
+
+	// busyThreshold is the queue length at which the peer is asked to pause,
+	// and resumeThreshold the length at which it may continue
+	busyThreshold   = 24
+	resumeThreshold = 8
+)
+
+// Session is a single connection speaking the native messaging protocol,
+// either the browser extension over stdio or a local client of the daemon
+type Session struct {
+	host    *TabdNativeHost
+	reader  io.Reader
+	writer  io.Writer
+	writeMu sync.Mutex
+
+	// Reassembles messages sent in several chunks
+	chunks *chunkAssembler
+
+	// Negotiated with the peer through the hello handshake
+	protocolVersion int
+	features        []string
+
+	// frameLimit is the largest frame sent to the peer; larger responses are
+	// split into chunks
+	frameLimit int
+
+	// wireFormat encodes frames after the handshake. allowMsgpack is set for
+	// local clients of the daemon; the browser leg always uses JSON.
+	// nextWireFormat takes effect once the hello response has been sent.
+	formatMu       sync.Mutex
+	wireFormat     string
+	allowMsgpack   bool
+	nextWireFormat string
+
+	// peer names the paired LAN host on the other end. allowedActions, when
+	// set, are the only actions the session may send: those permitted to a
+	// LAN peer or to a browser extension restricted by the config. Local
+	// sessions leave both unset.
+	peer           string
+	allowedActions map[string]bool
+
+	// limiter refuses messages sent faster than the configured rate
+	limiter *tokenBucket
+
+	// lastMessage is when a message last arrived, in Unix nanoseconds
+	lastMessage atomic.Int64
+
+	// Whether the peer was last told the host is busy
+	flowMu sync.Mutex
+	busy   bool
+
+	// Closed by Stop to end the session
+	quit       chan struct{}
+	stopOnce   sync.Once
+	stopReason string
+
+	// ctx is the parent of the context of each message handled, cancelled
+	// by Stop so storage work outstanding is abandoned
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// NewSession creates a protocol session reading requests from r and writing
+// responses to w
+func (t *TabdNativeHost) NewSession(r io.Reader, w io.Writer) *Session {
+	s := &Session{
+		host:            t,
+		reader:          r,
+		writer:          w,
+		chunks:          newChunkAssembler(),
+		protocolVersion: minProtocolVersion,
+		features:        hostFeatures(),
+		frameLimit:      maxMessageSize,
+		wireFormat:      wireJSON,
+		limiter:         newTokenBucket(t.config.RateLimit, t.config.RateBurst),
+		quit:            make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancelCause(context.Background())
+	s.lastMessage.Store(time.Now().UnixNano())
+	return s
+}
+
+// permitted reports whether the session may send an action
+func (s *Session) permitted(action string) bool {
+	return s.allowedActions == nil || s.allowedActions[action]
+}
+
+// serve reads and handles messages until the peer disconnects or the session
+// is stopped, receiving events broadcast by the host in the meantime
+func (s *Session) serve() error {
+	s.host.sessionsMu.Lock()
+	s.host.sessions[s] = true
+	s.host.sessionsMu.Unlock()
+
+	defer func() {
+		s.host.sessionsMu.Lock()
+		delete(s.host.sessions, s)
+		s.host.sessionsMu.Unlock()
+	}()
+
+	// Messages are read ahead into a queue and handled in order, so the peer
+	// can be told to throttle before reads start blocking
+	queue := make(chan []byte, messageQueueSize)
+	go s.readLoop(s.ctx, queue)
+
+	for {
+		// A stop request wins over queued messages. The message being handled
+		// is still answered, though its context is cancelled.
+		select {
+		case <-s.quit:
+			s.shutdown(len(queue))
+			return nil
+		default:
+		}
+
+		select {
+		case messageData, ok := <-queue:
+			if !ok {
+				// Queued messages are finished before a disconnected session ends
+				return nil
+			}
+			if err := s.safeHandleMessage(s.ctx, messageData); err != nil {
+				logErrorf("Error handling message: %v", err)
+			}
+			s.updateFlow(len(queue))
+		case <-s.quit:
+			s.shutdown(len(queue))
+			return nil
+		}
+	}
+}
+
+// readLoop reads messages from the peer into queue, closing it once the peer
+// disconnects
+func (s *Session) readLoop(ctx context.Context, queue chan<- []byte) {
+	for {
+		messageData, err := s.readMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				close(queue)
+				return
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+				logInfof("Client disconnected")
+				close(queue)
+				return
+			}
+			logErrorf("Error reading message: %v", err)
+
+			// The peer is told about messages that were skipped whole
+			var coded *codedError
+			if errors.As(err, &coded) {
+				responseData, _ := s.encode(&Response{
+					Status:    "error",
+					Code:      coded.code,
+					Message:   err.Error(),
+					Timestamp: time.Now().Unix(),
+					Version:   version,
+				})
+				if err := s.sendMessage(responseData); err != nil {
+					logErrorf("Error sending response: %v", err)
+				}
+			}
+			continue
+		}
+		s.lastMessage.Store(time.Now().UnixNano())
+
+		select {
+		case queue <- messageData:
+		case <-s.quit:
+			return
+		}
+		s.updateFlow(len(queue))
+	}
+}
+
+// Stop ends the session after the message being handled, telling the peer
+// why with a shutdown event. The message's context is cancelled, so storage
+// work it has yet to start is skipped and it is answered with CANCELLED.
+func (s *Session) Stop(reason string) {
+	s.stopOnce.Do(func() {
+		s.stopReason = reason
+		s.cancel(errSessionStopped)
+		close(s.quit)
+	})
+}
+
+// stopped returns why the session was stopped, or "" if it was not
+func (s *Session) stopped() string {
+	select {
+	case <-s.quit:
+		return s.stopReason
+	default:
+		return ""
+	}
+}
+
+// stopWhenIdle stops the session once no message has arrived for timeout
+func (s *Session) stopWhenIdle(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-timer.C:
+			idle := time.Since(time.Unix(0, s.lastMessage.Load()))
+			if idle >= timeout {
+				logInfof("No messages for %v, shutting down", timeout)
+				s.Stop("idle")
+				return
+			}
+			timer.Reset(timeout - idle)
+		}
+	}
+}
+
+// shutdown sends the shutdown event and flushes the writer
+func (s *Session) shutdown(pending int) {
+	logAttrs(slog.LevelInfo, "Session shutting down", slog.String("reason", s.stopReason), slog.Int("dropped", pending))
+	// A peer that disconnected cannot be told
+	if s.stopReason == "disconnected" {
+		return
+	}
+	s.sendEvent(&Event{
+		Event:     "shutdown",
+		Data:      map[string]interface{}{"reason": s.stopReason, "dropped": pending},
+		Timestamp: time.Now().Unix(),
+	})
+
+	if flusher, ok := s.writer.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			logErrorf("Error flushing output: %v", err)
+		}
+	}
+}
+
+// updateFlow sends a busy event when the queue of unprocessed messages
+// reaches busyThreshold, and a resume event once it drains to
+// resumeThreshold
+func (s *Session) updateFlow(pending int) {
+	if !s.hasFeature("flow_control") {
+		return
+	}
+
+	s.flowMu.Lock()
+	var event string
+	switch {
+	case !s.busy && pending >= busyThreshold:
+		s.busy = true
+		event = "busy"
+	case s.busy && pending <= resumeThreshold:
+		s.busy = false
+		event = "resume"
+	}
+	s.flowMu.Unlock()
+
+	if event == "" {
+		return
+	}
+
+	logAttrs(slog.LevelDebug, "Flow control", slog.String("event", event), slog.Int("pending", pending))
+	s.sendEvent(&Event{
+		Event:     event,
+		Data:      map[string]int{"pending": pending},
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// sendEvent sends an unsolicited event message to the peer
+func (s *Session) sendEvent(event *Event) {
+	eventData, err := s.encode(event)
+	if err != nil {
+		logErrorf("Error marshaling %s event: %v", event.Event, err)
+		return
+	}
+	if err := s.sendResponse(eventData); err != nil {
+		logErrorf("Error sending %s event: %v", event.Event, err)
+	}
+}
+
+// format returns the session's current wire format
+func (s *Session) format() string {
+	s.formatMu.Lock()
+	defer s.formatMu.Unlock()
+	return s.wireFormat
+}
+
+// encode marshals a message in the session's wire format
+func (s *Session) encode(v interface{}) ([]byte, error) {
+	if s.format() == wireMsgpack {
+		return msgpackMarshal(v)
+	}
+	return json.Marshal(v)
+}
+
+// decode unmarshals a message in the session's wire format
+func (s *Session) decode(data []byte, v interface{}) error {
+	if s.format() == wireMsgpack {
+		return msgpackUnmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// readMessage reads a message using Chrome's native messaging format, unless
+// ctx is done. A read already waiting for the peer is not interrupted.
+func (s *Session) readMessage(ctx context.Context) ([]byte, error) {
+	if err := contextErr(ctx); err != nil {
+		return nil, err
+	}
+	message, err := protocol.ReadFrame(s.reader, int64(s.incomingLimit()))
+	var tooLarge *protocol.FrameTooLargeError
+	if errors.As(err, &tooLarge) {
+		return nil, &codedError{code: codeTooLarge, err: err}
+	}
+	return message, err
+}
+
+// sendMessage sends a message using Chrome's native messaging format
+func (s *Session) sendMessage(message []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	var err error
+	if writer, ok := s.writer.(frameWriter); ok {
+		err = writer.WriteFrame(message)
+	} else {
+		err = protocol.WriteFrame(s.writer, message)
+	}
+
+	// Nothing more can reach a peer that closed its end, so the session
+	// ends instead of failing every response after it
+	if err != nil && isBrokenPipe(err) {
+		s.Stop("disconnected")
+	}
+	return err
+}
+
+// handleMessage processes incoming messages from the browser extension
+func (s *Session) handleMessage(ctx context.Context, messageData []byte) error {
+	// Parse the message
+	var msg Message
+	if err := s.decode(messageData, &msg); err != nil {
+		return s.rejectMessage(messageData, fmt.Errorf("failed to parse message: %v", err))
+	}
+
+	// Chunks are buffered until the final one completes the real message
+	if msg.Action == "chunk" {
+		complete, err := s.receiveChunk(messageData)
+		if err != nil || complete == nil {
+			return err
+		}
+
+		msg = Message{}
+		if err := s.decode(complete, &msg); err != nil {
+			return s.rejectMessage(complete, fmt.Errorf("failed to parse chunked message: %v", err))
+		}
+	}
+
+	start := time.Now()
+	response := s.host.dispatch(ctx, s, &msg)
+
+	responseData, err := s.encode(response)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response: %v", err)
+	}
+
+	logAttrs(slog.LevelDebug, "Handled message",
+		slog.String("action", response.Action),
+		slog.String("status", response.Status),
+		slog.Int("size", len(messageData)),
+		slog.Int("responseSize", len(responseData)),
+		slog.Duration("duration", time.Since(start)))
+
+	err = s.sendResponse(responseData)
+
+	// The hello response goes out in the old format, later frames in the new
+	s.formatMu.Lock()
+	if s.nextWireFormat != "" {
+		logInfof("Switching wire format to %s", s.nextWireFormat)
+		s.wireFormat, s.nextWireFormat = s.nextWireFormat, ""
+	}
+	s.formatMu.Unlock()
+	return err
+}
+
+// rejectMessage answers a message that could not be decoded with an
+// INVALID_REQUEST error, so the peer is not left waiting for a response. The
+// message's action and id are echoed when they can still be read, e.g. when
+// only another field has the wrong type.
+func (s *Session) rejectMessage(messageData []byte, err error) error {
+	logWarnf("Rejected message: %v", err)
+
+	var request struct {
+		Action     string `json:"action"`
+		ID         string `json:"id"`
+		TransferID string `json:"transferId"`
+	}
+	s.decode(messageData, &request)
+
+	response := &Response{
+		Status:    "error",
+		Action:    request.Action,
+		Code:      codeInvalidRequest,
+		Message:   err.Error(),
+		Timestamp: time.Now().Unix(),
+		Version:   version,
+	}
+	switch {
+	case request.ID != "":
+		response.Data = map[string]interface{}{"id": request.ID}
+	case request.TransferID != "":
+		response.Data = map[string]interface{}{"transferId": request.TransferID}
+	}
+
+	responseData, encodeErr := s.encode(response)
+	if encodeErr != nil {
+		return encodeErr
+	}
+	return s.sendMessage(responseData)
+}
+
+// safeHandleMessage handles a message, recovering from a panic outside the
+// action handler by replying with an internal_error response
+func (s *Session) safeHandleMessage(ctx context.Context, messageData []byte) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			s.host.recordPanic("message loop", value)
+			responseData, encodeErr := s.encode(&Response{
+				Status:    "internal_error",
+				Code:      codeInternal,
+				Message:   "Internal error handling message",
+				Timestamp: time.Now().Unix(),
+				Version:   version,
+			})
+			if encodeErr != nil {
+				err = encodeErr
+				return
+			}
+			err = s.sendMessage(responseData)
+		}
+	}()
+	return s.handleMessage(ctx, messageData)
+}
+
+// receiveChunk adds a chunk to the reassembly buffer, acknowledging partial
+// transfers and returning the complete message after the final chunk
+func (s *Session) receiveChunk(messageData []byte) ([]byte, error) {
+	var chunk Chunk
+	if err := s.decode(messageData, &chunk); err != nil {
+		return nil, s.rejectMessage(messageData, fmt.Errorf("failed to parse chunk: %v", err))
+	}
+
+	// Chunks are assembled before dispatch, so each takes a token here. A
+	// refused chunk is not buffered and may be sent again.
+	if allowed, retryAfter, started := s.limiter.Allow(); !allowed {
+		if started {
+			logWarnf("Rate limiting messages: more than %g per second", s.host.config.RateLimit)
+		}
+		responseData, _ := s.encode(&Response{
+			Status:    "rate_limited",
+			Action:    "chunk",
+			Code:      codeRateLimited,
+			Message:   "Too many messages, retry later",
+			Data:      map[string]interface{}{"transferId": chunk.TransferID, "seq": chunk.Seq, "retryAfter": retryAfter.Milliseconds() + 1},
+			Timestamp: time.Now().Unix(),
+			Version:   version,
+		})
+		return nil, s.sendMessage(responseData)
+	}
+
+	complete, err := s.chunks.add(&chunk)
+	if err != nil {
+		responseData, _ := s.encode(&Response{
+			Status:    "error",
+			Action:    "chunk",
+			Code:      errorCode(err),
+			Message:   err.Error(),
+			Data:      map[string]interface{}{"transferId": chunk.TransferID, "seq": chunk.Seq},
+			Timestamp: time.Now().Unix(),
+			Version:   version,
+		})
+		return nil, s.sendMessage(responseData)
+	}
+
+	if complete == nil {
+		responseData, _ := s.encode(&Response{
+			Status:    "success",
+			Action:    "chunk",
+			Message:   "Chunk received",
+			Data:      map[string]interface{}{"transferId": chunk.TransferID, "seq": chunk.Seq},
+			Timestamp: time.Now().Unix(),
+			Version:   version,
+		})
+		return nil, s.sendMessage(responseData)
+	}
+
+	return complete, nil
+}
+
+// incomingLimit returns the largest frame accepted from the peer. Local
+// clients that may use MessagePack are not bound by the browser's frame limit
+// and can send large messages whole.
+func (s *Session) incomingLimit() int {
+	if s.allowMsgpack {
+		return maxTransferSize
+	}
+	return s.host.config.MaxMessageSize
+}
+
+// sendResponse sends an encoded response, splitting it into chunks when it
+// exceeds the frame limit agreed with the peer
+func (s *Session) sendResponse(responseData []byte) error {
+	if len(responseData) <= s.frameLimit || s.format() != wireJSON {
+		return s.sendMessage(responseData)
+	}
+
+	frames, err := protocol.SplitChunks(responseData, s.frameLimit)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		if err := s.sendMessage(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+)
+
+func TestUndecodableMessageIsAnswered(t *testing.T) {
+	host := newTestHost(t)
+	var out bytes.Buffer
+	session := host.NewSession(nil, &out)
+
+	for _, tc := range []struct {
+		frame  string
+		action string
+		id     string
+	}{
+		{`{not json`, "", ""},
+		{`{"action": "get", "id": "1718000000000000000-1a2b3c4d", "limit": "ten"}`, "get", "1718000000000000000-1a2b3c4d"},
+	} {
+		out.Reset()
+		if err := session.handleMessage(context.Background(), []byte(tc.frame)); err != nil {
+			t.Fatalf("%s: %v", tc.frame, err)
+		}
+
+		frame, err := protocol.ReadFrame(&out, maxMessageSize)
+		if err != nil {
+			t.Fatalf("%s: no response: %v", tc.frame, err)
+		}
+		var response struct {
+			Response
+			Data struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(frame, &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != codeInvalidRequest || response.Action != tc.action || response.Data.ID != tc.id {
+			t.Fatalf("%s: response = %s", tc.frame, frame)
+		}
+	}
+}
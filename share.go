@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// shareTimeout bounds each request made to the paste service
+	shareTimeout = time.Minute
+
+	// maxShareTTL is the longest a shared entry may stay on the paste service
+	maxShareTTL = 30 * 24 * time.Hour
+
+	// maxShareSize caps the blob downloaded by share open
+	maxShareSize = 64 * 1024 * 1024
+)
+
+// ShareConfig is the paste service shared entries are uploaded to. The
+// service accepts a multipart POST with the blob as "file" and its expiry in
+// milliseconds since the epoch as "expires", and answers with the blob's URL
+// in the body, as 0x0.st and its clones do.
+type ShareConfig struct {
+	URL string `json:"url"`
+
+	// Headers are sent with every upload, e.g. Authorization
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// applyDefaults checks that the paste service URL is an absolute http or
+// https URL
+func (s *ShareConfig) applyDefaults() error {
+	parsed, err := url.Parse(s.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid share url %q: must be an http or https URL", s.URL)
+	}
+	return nil
+}
+
+// sharedEntry is the content of an entry as shared: the clipboard data
+// without the tags and note the user assigned to organise their history
+func sharedEntry(data *ClipboardData) *ClipboardData {
+	shared := *data
+	shared.Tags = nil
+	shared.Note = ""
+	return &shared
+}
+
+// shareEntry encrypts an entry with a new random key, uploads it to the
+// paste service to expire after ttl, and returns a link to it with the key in
+// the fragment. Browsers and HTTP clients do not send the fragment, so the
+// service only ever sees ciphertext.
+func shareEntry(config *ShareConfig, data *ClipboardData, ttl time.Duration) (string, error) {
+	plaintext, err := json.Marshal(sharedEntry(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal entry: %v", err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate share key: %v", err)
+	}
+	fragment := base64.RawURLEncoding.EncodeToString(key)
+	blob, err := NewBlobCipher(fragment).Encrypt(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt entry: %v", err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "tabd-share.bin")
+	if err != nil {
+		return "", err
+	}
+	file.Write(blob)
+	form.WriteField("expires", strconv.FormatInt(time.Now().Add(ttl).UnixMilli(), 10))
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, config.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.Header.Set("User-Agent", "tabd-native-host/"+version)
+	for name, value := range config.Headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := (&http.Client{Timeout: shareTimeout}).Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to %s: %v", config.URL, err)
+	}
+	defer response.Body.Close()
+	answer, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the paste service response: %v", err)
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", fmt.Errorf("paste service returned %s: %s", response.Status, strings.TrimSpace(string(answer)))
+	}
+
+	link, err := url.Parse(strings.TrimSpace(string(answer)))
+	if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+		return "", fmt.Errorf("paste service did not answer with a URL: %q", strings.TrimSpace(string(answer)))
+	}
+	link.Fragment = fragment
+	return link.String(), nil
+}
+
+// openSharedEntry downloads and decrypts an entry shared with shareEntry
+func openSharedEntry(link string) (*ClipboardData, error) {
+	parsed, err := url.Parse(link)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("invalid share link %q", link)
+	}
+	fragment := parsed.Fragment
+	if fragment == "" {
+		return nil, fmt.Errorf("share link has no key: the part after # is missing")
+	}
+	parsed.Fragment = ""
+
+	request, err := http.NewRequest(http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", "tabd-native-host/"+version)
+	response, err := (&http.Client{Timeout: shareTimeout}).Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download shared entry: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone {
+		return nil, &codedError{code: codeNotFound, err: fmt.Errorf("shared entry not found: it may have expired")}
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("paste service returned %s", response.Status)
+	}
+	blob, err := io.ReadAll(io.LimitReader(response.Body, maxShareSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download shared entry: %v", err)
+	}
+	if len(blob) > maxShareSize {
+		return nil, fmt.Errorf("shared entry exceeds %d bytes", maxShareSize)
+	}
+
+	plaintext, err := NewBlobCipher(fragment).Decrypt(blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt shared entry: the link is incomplete or the content was changed")
+	}
+	var data ClipboardData
+	if err := json.Unmarshal(plaintext, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse shared entry: %v", err)
+	}
+	return &data, nil
+}
+
+// newShareCommand creates the share command, which uploads an entry to the
+// paste service and prints a link to it, or with open, prints or saves an
+// entry someone shared
+func newShareCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "share [<id>] | share open <link>",
+		Short: "Upload an end-to-end encrypted entry to a paste service and print an expiring link",
+	}
+	flags := cmd.Flags()
+	ttl := flags.String("ttl", "1h", "how long the paste service keeps the entry, e.g. 10m or 1d")
+	force := flags.Bool("force", false, "share an entry tagged as sensitive")
+	format := flags.String("format", "text", "share open output format: json, text, html, rtf, png or jpeg")
+	save := flags.Bool("save", false, "share open: save the entry to history instead of printing it")
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		usage := fmt.Errorf("usage: share [--ttl <age>] [--force] [<id>] | share open [--save] [--format <format>] <link>")
+
+		if len(positional) > 0 && positional[0] == "open" {
+			if len(positional) != 2 {
+				return usage
+			}
+			data, err := openSharedEntry(positional[1])
+			if err != nil {
+				return err
+			}
+			if *save {
+				data.Timestamp = time.Now().UnixMilli()
+				id, _, err := host.saveClipboardData(context.Background(), data)
+				if err != nil {
+					return fmt.Errorf("failed to save shared entry: %v", err)
+				}
+				fmt.Printf("Saved shared entry as %s\n", id)
+				return nil
+			}
+			return writeClipboardOutput(os.Stdout, data, *format)
+		}
+		if len(positional) > 1 {
+			return usage
+		}
+
+		if host.config.Share == nil {
+			return fmt.Errorf("no paste service configured; set \"share\": {\"url\": \"https://...\"} in the config file")
+		}
+		period, err := parseAge(*ttl)
+		if err != nil || period <= 0 || period > maxShareTTL {
+			return fmt.Errorf("--ttl must be a positive age of at most 30d")
+		}
+
+		var data *ClipboardData
+		if len(positional) == 1 {
+			data, err = host.historyStore().Get(positional[0])
+		} else {
+			data, err = host.getClipboardData(context.Background())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to retrieve clipboard data: %w", err)
+		}
+		if len(data.Sensitive) > 0 && !*force {
+			return fmt.Errorf("entry is tagged as sensitive (%s); use --force to share it anyway", strings.Join(data.Sensitive, ", "))
+		}
+
+		link, err := shareEntry(host.config.Share, data, period)
+		if err != nil {
+			return err
+		}
+		fmt.Println(link)
+		notef("Expires %s. Anyone with the full link can read the entry; open it with: tabd-native-host share open '<link>'\n",
+			time.Now().Add(period).Format("2006-01-02 15:04"))
+		return nil
+	})
+	return cmd
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// snippetsName is the secure storage key holding the saved snippets
+const snippetsName = "snippets"
+
+// placeholderPattern matches a {{name}} placeholder in a snippet
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([a-z]+)\s*\}\}`)
+
+// snippetPlaceholders are the placeholders a snippet may contain
+var snippetPlaceholders = []string{"date", "time", "clipboard", "url"}
+
+// Snippet is a named piece of text that may contain placeholders, expanded
+// when it is rendered
+type Snippet struct {
+	Name    string `json:"name"`
+	Text    string `json:"text"`
+	Updated int64  `json:"updated"`
+}
+
+// snippetValues are the values substituted for placeholders
+type snippetValues struct {
+	now       time.Time
+	clipboard string
+	url       string
+}
+
+// snippetResult is the data returned by a render_snippet message
+type snippetResult struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// validateSnippet checks a snippet's name, following the same rules as
+// profile names, and that it only uses known placeholders
+func validateSnippet(snippet *Snippet) error {
+	if !validProfile.MatchString(snippet.Name) {
+		return invalidRequestf("invalid snippet name %q: use letters, digits, '-' and '_'", snippet.Name)
+	}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(snippet.Text, -1) {
+		if !knownPlaceholder(match[1]) {
+			return invalidRequestf("unknown placeholder {{%s}} (available: %s)", match[1], strings.Join(snippetPlaceholders, ", "))
+		}
+	}
+	return nil
+}
+
+// knownPlaceholder reports whether name is one of the snippet placeholders
+func knownPlaceholder(name string) bool {
+	for _, placeholder := range snippetPlaceholders {
+		if name == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+// expandSnippet replaces the placeholders of a snippet's text. {{date}} and
+// {{time}} are the local date and time, {{clipboard}} the text of the latest
+// entry and {{url}} the page the snippet is rendered for.
+func expandSnippet(text string, values *snippetValues) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		switch placeholderPattern.FindStringSubmatch(placeholder)[1] {
+		case "date":
+			return values.now.Format("2006-01-02")
+		case "time":
+			return values.now.Format("15:04")
+		case "clipboard":
+			return values.clipboard
+		case "url":
+			return values.url
+		default:
+			return placeholder
+		}
+	})
+}
+
+// snippets returns the saved snippets, sorted by name
+func (t *TabdNativeHost) snippets() ([]Snippet, error) {
+	data, err := t.storage().Retrieve(snippetsName)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snippet{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snippets: %v", err)
+	}
+	var snippets []Snippet
+	if err := json.Unmarshal(data, &snippets); err != nil {
+		return nil, fmt.Errorf("failed to parse snippets: %v", err)
+	}
+	return snippets, nil
+}
+
+// getSnippet looks up a saved snippet by name
+func (t *TabdNativeHost) getSnippet(name string) (*Snippet, error) {
+	snippets, err := t.snippets()
+	if err != nil {
+		return nil, err
+	}
+	for i := range snippets {
+		if snippets[i].Name == name {
+			return &snippets[i], nil
+		}
+	}
+	return nil, &codedError{code: codeNotFound, err: fmt.Errorf("no snippet named %q", name)}
+}
+
+// updateSnippets replaces the snippet named name with snippet, or removes it
+// if snippet is nil, reporting whether a snippet of that name existed
+func (t *TabdNativeHost) updateSnippets(name string, snippet *Snippet) (bool, error) {
+	lock, err := acquireFileLock(storageLockPath(t.tabdDir))
+	if err != nil {
+		return false, err
+	}
+	defer lock.Release()
+
+	snippets, err := t.snippets()
+	if err != nil {
+		return false, err
+	}
+	kept := []Snippet{}
+	existed := false
+	for _, existing := range snippets {
+		if existing.Name == name {
+			existed = true
+			continue
+		}
+		kept = append(kept, existing)
+	}
+	if snippet != nil {
+		kept = append(kept, *snippet)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Name < kept[j].Name })
+
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal snippets: %v", err)
+	}
+	if err := t.storage().Store(snippetsName, data); err != nil {
+		return false, fmt.Errorf("failed to store snippets: %v", err)
+	}
+	return existed, nil
+}
+
+// saveSnippet stores a snippet, replacing any with the same name
+func (t *TabdNativeHost) saveSnippet(snippet *Snippet) error {
+	if err := validateSnippet(snippet); err != nil {
+		return err
+	}
+	snippet.Updated = time.Now().UnixMilli()
+	_, err := t.updateSnippets(snippet.Name, snippet)
+	return err
+}
+
+// deleteSnippet removes a saved snippet by name
+func (t *TabdNativeHost) deleteSnippet(name string) error {
+	existed, err := t.updateSnippets(name, nil)
+	if err != nil {
+		return err
+	}
+	if !existed {
+		return &codedError{code: codeNotFound, err: fmt.Errorf("no snippet named %q", name)}
+	}
+	return nil
+}
+
+// renderSnippet expands a saved snippet. The url placeholder takes pageURL,
+// or the source page of the latest entry if pageURL is empty.
+func (t *TabdNativeHost) renderSnippet(ctx context.Context, name, pageURL string) (string, error) {
+	snippet, err := t.getSnippet(name)
+	if err != nil {
+		return "", err
+	}
+
+	values := &snippetValues{now: time.Now(), url: pageURL}
+	if latest, err := t.getClipboardData(ctx); err == nil {
+		values.clipboard = latest.Text
+		if values.url == "" {
+			values.url = latest.URL
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	return expandSnippet(snippet.Text, values), nil
+}
+
+// handleRenderSnippet returns a saved snippet with its placeholders expanded
+// for the page given by the message's url
+func (t *TabdNativeHost) handleRenderSnippet(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	text, err := t.renderSnippet(ctx, msg.Name, msg.URL)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to render snippet: %w", err)
+	}
+	return "", &snippetResult{Name: msg.Name, Text: text}, nil
+}
+
+// newSnippetCommand creates the snippet command, which adds, lists, renders or
+// deletes snippets
+func newSnippetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snippet [list | add <name> [<text>] | render <name> | delete <name>]",
+		Short: "Add, list, render or delete text snippets with placeholders",
+	}
+	flags := cmd.Flags()
+	pageURL := flags.String("url", "", "value of {{url}} when rendering (default: the latest entry's source page)")
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		usage := fmt.Errorf("usage: snippet [list | add <name> [<text>] | render <name> | delete <name>]")
+		if len(positional) == 0 || positional[0] == "list" {
+			snippets, err := host.snippets()
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(snippets)
+			}
+			for _, snippet := range snippets {
+				fmt.Printf("%-24s %s\n", snippet.Name, previewText(&ClipboardData{Text: snippet.Text}))
+			}
+			return nil
+		}
+		if len(positional) < 2 {
+			return usage
+		}
+		name := positional[1]
+
+		switch positional[0] {
+		case "add":
+			if len(positional) > 3 {
+				return usage
+			}
+			// Without text on the command line, the snippet is read from stdin
+			// so it can span lines
+			var text string
+			if len(positional) == 3 {
+				text = positional[2]
+			} else {
+				input, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("failed to read snippet: %v", err)
+				}
+				text = strings.TrimSuffix(string(input), "\n")
+			}
+			if text == "" {
+				return fmt.Errorf("snippet text must not be empty")
+			}
+			return host.saveSnippet(&Snippet{Name: name, Text: text})
+		case "render":
+			if len(positional) != 2 {
+				return usage
+			}
+			text, err := host.renderSnippet(context.Background(), name, *pageURL)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(os.Stdout, text)
+			return err
+		case "delete":
+			if len(positional) != 2 {
+				return usage
+			}
+			return host.deleteSnippet(name)
+		default:
+			return fmt.Errorf("unknown snippet command: %s", positional[0])
+		}
+	})
+	return cmd
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the key/value and history tables. Payloads are
+// encrypted; the URL, type, tags, kind and timestamp columns are kept in the clear
+// so history can be queried without decrypting every entry.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS kv (
+	key   TEXT PRIMARY KEY,
+	value BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS history (
+	seq       INTEGER PRIMARY KEY AUTOINCREMENT,
+	id        TEXT NOT NULL UNIQUE,
+	timestamp INTEGER NOT NULL,
+	url       TEXT NOT NULL DEFAULT '',
+	type      TEXT NOT NULL DEFAULT '',
+	tags      TEXT NOT NULL DEFAULT '[]',
+	kind      TEXT NOT NULL DEFAULT '',
+	language  TEXT NOT NULL DEFAULT '',
+	data      BLOB NOT NULL,
+	preview   BLOB
+);
+CREATE TABLE IF NOT EXISTS pinned (
+	id TEXT PRIMARY KEY
+);
+CREATE INDEX IF NOT EXISTS history_timestamp ON history (timestamp);
+CREATE INDEX IF NOT EXISTS history_url ON history (url);
+CREATE INDEX IF NOT EXISTS history_type ON history (type);
+`
+
+// SQLiteStorage stores encrypted key/value data in a SQLite database
+type SQLiteStorage struct {
+	db     *sql.DB
+	cipher *BlobCipher
+}
+
+// SQLiteHistory stores clipboard history in the same database as SQLiteStorage
+type SQLiteHistory struct {
+	db         *sql.DB
+	cipher     *BlobCipher
+	maxEntries int
+}
+
+// NewSQLiteStorage opens (or creates) the database at path
+func NewSQLiteStorage(path string, cipher *BlobCipher) (*SQLiteStorage, error) {
+	// Create the database file up front so it gets restricted permissions
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database: %v", err)
+	}
+	file.Close()
+
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	// Databases created by older versions lack the newer columns
+	addedKind := false
+	for _, column := range []struct{ name, definition string }{
+		{"tags", `TEXT NOT NULL DEFAULT '[]'`},
+		{"preview", `BLOB`},
+		{"kind", `TEXT NOT NULL DEFAULT ''`},
+		{"language", `TEXT NOT NULL DEFAULT ''`},
+	} {
+		var exists int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('history') WHERE name = ?`, column.name).Scan(&exists); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to inspect database: %v", err)
+		}
+		if exists == 0 {
+			if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE history ADD COLUMN %s %s`, column.name, column.definition)); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("failed to add %s column: %v", column.name, err)
+			}
+			addedKind = addedKind || column.name == "kind"
+		}
+	}
+	if addedKind {
+		if err := classifySQLiteHistory(db, cipher); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS history_kind ON history (kind)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %v", err)
+	}
+
+	return &SQLiteStorage{
+		db:     db,
+		cipher: cipher,
+	}, nil
+}
+
+// classifySQLiteHistory fills in the kind and language of entries saved
+// before they were kept, so they can be filtered by kind
+func classifySQLiteHistory(db *sql.DB, cipher *BlobCipher) error {
+	rows, err := db.Query(`SELECT id, data FROM history`)
+	if err != nil {
+		return fmt.Errorf("failed to read history: %v", err)
+	}
+	kinds := map[string][2]string{}
+	for rows.Next() {
+		var id string
+		var encrypted []byte
+		if err := rows.Scan(&id, &encrypted); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read history: %v", err)
+		}
+		jsonData, err := cipher.Decrypt(encrypted)
+		if err != nil {
+			logDebugf("Error decrypting history entry %s: %v", id, err)
+			continue
+		}
+		var data ClipboardData
+		if json.Unmarshal(jsonData, &data) != nil {
+			continue
+		}
+		kind, language := classifyContent(&data)
+		kinds[id] = [2]string{kind, language}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read history: %v", err)
+	}
+
+	for id, classification := range kinds {
+		if _, err := db.Exec(`UPDATE history SET kind = ?, language = ? WHERE id = ?`, classification[0], classification[1], id); err != nil {
+			return fmt.Errorf("failed to classify history entry %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// History returns a history store sharing this database, keeping at most
+// maxEntries items (or defaultMaxHistory if maxEntries is not positive)
+func (s *SQLiteStorage) History(maxEntries int) *SQLiteHistory {
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxHistory
+	}
+	return &SQLiteHistory{
+		db:         s.db,
+		cipher:     s.cipher,
+		maxEntries: maxEntries,
+	}
+}
+
+// Close closes the database
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// FreeSpace returns the size of the unused pages in the database file, which
+// SQLite reuses for new rows rather than returning to the filesystem
+func (s *SQLiteStorage) FreeSpace() int64 {
+	var pages, pageSize int64
+	if err := s.db.QueryRow(`PRAGMA freelist_count`).Scan(&pages); err != nil {
+		return 0
+	}
+	if err := s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0
+	}
+	return pages * pageSize
+}
+
+// EntrySize returns the size of a history entry's data and preview
+func (s *SQLiteStorage) EntrySize(id string) int64 {
+	var size int64
+	if err := s.db.QueryRow(`SELECT length(data) + coalesce(length(preview), 0) FROM history WHERE id = ?`, id).Scan(&size); err != nil {
+		return 0
+	}
+	return size
+}
+
+// Checkpoint copies the write-ahead log into the database file and truncates
+// it, so the size of the database files reflects what is stored
+func (s *SQLiteStorage) Checkpoint() error {
+	_, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	return err
+}
+
+// SecureStorage implementation
+func (s *SQLiteStorage) Store(key string, data []byte) error {
+	return s.StoreContext(context.Background(), key, data)
+}
+
+func (s *SQLiteStorage) StoreContext(ctx context.Context, key string, data []byte) error {
+	encrypted, err := s.cipher.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt data: %v", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO kv (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, encrypted)
+	if ctxErr := contextErr(ctx); err != nil && ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func (s *SQLiteStorage) Retrieve(key string) ([]byte, error) {
+	return s.RetrieveContext(context.Background(), key)
+}
+
+func (s *SQLiteStorage) RetrieveContext(ctx context.Context, key string) ([]byte, error) {
+	var encrypted []byte
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM kv WHERE key = ?`, key).Scan(&encrypted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", os.ErrNotExist, key)
+	}
+	if ctxErr := contextErr(ctx); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := s.cipher.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", key, ErrDecrypt, err)
+	}
+	return plaintext, nil
+}
+
+func (s *SQLiteStorage) Delete(key string) error {
+	result, err := s.db.Exec(`DELETE FROM kv WHERE key = ?`, key)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("%w: %s", os.ErrNotExist, key)
+	}
+	return nil
+}
+
+// Wipe deletes a key with secure_delete enabled so its page is zeroed
+func (s *SQLiteStorage) Wipe(key string) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `PRAGMA secure_delete = ON`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `PRAGMA secure_delete = OFF`)
+
+	result, err := conn.ExecContext(ctx, `DELETE FROM kv WHERE key = ?`, key)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("%w: %s", os.ErrNotExist, key)
+	}
+	return nil
+}
+
+// HistoryStore implementation
+func (h *SQLiteHistory) Append(data *ClipboardData) (string, error) {
+	return h.AppendContext(context.Background(), data)
+}
+
+func (h *SQLiteHistory) AppendContext(ctx context.Context, data *ClipboardData) (string, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal clipboard data: %v", err)
+	}
+
+	encrypted, err := h.cipher.Encrypt(jsonData)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt history entry: %v", err)
+	}
+
+	timestamp := data.Timestamp
+	if timestamp == 0 {
+		timestamp = time.Now().UnixMilli()
+	}
+
+	preview, err := h.encryptPreview(data)
+	if err != nil {
+		return "", err
+	}
+
+	kind, language := classifyContent(data)
+	id := generateEntryID()
+	_, err = h.db.ExecContext(ctx, `INSERT INTO history (id, timestamp, url, type, tags, kind, language, data, preview) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, timestamp, data.URL, data.Type, sqliteTags(data.Tags), kind, language, encrypted, preview)
+	if err != nil {
+		if ctxErr := contextErr(ctx); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", fmt.Errorf("failed to store history entry: %v", err)
+	}
+
+	if err := h.Prune(); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (h *SQLiteHistory) List() ([]HistoryEntry, error) {
+	return h.Query(HistoryQuery{})
+}
+
+func (h *SQLiteHistory) Query(query HistoryQuery) ([]HistoryEntry, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.URL != "" {
+		conditions = append(conditions, "url = ?")
+		args = append(args, query.URL)
+	}
+	if query.Type != "" {
+		conditions = append(conditions, "type = ?")
+		args = append(args, query.Type)
+	}
+	if query.Kind != "" {
+		conditions = append(conditions, "kind = ?")
+		args = append(args, query.Kind)
+	}
+	if query.Since != 0 {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, query.Since)
+	}
+	if query.Until != 0 {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, query.Until)
+	}
+
+	if query.Tag != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM json_each(history.tags) WHERE value = ?)")
+		args = append(args, query.Tag)
+	}
+
+	if query.Pinned {
+		conditions = append(conditions, "pinned.id IS NOT NULL")
+	}
+
+	statement := `SELECT history.id, timestamp, url, type, tags, kind, language, preview, pinned.id IS NOT NULL
+		FROM history LEFT JOIN pinned ON pinned.id = history.id`
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	statement += " ORDER BY seq DESC"
+
+	// Origin rules are matched on parsed URLs, so pages are cut after the
+	// rows are filtered. SQLite requires a LIMIT before OFFSET; -1 means
+	// unlimited.
+	if query.Origin == "" && (query.Limit > 0 || query.Offset > 0) {
+		limit := query.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		statement += " LIMIT ? OFFSET ?"
+		args = append(args, limit, query.Offset)
+	}
+
+	rows, err := h.db.Query(statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		var entry HistoryEntry
+		var tags string
+		var preview []byte
+		if err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.URL, &entry.Type, &tags, &entry.Kind, &entry.Language, &preview, &entry.Pinned); err != nil {
+			return nil, fmt.Errorf("failed to read history row: %v", err)
+		}
+		if err := json.Unmarshal([]byte(tags), &entry.Tags); err != nil {
+			return nil, fmt.Errorf("failed to read tags of history entry %s: %v", entry.ID, err)
+		}
+		entry.Preview = h.decryptPreview(entry.ID, preview)
+		if query.Origin != "" && !urlMatchesOrigin(query.Origin, entry.URL) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if query.Origin != "" {
+		return paginate(entries, query.Offset, query.Limit), nil
+	}
+	return entries, nil
+}
+
+// encryptPreview encrypts the preview of an entry for the preview column.
+// Previews hold part of the content, so unlike the other columns they are
+// never stored in the clear.
+func (h *SQLiteHistory) encryptPreview(data *ClipboardData) ([]byte, error) {
+	jsonData, err := json.Marshal(newEntryPreview(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal preview: %v", err)
+	}
+	encrypted, err := h.cipher.Encrypt(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt preview: %v", err)
+	}
+	return encrypted, nil
+}
+
+// decryptPreview reads the preview column of an entry. Entries saved before
+// previews were stored, or whose preview cannot be read, have none.
+func (h *SQLiteHistory) decryptPreview(id string, encrypted []byte) *EntryPreview {
+	if encrypted == nil {
+		return nil
+	}
+	jsonData, err := h.cipher.Decrypt(encrypted)
+	if err != nil {
+		logDebugf("Error decrypting preview of history entry %s: %v", id, err)
+		return nil
+	}
+	var preview EntryPreview
+	if err := json.Unmarshal(jsonData, &preview); err != nil {
+		return nil
+	}
+	return &preview
+}
+
+// sqliteTags encodes tags for the tags column as a JSON array
+func sqliteTags(tags []string) string {
+	if len(tags) == 0 {
+		return "[]"
+	}
+	encoded, _ := json.Marshal(tags)
+	return string(encoded)
+}
+
+func (h *SQLiteHistory) Get(id string) (*ClipboardData, error) {
+	return h.GetContext(context.Background(), id)
+}
+
+func (h *SQLiteHistory) GetContext(ctx context.Context, id string) (*ClipboardData, error) {
+	var encrypted []byte
+	err := h.db.QueryRowContext(ctx, `SELECT data FROM history WHERE id = ?`, id).Scan(&encrypted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEntryNotFound
+	}
+	if ctxErr := contextErr(ctx); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve history entry: %v", err)
+	}
+
+	jsonData, err := h.cipher.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("history entry %s: %w: %v", id, ErrDecrypt, err)
+	}
+
+	var data ClipboardData
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal history entry: %v", err)
+	}
+
+	return &data, nil
+}
+
+func (h *SQLiteHistory) Update(id string, data *ClipboardData) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal clipboard data: %v", err)
+	}
+
+	encrypted, err := h.cipher.Encrypt(jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt history entry: %v", err)
+	}
+
+	timestamp := data.Timestamp
+	if timestamp == 0 {
+		timestamp = time.Now().UnixMilli()
+	}
+
+	preview, err := h.encryptPreview(data)
+	if err != nil {
+		return err
+	}
+
+	kind, language := classifyContent(data)
+	result, err := h.db.Exec(`UPDATE history SET timestamp = ?, url = ?, type = ?, tags = ?, kind = ?, language = ?, data = ?, preview = ? WHERE id = ?`,
+		timestamp, data.URL, data.Type, sqliteTags(data.Tags), kind, language, encrypted, preview, id)
+	if err != nil {
+		return fmt.Errorf("failed to update history entry: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrEntryNotFound
+	}
+	return nil
+}
+
+func (h *SQLiteHistory) Delete(id string) error {
+	result, err := h.db.Exec(`DELETE FROM history WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete history entry: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrEntryNotFound
+	}
+	return nil
+}
+
+func (h *SQLiteHistory) Prune() error {
+	// Pinned entries are neither pruned nor counted towards the cap
+	_, err := h.db.Exec(`DELETE FROM history WHERE id NOT IN (SELECT id FROM pinned) AND seq NOT IN
+		(SELECT seq FROM history WHERE id NOT IN (SELECT id FROM pinned) ORDER BY seq DESC LIMIT ?)`, h.maxEntries)
+	if err != nil {
+		return fmt.Errorf("failed to prune history: %v", err)
+	}
+	return nil
+}
+
+// Clear removes entries older than before (or all entries if before is zero).
+// With wipe set, deleted pages are zeroed and the write-ahead log is truncated
+// so no copy of the removed rows remains in the database files.
+func (h *SQLiteHistory) Clear(before int64, wipe bool) (int, error) {
+	ctx := context.Background()
+
+	// Pin a single connection so the pragma applies to the delete
+	conn, err := h.db.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open database connection: %v", err)
+	}
+	defer conn.Close()
+
+	if wipe {
+		if _, err := conn.ExecContext(ctx, `PRAGMA secure_delete = ON`); err != nil {
+			return 0, fmt.Errorf("failed to enable secure delete: %v", err)
+		}
+		defer conn.ExecContext(ctx, `PRAGMA secure_delete = OFF`)
+	}
+
+	statement := `DELETE FROM history`
+	args := []interface{}{}
+	if before != 0 {
+		statement += ` WHERE timestamp < ?`
+		args = append(args, before)
+	}
+
+	result, err := conn.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear history: %v", err)
+	}
+	removed, _ := result.RowsAffected()
+
+	if _, err := conn.ExecContext(ctx, `DELETE FROM pinned WHERE id NOT IN (SELECT id FROM history)`); err != nil {
+		return int(removed), fmt.Errorf("failed to clear pinned entries: %v", err)
+	}
+
+	if wipe {
+		if _, err := conn.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+			return int(removed), fmt.Errorf("failed to checkpoint database: %v", err)
+		}
+	}
+
+	return int(removed), nil
+}
+
+func (h *SQLiteHistory) Expire(before int64) (int, error) {
+	result, err := h.db.Exec(`DELETE FROM history WHERE timestamp < ? AND id NOT IN (SELECT id FROM pinned)`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire history: %v", err)
+	}
+	removed, _ := result.RowsAffected()
+	return int(removed), nil
+}
+
+func (h *SQLiteHistory) Pin(id string, pinned bool) error {
+	var exists int
+	err := h.db.QueryRow(`SELECT COUNT(*) FROM history WHERE id = ?`, id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up history entry: %v", err)
+	}
+	if exists == 0 {
+		return ErrEntryNotFound
+	}
+
+	statement := `DELETE FROM pinned WHERE id = ?`
+	if pinned {
+		statement = `INSERT OR IGNORE INTO pinned (id) VALUES (?)`
+	}
+	if _, err := h.db.Exec(statement, id); err != nil {
+		return fmt.Errorf("failed to update pinned entries: %v", err)
+	}
+	return nil
+}
+
+// Reencrypt moves every stored value and history entry to a new cipher in a
+// single transaction, rewrapping their data keys where it can. commit is called before the transaction is
+// committed, so the new key can be persisted first; if it fails the database
+// is left unchanged.
+func (s *SQLiteStorage) Reencrypt(to *BlobCipher, commit func() error) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, table := range []struct{ name, key, value string }{
+		{"kv", "key", "value"},
+		{"history", "id", "data"},
+		{"history", "id", "preview"},
+	} {
+		rows, err := tx.Query(fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s IS NOT NULL`, table.key, table.value, table.name, table.value))
+		if err != nil {
+			return count, fmt.Errorf("failed to read %s: %v", table.name, err)
+		}
+
+		blobs := make(map[string][]byte)
+		for rows.Next() {
+			var key string
+			var encrypted []byte
+			if err := rows.Scan(&key, &encrypted); err != nil {
+				rows.Close()
+				return count, fmt.Errorf("failed to read %s row: %v", table.name, err)
+			}
+			blobs[key] = encrypted
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return count, fmt.Errorf("failed to read %s: %v", table.name, err)
+		}
+
+		statement := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ?`, table.name, table.value, table.key)
+		for key, encrypted := range blobs {
+			reencrypted, err := s.cipher.Rewrap(encrypted, to)
+			if err != nil {
+				return count, fmt.Errorf("failed to re-encrypt %s %s: %v", table.name, key, err)
+			}
+			if _, err := tx.Exec(statement, reencrypted, key); err != nil {
+				return count, fmt.Errorf("failed to update %s %s: %v", table.name, key, err)
+			}
+			count++
+		}
+	}
+
+	if err := commit(); err != nil {
+		return count, err
+	}
+	if err := tx.Commit(); err != nil {
+		return count, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	s.cipher = to
+	return count, nil
+}
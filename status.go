@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zalando/go-keyring"
+)
+
+// StatusResult describes the state of the host for diagnostics
+type StatusResult struct {
+	BuildInfo
+	ProtocolVersion int    `json:"protocolVersion"`
+	Profile         string `json:"profile,omitempty"`
+	StorageBackend  string `json:"storageBackend"`
+	StorageDir      string `json:"storageDir"`
+	StorageFormat   int    `json:"storageFormat"`
+	Cipher          string `json:"cipher"`
+	Entries         int    `json:"entries"`
+	PinnedEntries   int    `json:"pinnedEntries"`
+	DiskUsage       int64  `json:"diskUsage"`
+	Quota           int64  `json:"quota,omitempty"`
+	QuotaUsage      int64  `json:"quotaUsage,omitempty"`
+	QuotaExceeded   bool   `json:"quotaExceeded,omitempty"`
+	Keyring         string `json:"keyring"`
+	Locked          bool   `json:"locked"`
+	Incognito       bool   `json:"incognito"`
+	Uptime          int64  `json:"uptime"`
+}
+
+// keyringStatus reports whether the system keyring answers requests:
+// available, unavailable or disabled
+func keyringStatus() string {
+	if os.Getenv("TABD_DISABLE_KEYRING") != "" {
+		return "disabled"
+	}
+	if state, _ := probeKeyring(); state != keyringAvailable {
+		return string(state)
+	}
+
+	err := withKeyringTimeout(func() error {
+		_, err := keyring.Get(keyringService(), "status_probe")
+		return err
+	})
+	if err == nil || errors.Is(err, keyring.ErrNotFound) {
+		return "available"
+	}
+	return "unavailable"
+}
+
+// profileSubdirs hold the data of other profiles and their launchers inside
+// the default profile's directory
+var profileSubdirs = []string{"profiles", "launchers"}
+
+// diskUsage returns the total size of a profile's files under dir, leaving
+// out those of other profiles
+func diskUsage(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if filepath.Dir(path) == filepath.Clean(dir) && slices.Contains(profileSubdirs, entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// cipherDescription names the suite and KDF parameters new data is
+// encrypted with
+func (t *TabdNativeHost) cipherDescription() string {
+	if t.config.StorageBackend == "dpapi" {
+		return "dpapi"
+	}
+	suite, _ := parseCipherSuite(t.config.Cipher)
+	return fmt.Sprintf("%s, argon2id %s", suite, t.config.KDF.params())
+}
+
+// status collects the host's diagnostic information
+func (t *TabdNativeHost) status() *StatusResult {
+	result := &StatusResult{
+		BuildInfo:       buildInfo(),
+		ProtocolVersion: protocolVersion,
+		Profile:         currentProfile(),
+		StorageBackend:  t.config.StorageBackend,
+		StorageDir:      t.tabdDir,
+		Cipher:          t.cipherDescription(),
+		DiskUsage:       diskUsage(t.tabdDir),
+		Keyring:         keyringStatus(),
+		Locked:          t.isLocked(),
+		Incognito:       t.isIncognito(),
+		Uptime:          int64(time.Since(t.startTime).Seconds()),
+		Quota:           t.config.QuotaBytes(),
+	}
+	if version, err := readStorageFormat(t.tabdDir); err == nil {
+		result.StorageFormat = version
+	}
+	if result.Quota > 0 {
+		result.QuotaUsage = t.quotaUsage()
+		result.QuotaExceeded = result.QuotaUsage > result.Quota
+	}
+
+	if !result.Locked {
+		if entries, err := t.historyStore().List(); err == nil {
+			result.Entries = len(entries)
+			for _, entry := range entries {
+				if entry.Pinned {
+					result.PinnedEntries++
+				}
+			}
+		} else {
+			logWarnf("Error counting history entries: %v", err)
+		}
+	}
+
+	return result
+}
+
+// handleStatus returns diagnostic information about the host
+func (t *TabdNativeHost) handleStatus(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	return "", t.status(), nil
+}
+
+// newStatusCommand creates the status command, which prints diagnostic
+// information about the installation
+func newStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show version, storage and keyring diagnostics",
+		Args:  cobra.NoArgs,
+	}
+	cmd.RunE = func(*cobra.Command, []string) error {
+		config, err := LoadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		host, err := NewTabdNativeHost(config)
+		if err != nil {
+			return fmt.Errorf("failed to create native host: %w", err)
+		}
+		defer host.Close()
+
+		result := host.status()
+		if jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(result)
+		}
+
+		fmt.Printf("Version:          %s\n", result.Version)
+		fmt.Printf("Protocol version: %d\n", result.ProtocolVersion)
+		if result.Profile != "" {
+			fmt.Printf("Profile:          %s\n", result.Profile)
+		}
+		fmt.Printf("Storage backend:  %s\n", result.StorageBackend)
+		fmt.Printf("Storage dir:      %s\n", result.StorageDir)
+		fmt.Printf("Storage format:   %d\n", result.StorageFormat)
+		fmt.Printf("Cipher:           %s\n", result.Cipher)
+		if result.Locked {
+			fmt.Printf("Entries:          unknown (storage is locked)\n")
+		} else {
+			fmt.Printf("Entries:          %d (%d pinned)\n", result.Entries, result.PinnedEntries)
+		}
+		fmt.Printf("Disk usage:       %s\n", formatBytes(result.DiskUsage))
+		if result.Quota > 0 {
+			exceeded := ""
+			if result.QuotaExceeded {
+				exceeded = " (exceeded)"
+			}
+			fmt.Printf("Quota:            %s of %s used%s\n", formatBytes(result.QuotaUsage), formatBytes(result.Quota), exceeded)
+		}
+		fmt.Printf("Keyring:          %s\n", result.Keyring)
+		return nil
+	}
+	return cmd
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
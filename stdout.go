@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// stdoutBufferSize is the size of the buffer frames are assembled in before
+// each is flushed to stdout whole
+const stdoutBufferSize = 64 * 1024
+
+// frameWriter is implemented by writers that take whole native messaging
+// frames, so a frame cannot be split by writes from elsewhere
+type frameWriter interface {
+	WriteFrame(message []byte) error
+}
+
+// stdoutGuard owns the process's real stdout in native messaging mode. Only
+// well formed frames reach it: anything else written to stdout would corrupt
+// the framing the browser reads, so it is refused and the guard stops writing.
+// Frames are buffered and flushed one at a time, so a frame reaches the
+// browser in as few writes as possible and none is left waiting in the buffer.
+type stdoutGuard struct {
+	mu  sync.Mutex
+	out *bufio.Writer
+
+	// The frame being copied through Write: its length prefix until all four
+	// bytes have arrived, then the number of body bytes still to come
+	header  []byte
+	pending int
+
+	// Set once a write was refused or failed partway, as the stream can no
+	// longer be framed; every later write fails with it
+	err error
+}
+
+// claimStdout moves the real stdout behind a guard for the session to write
+// frames to. File descriptor 1 and os.Stdout are pointed at stderr, so a stray
+// fmt.Print or a library writing to stdout ends up there instead.
+func claimStdout() (*stdoutGuard, error) {
+	out, err := detachStdout()
+	if err != nil {
+		return nil, fmt.Errorf("failed to redirect stdout: %v", err)
+	}
+	os.Stdout = os.Stderr
+	return newStdoutGuard(out), nil
+}
+
+// newStdoutGuard creates a guard writing frames to out
+func newStdoutGuard(out io.Writer) *stdoutGuard {
+	return &stdoutGuard{out: bufio.NewWriterSize(out, stdoutBufferSize)}
+}
+
+// fail refuses a write, logging why so the corruption is not silent
+func (g *stdoutGuard) fail(format string, args ...interface{}) error {
+	g.err = fmt.Errorf("refusing to write to stdout: "+format, args...)
+	logErrorf("%v", g.err)
+	return g.err
+}
+
+// broken records a failed write. Part of a frame may have been written, so
+// nothing more can be.
+func (g *stdoutGuard) broken(err error) error {
+	g.err = fmt.Errorf("failed to write to stdout: %w", err)
+	return g.err
+}
+
+// WriteFrame writes a whole frame
+func (g *stdoutGuard) WriteFrame(message []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err != nil {
+		return g.err
+	}
+	if len(g.header) > 0 || g.pending > 0 {
+		return g.fail("a frame would be interleaved with one that is incomplete")
+	}
+
+	var header [4]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(len(message)))
+	g.out.Write(header[:])
+	g.out.Write(message)
+	if err := g.out.Flush(); err != nil {
+		return g.broken(err)
+	}
+	return nil
+}
+
+// Flush writes any buffered bytes of the frame being copied through Write
+func (g *stdoutGuard) Flush() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err != nil {
+		return g.err
+	}
+	if err := g.out.Flush(); err != nil {
+		return g.broken(err)
+	}
+	return nil
+}
+
+// Write copies a stream of frames, such as one relayed from the daemon,
+// checking each length prefix before the frame is passed on
+func (g *stdoutGuard) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err != nil {
+		return 0, g.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if g.pending == 0 {
+			n := min(4-len(g.header), len(p))
+			g.header = append(g.header, p[:n]...)
+			written += n
+			p = p[n:]
+			if len(g.header) < 4 {
+				break
+			}
+
+			length := binary.LittleEndian.Uint32(g.header)
+			if length > maxTransferSize {
+				return written, g.fail("%d byte frame is larger than any message; is something printing to stdout?", length)
+			}
+			g.out.Write(g.header)
+			g.header = g.header[:0]
+			g.pending = int(length)
+		} else {
+			n := min(g.pending, len(p))
+			g.out.Write(p[:n])
+			g.pending -= n
+			written += n
+			p = p[n:]
+		}
+
+		// bufio.Writer keeps the first error, so flushing when a frame
+		// is complete reports any write that failed along the way
+		if g.pending == 0 {
+			if err := g.out.Flush(); err != nil {
+				return written, g.broken(err)
+			}
+		}
+	}
+	return written, nil
+}
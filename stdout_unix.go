@@ -0,0 +1,31 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// detachStdout duplicates the stdout descriptor for the guard and points
+// descriptor 1 at stderr
+func detachStdout() (*os.File, error) {
+	fd, err := unix.Dup(1)
+	if err != nil {
+		return nil, err
+	}
+	unix.CloseOnExec(fd)
+	if err := unix.Dup2(2, 1); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "stdout"), nil
+}
+
+// isBrokenPipe reports whether a write failed because the reader went away
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// detachStdout keeps the stdout handle for the guard and makes stderr the
+// process's standard output handle
+func detachStdout() (*os.File, error) {
+	if err := windows.SetStdHandle(windows.STD_OUTPUT_HANDLE, windows.Handle(os.Stderr.Fd())); err != nil {
+		return nil, err
+	}
+	return os.Stdout, nil
+}
+
+// isBrokenPipe reports whether a write failed because the reader went away
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, windows.ERROR_BROKEN_PIPE) || errors.Is(err, windows.ERROR_NO_DATA)
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
+	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/zalando/go-keyring"
-	"golang.org/x/crypto/argon2"
 )
 
 // SecureStorage interface for platform-specific secure storage
@@ -23,175 +27,561 @@ type SecureStorage interface {
 // KeyringStorage uses the system keyring for secure storage
 type KeyringStorage struct {
 	serviceName string
+
+	probeOnce sync.Once
+	available bool
+}
+
+// FallbackStorage tries a primary storage for each operation and falls back
+// to a secondary storage when the primary fails. The fallback only holds a
+// key while the most recent write to the primary failed, so it is checked
+// first on retrieval.
+type FallbackStorage struct {
+	primary  SecureStorage
+	fallback SecureStorage
 }
 
 // EncryptedFileStorage uses encrypted files as fallback storage
 type EncryptedFileStorage struct {
 	storageDir string
-	passphrase string
+	cipher     *BlobCipher
+}
+
+// wiper is implemented by storages that can overwrite stored data before
+// removing it
+type wiper interface {
+	Wipe(key string) error
+}
+
+// wipeKey securely removes a key if the storage supports it and falls back to
+// a plain delete otherwise
+func wipeKey(storage SecureStorage, key string) error {
+	if w, ok := storage.(wiper); ok {
+		return w.Wipe(key)
+	}
+	return storage.Delete(key)
+}
+
+// contextStorage is implemented by storages that give up on an operation
+// whose context is done, checking it before anything is written
+type contextStorage interface {
+	StoreContext(ctx context.Context, key string, data []byte) error
+	RetrieveContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// storeKey stores data under a key for a request, passing its context on if
+// the storage takes one and otherwise checking it first
+func storeKey(ctx context.Context, storage SecureStorage, key string, data []byte) error {
+	if c, ok := storage.(contextStorage); ok {
+		return c.StoreContext(ctx, key, data)
+	}
+	if err := contextErr(ctx); err != nil {
+		return err
+	}
+	return storage.Store(key, data)
+}
+
+// retrieveKey retrieves a key for a request as storeKey stores one
+func retrieveKey(ctx context.Context, storage SecureStorage, key string) ([]byte, error) {
+	if c, ok := storage.(contextStorage); ok {
+		return c.RetrieveContext(ctx, key)
+	}
+	if err := contextErr(ctx); err != nil {
+		return nil, err
+	}
+	return storage.Retrieve(key)
+}
+
+// keyringServiceName is the service under which keyring items are stored
+const keyringServiceName = "tabd-native-host"
+
+// keyringTimeout bounds keyring calls, which can block indefinitely on an
+// unanswered macOS Keychain prompt or an unresponsive Secret Service daemon
+const keyringTimeout = 5 * time.Second
+
+// keyringState is whether the system keyring can be used, or why not
+type keyringState string
+
+const (
+	keyringAvailable   keyringState = "available"
+	keyringUnavailable keyringState = "unavailable"
+
+	// States the Secret Service on Linux and BSD can be diagnosed in
+	keyringNoBus        keyringState = "no_bus"
+	keyringNoDaemon     keyringState = "no_daemon"
+	keyringNoCollection keyringState = "no_collection"
+	keyringLocked       keyringState = "locked"
+)
+
+// errKeyringUnavailable is returned when the system keyring cannot be used
+var errKeyringUnavailable = errors.New("system keyring unavailable")
+
+// ErrKeychainDenied is returned when the user refuses the macOS Keychain
+// prompt for the storage key, so storage cannot be opened
+var ErrKeychainDenied = errors.New("access to the storage key was denied in the Keychain prompt; run the command again and choose Always Allow")
+
+// errNoStorageKey is returned by commands managing the storage key when the
+// dpapi backend, which has none, is selected
+var errNoStorageKey = errors.New("the dpapi storage backend has no storage key: DPAPI protects data with your Windows account")
+
+// openStorage creates the storage and history for the named backend: "sqlite"
+// for a single SQLite database, "dpapi" for DPAPI protected files on Windows,
+// or the default keyring/encrypted file storage, first migrating storage
+// written by older versions to the current format
+func openStorage(tabdDir, backend string, maxHistory int) (SecureStorage, HistoryStore, error) {
+	version, err := checkStorageFormat(tabdDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secureStorage, history, err := openBackend(tabdDir, backend, maxHistory)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := migrateStorage(tabdDir, version, secureStorage, history); err != nil {
+		if closer, ok := secureStorage.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, nil, err
+	}
+	return secureStorage, history, nil
+}
+
+// openBackend opens the storage and history of the named backend
+func openBackend(tabdDir, backend string, maxHistory int) (SecureStorage, HistoryStore, error) {
+	switch backend {
+	case "", "auto":
+		secureStorage, err := NewSecureStorage(tabdDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return secureStorage, NewHistory(secureStorage, maxHistory, storageLockPath(tabdDir)), nil
+	case "file":
+		secureStorage, err := newEncryptedFileStorage(tabdDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return secureStorage, NewHistory(secureStorage, maxHistory, storageLockPath(tabdDir)), nil
+	case "sqlite":
+		cipher, err := storageCipher(tabdDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		sqliteStorage, err := NewSQLiteStorage(filepath.Join(tabdDir, "tabd.db"), cipher)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sqliteStorage, sqliteStorage.History(maxHistory), nil
+	case "dpapi":
+		secureStorage, err := newDPAPIStorage(tabdDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return secureStorage, NewHistory(secureStorage, maxHistory, storageLockPath(tabdDir)), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
 }
 
 // NewSecureStorage creates the appropriate secure storage for the platform
-func NewSecureStorage(tabdDir string) SecureStorage {
-	// Try keyring first (works on macOS, Windows, and most Linux distros)
-	// TODO: Fix, broken
-	/*if supportsKeyring() {
-		return &KeyringStorage{serviceName: "tabd-native-host"}
-	}*/
-
-	// Fallback to encrypted file storage
-	passphrase := generateOrRetrievePassphrase(tabdDir)
+func NewSecureStorage(tabdDir string) (SecureStorage, error) {
+	// Encrypted file storage is always available as a fallback
+	fileStorage, err := newEncryptedFileStorage(tabdDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("TABD_DISABLE_KEYRING") != "" {
+		return fileStorage, nil
+	}
+
+	// Prefer the keyring (macOS Keychain, Windows Credential Manager, Secret
+	// Service on Linux), falling back to encrypted files per operation
+	return &FallbackStorage{
+		primary:  newDataKeyring(),
+		fallback: fileStorage,
+	}, nil
+}
+
+// newEncryptedFileStorage creates encrypted file storage in tabdDir
+func newEncryptedFileStorage(tabdDir string) (*EncryptedFileStorage, error) {
+	cipher, err := storageCipher(tabdDir)
+	if err != nil {
+		return nil, err
+	}
 	return &EncryptedFileStorage{
 		storageDir: tabdDir,
-		passphrase: passphrase,
-	}
+		cipher:     cipher,
+	}, nil
 }
 
-// supportsKeyring checks if the system supports keyring operations
-func supportsKeyring() bool {
+// probeKeyring checks that the system keyring can store and read back a
+// value, reporting why not otherwise
+func probeKeyring() (keyringState, string) {
+	// On Linux and BSD the Secret Service is reached over the D-Bus session
+	// bus; tell a missing bus or provider and a locked collection apart
+	// before a test write, which would show an unlock prompt
+	if state, detail := diagnoseKeyring(); state != keyringAvailable {
+		return state, detail
+	}
+
 	// Test keyring availability by trying to set and get a test value
 	testKey := "tabd-test-key"
 	testValue := "test"
 
-	err := keyring.Set("tabd-native-host", testKey, testValue)
+	err := withKeyringTimeout(func() error {
+		return keyring.Set(keyringServiceName, testKey, testValue)
+	})
 	if err != nil {
-		return false
+		return keyringUnavailable, fmt.Sprintf("test write failed: %v", err)
 	}
 
-	retrieved, err := keyring.Get("tabd-native-host", testKey)
-	if err != nil || retrieved != testValue {
-		return false
+	var retrieved string
+	err = withKeyringTimeout(func() error {
+		var err error
+		retrieved, err = keyring.Get(keyringServiceName, testKey)
+		return err
+	})
+	if err != nil {
+		return keyringUnavailable, fmt.Sprintf("test read failed: %v", err)
+	}
+	if retrieved != testValue {
+		return keyringUnavailable, "test read returned a different value"
 	}
 
 	// Clean up test key
-	keyring.Delete("tabd-native-host", testKey)
-	return true
+	withKeyringTimeout(func() error {
+		return keyring.Delete(keyringServiceName, testKey)
+	})
+	return keyringAvailable, "available"
 }
 
-// generateOrRetrievePassphrase creates or retrieves a passphrase for encrypted storage
-func generateOrRetrievePassphrase(tabdDir string) string {
-	passphrasePath := filepath.Join(tabdDir, ".passphrase")
+// withKeyringTimeout runs a keyring call, giving up after keyringTimeout
+func withKeyringTimeout(fn func() error) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- fn()
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(keyringTimeout):
+		return fmt.Errorf("%w: keyring call timed out", errKeyringUnavailable)
+	}
+}
+
+// storageCipher creates the cipher for data in tabdDir. Besides the current
+// passphrase it accepts any other copy found, such as a file written while
+// the keyring was briefly unavailable, and the previous passphrase if a key
+// rotation was interrupted. It returns ErrLocked if the key is protected by
+// a user passphrase and storage has not been unlocked.
+func storageCipher(tabdDir string) (*BlobCipher, error) {
+	keys := newMasterKeyStore(tabdDir)
+
+	var current []string
+	if keys.protected() {
+		key, ok := keys.sessionKey()
+		if !ok {
+			return nil, ErrLocked
+		}
+		current = []string{key}
+	} else if current = keys.load(masterKeyName); len(current) == 0 {
+		passphrase, err := generateOrRetrievePassphrase(tabdDir)
+		if err != nil {
+			return nil, err
+		}
+		current = []string{passphrase}
+	}
+
+	previous := append(current[1:], keys.load(previousKeyName)...)
+	return NewBlobCipher(current[0], previous...), nil
+}
+
+// generateOrRetrievePassphrase creates or retrieves a passphrase for encrypted
+// storage, which comes from the unlock session when a user passphrase
+// protects it
+func generateOrRetrievePassphrase(tabdDir string) (string, error) {
+	keys := newMasterKeyStore(tabdDir)
+
+	if keys.protected() {
+		key, ok := keys.sessionKey()
+		if !ok {
+			return "", ErrLocked
+		}
+		return key, nil
+	}
 
 	// Try to read existing passphrase
-	if data, err := os.ReadFile(passphrasePath); err == nil {
-		return string(data)
+	if found := keys.load(masterKeyName); len(found) > 0 {
+		return found[0], nil
+	}
+	if keys.denied != nil {
+		return "", keys.denied
 	}
 
-	// Generate new passphrase
+	// Generate and save a new passphrase
+	passphrase := newPassphrase()
+	if err := keys.save(masterKeyName, passphrase); err != nil {
+		logErrorf("Error saving storage passphrase: %v", err)
+	}
+
+	return passphrase, nil
+}
+
+// newPassphrase generates a random storage passphrase
+func newPassphrase() string {
 	passphraseBytes := make([]byte, 32)
 	rand.Read(passphraseBytes)
-	passphrase := base64.URLEncoding.EncodeToString(passphraseBytes)
+	return base64.URLEncoding.EncodeToString(passphraseBytes)
+}
 
-	// Save passphrase (with restricted permissions)
-	os.WriteFile(passphrasePath, []byte(passphrase), 0600)
+// logKeyringOnce keeps every keyring storage of a process from logging why
+// the keyring is unavailable
+var logKeyringOnce sync.Once
 
-	return passphrase
+// KeyringStorage implementation
+func (k *KeyringStorage) checkAvailable() error {
+	k.probeOnce.Do(func() {
+		state, detail := probeKeyring()
+		k.available = state == keyringAvailable
+		if k.available {
+			return
+		}
+		logKeyringOnce.Do(func() {
+			if state == keyringLocked {
+				logWarnf("System keyring unavailable: %s; keys are kept in files until it is unlocked, e.g. with: tabd-native-host unlock --keyring", detail)
+			} else {
+				logInfof("System keyring unavailable (%s): %s", state, detail)
+			}
+		})
+	})
+	if !k.available {
+		return errKeyringUnavailable
+	}
+	return nil
 }
 
-// KeyringStorage implementation
 func (k *KeyringStorage) Store(key string, data []byte) error {
+	if err := k.checkAvailable(); err != nil {
+		return err
+	}
+
 	encoded := base64.StdEncoding.EncodeToString(data)
-	return keyring.Set(k.serviceName, key, encoded)
+	return withKeyringTimeout(func() error {
+		return keyring.Set(k.serviceName, key, encoded)
+	})
 }
 
 func (k *KeyringStorage) Retrieve(key string) ([]byte, error) {
-	encoded, err := keyring.Get(k.serviceName, key)
+	if err := k.checkAvailable(); err != nil {
+		return nil, err
+	}
+
+	var encoded string
+	err := withKeyringTimeout(func() error {
+		var err error
+		encoded, err = keyring.Get(k.serviceName, key)
+		return err
+	})
 	if err != nil {
+		if errors.Is(err, keyring.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %v", os.ErrNotExist, err)
+		}
 		return nil, err
 	}
 	return base64.StdEncoding.DecodeString(encoded)
 }
 
 func (k *KeyringStorage) Delete(key string) error {
-	return keyring.Delete(k.serviceName, key)
-}
+	if err := k.checkAvailable(); err != nil {
+		return err
+	}
 
-// EncryptedFileStorage implementation
-func (e *EncryptedFileStorage) Store(key string, data []byte) error {
-	encrypted, err := e.encrypt(data)
-	if err != nil {
-		return fmt.Errorf("failed to encrypt data: %v", err)
+	err := withKeyringTimeout(func() error {
+		return keyring.Delete(k.serviceName, key)
+	})
+	if errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("%w: %v", os.ErrNotExist, err)
 	}
+	return err
+}
 
-	filePath := filepath.Join(e.storageDir, key+".enc")
-	return os.WriteFile(filePath, encrypted, 0600)
+// FallbackStorage implementation
+func (f *FallbackStorage) Store(key string, data []byte) error {
+	return f.StoreContext(context.Background(), key, data)
 }
 
-func (e *EncryptedFileStorage) Retrieve(key string) ([]byte, error) {
-	filePath := filepath.Join(e.storageDir, key+".enc")
-	encrypted, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, err
+func (f *FallbackStorage) StoreContext(ctx context.Context, key string, data []byte) error {
+	if err := storeKey(ctx, f.primary, key, data); err != nil {
+		if ctxErr := contextErr(ctx); ctxErr != nil {
+			return ctxErr
+		}
+
+		// Covers an unavailable keyring as well as payloads over the
+		// platform size limit (e.g. ~2.5KB in Windows Credential Manager)
+		if !errors.Is(err, errKeyringUnavailable) {
+			logWarnf("Primary storage failed for %s, using fallback: %v", key, err)
+		}
+
+		// Remove any older copy so it cannot shadow the new value
+		f.primary.Delete(key)
+		return storeKey(ctx, f.fallback, key, data)
 	}
 
-	return e.decrypt(encrypted)
+	// Drop any stale fallback copy now that the primary holds the value
+	if err := f.fallback.Delete(key); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logWarnf("Failed to remove fallback copy of %s: %v", key, err)
+	}
+	return nil
 }
 
-func (e *EncryptedFileStorage) Delete(key string) error {
-	filePath := filepath.Join(e.storageDir, key+".enc")
-	return os.Remove(filePath)
+func (f *FallbackStorage) Retrieve(key string) ([]byte, error) {
+	return f.RetrieveContext(context.Background(), key)
 }
 
-func (e *EncryptedFileStorage) encrypt(data []byte) ([]byte, error) {
-	// Derive key from passphrase using Argon2
-	salt := make([]byte, 16)
-	rand.Read(salt)
-	key := argon2.IDKey([]byte(e.passphrase), salt, 1, 64*1024, 4, 32)
-
-	// Create AES cipher
-	block, err := aes.NewCipher(key)
-	if err != nil {
+func (f *FallbackStorage) RetrieveContext(ctx context.Context, key string) ([]byte, error) {
+	data, err := retrieveKey(ctx, f.fallback, key)
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+	data, err = retrieveKey(ctx, f.primary, key)
 	if err != nil {
+		if errors.Is(err, errKeyringUnavailable) {
+			return nil, fmt.Errorf("%w: %v", os.ErrNotExist, err)
+		}
 		return nil, err
 	}
+	return data, nil
+}
+
+func (f *FallbackStorage) Delete(key string) error {
+	fallbackErr := f.fallback.Delete(key)
+	primaryErr := f.primary.Delete(key)
+
+	// Succeed if the key was removed from either storage
+	if fallbackErr == nil || primaryErr == nil {
+		return nil
+	}
+	if errors.Is(primaryErr, errKeyringUnavailable) {
+		return fallbackErr
+	}
+	return primaryErr
+}
+
+// Wipe overwrites the fallback copy and removes the key from both storages
+func (f *FallbackStorage) Wipe(key string) error {
+	fallbackErr := wipeKey(f.fallback, key)
+	primaryErr := wipeKey(f.primary, key)
+
+	if fallbackErr == nil || primaryErr == nil {
+		return nil
+	}
+	if errors.Is(primaryErr, errKeyringUnavailable) {
+		return fallbackErr
+	}
+	return primaryErr
+}
 
-	// Generate nonce
-	nonce := make([]byte, gcm.NonceSize())
-	rand.Read(nonce)
+// encFileMagic starts encrypted files that carry a SHA-256 checksum of their
+// ciphertext. Files written before it was introduced hold the bare blob.
+const encFileMagic = "TABDENC1"
 
-	// Encrypt data
-	ciphertext := gcm.Seal(nil, nonce, data, nil)
+var (
+	// ErrCorrupted is returned when a stored file fails its checksum
+	ErrCorrupted = errors.New("stored data is corrupted")
 
-	// Combine salt + nonce + ciphertext
-	result := make([]byte, 16+len(nonce)+len(ciphertext))
-	copy(result[:16], salt)
-	copy(result[16:16+len(nonce)], nonce)
-	copy(result[16+len(nonce):], ciphertext)
+	// ErrDecrypt is returned when intact data cannot be decrypted, usually
+	// because the storage key changed
+	ErrDecrypt = errors.New("failed to decrypt stored data")
+)
 
-	return result, nil
+// encodeEncFile prefixes an encrypted blob with the file header and checksum
+func encodeEncFile(encrypted []byte) []byte {
+	sum := sha256.Sum256(encrypted)
+	data := make([]byte, 0, len(encFileMagic)+len(sum)+len(encrypted))
+	data = append(data, encFileMagic...)
+	data = append(data, sum[:]...)
+	return append(data, encrypted...)
 }
 
-func (e *EncryptedFileStorage) decrypt(data []byte) ([]byte, error) {
-	if len(data) < 16+12 { // salt + nonce minimum
-		return nil, fmt.Errorf("invalid encrypted data")
+// decodeEncFile verifies the checksum of an encrypted file and returns the
+// blob, passing files without a header through unchecked
+func decodeEncFile(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encFileMagic)) {
+		return data, nil
 	}
 
-	// Extract components
-	salt := data[:16]
-	nonceSize := 12 // GCM standard nonce size
-	nonce := data[16 : 16+nonceSize]
-	ciphertext := data[16+nonceSize:]
+	data = data[len(encFileMagic):]
+	if len(data) < sha256.Size {
+		return nil, fmt.Errorf("%w: truncated header", ErrCorrupted)
+	}
+	sum, encrypted := data[:sha256.Size], data[sha256.Size:]
+	if actual := sha256.Sum256(encrypted); !bytes.Equal(sum, actual[:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+	return encrypted, nil
+}
 
-	// Derive key from passphrase
-	key := argon2.IDKey([]byte(e.passphrase), salt, 1, 64*1024, 4, 32)
+// EncryptedFileStorage implementation. Files are replaced atomically, so a
+// crash mid-write leaves the previous version in place.
+func (e *EncryptedFileStorage) Store(key string, data []byte) error {
+	return e.StoreContext(context.Background(), key, data)
+}
 
-	// Create AES cipher
-	block, err := aes.NewCipher(key)
+// StoreContext encrypts data and writes it, unless ctx is done by the time
+// it is encrypted
+func (e *EncryptedFileStorage) StoreContext(ctx context.Context, key string, data []byte) error {
+	encrypted, err := e.cipher.Encrypt(data)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to encrypt data: %v", err)
+	}
+	if err := contextErr(ctx); err != nil {
+		return err
 	}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+	filePath := filepath.Join(e.storageDir, key+".enc")
+	return writeFileAtomic(filePath, encodeEncFile(encrypted), 0600)
+}
+
+func (e *EncryptedFileStorage) Retrieve(key string) ([]byte, error) {
+	return e.RetrieveContext(context.Background(), key)
+}
+
+// RetrieveContext reads and decrypts a file, unless ctx is done by the time
+// it is read
+func (e *EncryptedFileStorage) RetrieveContext(ctx context.Context, key string) ([]byte, error) {
+	filePath := filepath.Join(e.storageDir, key+".enc")
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
+	if err := contextErr(ctx); err != nil {
+		return nil, err
+	}
+
+	encrypted, err := decodeEncFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(filePath), err)
+	}
+
+	plaintext, err := e.cipher.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", filepath.Base(filePath), ErrDecrypt, err)
+	}
+	return plaintext, nil
+}
+
+func (e *EncryptedFileStorage) Delete(key string) error {
+	filePath := filepath.Join(e.storageDir, key+".enc")
+	return os.Remove(filePath)
+}
 
-	// Decrypt data
-	return gcm.Open(nil, nonce, ciphertext, nil)
+// Wipe overwrites the encrypted file before removing it
+func (e *EncryptedFileStorage) Wipe(key string) error {
+	return wipeFile(filepath.Join(e.storageDir, key+".enc"))
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestFileStorage creates encrypted file storage in a temporary directory
+func newTestFileStorage(t *testing.T, cipher *BlobCipher) *EncryptedFileStorage {
+	t.Helper()
+	return &EncryptedFileStorage{storageDir: t.TempDir(), cipher: cipher}
+}
+
+func TestEncryptedFileStorageRoundTrip(t *testing.T) {
+	for _, suite := range testSuites {
+		storage := newTestFileStorage(t, newTestCipher(suite, "passphrase"))
+		if err := storage.Store("latest_clipboard", []byte("hello")); err != nil {
+			t.Fatalf("%s: Store: %v", suite, err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(storage.storageDir, "latest_clipboard.enc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, []byte(encFileMagic)) || bytes.Contains(data, []byte("hello")) {
+			t.Fatalf("%s: file is not an encrypted file with a checksum header", suite)
+		}
+
+		plaintext, err := storage.Retrieve("latest_clipboard")
+		if err != nil || string(plaintext) != "hello" {
+			t.Fatalf("%s: Retrieve = %q, %v", suite, plaintext, err)
+		}
+	}
+}
+
+func TestEncryptedFileStorageMissing(t *testing.T) {
+	storage := newTestFileStorage(t, newTestCipher(suiteAESGCM, "passphrase"))
+	if _, err := storage.Retrieve("latest_clipboard"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Retrieve of a missing key = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestEncryptedFileStorageCorrupted(t *testing.T) {
+	storage := newTestFileStorage(t, newTestCipher(suiteAESGCM, "passphrase"))
+	if err := storage.Store("latest_clipboard", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(storage.storageDir, "latest_clipboard.enc")
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0x01
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := storage.Retrieve("latest_clipboard")
+	if !errors.Is(err, ErrCorrupted) || errors.Is(err, ErrDecrypt) {
+		t.Fatalf("Retrieve of a damaged file = %v, want ErrCorrupted", err)
+	}
+	if code := errorCode(err); code != codeCorrupted {
+		t.Fatalf("errorCode = %s, want %s", code, codeCorrupted)
+	}
+	if code := exitCode(err); code != exitCorrupt {
+		t.Fatalf("exitCode = %d, want %d", code, exitCorrupt)
+	}
+}
+
+func TestEncryptedFileStorageWrongKey(t *testing.T) {
+	storage := newTestFileStorage(t, newTestCipher(suiteAESGCM, "old"))
+	if err := storage.Store("latest_clipboard", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	storage.cipher = newTestCipher(suiteAESGCM, "new")
+	_, err := storage.Retrieve("latest_clipboard")
+	if !errors.Is(err, ErrDecrypt) || errors.Is(err, ErrCorrupted) {
+		t.Fatalf("Retrieve with another key = %v, want ErrDecrypt", err)
+	}
+	if code := errorCode(err); code != codeDecryptFailed {
+		t.Fatalf("errorCode = %s, want %s", code, codeDecryptFailed)
+	}
+}
+
+func TestStageFileRotation(t *testing.T) {
+	from := newTestCipher(suiteAESGCM, "old")
+	to := newTestCipher(suiteAESGCM, "new")
+	storage := newTestFileStorage(t, from)
+	for _, key := range []string{"latest_clipboard", "history_index"} {
+		if err := storage.Store(key, []byte("value of "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacyPath := filepath.Join(storage.storageDir, "legacy.enc")
+	if err := os.WriteFile(legacyPath, sealLegacy(t, from, blobKeyMagic, []byte("value of legacy")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := stageFileRotation(storage.storageDir, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 {
+		t.Fatalf("staged %d files, want 3", len(paths))
+	}
+
+	// Until the staged copies are swapped in, the old key still reads
+	// everything
+	if data, err := storage.Retrieve("latest_clipboard"); err != nil || string(data) != "value of latest_clipboard" {
+		t.Fatalf("Retrieve before the swap = %q, %v", data, err)
+	}
+
+	before, _ := os.ReadFile(filepath.Join(storage.storageDir, "latest_clipboard.enc"))
+	for _, path := range paths {
+		if err := os.Rename(path+rotateSuffix, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, _ := os.ReadFile(filepath.Join(storage.storageDir, "latest_clipboard.enc"))
+
+	// Envelope blobs keep their sealed data; only the data key is rewrapped
+	sealedSize := suiteAESGCM.nonceSize() + len("value of latest_clipboard") + aeadTagSize
+	if !bytes.Equal(before[len(before)-sealedSize:], after[len(after)-sealedSize:]) {
+		t.Fatal("rotation re-encrypted the data of an envelope blob")
+	}
+
+	storage.cipher = to
+	for _, key := range []string{"latest_clipboard", "history_index", "legacy"} {
+		data, err := storage.Retrieve(key)
+		if err != nil || string(data) != "value of "+key {
+			t.Fatalf("Retrieve(%s) after rotation = %q, %v", key, data, err)
+		}
+	}
+	storage.cipher = from
+	if _, err := storage.Retrieve("latest_clipboard"); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("Retrieve with the old key after rotation = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestStageFileRotationFailure(t *testing.T) {
+	from := newTestCipher(suiteAESGCM, "old")
+	storage := newTestFileStorage(t, from)
+	if err := storage.Store("latest_clipboard", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	other := newTestFileStorage(t, newTestCipher(suiteAESGCM, "unrelated"))
+	other.storageDir = storage.storageDir
+	if err := other.Store("unreadable", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stageFileRotation(storage.storageDir, from, newTestCipher(suiteAESGCM, "new")); err == nil {
+		t.Fatal("rotation succeeded with a file the old key cannot read")
+	}
+	staged, _ := filepath.Glob(filepath.Join(storage.storageDir, "*"+rotateSuffix))
+	if len(staged) != 0 {
+		t.Fatalf("failed rotation left staged copies: %v", staged)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// syncKeyName and syncStateName are the secure storage keys holding the
+	// shared sync key and the content hashes already synced
+	syncKeyName   = "sync_key"
+	syncStateName = "sync_state"
+
+	// syncSnapshotVersion is the snapshot format written to the relay
+	syncSnapshotVersion = 1
+
+	// defaultSyncInterval is how often the daemon syncs when no interval is
+	// configured, and defaultSyncMaxEntries how many entries the relay keeps
+	defaultSyncInterval   = 15 * time.Minute
+	defaultSyncMaxEntries = 100
+
+	// syncAttempts bounds the retries when another machine updates the
+	// snapshot at the same time
+	syncAttempts = 3
+
+	// syncSeenLimit caps the content hashes remembered in the sync state
+	syncSeenLimit = 10000
+
+	// syncLockName serialises syncs started by the daemon and the CLI
+	syncLockName = ".sync.lock"
+)
+
+// SyncConfig configures the relay used to share history between machines
+type SyncConfig struct {
+	// Relay is an http(s):// URL, such as a WebDAV file, or s3://bucket/key
+	Relay string `json:"relay"`
+
+	// Headers are sent with every relay request, e.g. Authorization
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Region and Endpoint configure s3:// relays. Endpoint selects an S3
+	// compatible service instead of AWS.
+	Region   string `json:"region,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Interval is how often the daemon syncs, e.g. "15m", or "0" to only
+	// sync on demand
+	Interval string `json:"interval,omitempty"`
+
+	// MaxEntries caps the number of entries kept on the relay
+	MaxEntries int `json:"maxEntries,omitempty"`
+}
+
+// applyDefaults fills in unset sync values and validates the rest
+func (s *SyncConfig) applyDefaults() error {
+	parsed, err := url.Parse(s.Relay)
+	if s.Relay == "" || err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid sync relay %q", s.Relay)
+	}
+	switch parsed.Scheme {
+	case "http", "https", "s3":
+	default:
+		return fmt.Errorf("invalid sync relay %q: must be an http, https or s3 URL", s.Relay)
+	}
+
+	if s.Interval != "" {
+		if _, err := parseAge(s.Interval); err != nil {
+			return fmt.Errorf("invalid sync interval: %v", err)
+		}
+	}
+	if s.MaxEntries <= 0 {
+		s.MaxEntries = defaultSyncMaxEntries
+	}
+	return nil
+}
+
+// IntervalPeriod returns how often the daemon syncs, or zero if it does not
+func (s *SyncConfig) IntervalPeriod() time.Duration {
+	if s.Interval == "" {
+		return defaultSyncInterval
+	}
+	period, _ := parseAge(s.Interval)
+	return period
+}
+
+// syncEntry is a history entry in the relay snapshot
+type syncEntry struct {
+	Hash   string `json:"hash"`
+	Pinned bool   `json:"pinned,omitempty"`
+	ClipboardData
+}
+
+// syncSnapshot is the history shared through the relay, encrypted with the
+// sync key before it leaves the machine
+type syncSnapshot struct {
+	Version int         `json:"version"`
+	Updated int64       `json:"updated"`
+	Entries []syncEntry `json:"entries"`
+}
+
+// syncState records the content hashes this machine has already pulled or
+// pushed, so entries deleted locally are not pulled again
+type syncState struct {
+	Seen []string `json:"seen"`
+}
+
+// SyncResult reports the entries exchanged by a sync
+type SyncResult struct {
+	Pulled int `json:"pulled"`
+	Pushed int `json:"pushed"`
+}
+
+// newSyncKey generates a random shared sync key. Hex keeps it free of
+// characters that shells or flag parsing could misread.
+func newSyncKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return hex.EncodeToString(key)
+}
+
+// validateSyncKey checks that a key was produced by newSyncKey
+func validateSyncKey(key string) error {
+	decoded, err := hex.DecodeString(key)
+	if err != nil || len(decoded) != 32 {
+		return errors.New("invalid sync key: expected the key printed by sync init")
+	}
+	return nil
+}
+
+// loadSyncState reads the sync state, treating a missing state as empty
+func (t *TabdNativeHost) loadSyncState() (*syncState, error) {
+	state := &syncState{}
+	data, err := t.storage().Retrieve(syncStateName)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %v", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state: %v", err)
+	}
+	return state, nil
+}
+
+// syncHistory pushes local history to the relay and pulls entries added by
+// other machines
+func (t *TabdNativeHost) syncHistory() (*SyncResult, error) {
+	if t.config.Sync == nil {
+		return nil, errors.New("sync is not configured: set sync.relay in the config file")
+	}
+	if t.isIncognito() {
+		return nil, errors.New("sync is paused in incognito mode")
+	}
+
+	key, err := t.storage().Retrieve(syncKeyName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("no sync key: run sync init on the first machine and sync join on the others")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync key: %v", err)
+	}
+
+	relay, err := newSyncRelay(t.config.Sync)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := acquireFileLock(filepath.Join(t.tabdDir, syncLockName))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	cipher := NewBlobCipher(string(key))
+	for attempt := 1; ; attempt++ {
+		result, err := t.syncOnce(relay, cipher)
+		if errors.Is(err, errSyncConflict) && attempt < syncAttempts {
+			logInfof("Sync snapshot changed during upload, retrying")
+			continue
+		}
+		return result, err
+	}
+}
+
+// syncOnce runs one download, merge and upload cycle
+func (t *TabdNativeHost) syncOnce(relay syncRelay, cipher *BlobCipher) (*SyncResult, error) {
+	blob, etag, err := relay.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download sync snapshot: %v", err)
+	}
+
+	snapshot := &syncSnapshot{}
+	if blob != nil {
+		plaintext, err := cipher.Decrypt(blob)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt sync snapshot (is the same sync key used on every machine?): %v", err)
+		}
+		if err := json.Unmarshal(plaintext, snapshot); err != nil {
+			return nil, fmt.Errorf("failed to parse sync snapshot: %v", err)
+		}
+		if snapshot.Version > syncSnapshotVersion {
+			return nil, fmt.Errorf("sync snapshot version %d is newer than supported, update tabd-native-host", snapshot.Version)
+		}
+	}
+
+	state, err := t.loadSyncState()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(state.Seen))
+	for _, hash := range state.Seen {
+		seen[hash] = true
+	}
+
+	// Hash every local entry. Entries tagged as sensitive and entries from
+	// private browsing windows never leave the machine.
+	history, err := t.historyStore().List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clipboard history: %v", err)
+	}
+	local := make(map[string]bool, len(history))
+	var outgoing []syncEntry
+	for _, entry := range history {
+		data, err := t.historyStore().Get(entry.ID)
+		if err != nil {
+			logWarnf("Skipping unreadable history entry %s: %v", entry.ID, err)
+			continue
+		}
+		hash := contentHash(data)
+		local[hash] = true
+		if len(data.Sensitive) == 0 && !data.Incognito {
+			outgoing = append(outgoing, syncEntry{Hash: hash, Pinned: entry.Pinned, ClipboardData: *data})
+		}
+	}
+
+	// Pull entries this machine has never had, oldest first
+	remote := make(map[string]bool, len(snapshot.Entries))
+	var incoming []syncEntry
+	for _, entry := range snapshot.Entries {
+		remote[entry.Hash] = true
+		if !local[entry.Hash] && !seen[entry.Hash] {
+			incoming = append(incoming, entry)
+		}
+	}
+	sort.SliceStable(incoming, func(i, j int) bool { return incoming[i].Timestamp < incoming[j].Timestamp })
+
+	result := &SyncResult{}
+	for i := range incoming {
+		id, err := t.historyStore().Append(&incoming[i].ClipboardData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add synced entry: %v", err)
+		}
+		if incoming[i].Pinned {
+			if err := t.historyStore().Pin(id, true); err != nil {
+				logWarnf("Error pinning synced entry %s: %v", id, err)
+			}
+		}
+		local[incoming[i].Hash] = true
+		result.Pulled++
+	}
+
+	// Push local entries the relay does not have, keeping the newest
+	merged := snapshot.Entries
+	for _, entry := range outgoing {
+		if !remote[entry.Hash] {
+			merged = append(merged, entry)
+			remote[entry.Hash] = true
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Timestamp > merged[j].Timestamp })
+	if len(merged) > t.config.Sync.MaxEntries {
+		merged = merged[:t.config.Sync.MaxEntries]
+	}
+	previous := make(map[string]bool, len(snapshot.Entries))
+	for _, entry := range snapshot.Entries {
+		previous[entry.Hash] = true
+	}
+	for _, entry := range merged {
+		if !previous[entry.Hash] {
+			result.Pushed++
+		}
+	}
+
+	if result.Pushed > 0 {
+		plaintext, err := json.Marshal(&syncSnapshot{
+			Version: syncSnapshotVersion,
+			Updated: time.Now().Unix(),
+			Entries: merged,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode sync snapshot: %v", err)
+		}
+		blob, err := cipher.Encrypt(plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt sync snapshot: %v", err)
+		}
+		if err := relay.Put(blob, etag); err != nil {
+			if errors.Is(err, errSyncConflict) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to upload sync snapshot: %v", err)
+		}
+	}
+
+	// Remember everything on either side
+	for hash := range local {
+		if !seen[hash] {
+			state.Seen = append(state.Seen, hash)
+			seen[hash] = true
+		}
+	}
+	for hash := range remote {
+		if !seen[hash] {
+			state.Seen = append(state.Seen, hash)
+			seen[hash] = true
+		}
+	}
+	if len(state.Seen) > syncSeenLimit {
+		state.Seen = state.Seen[len(state.Seen)-syncSeenLimit:]
+	}
+	stateData, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sync state: %v", err)
+	}
+	if err := t.storage().Store(syncStateName, stateData); err != nil {
+		return nil, fmt.Errorf("failed to save sync state: %v", err)
+	}
+
+	return result, nil
+}
+
+// runSyncLoop syncs periodically until stop is closed
+func (t *TabdNativeHost) runSyncLoop(stop <-chan struct{}) {
+	if t.config.Sync == nil {
+		return
+	}
+	interval := t.config.Sync.IntervalPeriod()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if t.isIncognito() {
+			logDebugf("Not syncing clipboard history in incognito mode")
+		} else if result, err := t.syncHistory(); err != nil {
+			logErrorf("Error syncing clipboard history: %v", err)
+		} else if result.Pulled > 0 || result.Pushed > 0 {
+			logInfof("Synced clipboard history: pulled %d, pushed %d", result.Pulled, result.Pushed)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// newSyncCommand creates the sync command, which syncs history with the relay,
+// or sets up the shared sync key
+func newSyncCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync [init | join <key>]",
+		Short: "Sync clipboard history with other machines through a relay",
+	}
+	flags := cmd.Flags()
+	force := flags.Bool("force", false, "replace an existing sync key")
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		switch {
+		case len(positional) == 0:
+			result, err := host.syncHistory()
+			if err != nil {
+				return err
+			}
+			notef("Pulled %d and pushed %d entries\n", result.Pulled, result.Pushed)
+			return nil
+
+		case positional[0] == "init" && len(positional) == 1:
+			if _, err := host.storage().Retrieve(syncKeyName); err == nil && !*force {
+				return errors.New("a sync key already exists (use --force to replace it)")
+			}
+			key := newSyncKey()
+			if err := host.storage().Store(syncKeyName, []byte(key)); err != nil {
+				return fmt.Errorf("failed to store sync key: %v", err)
+			}
+			fmt.Println(key)
+			fmt.Fprintln(os.Stderr, "Run `tabd-native-host sync join <key>` with this key on your other machines")
+			return nil
+
+		case positional[0] == "join" && len(positional) == 2:
+			key := positional[1]
+			if err := validateSyncKey(key); err != nil {
+				return err
+			}
+			if _, err := host.storage().Retrieve(syncKeyName); err == nil && !*force {
+				return errors.New("a sync key already exists (use --force to replace it)")
+			}
+			if err := host.storage().Store(syncKeyName, []byte(key)); err != nil {
+				return fmt.Errorf("failed to store sync key: %v", err)
+			}
+			notef("Sync key saved\n")
+			return nil
+		}
+
+		return errors.New("usage: sync [init | join <key>] [--force]")
+	})
+	return cmd
+}
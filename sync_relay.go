@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// syncTimeout bounds each request made to the relay
+	syncTimeout = 2 * time.Minute
+
+	// maxSnapshotSize caps the encrypted snapshot downloaded from the relay
+	maxSnapshotSize = 256 * 1024 * 1024
+)
+
+// errSyncConflict reports that another machine replaced the snapshot between
+// downloading and uploading it
+var errSyncConflict = errors.New("snapshot changed on the relay")
+
+// syncRelay stores the encrypted history snapshot shared between machines
+type syncRelay interface {
+	// Get downloads the snapshot and its ETag, returning nil data if the
+	// relay holds no snapshot yet
+	Get() ([]byte, string, error)
+	// Put uploads the snapshot, replacing the version with the given ETag,
+	// or only creating it if the ETag is empty. It returns errSyncConflict
+	// if the snapshot has changed since.
+	Put(data []byte, etag string) error
+}
+
+// newSyncRelay creates the relay for an http(s):// URL, which covers WebDAV
+// and generic HTTP endpoints, or an s3://bucket/key URL
+func newSyncRelay(config *SyncConfig) (syncRelay, error) {
+	parsed, err := url.Parse(config.Relay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sync relay %q: %v", config.Relay, err)
+	}
+
+	relay := &httpRelay{
+		headers: config.Headers,
+		client:  &http.Client{Timeout: syncTimeout},
+	}
+
+	switch parsed.Scheme {
+	case "http", "https":
+		relay.url = config.Relay
+	case "s3":
+		signer, objectURL, err := newS3Signer(parsed, config)
+		if err != nil {
+			return nil, err
+		}
+		relay.url = objectURL
+		relay.sign = signer.sign
+	default:
+		return nil, fmt.Errorf("invalid sync relay %q: must be an http, https or s3 URL", config.Relay)
+	}
+
+	if relay.url == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid sync relay %q: missing host", config.Relay)
+	}
+	return relay, nil
+}
+
+// httpRelay keeps the snapshot at a URL that supports GET and PUT with
+// conditional requests, such as a WebDAV share or an S3 object
+type httpRelay struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	// sign, when set, authenticates each request with its payload
+	sign func(request *http.Request, payload []byte)
+}
+
+// do sends a request to the relay URL with the configured headers
+func (r *httpRelay) do(method string, payload []byte, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequest(method, r.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", "tabd-native-host/"+version)
+	for name, value := range r.headers {
+		request.Header.Set(name, value)
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	if r.sign != nil {
+		r.sign(request, payload)
+	}
+	return r.client.Do(request)
+}
+
+func (r *httpRelay) Get() ([]byte, string, error) {
+	response, err := r.do(http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("relay returned %s", response.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxSnapshotSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download snapshot: %v", err)
+	}
+	if len(data) > maxSnapshotSize {
+		return nil, "", fmt.Errorf("snapshot exceeds %d bytes", maxSnapshotSize)
+	}
+	return data, response.Header.Get("ETag"), nil
+}
+
+func (r *httpRelay) Put(data []byte, etag string) error {
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	} else {
+		header.Set("If-None-Match", "*")
+	}
+
+	response, err := r.do(http.MethodPut, data, header)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode == http.StatusPreconditionFailed {
+		return errSyncConflict
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("relay returned %s", response.Status)
+	}
+	return nil
+}
+
+// s3Signer signs requests with AWS Signature Version 4 using credentials
+// from the standard AWS environment variables
+type s3Signer struct {
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// newS3Signer creates a signer for an s3://bucket/key URL and returns the
+// HTTPS URL of the object. A custom endpoint, for S3 compatible services, is
+// addressed in path style.
+func newS3Signer(parsed *url.URL, config *SyncConfig) (*s3Signer, string, error) {
+	bucket := parsed.Host
+	key := strings.TrimPrefix(parsed.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, "", fmt.Errorf("invalid sync relay %q: expected s3://bucket/key", config.Relay)
+	}
+
+	signer := &s3Signer{
+		region:       firstNonEmpty(config.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if signer.accessKey == "" || signer.secretKey == "" {
+		return nil, "", fmt.Errorf("s3 sync requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+
+	objectURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, signer.region, s3EscapePath(key))
+	if config.Endpoint != "" {
+		objectURL = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(config.Endpoint, "/"), bucket, s3EscapePath(key))
+	}
+	return signer, objectURL, nil
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// s3EscapePath percent-encodes each segment of an object key
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
+
+// sign adds the SigV4 authorization headers to a request
+func (s *s3Signer) sign(request *http.Request, payload []byte) {
+	s.signAt(request, payload, time.Now().UTC())
+}
+
+// signAt signs a request as of the given time
+func (s *s3Signer) signAt(request *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(payload)
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if s.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	// Sign the host and every x-amz-* or conditional header
+	headers := map[string]string{"host": request.URL.Host}
+	for name := range request.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || strings.HasPrefix(lower, "if-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(request.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// tabSessionIndexName is the secure storage key listing saved sessions,
+	// and tabSessionPrefix the prefix of the key holding each session's tabs
+	tabSessionIndexName = "tab_sessions"
+	tabSessionPrefix    = "tab_session_"
+)
+
+// Tab is an open browser tab captured in a session
+type Tab = protocol.Tab
+
+// TabSession is a named snapshot of the open tabs
+type TabSession struct {
+	Name  string `json:"name"`
+	Saved int64  `json:"saved"`
+	Tabs  []Tab  `json:"tabs"`
+}
+
+// TabSessionSummary describes a saved session without its tabs
+type TabSessionSummary struct {
+	Name    string `json:"name"`
+	Saved   int64  `json:"saved"`
+	Tabs    int    `json:"tabs"`
+	Windows int    `json:"windows"`
+}
+
+// summary counts the tabs and windows of a session
+func (s *TabSession) summary() TabSessionSummary {
+	windows := map[int]bool{}
+	for _, tab := range s.Tabs {
+		windows[tab.WindowID] = true
+	}
+	return TabSessionSummary{Name: s.Name, Saved: s.Saved, Tabs: len(s.Tabs), Windows: len(windows)}
+}
+
+// Windows returns the session's tabs grouped by window, in window order
+func (s *TabSession) Windows() [][]Tab {
+	byWindow := map[int][]Tab{}
+	ids := []int{}
+	for _, tab := range s.Tabs {
+		if _, ok := byWindow[tab.WindowID]; !ok {
+			ids = append(ids, tab.WindowID)
+		}
+		byWindow[tab.WindowID] = append(byWindow[tab.WindowID], tab)
+	}
+
+	windows := make([][]Tab, 0, len(ids))
+	for _, id := range ids {
+		windows = append(windows, byWindow[id])
+	}
+	return windows
+}
+
+// validateSessionName checks that a session name is safe to use in a storage
+// key, following the same rules as profile names
+func validateSessionName(name string) error {
+	if !validProfile.MatchString(name) {
+		return invalidRequestf("invalid session name %q: use letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// tabSessions returns the summaries of the saved sessions, newest first
+func (t *TabdNativeHost) tabSessions() ([]TabSessionSummary, error) {
+	data, err := t.storage().Retrieve(tabSessionIndexName)
+	if errors.Is(err, os.ErrNotExist) {
+		return []TabSessionSummary{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session index: %v", err)
+	}
+	var sessions []TabSessionSummary
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to parse session index: %v", err)
+	}
+	return sessions, nil
+}
+
+// saveTabSession stores a session, replacing any with the same name
+func (t *TabdNativeHost) saveTabSession(session *TabSession) error {
+	if err := validateSessionName(session.Name); err != nil {
+		return err
+	}
+	for i, tab := range session.Tabs {
+		if tab.URL == "" {
+			return invalidRequestf("tab %d has no URL", i)
+		}
+	}
+	if session.Saved == 0 {
+		session.Saved = time.Now().UnixMilli()
+	}
+
+	lock, err := acquireFileLock(storageLockPath(t.tabdDir))
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %v", err)
+	}
+	if err := t.storage().Store(tabSessionPrefix+session.Name, data); err != nil {
+		return fmt.Errorf("failed to store session: %v", err)
+	}
+
+	sessions, err := t.tabSessions()
+	if err != nil {
+		return err
+	}
+	kept := []TabSessionSummary{session.summary()}
+	for _, existing := range sessions {
+		if existing.Name != session.Name {
+			kept = append(kept, existing)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Saved > kept[j].Saved })
+	return t.storeTabSessionIndex(kept)
+}
+
+// storeTabSessionIndex replaces the session index
+func (t *TabdNativeHost) storeTabSessionIndex(sessions []TabSessionSummary) error {
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+	if err := t.storage().Store(tabSessionIndexName, data); err != nil {
+		return fmt.Errorf("failed to store session index: %v", err)
+	}
+	return nil
+}
+
+// getTabSession loads a saved session by name
+func (t *TabdNativeHost) getTabSession(name string) (*TabSession, error) {
+	if err := validateSessionName(name); err != nil {
+		return nil, err
+	}
+	data, err := t.storage().Retrieve(tabSessionPrefix + name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &codedError{code: codeNotFound, err: fmt.Errorf("no session named %q", name)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+	var session TabSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to parse session: %v", err)
+	}
+	return &session, nil
+}
+
+// deleteTabSession removes a saved session by name
+func (t *TabdNativeHost) deleteTabSession(name string) error {
+	if err := validateSessionName(name); err != nil {
+		return err
+	}
+
+	lock, err := acquireFileLock(storageLockPath(t.tabdDir))
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if err := t.storage().Delete(tabSessionPrefix + name); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &codedError{code: codeNotFound, err: fmt.Errorf("no session named %q", name)}
+		}
+		return fmt.Errorf("failed to delete session: %v", err)
+	}
+
+	sessions, err := t.tabSessions()
+	if err != nil {
+		return err
+	}
+	kept := []TabSessionSummary{}
+	for _, existing := range sessions {
+		if existing.Name != name {
+			kept = append(kept, existing)
+		}
+	}
+	return t.storeTabSessionIndex(kept)
+}
+
+// handleSaveSession stores the tabs carried by the message under a name
+func (t *TabdNativeHost) handleSaveSession(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	tabSession := &TabSession{Name: msg.Name, Tabs: msg.Tabs}
+	if tabSession.Tabs == nil {
+		tabSession.Tabs = []Tab{}
+	}
+	if err := t.saveTabSession(tabSession); err != nil {
+		return "", nil, fmt.Errorf("Failed to save session: %w", err)
+	}
+	return "Session saved successfully", tabSession.summary(), nil
+}
+
+// handleListSessions returns the summaries of the saved sessions
+func (t *TabdNativeHost) handleListSessions(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	sessions, err := t.tabSessions()
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to list sessions: %w", err)
+	}
+	return "", sessions, nil
+}
+
+// handleGetSession returns a saved session with its tabs
+func (t *TabdNativeHost) handleGetSession(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	tabSession, err := t.getTabSession(msg.Name)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to get session: %w", err)
+	}
+	return "", tabSession, nil
+}
+
+// newSessionsCommand creates the sessions command, which lists, prints,
+// restores or deletes saved tab sessions
+func newSessionsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sessions [list | show <name> | restore <name> | delete <name>]",
+		Short: "List, show, restore or delete saved tab sessions",
+	}
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		if len(positional) == 0 || positional[0] == "list" {
+			sessions, err := host.tabSessions()
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(sessions)
+			}
+			for _, session := range sessions {
+				fmt.Printf("%-24s %d tabs in %d windows, saved %s\n", session.Name, session.Tabs, session.Windows,
+					time.UnixMilli(session.Saved).Format("2006-01-02 15:04"))
+			}
+			return nil
+		}
+
+		if len(positional) != 2 {
+			return fmt.Errorf("usage: sessions [list | show <name> | restore <name> | delete <name>]")
+		}
+		name := positional[1]
+
+		switch positional[0] {
+		case "show":
+			session, err := host.getTabSession(name)
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				return printJSON(session)
+			}
+			for i, window := range session.Windows() {
+				fmt.Printf("Window %d:\n", i+1)
+				for _, tab := range window {
+					marker := " "
+					if tab.Pinned {
+						marker = "*"
+					}
+					line := fmt.Sprintf(" %s %s", marker, tab.URL)
+					if tab.Title != "" {
+						line += "  " + tab.Title
+					}
+					fmt.Println(line)
+				}
+			}
+			return nil
+		case "restore":
+			session, err := host.getTabSession(name)
+			if err != nil {
+				return err
+			}
+			opened := 0
+			for _, window := range session.Windows() {
+				for _, tab := range window {
+					// Only web pages are handed to the OS, never local files or
+					// browser internal pages
+					if !strings.HasPrefix(tab.URL, "http://") && !strings.HasPrefix(tab.URL, "https://") {
+						fmt.Fprintf(os.Stderr, "Skipping %s: only http and https tabs can be restored\n", tab.URL)
+						continue
+					}
+					if err := openURL(tab.URL); err != nil {
+						return fmt.Errorf("failed to open %s: %v", tab.URL, err)
+					}
+					opened++
+				}
+			}
+			fmt.Printf("Opened %d tabs from session %s\n", opened, session.Name)
+			return nil
+		case "delete":
+			return host.deleteTabSession(name)
+		default:
+			return fmt.Errorf("unknown sessions command: %s", positional[0])
+		}
+	})
+	return cmd
+}
+
+// printJSON prints a value to stdout as indented JSON
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// openURL opens a URL in the default browser
+func openURL(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Run()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// maxTags caps the tags on one entry, and maxNoteLength the length of its
+	// note in bytes
+	maxTags       = 32
+	maxNoteLength = 4096
+)
+
+// validTag matches tags: up to 64 characters without spaces or commas, so
+// tags can be listed on the command line and in CSV exports
+var validTag = regexp.MustCompile(`^[^\s,]{1,64}$`)
+
+// normalizeTags validates tags and removes duplicates, keeping their order
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !validTag.MatchString(tag) {
+			return nil, invalidRequestf("invalid tag %q: use up to 64 characters without spaces or commas", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, invalidRequestf("too many tags: at most %d are allowed", maxTags)
+	}
+	return normalized, nil
+}
+
+// tagResult is the data returned by a tag message
+type tagResult struct {
+	ID   string   `json:"id"`
+	Tags []string `json:"tags"`
+	Note string   `json:"note,omitempty"`
+}
+
+// setEntryMetadata replaces the tags and note of a history entry, keeping its
+// timestamp and content
+func (t *TabdNativeHost) setEntryMetadata(id string, tags []string, note string) (*ClipboardData, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if len(note) > maxNoteLength {
+		return nil, &codedError{code: codeTooLarge, err: fmt.Errorf("note exceeds %d bytes", maxNoteLength)}
+	}
+
+	data, err := t.historyStore().Get(id)
+	if err != nil {
+		return nil, err
+	}
+	data.Tags, data.Note = tags, note
+
+	// Update stamps entries saved without a timestamp with the current time,
+	// which would move the entry, so keep the time it was recorded with
+	if data.Timestamp == 0 {
+		entries, err := t.historyStore().List()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.ID == id {
+				data.Timestamp = entry.Timestamp
+				break
+			}
+		}
+	}
+	if err := t.historyStore().Update(id, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// handleTag sets the tags and note of a history entry, replacing any it had
+func (t *TabdNativeHost) handleTag(ctx context.Context, session *Session, msg *Message) (string, interface{}, error) {
+	if msg.ID == "" {
+		return "", nil, invalidRequestf("Failed to tag entry: missing id")
+	}
+
+	data, err := t.setEntryMetadata(msg.ID, msg.Tags, msg.Note)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to tag entry: %w", err)
+	}
+
+	tags := data.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return "Entry tagged successfully", &tagResult{ID: msg.ID, Tags: tags, Note: data.Note}, nil
+}
+
+// newTagCommand creates the tag command, which sets the tags and note of a
+// history entry from the command line
+func newTagCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tag <id> [tags...]",
+		Short: "Set the tags and note of a history entry",
+	}
+	flags := cmd.Flags()
+	note := flags.String("note", "", "set the entry's note (an empty value removes it)")
+	clear := flags.Bool("clear", false, "remove all tags")
+	cmd.RunE = withHost(func(host *TabdNativeHost, positional []string) error {
+		if len(positional) == 0 {
+			return fmt.Errorf("usage: tag [--note <text>] [--clear] <id> [tags...]")
+		}
+		id, tags := positional[0], positional[1:]
+
+		noteSet := flags.Changed("note")
+		if len(tags) == 0 && !*clear && !noteSet {
+			return fmt.Errorf("nothing to change: give tags, --clear or --note")
+		}
+		if len(tags) > 0 && *clear {
+			return fmt.Errorf("--clear cannot be combined with tags")
+		}
+
+		// Only the parts given on the command line are changed
+		current, err := host.historyStore().Get(id)
+		if err != nil {
+			if errors.Is(err, ErrEntryNotFound) {
+				return &codedError{code: codeNotFound, err: fmt.Errorf("no history entry %s", id)}
+			}
+			return err
+		}
+		if len(tags) == 0 && !*clear {
+			tags = current.Tags
+		}
+		if !noteSet {
+			*note = current.Note
+		}
+
+		if _, err := host.setEntryMetadata(id, tags, *note); err != nil {
+			return fmt.Errorf("failed to tag entry: %w", err)
+		}
+		return nil
+	})
+	return cmd
+}
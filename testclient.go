@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+	"github.com/spf13/cobra"
+)
+
+// testOrigin is the extension origin passed to the host, as Chrome does
+const testOrigin = chromiumOriginPrefix + defaultExtensionID + "/"
+
+// testStep is one request of a testclient script and the response expected.
+// Every field of Expect must appear in the response with the same value; a
+// value of "*" only requires the field to be present.
+type testStep struct {
+	Send   json.RawMessage        `json:"send"`
+	Expect map[string]interface{} `json:"expect,omitempty"`
+}
+
+// smokeTestSteps exercise the basic actions when no script is given
+var smokeTestSteps = []string{
+	`{"send": {"action": "hello", "protocolVersion": 1}, "expect": {"status": "success", "data": {"protocolVersion": 1}}}`,
+	`{"send": {"action": "ping"}, "expect": {"status": "success", "message": "pong"}}`,
+	`{"send": {"action": "save", "type": "text", "text": "tabd testclient", "url": "https://example.com/"}, "expect": {"status": "success", "data": {"id": "*"}}}`,
+	`{"send": {"action": "get"}, "expect": {"status": "success", "data": {"text": "tabd testclient"}}}`,
+	`{"send": {"action": "status"}, "expect": {"status": "success", "data": {"entries": 1}}}`,
+	`{"send": {"action": "no_such_action"}, "expect": {"status": "error", "code": "UNKNOWN_ACTION"}}`,
+}
+
+// newTestClientCommand creates the testclient command, which spawns the native
+// host the way Chrome does, sends it the requests of a script and checks the
+// responses
+func newTestClientCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "testclient",
+		Short: "Drive the native host like Chrome and check its responses",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	script := flags.String("script", "", "JSON lines file of {\"send\": ..., \"expect\": ...} steps (default: a built-in smoke test)")
+	hostPath := flags.String("host", "", "native host binary to test (default: this binary)")
+	isolated := flags.Bool("isolated", true, "run the host against a temporary storage directory instead of ~/.tabd")
+	timeout := flags.Duration("timeout", 10*time.Second, "how long to wait for each response")
+	verbose := flags.Bool("verbose", false, "print every response and event")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		steps, err := loadTestSteps(*script)
+		if err != nil {
+			return err
+		}
+
+		if *hostPath == "" {
+			if *hostPath, err = os.Executable(); err != nil {
+				return fmt.Errorf("failed to find executable: %v", err)
+			}
+		}
+
+		// Chrome passes the caller's origin, and on Windows a parent window
+		// handle, then talks over stdin and stdout
+		hostArgs := []string{testOrigin}
+		if runtime.GOOS == "windows" {
+			hostArgs = append(hostArgs, "--parent-window=0")
+		}
+		cmd := exec.Command(*hostPath, hostArgs...)
+		cmd.Env = os.Environ()
+		if *isolated {
+			home, err := os.MkdirTemp("", "tabd-testclient-")
+			if err != nil {
+				return fmt.Errorf("failed to create temporary directory: %v", err)
+			}
+			defer os.RemoveAll(home)
+			cmd.Env = append(cmd.Env, "HOME="+home, "USERPROFILE="+home, "TABD_DISABLE_KEYRING=1", "TABD_NO_DAEMON=1")
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start host: %v", err)
+		}
+		defer cmd.Process.Kill()
+
+		client := protocol.NewClient(stdout, stdin)
+		if *verbose {
+			client.OnEvent = func(event *protocol.Event) {
+				data, _ := json.Marshal(event)
+				fmt.Printf("     event %s\n", data)
+			}
+		}
+
+		failed := 0
+		for i, step := range steps {
+			start := time.Now()
+			response, err := callWithTimeout(client, step.Send, *timeout)
+			if err != nil {
+				fmt.Printf("FAIL %d %s: %v\n", i+1, stepAction(step.Send), err)
+				if stderr.Len() > 0 {
+					fmt.Printf("host stderr:\n%s", stderr.String())
+				}
+				return fmt.Errorf("host stopped responding after %d of %d steps", i, len(steps))
+			}
+
+			if *verbose {
+				fmt.Printf("     response %s\n", response)
+			}
+			if problems := checkResponse(response, step.Expect); len(problems) > 0 {
+				failed++
+				fmt.Printf("FAIL %d %s: %s\n", i+1, stepAction(step.Send), strings.Join(problems, "; "))
+				continue
+			}
+			fmt.Printf("ok   %d %s (%v)\n", i+1, stepAction(step.Send), time.Since(start).Round(time.Microsecond))
+		}
+
+		// Closing stdin is how Chrome disconnects; the host should exit cleanly
+		stdin.Close()
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+		select {
+		case err := <-exited:
+			if err != nil {
+				failed++
+				fmt.Printf("FAIL host exit: %v\n", err)
+			} else {
+				fmt.Println("ok   host exited cleanly")
+			}
+		case <-time.After(*timeout):
+			failed++
+			fmt.Println("FAIL host exit: still running after stdin closed")
+		}
+
+		fmt.Printf("%d passed, %d failed\n", len(steps)+1-failed, failed)
+		if failed > 0 {
+			return fmt.Errorf("%d checks failed", failed)
+		}
+		return nil
+	}
+	return cmd
+}
+
+// loadTestSteps reads a testclient script, or returns the smoke test if path
+// is empty. Blank lines and lines starting with # are skipped.
+func loadTestSteps(path string) ([]testStep, error) {
+	lines := smokeTestSteps
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open script: %v", err)
+		}
+		defer file.Close()
+
+		lines = nil
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), maxTransferSize)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read script: %v", err)
+		}
+	}
+
+	steps := []testStep{}
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var step testStep
+		if err := json.Unmarshal([]byte(line), &step); err != nil {
+			return nil, fmt.Errorf("invalid step on line %d: %v", i+1, err)
+		}
+		if len(step.Send) == 0 {
+			return nil, fmt.Errorf("step on line %d has nothing to send", i+1)
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("script has no steps")
+	}
+	return steps, nil
+}
+
+// callWithTimeout sends a request and returns the response as raw JSON
+func callWithTimeout(client *protocol.Client, request []byte, timeout time.Duration) (json.RawMessage, error) {
+	type result struct {
+		response *protocol.Response
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		response, err := client.CallJSON(request, nil)
+		done <- result{response, err}
+	}()
+
+	select {
+	case r := <-done:
+		if errors.Is(r.err, io.EOF) {
+			return nil, fmt.Errorf("host closed stdout")
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		return json.Marshal(r.response)
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no response within %v", timeout)
+	}
+}
+
+// checkResponse compares a response with the expected fields, returning a
+// description of each mismatch
+func checkResponse(response json.RawMessage, expect map[string]interface{}) []string {
+	var actual map[string]interface{}
+	if err := json.Unmarshal(response, &actual); err != nil {
+		return []string{fmt.Sprintf("invalid response: %v", err)}
+	}
+
+	problems := []string{}
+	if _, ok := actual["version"]; !ok {
+		problems = append(problems, "response has no version")
+	}
+	return append(problems, matchFields("", actual, expect)...)
+}
+
+// matchFields checks that every expected field is present in actual with an
+// equal value, descending into nested objects
+func matchFields(prefix string, actual, expect map[string]interface{}) []string {
+	keys := make([]string, 0, len(expect))
+	for key := range expect {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := []string{}
+	for _, key := range keys {
+		want := expect[key]
+		got, ok := actual[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s%s is missing", prefix, key))
+			continue
+		}
+		if want == "*" {
+			continue
+		}
+
+		wantObject, wantIsObject := want.(map[string]interface{})
+		gotObject, gotIsObject := got.(map[string]interface{})
+		if wantIsObject && gotIsObject {
+			problems = append(problems, matchFields(prefix+key+".", gotObject, wantObject)...)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			problems = append(problems, fmt.Sprintf("%s%s is %s, want %s", prefix, key, gotJSON, wantJSON))
+		}
+	}
+	return problems
+}
+
+// stepAction names the action a step sends
+func stepAction(request []byte) string {
+	var msg struct {
+		Action string `json:"action"`
+	}
+	json.Unmarshal(request, &msg)
+	if msg.Action == "" {
+		return "save"
+	}
+	return msg.Action
+}
@@ -0,0 +1,276 @@
+//go:build linux || windows || (darwin && cgo)
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"fyne.io/systray"
+	"github.com/godbus/dbus/v5"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// defaultTrayEntries is the number of recent entries in the tray menu
+	defaultTrayEntries = 10
+
+	// maxTrayEntries bounds --entries, as menus taller than the screen scroll
+	// badly on some desktops
+	maxTrayEntries = 30
+
+	// defaultTrayInterval is how often the tray checks history for changes
+	defaultTrayInterval = 2 * time.Second
+
+	// trayLabelLength is the number of characters of a preview shown in the menu
+	trayLabelLength = 60
+)
+
+// trayMenu shows recent history entries in the system tray. Menu items are
+// created once and relabelled as history changes, as not every desktop
+// handles items being removed.
+type trayMenu struct {
+	host  *TabdNativeHost
+	items []*systray.MenuItem
+	empty *systray.MenuItem
+
+	mu      sync.Mutex
+	ids     []string
+	current string
+}
+
+// newTrayCommand creates the tray command, which shows recent clipboard
+// entries in a system tray or menu bar menu, copying an entry back to the OS
+// clipboard when it is clicked
+func newTrayCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tray",
+		Short: "Show recent clipboard entries in the system tray",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	entries := flags.Int("entries", defaultTrayEntries, "number of recent entries to show")
+	interval := flags.Duration("interval", defaultTrayInterval, "how often to check history for new entries")
+	takeover := flags.Bool("takeover", false, "replace a tray already running for this storage directory")
+	cmd.RunE = withHost(func(host *TabdNativeHost, _ []string) error {
+		if *entries < 1 || *entries > maxTrayEntries {
+			return fmt.Errorf("--entries must be between 1 and %d", maxTrayEntries)
+		}
+		if *interval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+
+		if err := checkTrayAvailable(); err != nil {
+			return err
+		}
+
+		guard, err := acquireInstance(host.tabdDir, "tray", *takeover)
+		if err != nil {
+			return err
+		}
+		defer guard.Release()
+
+		tray := &trayMenu{host: host, ids: make([]string, *entries)}
+		stop := make(chan struct{})
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		go func() {
+			select {
+			case <-signals:
+				systray.Quit()
+			case <-stop:
+			}
+		}()
+
+		systray.Run(func() { tray.ready(*interval, stop) }, func() { close(stop) })
+		return nil
+	})
+	return cmd
+}
+
+// checkTrayAvailable reports why no tray can be shown. Linux trays are
+// StatusNotifierItems on the session bus, without which the tray library
+// cannot start.
+func checkTrayAvailable() error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return fmt.Errorf("no system tray available: failed to connect to the D-Bus session bus: %v", err)
+	}
+	return conn.Close()
+}
+
+// ready builds the menu once the tray is available and starts refreshing it
+func (m *trayMenu) ready(interval time.Duration, stop <-chan struct{}) {
+	systray.SetIcon(trayIcon())
+	systray.SetTooltip("Tab'd clipboard history")
+
+	m.empty = systray.AddMenuItem("No clipboard entries yet", "")
+	m.empty.Disable()
+	m.items = make([]*systray.MenuItem, len(m.ids))
+	for i := range m.items {
+		item := systray.AddMenuItem("", "Copy to the clipboard")
+		item.Hide()
+		m.items[i] = item
+		go func(i int) {
+			for range item.ClickedCh {
+				m.copy(i)
+			}
+		}(i)
+	}
+	systray.AddSeparator()
+	quit := systray.AddMenuItem("Quit", "Close the Tab'd tray")
+	go func() {
+		<-quit.ClickedCh
+		systray.Quit()
+	}()
+
+	m.refresh()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				m.refresh()
+			}
+		}
+	}()
+}
+
+// refresh relabels the menu items if history has changed since the last check
+func (m *trayMenu) refresh() {
+	entries, err := m.host.historyStore().Query(HistoryQuery{Limit: len(m.items)})
+	if err != nil {
+		logWarnf("Error listing clipboard history for the tray: %v", err)
+		return
+	}
+
+	var state strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&state, "%s:%d:%t:%v;", entry.ID, entry.Timestamp, entry.Pinned, entry.Preview != nil)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state.String() == m.current {
+		return
+	}
+	m.current = state.String()
+
+	if len(entries) == 0 {
+		m.empty.Show()
+	} else {
+		m.empty.Hide()
+	}
+	for i, item := range m.items {
+		if i >= len(entries) {
+			m.ids[i] = ""
+			item.Hide()
+			continue
+		}
+		m.ids[i] = entries[i].ID
+		item.SetTitle(m.label(&entries[i]))
+		item.Show()
+	}
+}
+
+// label returns the menu text for an entry, decrypting entries saved before
+// previews were kept in the index
+func (m *trayMenu) label(entry *HistoryEntry) string {
+	var text string
+	if entry.Preview != nil {
+		text = entry.Preview.Text
+		if entry.Preview.LinkTitle != "" {
+			text = entry.Preview.LinkTitle
+		}
+	} else if data, err := m.host.historyStore().Get(entry.ID); err == nil {
+		text = previewText(data)
+	}
+	if text == "" {
+		text = "[empty]"
+	}
+	if utf8.RuneCountInString(text) > trayLabelLength {
+		text = string([]rune(text)[:trayLabelLength]) + "…"
+	}
+	if entry.Pinned {
+		text = "📌 " + text
+	}
+	return text
+}
+
+// copy places the entry shown in item i on the OS clipboard
+func (m *trayMenu) copy(i int) {
+	m.mu.Lock()
+	id := m.ids[i]
+	m.mu.Unlock()
+	if id == "" {
+		return
+	}
+
+	data, err := m.host.historyStore().Get(id)
+	if err != nil {
+		logWarnf("Error reading history entry %s for the tray: %v", id, err)
+		return
+	}
+	if err := writeClipboardData(data); err != nil {
+		logWarnf("Error copying history entry %s from the tray: %v", id, err)
+		return
+	}
+	logDebugf("Copied history entry %s from the tray", id)
+}
+
+// trayIcon draws the tray icon: a white T on a rounded dark square. Windows
+// takes the icon as an ICO file, which may hold a PNG image.
+func trayIcon() []byte {
+	const size = 32
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	background := color.NRGBA{R: 0x2b, G: 0x5b, B: 0xd7, A: 0xff}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			// Leave the corners transparent
+			dx, dy := min(x, size-1-x), min(y, size-1-y)
+			if dx < 4 && dy < 4 && (4-dx)*(4-dx)+(4-dy)*(4-dy) > 16 {
+				continue
+			}
+			inBar := y >= 7 && y < 12 && x >= 7 && x < size-7
+			inStem := y >= 7 && y < size-6 && x >= 13 && x < size-13
+			if inBar || inStem {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, background)
+			}
+		}
+	}
+
+	var icon bytes.Buffer
+	png.Encode(&icon, img)
+	if runtime.GOOS != "windows" {
+		return icon.Bytes()
+	}
+
+	var ico bytes.Buffer
+	binary.Write(&ico, binary.LittleEndian, []uint16{0, 1, 1})
+	ico.Write([]byte{size, size, 0, 0})
+	binary.Write(&ico, binary.LittleEndian, []uint16{1, 32})
+	binary.Write(&ico, binary.LittleEndian, []uint32{uint32(icon.Len()), 22})
+	ico.Write(icon.Bytes())
+	return ico.Bytes()
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !windows && !(darwin && cgo)
+
+package main
+
+import (
+	"errors"
+	"runtime"
+
+	"github.com/spf13/cobra"
+)
+
+// newTrayCommand creates a tray command that reports this build has no tray
+// support. The macOS menu bar is only reachable through cgo, and the tray
+// library does not build for BSD.
+func newTrayCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "tray",
+		Short: "Show recent clipboard entries in the system tray",
+		RunE: func(*cobra.Command, []string) error {
+			if runtime.GOOS == "darwin" {
+				return errors.New("tray is not available in this build: the menu bar needs a build with cgo enabled")
+			}
+			return errors.New("tray is not available on " + runtime.GOOS)
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "unicode/utf8"
+
+// defaultMaxTextLength is the longest text stored for one entry when no
+// maxTextLength is configured, so an accidental copy of a huge document does
+// not fill the store
+const defaultMaxTextLength = 1024 * 1024
+
+// truncateText cuts text longer than limit bytes at a character boundary and
+// drops representations longer than limit, marking the entry as truncated.
+// The remaining representations are dropped too when the text is cut, as
+// they would no longer match it. A limit of zero keeps everything. It
+// reports whether anything was cut.
+func truncateText(data *ClipboardData, limit int) bool {
+	if limit <= 0 {
+		return false
+	}
+
+	truncated := false
+	if len(data.Text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(data.Text[cut]) {
+			cut--
+		}
+		data.FullLength = len(data.Text)
+		data.Text = data.Text[:cut]
+		data.Flavors = nil
+		truncated = true
+	}
+	for _, value := range data.Flavors {
+		if len(value) > limit {
+			truncated = true
+			break
+		}
+	}
+	if truncated && data.Flavors != nil {
+		// The caller may still hold the original map
+		kept := make(map[string]string, len(data.Flavors))
+		for mimeType, value := range data.Flavors {
+			if len(value) <= limit {
+				kept[mimeType] = value
+			}
+		}
+		data.Flavors = kept
+	}
+
+	if truncated {
+		data.Truncated = true
+	}
+	return truncated
+}
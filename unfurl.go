@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// unfurlTimeout bounds fetching a link's page and favicon, and how long
+	// the host waits for outstanding fetches when it exits
+	unfurlTimeout = 5 * time.Second
+
+	// maxUnfurlPageSize is how much of a page is read looking for its title
+	// and icon, which belong in the head
+	maxUnfurlPageSize = 512 * 1024
+
+	// maxFaviconSize is the largest favicon kept; bigger icons are dropped
+	// rather than bloating the history index
+	maxFaviconSize = 16 * 1024
+)
+
+var (
+	titlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaTagPattern   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	linkTagPattern   = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?is)([a-z:-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+)
+
+// Unfurler fetches the title and favicon of copied links in the background
+type Unfurler struct {
+	client *http.Client
+	wg     sync.WaitGroup
+}
+
+// NewUnfurler creates an unfurler, or returns nil if unfurling is disabled
+func NewUnfurler(config *Config) *Unfurler {
+	if !config.UnfurlLinks {
+		return nil
+	}
+	return &Unfurler{client: &http.Client{Timeout: unfurlTimeout}}
+}
+
+// Unfurl fetches the link details of a saved URL entry and attaches them to
+// the history entry once they arrive, without holding up the save
+func (u *Unfurler) Unfurl(host *TabdNativeHost, id string, data *ClipboardData) {
+	if u == nil || data.Kind != kindURL || data.Link != nil {
+		return
+	}
+	link := strings.TrimSpace(data.Text)
+
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		preview, err := u.fetch(link)
+		if err != nil {
+			logDebugf("Error unfurling %s: %v", link, err)
+			return
+		}
+		if err := host.attachLink(id, preview); err != nil {
+			logWarnf("Error attaching link details to history entry %s: %v", id, err)
+			return
+		}
+		logDebugf("Unfurled %s as %q", link, preview.Title)
+	}()
+}
+
+// Wait blocks until outstanding fetches finish or the timeout passes
+func (u *Unfurler) Wait(timeout time.Duration) {
+	if u == nil {
+		return
+	}
+	if !waitTimeout(&u.wg, timeout) {
+		logWarnf("Gave up waiting for link unfurling after %s", timeout)
+	}
+}
+
+// fetch reads a page's title and favicon
+func (u *Unfurler) fetch(link string) (*LinkPreview, error) {
+	response, err := u.get(link, "text/html,application/xhtml+xml")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); !strings.Contains(contentType, "html") {
+		return nil, fmt.Errorf("not an HTML page: %s", contentType)
+	}
+	page, err := io.ReadAll(io.LimitReader(response.Body, maxUnfurlPageSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page: %v", err)
+	}
+
+	title, iconURL := parsePageHead(string(page))
+	if title == "" {
+		return nil, fmt.Errorf("page has no title")
+	}
+	preview := &LinkPreview{Title: title}
+
+	// Redirects may have moved the page, so relative icons resolve against
+	// where it ended up
+	base := response.Request.URL
+	if iconURL == "" {
+		iconURL = "/favicon.ico"
+	}
+	if icon, err := base.Parse(iconURL); err == nil {
+		favicon, err := u.fetchFavicon(icon.String())
+		if err != nil {
+			logDebugf("Error fetching favicon %s: %v", icon, err)
+		}
+		preview.Favicon = favicon
+	}
+	return preview, nil
+}
+
+// fetchFavicon downloads an icon as a data URL
+func (u *Unfurler) fetchFavicon(iconURL string) (string, error) {
+	if strings.HasPrefix(iconURL, "data:image/") {
+		if len(iconURL) > maxFaviconSize {
+			return "", fmt.Errorf("icon is larger than %d bytes", maxFaviconSize)
+		}
+		return iconURL, nil
+	}
+
+	response, err := u.get(iconURL, "image/*")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	contentType, _, _ := strings.Cut(response.Header.Get("Content-Type"), ";")
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("not an image: %s", contentType)
+	}
+	icon, err := io.ReadAll(io.LimitReader(response.Body, maxFaviconSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read icon: %v", err)
+	}
+	if len(icon) > maxFaviconSize {
+		return "", fmt.Errorf("icon is larger than %d bytes", maxFaviconSize)
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(icon), nil
+}
+
+// get requests an http or https URL, failing on unsuccessful statuses
+func (u *Unfurler) get(link, accept string) (*http.Response, error) {
+	parsed, err := url.Parse(link)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("not an http or https URL")
+	}
+
+	request, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", accept)
+	request.Header.Set("User-Agent", "tabd-native-host/"+version)
+
+	response, err := u.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		response.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return response, nil
+}
+
+// parsePageHead extracts the title and icon URL of an HTML page, preferring
+// the Open Graph title sites set for link previews
+func parsePageHead(page string) (title, iconURL string) {
+	for _, tag := range metaTagPattern.FindAllString(page, -1) {
+		attributes := tagAttributes(tag)
+		if attributes["property"] == "og:title" && attributes["content"] != "" {
+			title = attributes["content"]
+			break
+		}
+	}
+	if title == "" {
+		if match := titlePattern.FindStringSubmatch(page); match != nil {
+			title = html.UnescapeString(match[1])
+		}
+	}
+	title = strings.Join(strings.Fields(title), " ")
+
+	for _, tag := range linkTagPattern.FindAllString(page, -1) {
+		attributes := tagAttributes(tag)
+		for _, rel := range strings.Fields(strings.ToLower(attributes["rel"])) {
+			if rel == "icon" && attributes["href"] != "" {
+				return title, attributes["href"]
+			}
+		}
+	}
+	return title, ""
+}
+
+// tagAttributes returns the attributes of an HTML tag, with lowercase names
+// and unescaped values
+func tagAttributes(tag string) map[string]string {
+	attributes := map[string]string{}
+	for _, match := range attributePattern.FindAllStringSubmatch(tag, -1) {
+		value := strings.Trim(match[2], `"'`)
+		attributes[strings.ToLower(match[1])] = html.UnescapeString(value)
+	}
+	return attributes
+}
+
+// attachLink stores fetched link details with a history entry, and with the
+// latest entry if it is still the one unfurled
+func (t *TabdNativeHost) attachLink(id string, link *LinkPreview) error {
+	data, err := t.historyStore().Get(id)
+	if err != nil {
+		return err
+	}
+	data.Link = link
+	if err := t.historyStore().Update(id, data); err != nil {
+		return err
+	}
+
+	entries, err := t.historyStore().Query(HistoryQuery{Limit: 1})
+	if err != nil || len(entries) == 0 || entries[0].ID != id {
+		return err
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal clipboard data: %v", err)
+	}
+	return t.storage().Store("latest_clipboard", jsonData)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// defaultUnlockTimeout is how long an unlock lasts unless --timeout is given
+const defaultUnlockTimeout = 8 * time.Hour
+
+// ErrLocked is returned while passphrase protected storage is locked
+var ErrLocked = errors.New("storage is locked; run tabd-native-host unlock")
+
+// lockedActions can be handled while storage is locked
+var lockedActions = map[string]bool{
+	"hello":  true,
+	"ping":   true,
+	"status": true,
+}
+
+// passphraseInput reads piped passphrases, shared so that consecutive prompts
+// consume consecutive lines
+var passphraseInput = bufio.NewReader(os.Stdin)
+
+// unlockSession caches the unwrapped master key until it expires
+type unlockSession struct {
+	Key     string `json:"key"`
+	Expires int64  `json:"expires"`
+}
+
+// isLocked reports whether storage is waiting to be unlocked
+func (t *TabdNativeHost) isLocked() bool {
+	t.lockMu.Lock()
+	defer t.lockMu.Unlock()
+	return t.locked
+}
+
+// lockError returns why storage is unavailable, or nil if it is open
+func (t *TabdNativeHost) lockError() error {
+	t.lockMu.Lock()
+	defer t.lockMu.Unlock()
+	if !t.locked {
+		return nil
+	}
+	if t.lockedErr != nil {
+		return t.lockedErr
+	}
+	return ErrLocked
+}
+
+// ensureUnlocked opens locked storage if an unlock session has started since
+// the host was created, reporting whether storage is available. Hosts that
+// are already unlocked keep their key until they exit, even if the session
+// expires.
+func (t *TabdNativeHost) ensureUnlocked() bool {
+	t.lockMu.Lock()
+	defer t.lockMu.Unlock()
+
+	if !t.locked {
+		return true
+	}
+
+	secureStorage, history, err := openStorage(t.tabdDir, t.config.StorageBackend, t.config.MaxHistory)
+	if err != nil {
+		if errors.Is(err, ErrLocked) || errors.Is(err, ErrKeychainDenied) {
+			t.lockedErr = err
+		} else {
+			logErrorf("Error opening storage after unlock: %v", err)
+		}
+		return false
+	}
+
+	t.setDiskStorage(auditStorage(t.audit, secureStorage, history))
+	t.locked = false
+	logInfof("Storage unlocked")
+	return true
+}
+
+// readPassphrase prompts for a passphrase without echo on a terminal, or reads
+// a line from stdin otherwise
+func readPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %v", err)
+		}
+		return string(passphrase), nil
+	}
+
+	line, err := passphraseInput.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// openMasterKeyStore loads the config and returns the key store for it
+func openMasterKeyStore() (*masterKeyStore, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if config.StorageBackend == "dpapi" {
+		return nil, errNoStorageKey
+	}
+	if err := os.MkdirAll(config.StorageDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create .tabd directory: %v", err)
+	}
+	configureBlobCiphers(config)
+	return newMasterKeyStore(config.StorageDir), nil
+}
+
+// newUnlockCommand creates the unlock command, which unwraps the master key
+// with the user passphrase and caches it
+func newUnlockCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unlock",
+		Short: "Unlock passphrase protected storage",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	timeout := flags.Duration("timeout", defaultUnlockTimeout, "how long storage stays unlocked")
+	keyringOnly := flags.Bool("keyring", false, "unlock the system keyring's collection instead")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		if *keyringOnly {
+			return runUnlockKeyring()
+		}
+		if *timeout <= 0 {
+			return errors.New("--timeout must be positive")
+		}
+
+		keys, err := openMasterKeyStore()
+		if err != nil {
+			return err
+		}
+		if !keys.protected() {
+			return errors.New("storage is not protected by a passphrase; run tabd-native-host passphrase to set one")
+		}
+
+		userPassphrase, err := readPassphrase("Passphrase: ")
+		if err != nil {
+			return err
+		}
+		masterKey, err := keys.unwrap(userPassphrase)
+		if err != nil {
+			return err
+		}
+
+		expires := time.Now().Add(*timeout)
+		if err := keys.saveSession(unlockSession{Key: masterKey, Expires: expires.UnixMilli()}); err != nil {
+			return err
+		}
+
+		notef("Storage unlocked until %s\n", expires.Format(time.RFC1123))
+		return nil
+	}
+	return cmd
+}
+
+// runUnlockKeyring unlocks the Secret Service collection holding the storage
+// keys through the keyring's own prompt
+func runUnlockKeyring() error {
+	state, detail := diagnoseKeyring()
+	switch state {
+	case keyringAvailable:
+		notef("The system keyring is not locked\n")
+		return nil
+	case keyringLocked:
+	default:
+		return fmt.Errorf("system keyring unavailable: %s", detail)
+	}
+
+	if err := unlockKeyring(); err != nil {
+		return err
+	}
+	notef("System keyring unlocked\n")
+	return nil
+}
+
+// newLockCommand creates the lock command, which ends the unlock session
+func newLockCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Lock passphrase protected storage again",
+		Args:  cobra.NoArgs,
+	}
+	cmd.RunE = func(*cobra.Command, []string) error {
+		keys, err := openMasterKeyStore()
+		if err != nil {
+			return err
+		}
+		return keys.delete(sessionKeyName)
+	}
+	return cmd
+}
+
+// newPassphraseCommand creates the passphrase command, which sets, changes or
+// removes the user passphrase protecting the master key
+func newPassphraseCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "passphrase",
+		Short: "Set, change or remove the storage passphrase",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	remove := flags.Bool("remove", false, "remove passphrase protection")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		keys, err := openMasterKeyStore()
+		if err != nil {
+			return err
+		}
+
+		var masterKey string
+		if keys.protected() {
+			current, err := readPassphrase("Current passphrase: ")
+			if err != nil {
+				return err
+			}
+			if masterKey, err = keys.unwrap(current); err != nil {
+				return err
+			}
+		} else if *remove {
+			return errors.New("storage is not protected by a passphrase")
+		} else if masterKey, err = generateOrRetrievePassphrase(keys.tabdDir); err != nil {
+			return err
+		}
+
+		if *remove {
+			if err := keys.save(masterKeyName, masterKey); err != nil {
+				return fmt.Errorf("failed to save master key: %v", err)
+			}
+			keys.delete(sessionKeyName)
+			if err := keys.delete(wrappedKeyName); err != nil {
+				return fmt.Errorf("failed to remove wrapped master key: %v", err)
+			}
+			notef("Passphrase protection removed\n")
+			return nil
+		}
+
+		userPassphrase, err := readPassphrase("New passphrase: ")
+		if err != nil {
+			return err
+		}
+		if userPassphrase == "" {
+			return errors.New("passphrase must not be empty")
+		}
+		confirm, err := readPassphrase("Confirm passphrase: ")
+		if err != nil {
+			return err
+		}
+		if confirm != userPassphrase {
+			return errors.New("passphrases do not match")
+		}
+
+		if err := keys.setMasterKey(masterKey, userPassphrase); err != nil {
+			return err
+		}
+
+		notef("Storage is now protected by a passphrase\n")
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// updateRepository is the GitHub repository publishing release binaries
+	updateRepository = "iann0036/tabd-extension"
+
+	// checksumsAsset lists the SHA-256 digest of every release binary, and
+	// signatureAsset holds an Ed25519 signature over it
+	checksumsAsset = "SHA256SUMS"
+	signatureAsset = "SHA256SUMS.sig"
+
+	// updateTimeout bounds each request made while updating
+	updateTimeout = 5 * time.Minute
+)
+
+// updatePublicKey is the base64 Ed25519 key release checksums are signed
+// with, set at link time with -X main.updatePublicKey=... When empty, update
+// refuses to install without --insecure: the checksums come from the same
+// release as the binary, so they alone do not show who published it.
+var updatePublicKey = ""
+
+// githubRelease is the subset of the GitHub releases API used for updates
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+// githubAsset is a file attached to a release
+type githubAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+// asset returns the release asset with the given name
+func (r *githubRelease) asset(name string) *githubAsset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// releaseAssetName returns the binary name built by build.sh for this platform
+func releaseAssetName() string {
+	name := fmt.Sprintf("tabd-native-host-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// fetchRelease looks up the latest release, or the release with the given tag
+func fetchRelease(client *http.Client, tag string) (*githubRelease, error) {
+	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", updateRepository)
+	if tag != "" {
+		apiURL = fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", updateRepository, tag)
+	}
+
+	request, err := http.NewRequest(http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("User-Agent", "tabd-native-host/"+version)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for releases: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to check for releases: %s", response.Status)
+	}
+
+	var release githubRelease
+	if err := json.NewDecoder(response.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("failed to parse release: %v", err)
+	}
+	return &release, nil
+}
+
+// download fetches an asset into memory, up to limit bytes
+func download(client *http.Client, asset *githubAsset, limit int64) ([]byte, error) {
+	response, err := client.Get(asset.BrowserDownloadURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", asset.Name, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", asset.Name, response.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", asset.Name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", asset.Name, limit)
+	}
+	return data, nil
+}
+
+// expectedChecksum finds the digest for name in a SHA256SUMS file
+func expectedChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(string(checksums)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum listed for %s", name)
+}
+
+// verifyRelease checks the downloaded binary against the release checksums
+// and, when a public key is built in, the checksums against their signature
+func verifyRelease(client *http.Client, release *githubRelease, name string, binary []byte) error {
+	checksumsAssetInfo := release.asset(checksumsAsset)
+	if checksumsAssetInfo == nil {
+		return fmt.Errorf("release %s has no %s", release.TagName, checksumsAsset)
+	}
+	checksums, err := download(client, checksumsAssetInfo, 1<<20)
+	if err != nil {
+		return err
+	}
+
+	if updatePublicKey != "" {
+		publicKey, err := base64.StdEncoding.DecodeString(updatePublicKey)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return errors.New("invalid built-in update public key")
+		}
+		signatureAssetInfo := release.asset(signatureAsset)
+		if signatureAssetInfo == nil {
+			return fmt.Errorf("release %s is not signed", release.TagName)
+		}
+		signature, err := download(client, signatureAssetInfo, 1<<10)
+		if err != nil {
+			return err
+		}
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err == nil {
+			signature = decoded
+		}
+		if !ed25519.Verify(publicKey, checksums, signature) {
+			return fmt.Errorf("signature verification failed for %s", checksumsAsset)
+		}
+	}
+
+	expected, err := expectedChecksum(checksums, name)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(binary)
+	if hex.EncodeToString(sum[:]) != expected {
+		return fmt.Errorf("checksum mismatch for %s", name)
+	}
+	return nil
+}
+
+// replaceExecutable atomically swaps the running binary for the new one. On
+// Windows, where a running executable cannot be overwritten, the old binary
+// is moved aside first.
+func replaceExecutable(binary []byte) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate executable: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	if err := writeFileAtomic(executable+".new", binary, 0755); err != nil {
+		return "", fmt.Errorf("failed to write new binary: %v", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		oldPath := executable + ".old"
+		os.Remove(oldPath)
+		if err := os.Rename(executable, oldPath); err != nil {
+			os.Remove(executable + ".new")
+			return "", fmt.Errorf("failed to move old binary aside: %v", err)
+		}
+	}
+
+	if err := os.Rename(executable+".new", executable); err != nil {
+		os.Remove(executable + ".new")
+		return "", fmt.Errorf("failed to replace binary: %v", err)
+	}
+	return executable, nil
+}
+
+// newUpdateCommand creates the update command, which checks GitHub for a newer
+// release and installs it
+func newUpdateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Download and install the latest release",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	check := flags.Bool("check", false, "only report whether an update is available, exiting with status 6 if one is")
+	force := flags.Bool("force", false, "install even if the release matches this version")
+	tag := flags.String("version", "", "install a specific release tag instead of the latest")
+	insecure := flags.Bool("insecure", false, "install without a signature check when this build has no update public key")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		client := &http.Client{Timeout: updateTimeout}
+		release, err := fetchRelease(client, *tag)
+		if err != nil {
+			return err
+		}
+
+		current := strings.TrimPrefix(version, "v")
+		latest := strings.TrimPrefix(release.TagName, "v")
+		if latest == current && !*force {
+			notef("Already up to date (%s)\n", version)
+			return nil
+		}
+		if *check {
+			notef("Update available: %s (installed %s)\n", release.TagName, version)
+			return exitStatus(exitUpdateAvailable)
+		}
+		if version == "dev" && !*force {
+			return errors.New("this is a development build; use --force to replace it with a release")
+		}
+		if updatePublicKey == "" && !*insecure {
+			return errors.New("this build has no update public key, so the release signature cannot be verified; use --insecure to install it after only checking its checksum")
+		}
+
+		name := releaseAssetName()
+		asset := release.asset(name)
+		if asset == nil {
+			return fmt.Errorf("release %s has no binary for %s/%s", release.TagName, runtime.GOOS, runtime.GOARCH)
+		}
+
+		notef("Downloading %s %s...\n", name, release.TagName)
+		binary, err := download(client, asset, 256<<20)
+		if err != nil {
+			return err
+		}
+		if err := verifyRelease(client, release, name, binary); err != nil {
+			return err
+		}
+
+		path, err := replaceExecutable(binary)
+		if err != nil {
+			return err
+		}
+
+		notef("Updated %s to %s\n", path, release.TagName)
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// buildInfo returns the linked build information, falling back to the VCS
+// details recorded by the Go toolchain for builds without ldflags
+func buildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	if goInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range goInfo.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			}
+		}
+	}
+
+	return info
+}
+
+// versionString formats the build information for --version
+func versionString() string {
+	info := buildInfo()
+	text := fmt.Sprintf("tabd-native-host %s", info.Version)
+	if info.Commit != "" {
+		short := info.Commit
+		if len(short) > 12 {
+			short = short[:12]
+		}
+		text += fmt.Sprintf(" (commit %s", short)
+		if info.BuildDate != "" {
+			text += ", built " + info.BuildDate
+		}
+		text += ")"
+	}
+	return text + fmt.Sprintf(" %s %s/%s", info.GoVersion, runtime.GOOS, runtime.GOARCH)
+}
+
+// newVersionCommand creates the version command, which prints the build
+// information
+func newVersionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print version and build information",
+		Args:  cobra.NoArgs,
+	}
+	cmd.RunE = func(*cobra.Command, []string) error {
+		if jsonOutput {
+			output, err := json.MarshalIndent(buildInfo(), "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(output))
+			return nil
+		}
+		fmt.Println(versionString())
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+	"github.com/spf13/cobra"
+)
+
+// defaultWatchEvents are the events the watch command prints unless --events
+// is given
+var defaultWatchEvents = []string{"entry_saved", "entry_deleted", "locked", "unlocked"}
+
+// newWatchCommand creates the watch command, which connects to the daemon and
+// prints its events as JSON lines until the daemon goes away, for scripts and
+// status bars
+func newWatchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Print daemon events such as saved and deleted entries as JSON lines",
+		Args:  cobra.NoArgs,
+	}
+	flags := cmd.Flags()
+	events := flags.String("events", "", "comma-separated events to print, or all (default entry_saved,entry_deleted,locked,unlocked)")
+	cmd.RunE = func(*cobra.Command, []string) error {
+		wanted := map[string]bool{}
+		selected := defaultWatchEvents
+		if *events != "" {
+			selected = splitList(*events)
+		}
+		for _, event := range selected {
+			wanted[event] = true
+		}
+
+		config, err := LoadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		client, err := protocol.Dial(config.StorageDir)
+		if err != nil {
+			return fmt.Errorf("%v; start one with tabd-native-host daemon", err)
+		}
+		defer client.Close()
+
+		response, err := client.Call(&Message{
+			Action:          "hello",
+			ProtocolVersion: protocolVersion,
+			Features:        []string{"events", entryEventsFeature},
+		}, nil)
+		if err != nil {
+			return err
+		}
+		if response.Status != "success" {
+			return fmt.Errorf("daemon refused the connection: %s", response.Message)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		for {
+			event, err := client.ReadEvent()
+			if errors.Is(err, io.EOF) {
+				return errors.New("the daemon closed the connection")
+			}
+			if err != nil {
+				return err
+			}
+			if !wanted["all"] && !wanted[event.Event] {
+				continue
+			}
+			if err := encoder.Encode(event); err != nil {
+				return err
+			}
+		}
+	}
+	return cmd
+}
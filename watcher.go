@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iann0036/tabd-extension/tabd-native-host/pkg/protocol"
+)
+
+// defaultWatchInterval is how often the OS clipboard is polled for changes
+const defaultWatchInterval = time.Second
+
+// Event is an unsolicited message pushed from the host to connected clients
+type Event = protocol.Event
+
+// ClipboardWatcher polls the OS clipboard and records copies made outside the
+// browser, pushing a clipboard_changed event to every connected session
+type ClipboardWatcher struct {
+	host     *TabdNativeHost
+	interval time.Duration
+}
+
+// NewClipboardWatcher creates a watcher polling at the given interval
+func NewClipboardWatcher(host *TabdNativeHost, interval time.Duration) *ClipboardWatcher {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	return &ClipboardWatcher{
+		host:     host,
+		interval: interval,
+	}
+}
+
+// watchIntervalFromEnv reads the polling interval from TABD_WATCH_INTERVAL
+func watchIntervalFromEnv() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("TABD_WATCH_INTERVAL"))
+	if err != nil {
+		return defaultWatchInterval
+	}
+	return interval
+}
+
+// Run polls until stop is closed. Whatever is on the clipboard at startup is
+// treated as already seen.
+func (w *ClipboardWatcher) Run(stop <-chan struct{}) {
+	if text, err := readSystemClipboard(); err == nil {
+		w.host.noteClipboardText(text)
+	} else {
+		logWarnf("Clipboard watcher cannot read the system clipboard: %v", err)
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+// poll checks the clipboard once and records a change
+func (w *ClipboardWatcher) poll() {
+	data, err := systemClipboardData()
+	if err != nil || data.Text == "" {
+		return
+	}
+
+	// Skip content the host already knows about, including copies made in
+	// the browser and text the host placed on the clipboard itself
+	if !w.host.noteClipboardText(data.Text) {
+		return
+	}
+
+	if report := w.host.sensitive.Apply(data); report != nil && report.Decision == "refused" {
+		logInfof("Not recording system clipboard change: detected %s", strings.Join(report.Sensitive, ", "))
+		return
+	}
+
+	id, _, err := w.host.saveClipboardData(context.Background(), data)
+	if err != nil {
+		logErrorf("Error saving system clipboard change: %v", err)
+		return
+	}
+
+	w.host.broadcast(&Event{
+		Event:     "clipboard_changed",
+		Data:      &HistoryRecord{ID: id, ClipboardData: *data},
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// noteClipboardText records text known to be on the OS clipboard, returning
+// false if it was already the last known text
+func (t *TabdNativeHost) noteClipboardText(text string) bool {
+	t.clipboardMu.Lock()
+	defer t.clipboardMu.Unlock()
+
+	if text == t.lastClipboardText {
+		return false
+	}
+	t.lastClipboardText = text
+	return true
+}
+
+// broadcast pushes an event to every connected session
+func (t *TabdNativeHost) broadcast(event *Event) {
+	t.broadcastTo("", event)
+}
+
+// broadcastTo pushes an event to the connected sessions that negotiated
+// feature, or to every session if feature is empty
+func (t *TabdNativeHost) broadcastTo(feature string, event *Event) {
+	t.sessionsMu.Lock()
+	sessions := make([]*Session, 0, len(t.sessions))
+	for session := range t.sessions {
+		if feature == "" || session.hasFeature(feature) {
+			sessions = append(sessions, session)
+		}
+	}
+	for stream := range t.streams {
+		if feature == "" || feature == stream.feature {
+			stream.send(event)
+		}
+	}
+	t.sessionsMu.Unlock()
+
+	// Sessions may use different wire formats, so each encodes its own copy
+	for _, session := range sessions {
+		session.sendEvent(event)
+	}
+}
+
+// eventStreamSize is the number of events an event stream buffers for a slow
+// reader before dropping them
+const eventStreamSize = 64
+
+// eventStream receives the events broadcast for a feature, for clients that
+// are not protocol sessions
+type eventStream struct {
+	feature string
+	events  chan *Event
+}
+
+// send queues an event, dropping it if the reader has fallen behind
+func (s *eventStream) send(event *Event) {
+	select {
+	case s.events <- event:
+	default:
+		logWarnf("Dropped %s event for a slow event stream", event.Event)
+	}
+}
+
+// subscribe opens a stream of the events broadcast for feature, returning a
+// function that closes it
+func (t *TabdNativeHost) subscribe(feature string) (*eventStream, func()) {
+	stream := &eventStream{feature: feature, events: make(chan *Event, eventStreamSize)}
+	t.sessionsMu.Lock()
+	t.streams[stream] = true
+	t.sessionsMu.Unlock()
+
+	return stream, func() {
+		t.sessionsMu.Lock()
+		delete(t.streams, stream)
+		t.sessionsMu.Unlock()
+	}
+}
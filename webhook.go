@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookTimeout bounds each webhook delivery, and how long the host
+	// waits for outstanding deliveries when it exits
+	webhookTimeout = 10 * time.Second
+
+	// webhookSignatureHeader carries the hex HMAC-SHA256 of the request body
+	webhookSignatureHeader = "X-Tabd-Signature"
+)
+
+// WebhookConfig is a URL notified of clipboard events. When Secret is set,
+// each request is signed with it so the receiver can verify the sender.
+type WebhookConfig struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// validate checks that the webhook URL is an absolute http or https URL
+func (w *WebhookConfig) validate() error {
+	parsed, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url %q: %v", w.URL, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid webhook url %q: must be an http or https URL", w.URL)
+	}
+	return nil
+}
+
+// WebhookPayload is the JSON body posted to webhooks
+type WebhookPayload struct {
+	Event     string         `json:"event"`
+	ID        string         `json:"id"`
+	Entry     *ClipboardData `json:"entry"`
+	Timestamp int64          `json:"timestamp"`
+}
+
+// Webhooks delivers clipboard events to the configured webhooks in the
+// background
+type Webhooks struct {
+	// mu guards hooks, which a config reload may replace
+	mu     sync.RWMutex
+	hooks  []WebhookConfig
+	client *http.Client
+	wg     sync.WaitGroup
+}
+
+// NewWebhooks creates a notifier for the config webhooks
+func NewWebhooks(config *Config) *Webhooks {
+	return &Webhooks{
+		hooks:  config.Webhooks,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// update replaces the webhooks with those of a reloaded config. Deliveries
+// already started still go to the old URLs.
+func (w *Webhooks) update(config *Config) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hooks = config.Webhooks
+}
+
+// signWebhook returns the signature header value for a request body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify posts an event for a history entry to every webhook without
+// waiting for the deliveries. Sensitive entries are sent redacted.
+func (w *Webhooks) Notify(event, id string, data *ClipboardData) {
+	w.mu.RLock()
+	hooks := w.hooks
+	w.mu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(&WebhookPayload{
+		Event:     event,
+		ID:        id,
+		Entry:     redactSensitive(data),
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		logErrorf("Error encoding webhook payload: %v", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		w.wg.Add(1)
+		go func(hook WebhookConfig) {
+			defer w.wg.Done()
+			if err := w.deliver(hook, event, body); err != nil {
+				logWarnf("Error delivering %s webhook to %s: %v", event, hook.URL, err)
+			}
+		}(hook)
+	}
+}
+
+// deliver posts a payload to a single webhook
+func (w *Webhooks) deliver(hook WebhookConfig, event string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "tabd-native-host/"+version)
+	request.Header.Set("X-Tabd-Event", event)
+	if hook.Secret != "" {
+		request.Header.Set(webhookSignatureHeader, signWebhook(hook.Secret, body))
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	logDebugf("Delivered %s webhook to %s", event, hook.URL)
+	return nil
+}
+
+// Wait blocks until outstanding deliveries finish or the timeout passes
+func (w *Webhooks) Wait(timeout time.Duration) {
+	if !waitTimeout(&w.wg, timeout) {
+		logWarnf("Gave up waiting for webhook deliveries after %s", timeout)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookRedactsSensitiveEntries(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	webhooks := NewWebhooks(&Config{Webhooks: []WebhookConfig{{URL: server.URL}}})
+	webhooks.Notify("clipboard.saved", "sensitive", &ClipboardData{
+		Text:      "password: hunter2",
+		Type:      "text",
+		Flavors:   map[string]string{"text/html": "<b>password: hunter2</b>"},
+		Sensitive: []string{"password"},
+	})
+	webhooks.Notify("clipboard.saved", "plain", &ClipboardData{Text: "hello", Type: "text"})
+	webhooks.Wait(5 * time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 {
+		t.Fatalf("delivered %d webhooks, want 2", len(bodies))
+	}
+	for _, body := range bodies {
+		var payload WebhookPayload
+		if err := json.Unmarshal([]byte(body), &payload); err != nil {
+			t.Fatal(err)
+		}
+		switch payload.ID {
+		case "sensitive":
+			if strings.Contains(body, "hunter2") || payload.Entry.Text != redactedText || len(payload.Entry.Sensitive) == 0 {
+				t.Fatalf("sensitive entry delivered as %s", body)
+			}
+		case "plain":
+			if payload.Entry.Text != "hello" {
+				t.Fatalf("plain entry delivered as %s", body)
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// webSocketWriteTimeout bounds how long a frame may take to reach a
+	// WebSocket client before the connection is dropped
+	webSocketWriteTimeout = 10 * time.Second
+
+	// webSocketPingInterval is how often idle WebSocket clients are pinged,
+	// and webSocketPongTimeout how long they have to answer
+	webSocketPingInterval = 30 * time.Second
+	webSocketPongTimeout  = 60 * time.Second
+
+	// webSocketProtocol is the subprotocol selected for browser clients,
+	// which offer it alongside webSocketTokenPrefix followed by the token
+	webSocketProtocol    = "tabd"
+	webSocketTokenPrefix = "tabd.token."
+)
+
+// WebSocketServer pushes the daemon's events to local web apps over WebSocket
+// and dispatches the protocol messages they send
+type WebSocketServer struct {
+	host     *TabdNativeHost
+	token    string
+	upgrader websocket.Upgrader
+	server   *http.Server
+
+	mu    sync.Mutex
+	conns map[*websocket.Conn]bool
+}
+
+// NewWebSocketServer creates a WebSocket server authenticating clients with
+// the HTTP API token
+func NewWebSocketServer(host *TabdNativeHost, token string) *WebSocketServer {
+	s := &WebSocketServer{
+		host:  host,
+		token: token,
+		conns: make(map[*websocket.Conn]bool),
+	}
+	s.upgrader = websocket.Upgrader{
+		CheckOrigin:  s.originAllowed,
+		Subprotocols: []string{webSocketProtocol},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws", s.handleWebSocket)
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Serve accepts WebSocket clients on a listener until Close is called
+func (s *WebSocketServer) Serve(listener net.Listener) error {
+	err := s.server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops accepting clients and disconnects those connected
+func (s *WebSocketServer) Close() error {
+	err := s.server.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for conn := range s.conns {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "daemon shutting down"), deadline)
+		conn.Close()
+	}
+	return err
+}
+
+// originAllowed lets in clients without an Origin, which are not web pages,
+// the extensions in allowedExtensions and pages served from this machine.
+// Any other page could otherwise drive the daemon from a visitor's browser.
+func (s *WebSocketServer) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	switch originURL.Scheme {
+	case "chrome-extension", "moz-extension":
+		return s.host.config.extensionAllowed(origin)
+	case "http", "https":
+		host := originURL.Hostname()
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	default:
+		return false
+	}
+}
+
+// authorized checks the bearer token. Browsers cannot set headers on a
+// WebSocket handshake, so they offer it as a subprotocol instead, which
+// unlike a query parameter stays out of logs and history.
+func (s *WebSocketServer) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		for _, protocol := range websocket.Subprotocols(r) {
+			if token, ok = strings.CutPrefix(protocol, webSocketTokenPrefix); ok {
+				break
+			}
+		}
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// handleWebSocket upgrades an authenticated request and serves the client
+func (s *WebSocketServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !s.upgrader.CheckOrigin(r) {
+		logWarnf("Refused WebSocket client from origin %s", r.Header.Get("Origin"))
+		writeJSONError(w, http.StatusForbidden, codeNotPermitted, "origin not allowed")
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tabd"`)
+		writeJSONError(w, http.StatusUnauthorized, codeNotPermitted, "missing or invalid token")
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied
+		logWarnf("WebSocket handshake failed: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	logInfof("WebSocket client connected from %s", r.RemoteAddr)
+	client := &webSocketClient{conn: conn, session: s.host.NewSession(nil, nil)}
+	client.serve(s.host)
+	logInfof("WebSocket client %s disconnected", r.RemoteAddr)
+}
+
+// webSocketClient is one connected WebSocket client. Its session rate limits
+// the messages it sends and records its hello.
+type webSocketClient struct {
+	conn    *websocket.Conn
+	session *Session
+	writeMu sync.Mutex
+}
+
+// send writes a JSON text frame
+func (c *webSocketClient) send(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	return c.conn.WriteJSON(v)
+}
+
+// ping writes a ping frame
+func (c *webSocketClient) ping() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteTimeout))
+}
+
+// serve pushes events to the client while answering its messages, until it
+// disconnects
+func (c *webSocketClient) serve(host *TabdNativeHost) {
+	events, unsubscribe := host.subscribe(entryEventsFeature)
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(webSocketPingInterval)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case <-done:
+				return
+			case event := <-events.events:
+				err = c.send(event)
+			case <-ticker.C:
+				err = c.ping()
+			}
+			if err != nil {
+				// Unblocks the read below
+				c.conn.Close()
+				return
+			}
+		}
+	}()
+
+	c.conn.SetReadLimit(maxTransferSize)
+	c.conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+	})
+
+	for {
+		kind, messageData, err := c.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && !errors.Is(err, net.ErrClosed) {
+				logWarnf("WebSocket read error: %v", err)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(webSocketPongTimeout))
+		if kind != websocket.TextMessage {
+			continue
+		}
+
+		var msg Message
+		var response *Response
+		if err := json.Unmarshal(messageData, &msg); err != nil {
+			response = &Response{
+				Status:    "error",
+				Code:      codeInvalidRequest,
+				Message:   "failed to parse message: " + err.Error(),
+				Timestamp: time.Now().Unix(),
+				Version:   version,
+			}
+		} else {
+			response = host.dispatch(c.session.ctx, c.session, &msg)
+		}
+		if err := c.send(response); err != nil {
+			logWarnf("WebSocket write error: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketOriginAndToken(t *testing.T) {
+	host := newTestHost(t)
+	server := httptest.NewServer(NewWebSocketServer(host, "secret").server.Handler)
+	defer server.Close()
+	endpoint := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	for _, tc := range []struct {
+		name      string
+		url       string
+		origin    string
+		bearer    bool
+		protocols []string
+		status    int
+	}{
+		{"script with bearer token", endpoint, "", true, nil, http.StatusSwitchingProtocols},
+		{"allowed extension", endpoint, "chrome-extension://" + defaultExtensionID, false, []string{"tabd", "tabd.token.secret"}, http.StatusSwitchingProtocols},
+		{"local page", endpoint, "http://localhost:3000", false, []string{"tabd", "tabd.token.secret"}, http.StatusSwitchingProtocols},
+		{"loopback page", endpoint, "http://127.0.0.1:8080", true, nil, http.StatusSwitchingProtocols},
+		{"other extension", endpoint, "chrome-extension://otherextensionid", true, nil, http.StatusForbidden},
+		{"web page", endpoint, "https://attacker.example", true, nil, http.StatusForbidden},
+		{"token in the query", endpoint + "?token=secret", "", false, nil, http.StatusUnauthorized},
+		{"wrong token", endpoint, "", false, []string{"tabd", "tabd.token.wrong"}, http.StatusUnauthorized},
+	} {
+		header := http.Header{}
+		if tc.origin != "" {
+			header.Set("Origin", tc.origin)
+		}
+		if tc.bearer {
+			header.Set("Authorization", "Bearer secret")
+		}
+		dialer := websocket.Dialer{Subprotocols: tc.protocols}
+
+		conn, response, err := dialer.Dial(tc.url, header)
+		if response == nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if response.StatusCode != tc.status {
+			t.Fatalf("%s: status %d, want %d", tc.name, response.StatusCode, tc.status)
+		}
+		if conn != nil {
+			if tc.protocols != nil && conn.Subprotocol() != webSocketProtocol {
+				t.Fatalf("%s: subprotocol %q, want %q", tc.name, conn.Subprotocol(), webSocketProtocol)
+			}
+			conn.Close()
+		}
+	}
+}